@@ -0,0 +1,121 @@
+// Package api serves the JSON HTTP API of roper under /api/v1/
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/controller"
+	"github.com/alapidas/roper/model"
+	"github.com/gorilla/mux"
+)
+
+// Prefix is the path the API is served under
+const Prefix = "api/v1"
+
+// Config holds the settings of the API
+type Config struct {
+	Uploads       bool  // accept package uploads
+	MaxUploadSize int64 // largest package accepted, in bytes, 0 means no limit
+}
+
+// API is the http.Handler for the API
+type API struct {
+	rc     *controller.RoperController
+	cfg    Config
+	router *mux.Router
+}
+
+// New returns the API for a controller
+func New(rc *controller.RoperController, cfg Config) *API {
+	a := &API{rc: rc, cfg: cfg, router: mux.NewRouter()}
+	r := a.router.PathPrefix("/" + Prefix).Subrouter()
+	r.HandleFunc("/repos", a.listRepos).Methods("GET")
+	r.HandleFunc("/repos", a.createRepo).Methods("POST")
+	r.HandleFunc("/repos/{name}", a.getRepo).Methods("GET")
+	r.HandleFunc("/repos/{name}", a.deleteRepo).Methods("DELETE")
+	r.HandleFunc("/repos/{name}/build", a.getBuild).Methods("GET")
+	r.HandleFunc("/repos/{name}/discover", a.discoverRepo).Methods("POST")
+	r.HandleFunc("/repos/{name}/rebuild", a.rebuildRepo).Methods("POST")
+	r.HandleFunc("/repos/{name}/packages", a.listPackages).Methods("GET")
+	r.Handle("/repos/{name}/packages", a.uploads(a.postPackages)).Methods("POST")
+	r.HandleFunc("/repos/{name}/packages/{relpath:.+}", a.getPackage).Methods("GET")
+	r.Handle("/repos/{name}/packages/{relpath:.+}", a.uploads(a.putPackage)).Methods("PUT")
+	r.HandleFunc("/packages", a.searchPackages).Methods("GET")
+	a.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no such endpoint")
+	})
+	return a
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.router.ServeHTTP(w, r)
+}
+
+// uploads wraps handlers that are only available when uploads are on
+func (a *API) uploads(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.cfg.Uploads {
+			writeError(w, http.StatusForbidden, "uploads are disabled")
+			return
+		}
+		h(w, r)
+	})
+}
+
+// authorize checks that the request is from someone with a role on a repo, or on every repo if repoName
+// is empty, and writes an error response if it isn't
+func (a *API) authorize(w http.ResponseWriter, r *http.Request, repoName, role string) (*model.User, bool) {
+	user, err := a.rc.Authorize(r, repoName, role)
+	if err != nil {
+		a.authError(w, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// authenticate returns the user a request is from, nil for anonymous requests, and writes an error
+// response if the request has bad credentials
+func (a *API) authenticate(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	user, err := a.rc.Authenticate(r)
+	if err != nil {
+		a.authError(w, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// authError writes the response for a request that failed authorization
+func (a *API) authError(w http.ResponseWriter, err error) {
+	switch err {
+	case controller.ErrUnauthenticated:
+		w.Header().Set("WWW-Authenticate", `Basic realm="roper"`)
+		writeError(w, http.StatusUnauthorized, err.Error())
+	case controller.ErrForbidden:
+		writeError(w, http.StatusForbidden, err.Error())
+	default:
+		a.internalError(w, err)
+	}
+}
+
+// errorBody is the body of every error response
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// writeJSON writes a value as the JSON body of a response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.WithField("error", err).Warn("unable to write API response")
+	}
+}
+
+// writeError writes an error response
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorBody{Error: msg})
+}
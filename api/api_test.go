@@ -0,0 +1,291 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alapidas/roper/controller"
+	"github.com/alapidas/roper/model"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TheSuite struct {
+	rc       *controller.RoperController
+	repoPath string
+	api      *API
+	token    string // token of an admin, sent with requests that don't have credentials of their own
+}
+
+var _ = Suite(&TheSuite{})
+
+const testPkg = "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm"
+
+// Create a controller with an empty repo and an admin, and an API for it that accepts uploads
+func (suite *TheSuite) SetUpTest(c *C) {
+	rc, err := controller.Init(filepath.Join(c.MkDir(), "roper.db"), "nothing")
+	c.Assert(err, IsNil)
+	suite.rc = rc
+	suite.repoPath = c.MkDir()
+	c.Assert(rc.AddRepo(&model.Repo{Name: "Repo", AbsPath: suite.repoPath}), IsNil)
+	suite.api = New(rc, Config{Uploads: true, MaxUploadSize: 1 << 20})
+	c.Assert(rc.AddUser("admin", "", true), IsNil)
+	suite.token, _, err = rc.CreateToken("admin", "")
+	c.Assert(err, IsNil)
+}
+
+func (suite *TheSuite) TearDownTest(c *C) {
+	suite.rc.Close()
+}
+
+// readTestPkg returns the contents of an rpm from the docker test repo
+func readTestPkg(c *C, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("..", "hack", "test_repos", "docker", "7", "Packages", name))
+	c.Assert(err, IsNil)
+	return data
+}
+
+// serve sends a request to the API, as the admin unless it has credentials.  An empty Authorization
+// header makes an anonymous request.
+func (suite *TheSuite) serve(req *http.Request) *httptest.ResponseRecorder {
+	if _, ok := req.Header["Authorization"]; !ok {
+		req.Header.Set("Authorization", "Bearer "+suite.token)
+	}
+	rec := httptest.NewRecorder()
+	suite.api.ServeHTTP(rec, req)
+	return rec
+}
+
+// do sends a request to the API, and decodes the JSON response into v
+func (suite *TheSuite) do(c *C, req *http.Request, v interface{}) int {
+	rec := suite.serve(req)
+	c.Assert(rec.Header().Get("Content-Type"), Equals, "application/json")
+	if v != nil {
+		c.Assert(json.Unmarshal(rec.Body.Bytes(), v), IsNil, Commentf("body: %s", rec.Body.String()))
+	}
+	return rec.Code
+}
+
+func (suite *TheSuite) TestPutPackage(c *C) {
+	data := readTestPkg(c, testPkg)
+	put := func(target string, body []byte, v interface{}) int {
+		return suite.do(c, httptest.NewRequest("PUT", target, bytes.NewReader(body)), v)
+	}
+	pkg := &Package{}
+	c.Assert(put("/api/v1/repos/Repo/packages/Packages/"+testPkg, data, pkg), Equals, http.StatusCreated)
+	c.Assert(pkg.NEVRA, Equals, "docker-engine-selinux-1.9.0-1.el7.centos.noarch")
+	c.Assert(pkg.Path, Equals, "Packages/"+testPkg)
+	c.Assert(pkg.Size, Equals, int64(len(data)))
+	c.Assert(pkg.Checksum, HasLen, 64)
+
+	// the package is in the repo and its metadata right away
+	repo, err := suite.rc.GetRepo("Repo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages["Packages/"+testPkg].Checksum, Equals, pkg.Checksum)
+	_, err = os.Stat(filepath.Join(suite.repoPath, "repodata", "repomd.xml"))
+	c.Assert(err, IsNil)
+
+	// existing files are only replaced when asked to
+	errBody := &errorBody{}
+	c.Assert(put("/api/v1/repos/Repo/packages/Packages/"+testPkg, data, errBody), Equals, http.StatusConflict)
+	c.Assert(errBody.Error, Not(Equals), "")
+	c.Assert(put("/api/v1/repos/Repo/packages/Packages/"+testPkg+"?overwrite=true", data, pkg), Equals, http.StatusCreated)
+	c.Assert(put("/api/v1/repos/Repo/packages/Packages/"+testPkg+"?overwrite=maybe", data, nil), Equals, http.StatusBadRequest)
+
+	// bad uploads leave nothing behind
+	c.Assert(put("/api/v1/repos/Repo/packages/bad.rpm", []byte("not an rpm"), nil), Equals, http.StatusBadRequest)
+	c.Assert(put("/api/v1/repos/Repo/packages/bad.txt", data, nil), Equals, http.StatusBadRequest)
+	c.Assert(put("/api/v1/repos/Repo/packages/repodata/bad.rpm", data, nil), Equals, http.StatusBadRequest)
+	_, err = suite.rc.UploadPackage("Repo", "a/../../bad.rpm", bytes.NewReader(data), controller.UploadOptions{})
+	_, ok := err.(*controller.InvalidPackageError)
+	c.Assert(ok, Equals, true)
+	c.Assert(put("/api/v1/repos/Nothing/packages/bad.rpm", data, nil), Equals, http.StatusNotFound)
+	suite.api.cfg.MaxUploadSize = int64(len(data) - 1)
+	c.Assert(put("/api/v1/repos/Repo/packages/big.rpm", data, nil), Equals, http.StatusRequestEntityTooLarge)
+	req := httptest.NewRequest("PUT", "/api/v1/repos/Repo/packages/big.rpm", bytes.NewReader(data))
+	req.ContentLength = -1
+	c.Assert(suite.do(c, req, nil), Equals, http.StatusRequestEntityTooLarge)
+	files, err := ioutil.ReadDir(suite.repoPath)
+	c.Assert(err, IsNil)
+	var names []string
+	for _, fi := range files {
+		names = append(names, fi.Name())
+	}
+	c.Assert(names, DeepEquals, []string{"Packages", "repodata"})
+
+	// uploads can be turned off
+	suite.api.cfg.Uploads = false
+	c.Assert(put("/api/v1/repos/Repo/packages/other.rpm", data, nil), Equals, http.StatusForbidden)
+}
+
+func (suite *TheSuite) TestPostPackages(c *C) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	c.Assert(mw.WriteField("comment", "ignored"), IsNil)
+	for _, name := range []string{testPkg, "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm"} {
+		fw, err := mw.CreateFormFile("file", "../"+name)
+		c.Assert(err, IsNil)
+		_, err = fw.Write(readTestPkg(c, name))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(mw.Close(), IsNil)
+	req := httptest.NewRequest("POST", "/api/v1/repos/Repo/packages?dir=el7/Packages", bytes.NewReader(body.Bytes()))
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	var pkgs []*Package
+	c.Assert(suite.do(c, req, &pkgs), Equals, http.StatusCreated)
+	c.Assert(pkgs, HasLen, 2)
+	c.Assert(pkgs[0].Path, Equals, "el7/Packages/"+testPkg)
+	c.Assert(pkgs[1].NEVRA, Equals, "docker-engine-selinux-1.9.1-1.el7.centos.noarch")
+	repo, err := suite.rc.GetRepo("Repo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 2)
+
+	req = httptest.NewRequest("POST", "/api/v1/repos/Repo/packages", bytes.NewReader([]byte("x")))
+	c.Assert(suite.do(c, req, nil), Equals, http.StatusBadRequest)
+}
+
+func (suite *TheSuite) TestRepos(c *C) {
+	var repos []*Repo
+	c.Assert(suite.do(c, httptest.NewRequest("GET", "/api/v1/repos", nil), &repos), Equals, http.StatusOK)
+	c.Assert(repos, HasLen, 1)
+	c.Assert(repos[0].Name, Equals, "Repo")
+	c.Assert(repos[0].Type, Equals, model.TypeYum)
+	c.Assert(repos[0].LastBuild, NotNil)
+
+	// add a repo holding a package, with settings
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, testPkg), readTestPkg(c, testPkg), 0644), IsNil)
+	body := `{"name": "Other", "path": "` + dir + `", "settings": {"Retention": {"KeepLast": 3}}}`
+	repo := &Repo{}
+	c.Assert(suite.do(c, httptest.NewRequest("POST", "/api/v1/repos", strings.NewReader(body)), repo), Equals, http.StatusCreated)
+	c.Assert(repo.Packages, Equals, 1)
+	c.Assert(repo.Size, Equals, int64(len(readTestPkg(c, testPkg))))
+	c.Assert(suite.do(c, httptest.NewRequest("POST", "/api/v1/repos", strings.NewReader(body)), nil), Equals, http.StatusConflict)
+	for _, bad := range []string{`{"name": "a/b", "path": "/tmp"}`, `{"name": "..", "path": "/tmp"}`, `{"name": ".", "path": "/tmp"}`, `{"name": "a", "path": "tmp"}`, `{"name": "api", "path": "/tmp"}`, `nope`} {
+		c.Assert(suite.do(c, httptest.NewRequest("POST", "/api/v1/repos", strings.NewReader(bad)), nil), Equals, http.StatusBadRequest, Commentf("body %s", bad))
+	}
+
+	repo = &Repo{}
+	c.Assert(suite.do(c, httptest.NewRequest("GET", "/api/v1/repos/Other", nil), repo), Equals, http.StatusOK)
+	c.Assert(repo.Settings, NotNil)
+	c.Assert(repo.Settings.Retention.KeepLast, Equals, 3)
+	c.Assert(repo.Settings.Packages, IsNil)
+	c.Assert(suite.do(c, httptest.NewRequest("GET", "/api/v1/repos/Nothing", nil), nil), Equals, http.StatusNotFound)
+
+	// only those who can write to a repo see its settings
+	c.Assert(suite.rc.AddUser("reader", "secret", false), IsNil)
+	c.Assert(suite.rc.GrantRole("reader", "Other", model.RoleRead), IsNil)
+	anonymous := httptest.NewRequest("GET", "/api/v1/repos/Other", nil)
+	anonymous.Header.Set("Authorization", "")
+	reader := httptest.NewRequest("GET", "/api/v1/repos/Other", nil)
+	reader.SetBasicAuth("reader", "secret")
+	for _, req := range []*http.Request{anonymous, reader} {
+		repo = &Repo{}
+		c.Assert(suite.do(c, req, repo), Equals, http.StatusOK)
+		c.Assert(repo.Name, Equals, "Other")
+		c.Assert(repo.Settings, IsNil)
+	}
+
+	rec := suite.serve(httptest.NewRequest("DELETE", "/api/v1/repos/Other", nil))
+	c.Assert(rec.Code, Equals, http.StatusNoContent)
+	_, err := os.Stat(filepath.Join(dir, testPkg))
+	c.Assert(err, IsNil)
+	c.Assert(suite.do(c, httptest.NewRequest("DELETE", "/api/v1/repos/Other", nil), nil), Equals, http.StatusNotFound)
+}
+
+func (suite *TheSuite) TestPackagesAndBuilds(c *C) {
+	c.Assert(os.MkdirAll(filepath.Join(suite.repoPath, "Packages"), 0755), IsNil)
+	for _, name := range []string{testPkg, "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(suite.repoPath, "Packages", name), readTestPkg(c, name), 0644), IsNil)
+	}
+	// new files are picked up by a discover
+	build := &Build{}
+	c.Assert(suite.do(c, httptest.NewRequest("POST", "/api/v1/repos/Repo/discover?wait=true", nil), build), Equals, http.StatusOK)
+	c.Assert(build.Packages, Equals, 2)
+	c.Assert(build.Running, Equals, false)
+	c.Assert(build.Error, Equals, "")
+
+	var pkgs []*Package
+	c.Assert(suite.do(c, httptest.NewRequest("GET", "/api/v1/repos/Repo/packages", nil), &pkgs), Equals, http.StatusOK)
+	c.Assert(pkgs, HasLen, 2)
+	c.Assert(suite.do(c, httptest.NewRequest("GET", "/api/v1/repos/Repo/packages?q=*-1.9.1-*", nil), &pkgs), Equals, http.StatusOK)
+	c.Assert(pkgs, HasLen, 1)
+	c.Assert(suite.do(c, httptest.NewRequest("GET", "/api/v1/packages?q=docker-engine-selinux", nil), &pkgs), Equals, http.StatusOK)
+	c.Assert(pkgs, HasLen, 2)
+	c.Assert(suite.do(c, httptest.NewRequest("GET", "/api/v1/packages?q=[", nil), nil), Equals, http.StatusBadRequest)
+	c.Assert(suite.do(c, httptest.NewRequest("GET", "/api/v1/repos/Nothing/packages", nil), nil), Equals, http.StatusNotFound)
+
+	pkg := &Package{}
+	c.Assert(suite.do(c, httptest.NewRequest("GET", "/api/v1/repos/Repo/packages/Packages/"+testPkg, nil), pkg), Equals, http.StatusOK)
+	c.Assert(pkg.Name, Equals, "docker-engine-selinux")
+	c.Assert(pkg.Version, Equals, "1.9.0")
+	c.Assert(suite.do(c, httptest.NewRequest("GET", "/api/v1/repos/Repo/packages/Packages/nothing.rpm", nil), nil), Equals, http.StatusNotFound)
+
+	// rebuilds run in the background unless asked to wait
+	rec := suite.serve(httptest.NewRequest("POST", "/api/v1/repos/Repo/rebuild", nil))
+	c.Assert(rec.Code, Equals, http.StatusAccepted)
+	c.Assert(rec.Header().Get("Location"), Equals, "/api/v1/repos/Repo/build")
+	c.Assert(suite.do(c, httptest.NewRequest("POST", "/api/v1/repos/Repo/rebuild?wait=true", nil), build), Equals, http.StatusOK)
+	c.Assert(suite.do(c, httptest.NewRequest("GET", "/api/v1/repos/Repo/build", nil), build), Equals, http.StatusOK)
+	c.Assert(build.Repo, Equals, "Repo")
+	c.Assert(suite.do(c, httptest.NewRequest("POST", "/api/v1/repos/Nothing/rebuild", nil), nil), Equals, http.StatusNotFound)
+}
+
+func (suite *TheSuite) TestAuth(c *C) {
+	c.Assert(suite.rc.AddUser("dev", "secret", false), IsNil)
+	c.Assert(suite.rc.GrantRole("dev", "Repo", model.RoleWrite), IsNil)
+	c.Assert(suite.rc.AddUser("nobody", "secret", false), IsNil)
+	dir := c.MkDir()
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "Private", AbsPath: dir, Access: model.AccessOptions{Private: true}}), IsNil)
+	req := func(method, target, user, password string) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("Authorization", "")
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+		return r
+	}
+
+	// anonymous requests can only read public repos
+	var repos []*Repo
+	c.Assert(suite.do(c, req("GET", "/api/v1/repos", "", ""), &repos), Equals, http.StatusOK)
+	c.Assert(repos, HasLen, 1)
+	c.Assert(suite.do(c, req("GET", "/api/v1/repos/Repo", "", ""), nil), Equals, http.StatusOK)
+	rec := suite.serve(req("GET", "/api/v1/repos/Private", "", ""))
+	c.Assert(rec.Code, Equals, http.StatusUnauthorized)
+	c.Assert(rec.Header().Get("WWW-Authenticate"), Equals, `Basic realm="roper"`)
+	c.Assert(suite.do(c, req("POST", "/api/v1/repos/Repo/rebuild", "", ""), nil), Equals, http.StatusUnauthorized)
+
+	// bad credentials are refused, even for public repos
+	c.Assert(suite.do(c, req("GET", "/api/v1/repos/Repo", "dev", "wrong"), nil), Equals, http.StatusUnauthorized)
+	c.Assert(suite.do(c, req("GET", "/api/v1/repos", "ghost", "secret"), nil), Equals, http.StatusUnauthorized)
+
+	// roles are per repo
+	c.Assert(suite.do(c, req("POST", "/api/v1/repos/Repo/rebuild?wait=true", "dev", "secret"), nil), Equals, http.StatusOK)
+	c.Assert(suite.do(c, req("DELETE", "/api/v1/repos/Repo", "dev", "secret"), nil), Equals, http.StatusForbidden)
+	c.Assert(suite.do(c, req("GET", "/api/v1/repos/Private", "dev", "secret"), nil), Equals, http.StatusForbidden)
+	c.Assert(suite.do(c, req("POST", "/api/v1/repos/Repo/rebuild", "nobody", "secret"), nil), Equals, http.StatusForbidden)
+	c.Assert(suite.do(c, req("POST", "/api/v1/repos", "dev", "secret"), nil), Equals, http.StatusForbidden)
+	c.Assert(suite.do(c, req("PUT", "/api/v1/repos/Private/packages/"+testPkg, "dev", "secret"), nil), Equals, http.StatusForbidden)
+	c.Assert(suite.rc.GrantRole("dev", "Private", model.RoleRead), IsNil)
+	c.Assert(suite.do(c, req("GET", "/api/v1/repos", "dev", "secret"), &repos), Equals, http.StatusOK)
+	c.Assert(repos, HasLen, 2)
+
+	// tokens work as bearer tokens, and as the password of basic auth
+	token, _, err := suite.rc.CreateToken("dev", "ci")
+	c.Assert(err, IsNil)
+	r := req("GET", "/api/v1/repos/Private", "", "")
+	r.Header.Set("Authorization", "Bearer "+token)
+	c.Assert(suite.do(c, r, nil), Equals, http.StatusOK)
+	c.Assert(suite.do(c, req("GET", "/api/v1/repos/Private", "dev", token), nil), Equals, http.StatusOK)
+	c.Assert(suite.do(c, req("GET", "/api/v1/repos/Private", "nobody", token), nil), Equals, http.StatusUnauthorized)
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/controller"
+	"github.com/alapidas/roper/model"
+	"github.com/gorilla/mux"
+)
+
+// Package describes a package in a repo
+type Package struct {
+	Repo     string `json:"repo"`
+	Path     string `json:"path"`
+	NEVRA    string `json:"nevra"`
+	Name     string `json:"name"`
+	Epoch    int    `json:"epoch"`
+	Version  string `json:"version"`
+	Release  string `json:"release"`
+	Arch     string `json:"arch"`
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+}
+
+// newPackage describes a package of the model
+func newPackage(pkg *model.Package) *Package {
+	return &Package{
+		Repo:     pkg.RepoName,
+		Path:     pkg.RelPath,
+		NEVRA:    pkg.NEVRA(),
+		Name:     pkg.Name,
+		Epoch:    pkg.Epoch,
+		Version:  pkg.Version,
+		Release:  pkg.Release,
+		Arch:     pkg.Arch,
+		Checksum: pkg.Checksum,
+		Size:     pkg.Size,
+	}
+}
+
+// putPackage stores the request body as the package at a path in a repo.  An existing file is only
+// replaced if the overwrite query parameter is true.
+func (a *API) putPackage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if _, ok := a.authorize(w, r, vars["name"], model.RoleWrite); !ok {
+		return
+	}
+	overwrite, err := boolParam(r, "overwrite")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if a.tooLarge(w, r) {
+		return
+	}
+	pkg, status, err := a.upload(vars["name"], vars["relpath"], r.Body, overwrite)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, pkg)
+}
+
+// postPackages stores the files of a multipart/form-data request as packages in a repo.  Files are
+// stored by their file name, in the dir given by the dir query parameter.  Files are stored in order,
+// and the ones before a failure are kept.
+func (a *API) postPackages(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, ok := a.authorize(w, r, name, model.RoleWrite); !ok {
+		return
+	}
+	overwrite, err := boolParam(r, "overwrite")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	dir := r.URL.Query().Get("dir")
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("expected a multipart/form-data body: %s", err))
+		return
+	}
+	pkgs := []*Package{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("bad multipart body: %s", err))
+			return
+		}
+		fileName := partFileName(part.Header.Get("Content-Disposition"))
+		if fileName == "" {
+			part.Close()
+			continue
+		}
+		pkg, status, err := a.upload(name, path.Join(dir, fileName), part, overwrite)
+		part.Close()
+		if err != nil {
+			writeError(w, status, fmt.Sprintf("%s: %s", fileName, err))
+			return
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	if len(pkgs) == 0 {
+		writeError(w, http.StatusBadRequest, "no files in request")
+		return
+	}
+	writeJSON(w, http.StatusCreated, pkgs)
+}
+
+// upload stores a package, and returns the status to respond with if it fails
+func (a *API) upload(repoName, relPath string, body io.Reader, overwrite bool) (*Package, int, error) {
+	if _, err := a.rc.GetRepo(repoName); err != nil {
+		return nil, http.StatusNotFound, fmt.Errorf("no repo named %s", repoName)
+	}
+	pkg, err := a.rc.UploadPackage(repoName, relPath, body, controller.UploadOptions{
+		MaxSize:   a.cfg.MaxUploadSize,
+		Overwrite: overwrite,
+	})
+	switch err.(type) {
+	case nil:
+		return newPackage(pkg), http.StatusCreated, nil
+	case *controller.InvalidPackageError:
+		return nil, http.StatusBadRequest, err
+	}
+	switch err {
+	case controller.ErrPackageExists:
+		return nil, http.StatusConflict, err
+	case controller.ErrPackageTooLarge:
+		return nil, http.StatusRequestEntityTooLarge, err
+	}
+	log.WithFields(log.Fields{
+		"repo":  repoName,
+		"path":  relPath,
+		"error": err,
+	}).Error("Error uploading package")
+	return nil, http.StatusInternalServerError, err
+}
+
+// tooLarge rejects uploads that say up front they are over the upload limit
+func (a *API) tooLarge(w http.ResponseWriter, r *http.Request) bool {
+	if a.cfg.MaxUploadSize > 0 && r.ContentLength > a.cfg.MaxUploadSize {
+		writeError(w, http.StatusRequestEntityTooLarge, controller.ErrPackageTooLarge.Error())
+		return true
+	}
+	return false
+}
+
+// partFileName returns the base name of the file in a multipart part, or "" if it isn't a file.
+// Part.FileName isn't used since older versions of it don't strip directories.
+func partFileName(disposition string) string {
+	_, params, err := mime.ParseMediaType(disposition)
+	if err != nil || params["filename"] == "" {
+		return ""
+	}
+	return path.Base(path.Clean("/" + params["filename"]))
+}
+
+// boolParam parses an optional boolean query parameter
+func boolParam(r *http.Request, name string) (bool, error) {
+	val := r.URL.Query().Get(name)
+	if val == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("bad value %s for %s", val, name)
+	}
+	return b, nil
+}
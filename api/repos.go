@@ -0,0 +1,340 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/controller"
+	"github.com/alapidas/roper/model"
+	"github.com/gorilla/mux"
+)
+
+// Repo describes a repo and its packages
+type Repo struct {
+	Name      string      `json:"name"`
+	Path      string      `json:"path"`
+	Type      string      `json:"type"`
+	Packages  int         `json:"packages"`
+	Size      int64       `json:"size"` // total size of the packages, in bytes
+	LastBuild *Build      `json:"last_build"`
+	Settings  *model.Repo `json:"settings,omitempty"`
+}
+
+// Build describes the last metadata build of a repo
+type Build struct {
+	Repo     string    `json:"repo"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration"` // in seconds
+	Packages int       `json:"packages"`
+	Running  bool      `json:"running"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// newRepoRequest is the body of a request to add a repo.  Settings holds the optional settings of the
+// repo, like signing and retention, in the same form as the settings of a repo response.
+type newRepoRequest struct {
+	Name     string     `json:"name"`
+	Path     string     `json:"path"`
+	Type     string     `json:"type"`
+	Settings model.Repo `json:"settings"`
+}
+
+// newRepo describes a repo of the model, and its last build, if any
+func newRepo(repo *model.Repo, status *model.BuildStatus) *Repo {
+	r := &Repo{Name: repo.Name, Path: repo.AbsPath, Type: repo.Type, Packages: len(repo.Packages)}
+	if r.Type == "" {
+		r.Type = model.TypeYum
+	}
+	for _, pkg := range repo.Packages {
+		r.Size += pkg.Size
+	}
+	if status != nil {
+		r.LastBuild = newBuild(status)
+	}
+	return r
+}
+
+// newBuild describes a build status of the model
+func newBuild(status *model.BuildStatus) *Build {
+	return &Build{
+		Repo:     status.Repo,
+		Started:  time.Unix(status.Started, 0).UTC(),
+		Duration: status.Duration.Seconds(),
+		Packages: status.Packages,
+		Running:  status.Running,
+		Error:    status.Error,
+	}
+}
+
+// repo looks up the repo named in the request, if the request is from someone with a role on it, and
+// writes an error response if it isn't or there is no such repo
+func (a *API) repo(w http.ResponseWriter, r *http.Request, role string) (*model.Repo, bool) {
+	name := mux.Vars(r)["name"]
+	if _, ok := a.authorize(w, r, name, role); !ok {
+		return nil, false
+	}
+	repo, err := a.rc.GetRepo(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no repo named %s", name))
+		return nil, false
+	}
+	return repo, true
+}
+
+// describeRepo describes a repo along with its last build
+func (a *API) describeRepo(repo *model.Repo) (*Repo, error) {
+	status, err := a.rc.GetBuildStatus(repo.Name)
+	if err != nil {
+		return nil, err
+	}
+	return newRepo(repo, status), nil
+}
+
+// listRepos lists the repos the request can read
+func (a *API) listRepos(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.authenticate(w, r)
+	if !ok {
+		return
+	}
+	repos, err := a.rc.GetRepos()
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	list := []*Repo{}
+	for _, repo := range repos {
+		if !controller.Allowed(user, controller.ClientCert(r), repo, model.RoleRead) {
+			continue
+		}
+		desc, err := a.describeRepo(repo)
+		if err != nil {
+			a.internalError(w, err)
+			return
+		}
+		list = append(list, desc)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// getRepo describes a repo.  Its settings are only included for those who can write to it, since they
+// hold things like upstream URLs with credentials, keyring paths and access rules.
+func (a *API) getRepo(w http.ResponseWriter, r *http.Request) {
+	repo, ok := a.repo(w, r, model.RoleRead)
+	if !ok {
+		return
+	}
+	desc, err := a.describeRepo(repo)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	user, err := a.rc.Authenticate(r)
+	if err != nil || !controller.Allowed(user, nil, repo, model.RoleWrite) {
+		writeJSON(w, http.StatusOK, desc)
+		return
+	}
+	settings := *repo
+	settings.Packages = nil
+	desc.Settings = &settings
+	writeJSON(w, http.StatusOK, desc)
+}
+
+// createRepo adds a repo, and discovers the packages already at its path.  Only admins can add repos.
+func (a *API) createRepo(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.authorize(w, r, "", model.RoleAdmin); !ok {
+		return
+	}
+	req := &newRepoRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("bad repo: %s", err))
+		return
+	}
+	if err := controller.ValidateRepoName(req.Name); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !filepath.IsAbs(req.Path) {
+		writeError(w, http.StatusBadRequest, "a repo needs an absolute path")
+		return
+	}
+	if _, err := a.rc.GetRepo(req.Name); err == nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("repo %s already exists", req.Name))
+		return
+	}
+	repo := req.Settings
+	repo.Name, repo.AbsPath, repo.Type, repo.Packages = req.Name, req.Path, req.Type, nil
+	if err := a.rc.AddRepo(&repo); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	added, err := a.rc.GetRepo(req.Name)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	desc, err := a.describeRepo(added)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/"+Prefix+"/repos/"+req.Name)
+	writeJSON(w, http.StatusCreated, desc)
+}
+
+// deleteRepo removes a repo from roper.  The files of the repo are left alone.
+func (a *API) deleteRepo(w http.ResponseWriter, r *http.Request) {
+	repo, ok := a.repo(w, r, model.RoleAdmin)
+	if !ok {
+		return
+	}
+	if err := a.rc.RemoveRepo(repo.Name); err != nil {
+		a.internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getBuild describes the last metadata build of a repo
+func (a *API) getBuild(w http.ResponseWriter, r *http.Request) {
+	repo, ok := a.repo(w, r, model.RoleRead)
+	if !ok {
+		return
+	}
+	status, err := a.rc.GetBuildStatus(repo.Name)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	if status == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("metadata of repo %s hasn't been built yet", repo.Name))
+		return
+	}
+	writeJSON(w, http.StatusOK, newBuild(status))
+}
+
+// discoverRepo rescans the path of a repo for packages, and rebuilds its metadata
+func (a *API) discoverRepo(w http.ResponseWriter, r *http.Request) {
+	a.runJob(w, r, "discover", func(repo *model.Repo) error {
+		return a.rc.Discover(repo.Name, repo.AbsPath)
+	})
+}
+
+// rebuildRepo rebuilds the metadata of a repo from the packages roper knows about
+func (a *API) rebuildRepo(w http.ResponseWriter, r *http.Request) {
+	a.runJob(w, r, "rebuild", func(repo *model.Repo) error {
+		return a.rc.RebuildMetadata(repo.Name)
+	})
+}
+
+// runJob runs a job on the repo of a request.  Jobs run in the background, and the response points at
+// the build status of the repo, unless the wait query parameter is true, in which case the job is
+// finished before responding with the build status.
+func (a *API) runJob(w http.ResponseWriter, r *http.Request, job string, run func(repo *model.Repo) error) {
+	repo, ok := a.repo(w, r, model.RoleWrite)
+	if !ok {
+		return
+	}
+	wait, err := boolParam(r, "wait")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	fail := func(err error) {
+		log.WithFields(log.Fields{
+			"repo":  repo.Name,
+			"job":   job,
+			"error": err,
+		}).Error("Error running API job")
+	}
+	if !wait {
+		go func() {
+			if err := run(repo); err != nil {
+				fail(err)
+			}
+		}()
+		w.Header().Set("Location", "/"+Prefix+"/repos/"+repo.Name+"/build")
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+		return
+	}
+	if err = run(repo); err != nil {
+		fail(err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.getBuild(w, r)
+}
+
+// listPackages lists the packages of a repo, optionally only the ones whose name or NEVRA match the glob
+// in the q query parameter
+func (a *API) listPackages(w http.ResponseWriter, r *http.Request) {
+	repo, ok := a.repo(w, r, model.RoleRead)
+	if !ok {
+		return
+	}
+	a.findPackages(w, repo.Name, r.URL.Query().Get("q"), nil)
+}
+
+// searchPackages lists the packages whose name or NEVRA match the glob in the q query parameter, in
+// all the repos the request can read
+func (a *API) searchPackages(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.authenticate(w, r)
+	if !ok {
+		return
+	}
+	repos, err := a.rc.GetRepos()
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	readable := map[string]bool{}
+	for _, repo := range repos {
+		readable[repo.Name] = controller.Allowed(user, controller.ClientCert(r), repo, model.RoleRead)
+	}
+	a.findPackages(w, "", r.URL.Query().Get("q"), readable)
+}
+
+// findPackages writes the packages matching a glob, in the readable repos if readable isn't nil
+func (a *API) findPackages(w http.ResponseWriter, repoName, pattern string, readable map[string]bool) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("bad pattern %s", pattern))
+		return
+	}
+	pkgs, err := a.rc.FindPackages(repoName, pattern)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	list := []*Package{}
+	for _, pkg := range pkgs {
+		if readable == nil || readable[pkg.RepoName] {
+			list = append(list, newPackage(pkg))
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// getPackage describes the package at a path in a repo
+func (a *API) getPackage(w http.ResponseWriter, r *http.Request) {
+	repo, ok := a.repo(w, r, model.RoleRead)
+	if !ok {
+		return
+	}
+	relPath := mux.Vars(r)["relpath"]
+	pkg, ok := repo.Packages[relPath]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no package at %s in repo %s", relPath, repo.Name))
+		return
+	}
+	writeJSON(w, http.StatusOK, newPackage(pkg))
+}
+
+// internalError logs an unexpected error, and writes it as the response
+func (a *API) internalError(w http.ResponseWriter, err error) {
+	log.WithField("error", err).Error("Error serving API request")
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
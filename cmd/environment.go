@@ -0,0 +1,235 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	environment    model.Environment
+	envGroupOption bool
+)
+
+// environmentCmd represents the environment command
+var environmentCmd = &cobra.Command{
+	Use:     "environment",
+	Aliases: []string{"env"},
+	Short:   "Manage the environments of a repo",
+	Long: `
+The environment subcommand manages the environments published in the comps.xml
+of a repo.  An environment is a set of package groups (see the group
+subcommand), plus optional groups that can be installed with it.`,
+}
+
+var environmentCreateCmd = &cobra.Command{
+	Use:   "create <repo_name> <environment_id>",
+	Short: "Create an environment",
+	Run:   environmentCreateFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("create command requires 2 positional arguments")
+		}
+		return nil
+	},
+}
+
+var environmentRmCmd = &cobra.Command{
+	Use:   "rm <repo_name> <environment_id>",
+	Short: "Remove an environment",
+	Run:   environmentRmFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("rm command requires 2 positional arguments")
+		}
+		return nil
+	},
+}
+
+var environmentLsCmd = &cobra.Command{
+	Use:   "ls [repo_name]",
+	Short: "List environments",
+	Long: `
+List the environments of a repo, or of all repos if no repo is given.`,
+	Run: environmentLsFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("ls command takes at most 1 positional argument")
+		}
+		return nil
+	},
+}
+
+var environmentAddCmd = &cobra.Command{
+	Use:   "add <repo_name> <environment_id> <group_id>...",
+	Short: "Add groups to an environment",
+	Run:   environmentAddFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 3 {
+			return errors.New("add command requires at least 3 positional arguments")
+		}
+		return nil
+	},
+}
+
+var environmentRemoveCmd = &cobra.Command{
+	Use:   "remove <repo_name> <environment_id> <group_id>...",
+	Short: "Remove groups from an environment",
+	Run:   environmentRemoveFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 3 {
+			return errors.New("remove command requires at least 3 positional arguments")
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(environmentCmd)
+	environmentCmd.AddCommand(environmentCreateCmd)
+	environmentCmd.AddCommand(environmentRmCmd)
+	environmentCmd.AddCommand(environmentLsCmd)
+	environmentCmd.AddCommand(environmentAddCmd)
+	environmentCmd.AddCommand(environmentRemoveCmd)
+
+	environmentCreateCmd.Flags().StringVar(&environment.Name, "name", "", "display name of the environment (default is the id)")
+	environmentCreateCmd.Flags().StringVar(&environment.Description, "description", "", "description of the environment")
+
+	environmentAddCmd.Flags().BoolVar(&envGroupOption, "optional", false, "add the groups as optional")
+}
+
+func environmentCreateFunc(cmd *cobra.Command, args []string) {
+	env := environment
+	env.RepoName, env.ID = args[0], args[1]
+	if env.Name == "" {
+		env.Name = env.ID
+	}
+	if err := rc.CreateEnvironment(&env); err != nil {
+		log.WithFields(log.Fields{
+			"repo":        env.RepoName,
+			"environment": env.ID,
+			"error":       err,
+		}).Error("Error creating environment")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo":        env.RepoName,
+		"environment": env.ID,
+	}).Info("Environment created")
+}
+
+func environmentRmFunc(cmd *cobra.Command, args []string) {
+	name, id := args[0], args[1]
+	if err := rc.RemoveEnvironment(name, id); err != nil {
+		log.WithFields(log.Fields{
+			"repo":        name,
+			"environment": id,
+			"error":       err,
+		}).Error("Error removing environment")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo":        name,
+		"environment": id,
+	}).Info("Environment removed")
+}
+
+func environmentLsFunc(cmd *cobra.Command, args []string) {
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	envs, err := rc.GetEnvironments(name)
+	if err != nil {
+		log.WithField("error", err).Error("Error retrieving environments")
+		return
+	}
+	for _, env := range envs {
+		log.Infof("REPO: %s | ID: %s | NAME: %s | GROUPS: %s | OPTIONS: %s",
+			env.RepoName, env.ID, env.Name, strings.Join(env.Groups, " "), strings.Join(env.Options, " "))
+	}
+}
+
+func environmentAddFunc(cmd *cobra.Command, args []string) {
+	name, id, groupIDs := args[0], args[1], args[2:]
+	err := rc.UpdateEnvironment(name, id, func(env *model.Environment) error {
+		for _, groupID := range groupIDs {
+			// a group is either required or optional, not both
+			env.Groups, env.Options = removeString(env.Groups, groupID), removeString(env.Options, groupID)
+			if envGroupOption {
+				env.Options = append(env.Options, groupID)
+			} else {
+				env.Groups = append(env.Groups, groupID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"repo":        name,
+			"environment": id,
+			"error":       err,
+		}).Error("Error adding groups to environment")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo":        name,
+		"environment": id,
+		"groups":      strings.Join(groupIDs, " "),
+	}).Info("Groups added to environment")
+}
+
+func environmentRemoveFunc(cmd *cobra.Command, args []string) {
+	name, id, groupIDs := args[0], args[1], args[2:]
+	err := rc.UpdateEnvironment(name, id, func(env *model.Environment) error {
+		for _, groupID := range groupIDs {
+			groups, options := removeString(env.Groups, groupID), removeString(env.Options, groupID)
+			if len(groups) == len(env.Groups) && len(options) == len(env.Options) {
+				return errors.New("group " + groupID + " is not in the environment")
+			}
+			env.Groups, env.Options = groups, options
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"repo":        name,
+			"environment": id,
+			"error":       err,
+		}).Error("Error removing groups from environment")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo":        name,
+		"environment": id,
+		"groups":      strings.Join(groupIDs, " "),
+	}).Info("Groups removed from environment")
+}
+
+// removeString returns list without any copies of s
+func removeString(list []string, s string) []string {
+	out := []string{}
+	for _, item := range list {
+		if item != s {
+			out = append(out, item)
+		}
+	}
+	return out
+}
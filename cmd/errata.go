@@ -0,0 +1,33 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// errataCmd represents the errata command
+var errataCmd = &cobra.Command{
+	Use:   "errata",
+	Short: "Manage the errata of a repo",
+	Long: `
+The errata subcommand manages the advisories published in the updateinfo.xml
+of a repo, which lets clients use 'yum updateinfo' and 'dnf --security'.  The
+repo metadata is rebuilt whenever its errata change.`,
+}
+
+func init() {
+	RootCmd.AddCommand(errataCmd)
+}
@@ -0,0 +1,132 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	advisory       model.Advisory
+	advisoryIssued string
+	advisoryRefs   stringList
+)
+
+// stringList is a repeatable flag value.  Unlike pflag's string slices, values are not split on
+// commas.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, " ") }
+func (l *stringList) Type() string   { return "stringList" }
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// errataAddCmd represents the errata add command
+var errataAddCmd = &cobra.Command{
+	Use:   "add <repo_name> <advisory_id>",
+	Short: "Add an advisory to a repo",
+	Long: `
+Add an advisory to the errata of a repo.  An existing advisory with the same ID
+is replaced.  Packages are given by NEVRA, and references as
+'type,id[,href[,title]]', for example:
+
+  roper errata add DockerRepo ROPER-2016:0001 --type security --severity Important \
+    --pkg docker-engine-1.9.1-1.el7.centos.x86_64 --ref cve,CVE-2016-0001`,
+	Run: errataAddFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("add command requires 2 positional arguments")
+		}
+		return nil
+	},
+}
+
+func init() {
+	errataCmd.AddCommand(errataAddCmd)
+
+	errataAddCmd.Flags().StringVar(&advisory.Type, "type", model.AdvisorySecurity, "advisory type ('security', 'bugfix', 'enhancement' or 'newpackage')")
+	errataAddCmd.Flags().StringVar(&advisory.Severity, "severity", "", "severity, e.g. 'Critical', 'Important', 'Moderate' or 'Low'")
+	errataAddCmd.Flags().StringVar(&advisory.Title, "title", "", "advisory title")
+	errataAddCmd.Flags().StringVar(&advisory.Description, "description", "", "advisory description")
+	errataAddCmd.Flags().StringVar(&advisoryIssued, "issued", "", "issue date, as YYYY-MM-DD or RFC 3339 (default now)")
+	errataAddCmd.Flags().StringSliceVar(&advisory.Packages, "pkg", nil, "NEVRA of a package fixed by the advisory (may be repeated)")
+	errataAddCmd.Flags().Var(&advisoryRefs, "ref", "reference as 'type,id[,href[,title]]' (may be repeated)")
+}
+
+// parseReference parses a reference given as type,id[,href[,title]]
+func parseReference(s string) (model.Reference, error) {
+	parts := strings.SplitN(s, ",", 4)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return model.Reference{}, fmt.Errorf("reference %q is not of the form type,id[,href[,title]]", s)
+	}
+	ref := model.Reference{Type: parts[0], ID: parts[1]}
+	if len(parts) > 2 {
+		ref.Href = parts[2]
+	}
+	if len(parts) > 3 {
+		ref.Title = parts[3]
+	}
+	return ref, nil
+}
+
+// parseDate parses a date given as YYYY-MM-DD or RFC 3339
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func errataAddFunc(cmd *cobra.Command, args []string) {
+	adv := advisory
+	adv.RepoName, adv.ID = args[0], args[1]
+	for _, s := range advisoryRefs {
+		ref, err := parseReference(s)
+		if err != nil {
+			log.WithField("error", err).Error("Invalid reference")
+			return
+		}
+		adv.References = append(adv.References, ref)
+	}
+	if advisoryIssued != "" {
+		issued, err := parseDate(advisoryIssued)
+		if err != nil {
+			log.WithField("error", err).Error("Invalid issue date")
+			return
+		}
+		adv.Issued = issued.Unix()
+	}
+	if err := rc.AddAdvisory(&adv); err != nil {
+		log.WithFields(log.Fields{
+			"repo":     adv.RepoName,
+			"advisory": adv.ID,
+			"error":    err,
+		}).Error("Error adding advisory")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo":     adv.RepoName,
+		"advisory": adv.ID,
+	}).Info("Advisory added")
+}
@@ -0,0 +1,67 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// errataLsCmd represents the errata ls command
+var errataLsCmd = &cobra.Command{
+	Use:   "ls [repo_name]",
+	Short: "List the errata of repos",
+	Long: `
+List the advisories of a repo, or of all repos if no repo is given.`,
+	Run: errataLsFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("ls command takes at most 1 positional argument")
+		}
+		return nil
+	},
+}
+
+func init() {
+	errataCmd.AddCommand(errataLsCmd)
+
+	errataLsCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "print out references and packages as well")
+}
+
+func errataLsFunc(cmd *cobra.Command, args []string) {
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	advs, err := rc.GetAdvisories(name)
+	if err != nil {
+		log.WithField("error", err).Error("Error retrieving errata")
+		return
+	}
+	for _, adv := range advs {
+		log.Infof("REPO: %s | ID: %s | TYPE: %s | SEVERITY: %s | ISSUED: %s | TITLE: %s",
+			adv.RepoName, adv.ID, adv.Type, adv.Severity, time.Unix(adv.Issued, 0).Format("2006-01-02"), adv.Title)
+		if verbose {
+			for _, ref := range adv.References {
+				log.Infof("REFERENCE: %s | ID: %s | HREF: %s", ref.Type, ref.ID, ref.Href)
+			}
+			log.Infof("PACKAGES: %s", strings.Join(adv.Packages, " "))
+		}
+	}
+}
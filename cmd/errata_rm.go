@@ -0,0 +1,57 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// errataRmCmd represents the errata rm command
+var errataRmCmd = &cobra.Command{
+	Use:   "rm <repo_name> <advisory_id>",
+	Short: "Remove an advisory from a repo",
+	Long: `
+Remove an advisory from the errata of a repo.`,
+	Run: errataRmFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("rm command requires 2 positional arguments")
+		}
+		return nil
+	},
+}
+
+func init() {
+	errataCmd.AddCommand(errataRmCmd)
+}
+
+func errataRmFunc(cmd *cobra.Command, args []string) {
+	name, id := args[0], args[1]
+	if err := rc.RemoveAdvisory(name, id); err != nil {
+		log.WithFields(log.Fields{
+			"repo":     name,
+			"advisory": id,
+			"error":    err,
+		}).Error("Error removing advisory")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo":     name,
+		"advisory": id,
+	}).Info("Advisory removed")
+}
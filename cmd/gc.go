@@ -0,0 +1,63 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var gcDryRun bool
+
+// gcCmd represents the gc command
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Reclaim blobs that nothing references",
+	Long: `
+Delete the blobs in the blob store (see --blob-dir) that no repo package or
+snapshot references anymore.  Repo and snapshot files are never touched.  The
+server must be down, since the database is locked while it runs.`,
+	Run: gcFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 {
+			return errors.New("gc command takes no positional arguments")
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(gcCmd)
+
+	gcCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "only list the blobs that would be deleted")
+}
+
+func gcFunc(cmd *cobra.Command, args []string) {
+	report, err := rc.GC(gcDryRun)
+	if err != nil {
+		log.WithField("error", err).Error("Error collecting blobs")
+		return
+	}
+	for _, sum := range report.Removed {
+		log.Infof("BLOB: %s", sum)
+	}
+	log.WithFields(log.Fields{
+		"blobs":   len(report.Removed),
+		"bytes":   report.Freed,
+		"dry_run": gcDryRun,
+	}).Info("Blob garbage collection finished")
+}
@@ -0,0 +1,249 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	group       model.Group
+	groupPkgReq model.GroupPackage
+)
+
+// groupCmd represents the group command
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Manage the package groups of a repo",
+	Long: `
+The group subcommand manages the package groups published in the comps.xml of
+a repo, which lets clients use 'yum groupinstall'.  Groups are combined into
+environments with the environment subcommand.  The repo metadata is rebuilt
+whenever its groups change.`,
+}
+
+var groupCreateCmd = &cobra.Command{
+	Use:   "create <repo_name> <group_id>",
+	Short: "Create a package group",
+	Run:   groupCreateFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("create command requires 2 positional arguments")
+		}
+		return nil
+	},
+}
+
+var groupRmCmd = &cobra.Command{
+	Use:   "rm <repo_name> <group_id>",
+	Short: "Remove a package group",
+	Run:   groupRmFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("rm command requires 2 positional arguments")
+		}
+		return nil
+	},
+}
+
+var groupLsCmd = &cobra.Command{
+	Use:   "ls [repo_name]",
+	Short: "List package groups",
+	Long: `
+List the package groups of a repo, or of all repos if no repo is given.`,
+	Run: groupLsFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("ls command takes at most 1 positional argument")
+		}
+		return nil
+	},
+}
+
+var groupAddCmd = &cobra.Command{
+	Use:   "add <repo_name> <group_id> <package_name>...",
+	Short: "Add packages to a group",
+	Long: `
+Add packages to a group by name.  Packages already in the group have their type
+changed.`,
+	Run: groupAddFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 3 {
+			return errors.New("add command requires at least 3 positional arguments")
+		}
+		return nil
+	},
+}
+
+var groupRemoveCmd = &cobra.Command{
+	Use:   "remove <repo_name> <group_id> <package_name>...",
+	Short: "Remove packages from a group",
+	Run:   groupRemoveFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 3 {
+			return errors.New("remove command requires at least 3 positional arguments")
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(groupCmd)
+	groupCmd.AddCommand(groupCreateCmd)
+	groupCmd.AddCommand(groupRmCmd)
+	groupCmd.AddCommand(groupLsCmd)
+	groupCmd.AddCommand(groupAddCmd)
+	groupCmd.AddCommand(groupRemoveCmd)
+
+	groupCreateCmd.Flags().StringVar(&group.Name, "name", "", "display name of the group (default is the id)")
+	groupCreateCmd.Flags().StringVar(&group.Description, "description", "", "description of the group")
+	groupCreateCmd.Flags().BoolVar(&group.Default, "default", false, "install the group by default")
+	groupCreateCmd.Flags().BoolVar(&group.UserVisible, "uservisible", true, "show the group in group lists")
+
+	groupLsCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "print out packages in groups as well")
+
+	groupAddCmd.Flags().StringVar(&groupPkgReq.Type, "type", model.GroupDefault, "package type ('mandatory', 'default', 'optional' or 'conditional')")
+	groupAddCmd.Flags().StringVar(&groupPkgReq.Requires, "requires", "", "package that pulls in conditional packages")
+}
+
+func groupCreateFunc(cmd *cobra.Command, args []string) {
+	g := group
+	g.RepoName, g.ID = args[0], args[1]
+	if g.Name == "" {
+		g.Name = g.ID
+	}
+	if err := rc.CreateGroup(&g); err != nil {
+		log.WithFields(log.Fields{
+			"repo":  g.RepoName,
+			"group": g.ID,
+			"error": err,
+		}).Error("Error creating group")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo":  g.RepoName,
+		"group": g.ID,
+	}).Info("Group created")
+}
+
+func groupRmFunc(cmd *cobra.Command, args []string) {
+	name, id := args[0], args[1]
+	if err := rc.RemoveGroup(name, id); err != nil {
+		log.WithFields(log.Fields{
+			"repo":  name,
+			"group": id,
+			"error": err,
+		}).Error("Error removing group")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo":  name,
+		"group": id,
+	}).Info("Group removed")
+}
+
+func groupLsFunc(cmd *cobra.Command, args []string) {
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	groups, err := rc.GetGroups(name)
+	if err != nil {
+		log.WithField("error", err).Error("Error retrieving groups")
+		return
+	}
+	for _, g := range groups {
+		log.Infof("REPO: %s | ID: %s | NAME: %s | PACKAGES: %d", g.RepoName, g.ID, g.Name, len(g.Packages))
+		if verbose {
+			for _, pkg := range g.Packages {
+				if pkg.Requires != "" {
+					log.Infof("PACKAGE: %s | TYPE: %s | REQUIRES: %s", pkg.Name, pkg.Type, pkg.Requires)
+				} else {
+					log.Infof("PACKAGE: %s | TYPE: %s", pkg.Name, pkg.Type)
+				}
+			}
+		}
+	}
+}
+
+func groupAddFunc(cmd *cobra.Command, args []string) {
+	name, id, pkgNames := args[0], args[1], args[2:]
+	err := rc.UpdateGroup(name, id, func(g *model.Group) error {
+		for _, pkgName := range pkgNames {
+			req := groupPkgReq
+			req.Name = pkgName
+			found := false
+			for i := range g.Packages {
+				if g.Packages[i].Name == pkgName {
+					g.Packages[i], found = req, true
+				}
+			}
+			if !found {
+				g.Packages = append(g.Packages, req)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"repo":  name,
+			"group": id,
+			"error": err,
+		}).Error("Error adding packages to group")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo":     name,
+		"group":    id,
+		"packages": strings.Join(pkgNames, " "),
+	}).Info("Packages added to group")
+}
+
+func groupRemoveFunc(cmd *cobra.Command, args []string) {
+	name, id, pkgNames := args[0], args[1], args[2:]
+	err := rc.UpdateGroup(name, id, func(g *model.Group) error {
+		for _, pkgName := range pkgNames {
+			kept := g.Packages[:0]
+			for _, pkg := range g.Packages {
+				if pkg.Name != pkgName {
+					kept = append(kept, pkg)
+				}
+			}
+			if len(kept) == len(g.Packages) {
+				return errors.New("package " + pkgName + " is not in the group")
+			}
+			g.Packages = kept
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"repo":  name,
+			"group": id,
+			"error": err,
+		}).Error("Error removing packages from group")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo":     name,
+		"group":    id,
+		"packages": strings.Join(pkgNames, " "),
+	}).Info("Packages removed from group")
+}
@@ -0,0 +1,32 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// pkgCmd represents the pkg command
+var pkgCmd = &cobra.Command{
+	Use:   "pkg",
+	Short: "Manage the packages of repos",
+	Long: `
+The pkg subcommand works with individual packages across the repos roper
+manages, such as promoting packages from one repo to another.`,
+}
+
+func init() {
+	RootCmd.AddCommand(pkgCmd)
+}
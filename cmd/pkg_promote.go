@@ -0,0 +1,123 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/controller"
+	"github.com/spf13/cobra"
+)
+
+var (
+	promoteFrom string
+	promoteTo   string
+	promoteOpts controller.PromoteOptions
+)
+
+var pkgPromoteCmd = &cobra.Command{
+	Use:   "promote <nevra|glob>",
+	Short: "Promote packages from one repo to another",
+	Long: `
+Promote the packages of a repo whose NEVRA matches a glob, such as
+'docker-engine-1.11.*', into another repo at the same path.  Files are
+hardlinked where possible, the target repo is updated in one transaction, and
+its metadata is rebuilt once.  Every promotion is recorded along with who made
+it, see 'roper pkg promotions'.`,
+	Run: pkgPromoteFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("promote command requires 1 positional argument")
+		}
+		if promoteFrom == "" || promoteTo == "" {
+			return errors.New("promote command requires --from and --to")
+		}
+		return nil
+	},
+}
+
+var pkgPromotionsCmd = &cobra.Command{
+	Use:   "promotions [repo_name]",
+	Short: "List the promotions into and out of repos",
+	Run:   pkgPromotionsFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("promotions command takes at most 1 positional argument")
+		}
+		return nil
+	},
+}
+
+func init() {
+	pkgCmd.AddCommand(pkgPromoteCmd)
+	pkgCmd.AddCommand(pkgPromotionsCmd)
+
+	pkgPromoteCmd.Flags().StringVar(&promoteFrom, "from", "", "repo to promote packages from")
+	pkgPromoteCmd.Flags().StringVar(&promoteTo, "to", "", "repo to promote packages to")
+	pkgPromoteCmd.Flags().BoolVar(&promoteOpts.WithDeps, "with-deps", false, "also promote required packages the target repo is missing")
+	pkgPromoteCmd.Flags().BoolVar(&promoteOpts.Copy, "copy", false, "copy the packages instead of hardlinking them")
+	pkgPromoteCmd.Flags().BoolVar(&promoteOpts.DryRun, "dry-run", false, "only list the packages that would be promoted")
+}
+
+// currentUser returns the name of the user running roper, for audit records
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+func pkgPromoteFunc(cmd *cobra.Command, args []string) {
+	pattern := args[0]
+	promoteOpts.User = currentUser()
+	promotion, err := rc.Promote(pattern, promoteFrom, promoteTo, promoteOpts)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"from":  promoteFrom,
+			"to":    promoteTo,
+			"error": err,
+		}).Error("Error promoting packages")
+		return
+	}
+	for _, nevra := range promotion.Packages {
+		log.Infof("PROMOTE: %s | FROM: %s | TO: %s", nevra, promoteFrom, promoteTo)
+	}
+	if len(promotion.Packages) == 0 {
+		log.WithField("repo", promoteTo).Info("Repo already has the matching packages")
+	}
+}
+
+func pkgPromotionsFunc(cmd *cobra.Command, args []string) {
+	var name string
+	if len(args) == 1 {
+		name = args[0]
+	}
+	promotions, err := rc.GetPromotions(name)
+	if err != nil {
+		log.WithField("error", err).Error("Error retrieving promotions")
+		return
+	}
+	for _, p := range promotions {
+		log.Infof("TIME: %s | USER: %s | FROM: %s | TO: %s | PATTERN: %s | PACKAGES: %d",
+			time.Unix(0, p.Time).Format(time.RFC3339), p.User, p.From, p.To, p.Pattern, len(p.Packages))
+		for _, nevra := range p.Packages {
+			log.Infof("    PACKAGE: %s", nevra)
+		}
+	}
+}
@@ -18,15 +18,21 @@ import (
 	"errors"
 	log "github.com/Sirupsen/logrus"
 
+	"github.com/alapidas/roper/model"
 	"github.com/spf13/cobra"
 )
 
+var (
+	repoBackend string
+	repoType    string
+)
+
 // addCmd represents the add command
 var repoAddCmd = &cobra.Command{
 	Use:   "add <repo_path> <repo_name>",
 	Short: "Add a repo to roper",
 	Long: `
-Add a given yum repository at a given path on the filesystem to roper using the provided name.`,
+Add a given yum, apt, helm, proxy or virtual repository at a given path on the filesystem to roper using the provided name.`,
 	Run: repoAddFunc,
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) != 2 {
@@ -49,6 +55,17 @@ func init() {
 	// is called directly, e.g.:
 	// addCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
+	repoAddCmd.Flags().StringVar(&repoType, "type", model.TypeYum, "type of repo ('yum', 'apt', 'helm', 'proxy' or 'virtual')")
+	repoAddCmd.Flags().StringVar(&repoBackend, "backend", model.BackendNative, "metadata backend to use for the repo ('native' or 'createrepo')")
+	addCreaterepoFlags(repoAddCmd.Flags())
+	addSigningFlags(repoAddCmd.Flags())
+	addVerifyFlags(repoAddCmd.Flags())
+	addRetentionFlags(repoAddCmd.Flags())
+	addAptFlags(repoAddCmd.Flags())
+	addHelmFlags(repoAddCmd.Flags())
+	addProxyFlags(repoAddCmd.Flags())
+	addVirtualFlags(repoAddCmd.Flags())
+	addAccessFlags(repoAddCmd.Flags())
 }
 
 func repoAddFunc(cmd *cobra.Command, args []string) {
@@ -59,7 +76,8 @@ func repoAddFunc(cmd *cobra.Command, args []string) {
 	//repoMap["TestEpel"] = "/Users/alapidas/goWorkspace/src/github.com/alapidas/roper/hack/test_repos/epel"
 	//repoMap["Docker"] = "/Users/alapidas/goWorkspace/src/github.com/alapidas/roper/hack/test_repos/docker/7"
 
-	if err := rc.Discover(name, path); err != nil {
+	repo := &model.Repo{Name: name, AbsPath: path, Backend: repoBackend, Createrepo: crOpts, Signing: signOpts, Verify: verifyOpts, Retention: retainOpts, Type: repoType, Apt: aptOpts, Helm: helmOpts, Proxy: proxyOpts, Virtual: virtOpts, Access: accessOpts}
+	if err := rc.AddRepo(repo); err != nil {
 		log.WithFields(log.Fields{
 			"name": name,
 			"path": path,
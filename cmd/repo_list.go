@@ -61,8 +61,8 @@ func repoLsFunc(cmd *cobra.Command, args []string) {
 	for _, repo := range repos {
 		log.Infof("NAME: %s | PATH: %s", repo.Name, repo.AbsPath)
 		if verbose {
-			for pkg, _ := range repo.Packages {
-				log.Infof("PACKAGE: %s", pkg)
+			for path, pkg := range repo.Packages {
+				log.Infof("PACKAGE: %s | NEVRA: %s | SHA256: %s", path, pkg.NEVRA(), pkg.Checksum)
 			}
 		}
 	}
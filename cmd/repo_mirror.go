@@ -0,0 +1,101 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mirrorPath   string
+	mirrorDelete bool
+)
+
+// mirrorCmd represents the mirror command
+var repoMirrorCmd = &cobra.Command{
+	Use:   "mirror <url> <repo_name>",
+	Short: "Mirror a remote yum repo",
+	Long: `
+Add a repo that mirrors the remote yum repo at the given url.  The packages
+of the remote repo are downloaded into the repo's path, and roper serve
+keeps the mirror in sync (see --mirror-interval).  Downloads are checked
+against the checksums in the remote metadata, and interrupted downloads
+are resumed on the next sync.`,
+	Run: repoMirrorFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("mirror command requires 2 positional arguments")
+		}
+		return nil
+	},
+}
+
+func init() {
+	repoCmd.AddCommand(repoMirrorCmd)
+
+	repoMirrorCmd.Flags().StringVar(&mirrorPath, "path", "", "directory to download the packages to (default is mirrors/<repo_name> next to the database)")
+	repoMirrorCmd.Flags().BoolVar(&mirrorDelete, "delete", false, "delete packages that are removed from the remote repo")
+	repoMirrorCmd.Flags().StringVar(&repoBackend, "backend", model.BackendNative, "metadata backend to use for the repo ('native' or 'createrepo')")
+	addCreaterepoFlags(repoMirrorCmd.Flags())
+	addSigningFlags(repoMirrorCmd.Flags())
+	addVerifyFlags(repoMirrorCmd.Flags())
+	addRetentionFlags(repoMirrorCmd.Flags())
+}
+
+func repoMirrorFunc(cmd *cobra.Command, args []string) {
+	url := args[0]
+	name := args[1]
+	path := mirrorPath
+	if path == "" {
+		path = filepath.Join(filepath.Dir(dbPath), "mirrors", name)
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		log.WithField("error", err).Error("Unable to get absolute path of mirror")
+		return
+	}
+
+	repo := &model.Repo{
+		Name:       name,
+		AbsPath:    path,
+		Backend:    repoBackend,
+		Createrepo: crOpts,
+		Signing:    signOpts,
+		Verify:     verifyOpts,
+		Retention:  retainOpts,
+		Mirror:     model.MirrorOptions{URL: url, Delete: mirrorDelete},
+	}
+	report, err := rc.AddMirror(repo)
+	if report != nil {
+		logMirrorReport(name, report)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"name":  name,
+			"url":   url,
+			"error": err,
+		}).Error("Unable to mirror repo")
+		return
+	}
+	log.WithFields(log.Fields{
+		"name": name,
+		"path": path,
+	}).Info("Repo mirrored")
+}
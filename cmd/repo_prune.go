@@ -0,0 +1,84 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneDryRun bool
+)
+
+// pruneCmd represents the prune command
+var repoPruneCmd = &cobra.Command{
+	Use:   "prune [repo_name]",
+	Short: "Prune old packages from repos",
+	Long: `
+Delete the packages that fall outside of a repo's retention policy (see
+--keep-last and --max-age-days), and rebuild its metadata.  All repos with a
+retention policy are pruned if no repo is given.`,
+	Run: repoPruneFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("prune command takes at most 1 positional argument")
+		}
+		return nil
+	},
+}
+
+func init() {
+	repoCmd.AddCommand(repoPruneCmd)
+
+	repoPruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "only print the packages that would be pruned")
+}
+
+func repoPruneFunc(cmd *cobra.Command, args []string) {
+	var names []string
+	if len(args) == 1 {
+		names = args
+	} else {
+		repos, err := rc.GetRepos()
+		if err != nil {
+			log.WithField("error", err).Error("Error retrieving repos")
+			return
+		}
+		for _, repo := range repos {
+			if repo.Retention.Enabled() {
+				names = append(names, repo.Name)
+			}
+		}
+	}
+	for _, name := range names {
+		pruned, err := rc.Prune(name, pruneDryRun)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"repo":  name,
+				"error": err,
+			}).Error("Error pruning repo")
+			return
+		}
+		for _, pkg := range pruned {
+			if pruneDryRun {
+				log.Infof("WOULD PRUNE: %s | PACKAGE: %s | NEVRA: %s", name, pkg.RelPath, pkg.NEVRA())
+			} else {
+				log.Infof("PRUNED: %s | PACKAGE: %s | NEVRA: %s", name, pkg.RelPath, pkg.NEVRA())
+			}
+		}
+	}
+}
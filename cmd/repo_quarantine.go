@@ -0,0 +1,133 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// quarantineCmd represents the quarantine command
+var repoQuarantineCmd = &cobra.Command{
+	Use:   "quarantine",
+	Short: "Inspect and release quarantined packages",
+	Long: `
+Packages added to a repo that verifies signatures (see --verify-keyring) are
+quarantined if they are unsigned or signed with an unknown key.  Quarantined
+packages are left on disk, but are not published in the repo metadata until
+they are approved.`,
+}
+
+var repoQuarantineLsCmd = &cobra.Command{
+	Use:   "ls [repo_name]",
+	Short: "List quarantined packages",
+	Long: `
+List the quarantined packages of a repo, or of all repos if no repo is given.`,
+	Run: repoQuarantineLsFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("ls command takes at most 1 positional argument")
+		}
+		return nil
+	},
+}
+
+var repoQuarantineApproveCmd = &cobra.Command{
+	Use:   "approve <repo_name> <package_path>",
+	Short: "Release a quarantined package into its repo",
+	Long: `
+Add a quarantined package to its repo and rebuild the repo metadata.  The path
+is relative to the root of the repo, as shown by 'quarantine ls'.`,
+	Run: repoQuarantineApproveFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("approve command requires 2 positional arguments")
+		}
+		return nil
+	},
+}
+
+var repoQuarantineRejectCmd = &cobra.Command{
+	Use:   "reject <repo_name> <package_path>",
+	Short: "Delete a quarantined package",
+	Long: `
+Delete a quarantined package from disk.  The path is relative to the root of
+the repo, as shown by 'quarantine ls'.`,
+	Run: repoQuarantineRejectFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("reject command requires 2 positional arguments")
+		}
+		return nil
+	},
+}
+
+func init() {
+	repoCmd.AddCommand(repoQuarantineCmd)
+	repoQuarantineCmd.AddCommand(repoQuarantineLsCmd)
+	repoQuarantineCmd.AddCommand(repoQuarantineApproveCmd)
+	repoQuarantineCmd.AddCommand(repoQuarantineRejectCmd)
+}
+
+func repoQuarantineLsFunc(cmd *cobra.Command, args []string) {
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	pkgs, err := rc.GetQuarantine(name)
+	if err != nil {
+		log.WithField("error", err).Error("Error retrieving quarantined packages")
+		return
+	}
+	for _, pkg := range pkgs {
+		log.Infof("REPO: %s | PACKAGE: %s | NEVRA: %s | SINCE: %s | REASON: %s",
+			pkg.RepoName, pkg.RelPath, pkg.NEVRA(), time.Unix(pkg.Time, 0).Format(time.RFC3339), pkg.Reason)
+	}
+}
+
+func repoQuarantineApproveFunc(cmd *cobra.Command, args []string) {
+	name, path := args[0], args[1]
+	if err := rc.ApproveQuarantined(name, path); err != nil {
+		log.WithFields(log.Fields{
+			"repo":  name,
+			"path":  path,
+			"error": err,
+		}).Error("Error approving package")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo": name,
+		"path": path,
+	}).Info("Package approved")
+}
+
+func repoQuarantineRejectFunc(cmd *cobra.Command, args []string) {
+	name, path := args[0], args[1]
+	if err := rc.RejectQuarantined(name, path); err != nil {
+		log.WithFields(log.Fields{
+			"repo":  name,
+			"path":  path,
+			"error": err,
+		}).Error("Error rejecting package")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo": name,
+		"path": path,
+	}).Info("Package rejected")
+}
@@ -0,0 +1,287 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/alapidas/roper/model"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	crOpts     model.CreaterepoOptions
+	signOpts   model.SigningOptions
+	verifyOpts model.VerifyOptions
+	retainOpts model.RetentionOptions
+	aptOpts    model.AptOptions
+	helmOpts   model.HelmOptions
+	proxyOpts  model.ProxyOptions
+	virtOpts   model.VirtualOptions
+	accessOpts model.AccessOptions
+)
+
+// setCmd represents the set command
+var repoSetCmd = &cobra.Command{
+	Use:   "set <repo_name>",
+	Short: "Change the settings of a repo",
+	Long: `
+Change the metadata settings of a repo that roper already manages.  Only the
+flags that are given are changed, and the repo metadata is rebuilt afterwards.`,
+	Run: repoSetFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("set command requires 1 positional argument")
+		}
+		return nil
+	},
+}
+
+func init() {
+	repoCmd.AddCommand(repoSetCmd)
+
+	repoSetCmd.Flags().StringVar(&repoBackend, "backend", model.BackendNative, "metadata backend to use for the repo ('native' or 'createrepo')")
+	addCreaterepoFlags(repoSetCmd.Flags())
+	addSigningFlags(repoSetCmd.Flags())
+	addVerifyFlags(repoSetCmd.Flags())
+	addRetentionFlags(repoSetCmd.Flags())
+	addAptFlags(repoSetCmd.Flags())
+	addHelmFlags(repoSetCmd.Flags())
+	addProxyFlags(repoSetCmd.Flags())
+	addVirtualFlags(repoSetCmd.Flags())
+	addAccessFlags(repoSetCmd.Flags())
+}
+
+// addAccessFlags adds the flags for who can read a repo to a flag set
+func addAccessFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&accessOpts.Private, "private", false, "only serve the repo to users with a role on it (see 'roper user grant')")
+	flags.StringSliceVar(&accessOpts.ClientCerts, "client-cert", nil, "client certificates that can read a private repo, like 'ou:build', 'cn:*.example.com', 'san:host1.example.com' or 'subject:CN=host1' (may be repeated)")
+}
+
+// applyAccessFlags copies the access options given on the command line onto a repo.  Only flags that
+// were actually set are copied.
+func applyAccessFlags(flags *pflag.FlagSet, repo *model.Repo) {
+	if flags.Changed("private") {
+		repo.Access.Private = accessOpts.Private
+	}
+	if flags.Changed("client-cert") {
+		repo.Access.ClientCerts = accessOpts.ClientCerts
+	}
+}
+
+// addVirtualFlags adds the flags for the per-repo virtual repo options to a flag set
+func addVirtualFlags(flags *pflag.FlagSet) {
+	flags.StringSliceVar(&virtOpts.Members, "members", nil, "repos a virtual repo merges, highest priority first")
+	flags.StringVar(&virtOpts.Resolve, "resolve", model.ResolvePriority, "how a virtual repo resolves packages in more than one member ('priority' or 'newest')")
+}
+
+// applyVirtualFlags copies the virtual repo options given on the command line onto a repo.  Only
+// flags that were actually set are copied.
+func applyVirtualFlags(flags *pflag.FlagSet, repo *model.Repo) {
+	if flags.Changed("members") {
+		repo.Virtual.Members = virtOpts.Members
+	}
+	if flags.Changed("resolve") {
+		repo.Virtual.Resolve = virtOpts.Resolve
+	}
+}
+
+// addProxyFlags adds the flags for the per-repo proxy options to a flag set
+func addProxyFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&proxyOpts.URL, "proxy-url", "", "url of the remote yum repo a proxy repo fetches from")
+	flags.DurationVar(&proxyOpts.MetadataTTL, "metadata-ttl", 0, "how long a proxy repo uses remote metadata before checking for new metadata (default 1h)")
+	flags.DurationVar(&proxyOpts.NegativeTTL, "negative-ttl", 0, "how long a proxy repo remembers files the remote repo doesn't have (default 5m)")
+}
+
+// applyProxyFlags copies the proxy options given on the command line onto a repo.  Only flags that
+// were actually set are copied.
+func applyProxyFlags(flags *pflag.FlagSet, repo *model.Repo) {
+	if flags.Changed("proxy-url") {
+		repo.Proxy.URL = proxyOpts.URL
+	}
+	if flags.Changed("metadata-ttl") {
+		repo.Proxy.MetadataTTL = proxyOpts.MetadataTTL
+	}
+	if flags.Changed("negative-ttl") {
+		repo.Proxy.NegativeTTL = proxyOpts.NegativeTTL
+	}
+}
+
+// addHelmFlags adds the flags for the per-repo helm options to a flag set
+func addHelmFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&helmOpts.URL, "helm-url", "", "base url of a helm repo's charts in index.yaml (default is relative urls)")
+}
+
+// applyHelmFlags copies the helm options given on the command line onto a repo.  Only flags that were
+// actually set are copied.
+func applyHelmFlags(flags *pflag.FlagSet, repo *model.Repo) {
+	if flags.Changed("helm-url") {
+		repo.Helm.URL = helmOpts.URL
+	}
+}
+
+// addAptFlags adds the flags for the per-repo apt options to a flag set
+func addAptFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&aptOpts.Suite, "suite", "", "suite to publish an apt repo as (default \"stable\")")
+	flags.StringVar(&aptOpts.Codename, "codename", "", "codename of an apt repo (default is the suite)")
+	flags.StringVar(&aptOpts.Component, "component", "", "component to publish the packages of an apt repo under (default \"main\")")
+	flags.StringVar(&aptOpts.Origin, "origin", "", "Origin field of an apt repo's Release file")
+	flags.StringVar(&aptOpts.Label, "label", "", "Label field of an apt repo's Release file")
+	flags.StringSliceVar(&aptOpts.Architectures, "architectures", nil, "architectures to publish indexes for (default is those of the packages)")
+}
+
+// applyAptFlags copies the apt options given on the command line onto a repo.  Only flags that were
+// actually set are copied.
+func applyAptFlags(flags *pflag.FlagSet, repo *model.Repo) {
+	if flags.Changed("suite") {
+		repo.Apt.Suite = aptOpts.Suite
+	}
+	if flags.Changed("codename") {
+		repo.Apt.Codename = aptOpts.Codename
+	}
+	if flags.Changed("component") {
+		repo.Apt.Component = aptOpts.Component
+	}
+	if flags.Changed("origin") {
+		repo.Apt.Origin = aptOpts.Origin
+	}
+	if flags.Changed("label") {
+		repo.Apt.Label = aptOpts.Label
+	}
+	if flags.Changed("architectures") {
+		repo.Apt.Architectures = aptOpts.Architectures
+	}
+}
+
+// addRetentionFlags adds the flags for the per-repo retention policy to a flag set
+func addRetentionFlags(flags *pflag.FlagSet) {
+	flags.IntVar(&retainOpts.KeepLast, "keep-last", 0, "number of versions of each package name.arch to keep (0 keeps all)")
+	flags.IntVar(&retainOpts.MaxAgeDays, "max-age-days", 0, "prune versions added to the repo more than this many days ago, except the newest (0 disables)")
+}
+
+// applyRetentionFlags copies the retention policy given on the command line onto a repo.  Only flags
+// that were actually set are copied.
+func applyRetentionFlags(flags *pflag.FlagSet, repo *model.Repo) {
+	if flags.Changed("keep-last") {
+		repo.Retention.KeepLast = retainOpts.KeepLast
+	}
+	if flags.Changed("max-age-days") {
+		repo.Retention.MaxAgeDays = retainOpts.MaxAgeDays
+	}
+}
+
+// addVerifyFlags adds the flags for the per-repo package verification options to a flag set
+func addVerifyFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&verifyOpts.Keyring, "verify-keyring", "", "keyring file with the public keys packages must be signed with (empty disables verification)")
+}
+
+// applyVerifyFlags copies the verification options given on the command line onto a repo.  Only
+// flags that were actually set are copied.
+func applyVerifyFlags(flags *pflag.FlagSet, repo *model.Repo) {
+	if flags.Changed("verify-keyring") {
+		repo.Verify.Keyring = verifyOpts.Keyring
+	}
+}
+
+// addSigningFlags adds the flags for the per-repo signing options to a flag set
+func addSigningFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&signOpts.Keyring, "sign-keyring", "", "keyring file with the private key to sign repomd.xml with (empty disables signing)")
+	flags.StringVar(&signOpts.KeyID, "sign-keyid", "", "id of the key to sign with (default is the signing.keyid config value)")
+}
+
+// applySigningFlags copies the signing options given on the command line onto a repo.  Only flags
+// that were actually set are copied.
+func applySigningFlags(flags *pflag.FlagSet, repo *model.Repo) {
+	if flags.Changed("sign-keyring") {
+		repo.Signing.Keyring = signOpts.Keyring
+	}
+	if flags.Changed("sign-keyid") {
+		repo.Signing.KeyID = signOpts.KeyID
+	}
+}
+
+// addCreaterepoFlags adds the flags for the per-repo createrepo options to a flag set
+func addCreaterepoFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&crOpts.Update, "update", false, "only update metadata for changed packages (createrepo backend only, the native backend always does)")
+	flags.StringVar(&crOpts.CacheDir, "cachedir", "", "directory to keep the checksum cache in (createrepo backend only)")
+	flags.StringVar(&crOpts.Checksum, "checksum", "", "checksum type to use in the metadata (native backend only supports sha256)")
+	flags.IntVar(&crOpts.Workers, "workers", 0, "number of workers reading packages")
+	flags.BoolVar(&crOpts.Deltas, "deltas", false, "generate delta rpms (createrepo backend only)")
+	flags.StringVar(&crOpts.GroupFile, "groupfile", "", "comps.xml group file to include, absolute or relative to the repo")
+	flags.StringSliceVar(&crOpts.Distro, "distro", nil, "distro tag, optionally as 'cpeid,tag' (may be repeated)")
+	flags.StringSliceVar(&crOpts.Content, "content", nil, "content tag (may be repeated)")
+	flags.IntVar(&crOpts.RetainOldMD, "retain-old-md", 0, "number of old metadata files to keep")
+}
+
+// applyCreaterepoFlags copies the createrepo options given on the command line onto a repo.  Only
+// flags that were actually set are copied.
+func applyCreaterepoFlags(flags *pflag.FlagSet, repo *model.Repo) {
+	opts := &repo.Createrepo
+	if flags.Changed("backend") {
+		repo.Backend = repoBackend
+	}
+	if flags.Changed("update") {
+		opts.Update = crOpts.Update
+	}
+	if flags.Changed("cachedir") {
+		opts.CacheDir = crOpts.CacheDir
+	}
+	if flags.Changed("checksum") {
+		opts.Checksum = crOpts.Checksum
+	}
+	if flags.Changed("workers") {
+		opts.Workers = crOpts.Workers
+	}
+	if flags.Changed("deltas") {
+		opts.Deltas = crOpts.Deltas
+	}
+	if flags.Changed("groupfile") {
+		opts.GroupFile = crOpts.GroupFile
+	}
+	if flags.Changed("distro") {
+		opts.Distro = crOpts.Distro
+	}
+	if flags.Changed("content") {
+		opts.Content = crOpts.Content
+	}
+	if flags.Changed("retain-old-md") {
+		opts.RetainOldMD = crOpts.RetainOldMD
+	}
+}
+
+func repoSetFunc(cmd *cobra.Command, args []string) {
+	name := args[0]
+	err := rc.UpdateRepoSettings(name, func(repo *model.Repo) {
+		applyCreaterepoFlags(cmd.Flags(), repo)
+		applySigningFlags(cmd.Flags(), repo)
+		applyVerifyFlags(cmd.Flags(), repo)
+		applyRetentionFlags(cmd.Flags(), repo)
+		applyAptFlags(cmd.Flags(), repo)
+		applyHelmFlags(cmd.Flags(), repo)
+		applyProxyFlags(cmd.Flags(), repo)
+		applyVirtualFlags(cmd.Flags(), repo)
+		applyAccessFlags(cmd.Flags(), repo)
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"repo":  name,
+			"error": err,
+		}).Error("Error changing repo settings")
+		return
+	}
+	log.WithField("repo", name).Info("Repo settings changed")
+}
@@ -0,0 +1,175 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	snapshotLabel   string
+	snapshotVerbose bool
+)
+
+// snapshotCmd represents the snapshot command
+var repoSnapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Manage frozen snapshots of repos",
+	Long: `
+A snapshot freezes the packages and metadata of a repo at a point in time.
+The files are copied into the snapshot store (see --snapshot-dir), as reflinks
+on filesystems that support them, or hardlinks to the read-only blobs when the blob
+store is on, and roper serve publishes each snapshot at /<repo_name>/snapshots/<id>/.`,
+}
+
+var repoSnapshotCreateCmd = &cobra.Command{
+	Use:   "create <repo_name>",
+	Short: "Snapshot a repo",
+	Run:   repoSnapshotCreateFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("create command requires 1 positional argument")
+		}
+		return nil
+	},
+}
+
+var repoSnapshotLsCmd = &cobra.Command{
+	Use:   "ls <repo_name>",
+	Short: "List the snapshots of a repo",
+	Run:   repoSnapshotLsFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("ls command requires 1 positional argument")
+		}
+		return nil
+	},
+}
+
+var repoSnapshotRmCmd = &cobra.Command{
+	Use:   "rm <repo_name> <snapshot>",
+	Short: "Delete a snapshot",
+	Long: `
+Delete a snapshot of a repo, given by id or label.`,
+	Run: repoSnapshotRmFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("rm command requires 2 positional arguments")
+		}
+		return nil
+	},
+}
+
+var repoSnapshotDiffCmd = &cobra.Command{
+	Use:   "diff <repo_name> <from_snapshot> [to_snapshot]",
+	Short: "Show the packages added and removed between snapshots",
+	Long: `
+Compare two snapshots of a repo, given by id or label.  If only one snapshot
+is given, it is compared to the repo as it is now.`,
+	Run: repoSnapshotDiffFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 && len(args) != 3 {
+			return errors.New("diff command requires 2 or 3 positional arguments")
+		}
+		return nil
+	},
+}
+
+func init() {
+	repoCmd.AddCommand(repoSnapshotCmd)
+	repoSnapshotCmd.AddCommand(repoSnapshotCreateCmd)
+	repoSnapshotCmd.AddCommand(repoSnapshotLsCmd)
+	repoSnapshotCmd.AddCommand(repoSnapshotRmCmd)
+	repoSnapshotCmd.AddCommand(repoSnapshotDiffCmd)
+
+	repoSnapshotCreateCmd.Flags().StringVar(&snapshotLabel, "label", "", "name for the snapshot, which can be used in place of its id")
+	repoSnapshotLsCmd.Flags().BoolVarP(&snapshotVerbose, "verbose", "v", false, "also list the packages of each snapshot")
+}
+
+func repoSnapshotCreateFunc(cmd *cobra.Command, args []string) {
+	name := args[0]
+	snap, err := rc.CreateSnapshot(name, snapshotLabel)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"repo":  name,
+			"error": err,
+		}).Error("Error creating snapshot")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo":  name,
+		"id":    snap.ID,
+		"label": snap.Label,
+	}).Info("Snapshot created")
+}
+
+func repoSnapshotLsFunc(cmd *cobra.Command, args []string) {
+	name := args[0]
+	snaps, err := rc.GetSnapshots(name)
+	if err != nil {
+		log.WithField("error", err).Error("Error retrieving snapshots")
+		return
+	}
+	for _, snap := range snaps {
+		log.Infof("REPO: %s | SNAPSHOT: %d | LABEL: %s | CREATED: %s | PACKAGES: %d",
+			name, snap.ID, snap.Label, time.Unix(snap.Created, 0).Format(time.RFC3339), len(snap.Packages))
+		if snapshotVerbose {
+			for _, pkg := range snap.Packages {
+				log.Infof("    PACKAGE: %s | NEVRA: %s", pkg.RelPath, pkg.NEVRA)
+			}
+		}
+	}
+}
+
+func repoSnapshotRmFunc(cmd *cobra.Command, args []string) {
+	name, ref := args[0], args[1]
+	if err := rc.RemoveSnapshot(name, ref); err != nil {
+		log.WithFields(log.Fields{
+			"repo":     name,
+			"snapshot": ref,
+			"error":    err,
+		}).Error("Error removing snapshot")
+		return
+	}
+	log.WithFields(log.Fields{
+		"repo":     name,
+		"snapshot": ref,
+	}).Info("Snapshot removed")
+}
+
+func repoSnapshotDiffFunc(cmd *cobra.Command, args []string) {
+	name, from, to := args[0], args[1], ""
+	if len(args) == 3 {
+		to = args[2]
+	}
+	added, removed, err := rc.DiffSnapshots(name, from, to)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"repo":  name,
+			"error": err,
+		}).Error("Error comparing snapshots")
+		return
+	}
+	for _, pkg := range removed {
+		log.Infof("REMOVED: %s | NEVRA: %s", pkg.RelPath, pkg.NEVRA)
+	}
+	for _, pkg := range added {
+		log.Infof("ADDED: %s | NEVRA: %s", pkg.RelPath, pkg.NEVRA)
+	}
+}
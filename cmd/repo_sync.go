@@ -0,0 +1,86 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/mirror"
+	"github.com/spf13/cobra"
+)
+
+// syncCmd represents the sync command
+var repoSyncCmd = &cobra.Command{
+	Use:   "sync [repo_name]",
+	Short: "Sync mirrored repos with their remote repos",
+	Long: `
+Download the new packages of a mirrored repo's remote repo, and rebuild
+its metadata.  All mirrored repos are synced if no repo is given.`,
+	Run: repoSyncFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("sync command takes at most 1 positional argument")
+		}
+		return nil
+	},
+}
+
+func init() {
+	repoCmd.AddCommand(repoSyncCmd)
+}
+
+func repoSyncFunc(cmd *cobra.Command, args []string) {
+	var names []string
+	if len(args) == 1 {
+		names = args
+	} else {
+		repos, err := rc.GetRepos()
+		if err != nil {
+			log.WithField("error", err).Error("Error retrieving repos")
+			return
+		}
+		for _, repo := range repos {
+			if repo.Mirror.Enabled() {
+				names = append(names, repo.Name)
+			}
+		}
+	}
+	for _, name := range names {
+		report, err := rc.SyncMirror(name)
+		if report != nil {
+			logMirrorReport(name, report)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"repo":  name,
+				"error": err,
+			}).Error("Error syncing repo")
+		}
+	}
+}
+
+// logMirrorReport logs what a mirror sync changed
+func logMirrorReport(name string, report *mirror.Report) {
+	for _, path := range report.Downloaded {
+		log.Infof("DOWNLOADED: %s | PACKAGE: %s", name, path)
+	}
+	for _, path := range report.Deleted {
+		log.Infof("DELETED: %s | PACKAGE: %s", name, path)
+	}
+	for path, err := range report.Failed {
+		log.Warnf("FAILED: %s | PACKAGE: %s | ERROR: %s", name, path, err)
+	}
+}
@@ -15,11 +15,14 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -50,6 +53,16 @@ against them (if desired) when changes are detected.`,
 		if err != nil {
 			log.Fatalf("Unable to initialize application: %s", err)
 		}
+		if dir := viper.GetString("snapshot_dir"); dir != "" {
+			rc.SetSnapshotDir(dir)
+		}
+		if err = rc.SetBlobStore(viper.GetString("blob_dir"), viper.GetString("blob_link")); err != nil {
+			log.Fatalf("Unable to initialize application: %s", err)
+		}
+		rc.SetSigningConfig(controller.SigningConfig{
+			KeyID:      viper.GetString("signing.keyid"),
+			Passphrase: signingPassphrase,
+		})
 	},
 	PersistentPostRun: func(cmd *cobra.Command, args []string) {
 		// close db
@@ -93,11 +106,42 @@ func init() {
 	defaultCrPath, _ := exec.LookPath("createrepo")
 	RootCmd.PersistentFlags().StringVar(&crPath, "createrepo_path", defaultCrPath, "path to the 'createrepo' executable")
 
+	RootCmd.PersistentFlags().String("snapshot-dir", "", "dir to store repo snapshots in (default is snapshots next to the database)")
+	viper.BindPFlag("snapshot_dir", RootCmd.PersistentFlags().Lookup("snapshot-dir"))
+	RootCmd.PersistentFlags().String("blob-dir", "", "dir of the blob store that deduplicates package files across repos (empty disables the store)")
+	viper.BindPFlag("blob_dir", RootCmd.PersistentFlags().Lookup("blob-dir"))
+	RootCmd.PersistentFlags().String("blob-link", controller.BlobHardlink, "how repo files link to the blob store ('hardlink' or 'reflink')")
+	viper.BindPFlag("blob_link", RootCmd.PersistentFlags().Lookup("blob-link"))
+
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
+// signingPassphrase looks up the passphrase for the signing key.  The first config value that is set
+// wins: signing.passphrase_env names an environment variable holding the passphrase,
+// signing.passphrase_file names a file holding it, and signing.passphrase is the passphrase itself.
+func signingPassphrase(keyID string) ([]byte, error) {
+	if env := viper.GetString("signing.passphrase_env"); env != "" {
+		pass, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, fmt.Errorf("passphrase environment variable %s is not set", env)
+		}
+		return []byte(pass), nil
+	}
+	if path := viper.GetString("signing.passphrase_file"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read passphrase file: %s", err)
+		}
+		return bytes.TrimRight(data, "\r\n"), nil
+	}
+	if pass := viper.GetString("signing.passphrase"); pass != "" {
+		return []byte(pass), nil
+	}
+	return nil, nil
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if cfgFile != "" { // enable ability to specify config file via flag
@@ -107,6 +151,8 @@ func initConfig() {
 	viper.SetConfigName(".roper") // name of config file (without extension)
 	viper.AddConfigPath("$HOME")  // adding home directory as first search path
 	viper.AutomaticEnv()          // read in environment variables that match
+	// nested keys like tls.cert are read from environment variables like TLS_CERT
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
@@ -16,26 +16,60 @@ package cmd
 
 import (
 	log "github.com/Sirupsen/logrus"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
+	"unicode"
 
+	"github.com/alapidas/roper/api"
+	"github.com/alapidas/roper/controller"
 	"github.com/alapidas/roper/interfaces"
+	"github.com/alapidas/roper/model"
+	"github.com/spf13/cast"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 )
 
 type webserverDirConfigs struct {
 	configs []interfaces.DirConfig
+	routes  map[string]http.Handler
 }
 
 type webserverDirConfig struct {
 	absPath  string
 	topLevel string
+	fallback http.Handler
+	repo     string // name of the repo the dir belongs to
 }
 
 func (ws webserverDirConfigs) Configs() []interfaces.DirConfig { return ws.configs }
+func (ws webserverDirConfigs) Routes() map[string]http.Handler { return ws.routes }
 func (w webserverDirConfig) AbsPath() string                   { return w.absPath }
 func (w webserverDirConfig) TopLevel() string                  { return w.topLevel }
+func (w webserverDirConfig) Fallback() http.Handler            { return w.fallback }
+
+// Authorize only serves the files of private repos to users that can read them
+func (w webserverDirConfig) Authorize(r *http.Request) int {
+	_, err := rc.Authorize(r, w.repo, model.RoleRead)
+	switch err {
+	case nil:
+		return 0
+	case controller.ErrUnauthenticated:
+		return http.StatusUnauthorized
+	case controller.ErrForbidden:
+		return http.StatusForbidden
+	}
+	log.WithFields(log.Fields{
+		"repo":  w.repo,
+		"error": err,
+	}).Error("Error authorizing request")
+	return http.StatusInternalServerError
+}
 
 // serveCmd represents the serve command
 var serveCmd = &cobra.Command{
@@ -56,7 +90,7 @@ your repos and monitor them for changes.
 		// TODO: Make this buffered and handle multiple errors coming in on it.  Only handles one error, then exits now.
 		errChan := make(chan error, 1)
 		signalChan := make(chan os.Signal, 1)
-		signal.Notify(signalChan, os.Interrupt)
+		signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
 		log.Infof("Starting Server")
 
@@ -66,22 +100,43 @@ your repos and monitor them for changes.
 		}
 
 		// start web server
-		dirConfigs := webserverDirConfigs{}
+		dirConfigs := webserverDirConfigs{routes: map[string]http.Handler{
+			api.Prefix: api.New(rc, api.Config{
+				Uploads:       viper.GetBool("api.uploads"),
+				MaxUploadSize: int64(viper.GetInt("api.max_upload_size")),
+			}),
+			interfaces.UIPrefix: interfaces.NewUI(rc),
+		}}
+		router := interfaces.NewRouter(dirConfigs)
 		for _, repo := range repos {
-			dirConfigs.configs = append(dirConfigs.configs, webserverDirConfig{
-				topLevel: repo.Name,
-				absPath:  repo.AbsPath,
-			})
+			router.Set(repo.Name, repoDirConfigs(repo))
 		}
+		// repos added, changed or removed while serving are picked up without a restart
+		rc.AddRepoListener(func(event controller.RepoEvent) {
+			if event.Type == controller.RepoRemoved {
+				router.Remove(event.Name)
+				return
+			}
+			router.Set(event.Name, repoDirConfigs(event.Repo))
+		})
+		wg.Add(1)
 		go func() {
-			wg.Add(1)
 			defer wg.Done()
-			interfaces.StartWeb(shutdownChan, errChan, dirConfigs)
+			interfaces.StartWeb(shutdownChan, errChan, router, interfaces.ListenConfig{
+				Addrs:         configList(cmd.Flags(), "listen", "listen"),
+				TLSCert:       viper.GetString("tls.cert"),
+				TLSKey:        viper.GetString("tls.key"),
+				RedirectAddrs: configList(cmd.Flags(), "redirect-http", "tls.redirect"),
+				ClientCA:      viper.GetString("tls.client_ca"),
+				ClientAuth:    viper.GetString("tls.client_auth"),
+			})
 		}()
 
 		// start repo watchers
+		rc.SetPruneInterval(viper.GetDuration("prune_interval"))
+		rc.SetMirrorInterval(viper.GetDuration("mirror_interval"))
+		wg.Add(1)
 		go func() {
-			wg.Add(1)
 			defer wg.Done()
 			rc.StartMonitor(shutdownChan, errChan)
 		}()
@@ -100,11 +155,74 @@ your repos and monitor them for changes.
 	},
 }
 
+// repoDirConfigs returns the dirs a repo is served from
+func repoDirConfigs(repo *model.Repo) []interfaces.DirConfig {
+	// proxy and virtual repos serve files that aren't in their dir
+	configs := []interfaces.DirConfig{
+		webserverDirConfig{
+			topLevel: repo.Name,
+			absPath:  repo.AbsPath,
+			fallback: rc.FallbackHandler(repo),
+			repo:     repo.Name,
+		},
+	}
+	snapDir, err := rc.SnapshotDir(repo.Name)
+	if err != nil {
+		return configs
+	}
+	return append(configs, webserverDirConfig{
+		topLevel: repo.Name + "/snapshots",
+		absPath:  snapDir,
+		repo:     repo.Name,
+	})
+}
+
+// configList returns a list from a string slice flag, or the config key it is bound to.  The
+// vendored viper only passes flags on as the "[a,b]" string pflag prints, so flags are read directly,
+// and lists from the environment can be separated by commas or spaces.
+func configList(flags *pflag.FlagSet, name, key string) []string {
+	flag := flags.Lookup(name)
+	val := viper.Get(key)
+	if s, ok := val.(string); flag.Changed || (ok && s == flag.DefValue) {
+		list, _ := flags.GetStringSlice(name)
+		return list
+	}
+	switch val := val.(type) {
+	case []interface{}:
+		return cast.ToStringSlice(val)
+	case []string:
+		return val
+	case string:
+		return strings.FieldsFunc(val, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	}
+	return nil
+}
+
 func init() {
-	//serveCmd.Flags().String("bind-address", "0.0.0.0", "address on which to bind")
-	//serveCmd.Flags().Int("port", 3000, "port on which to bind")
 	RootCmd.AddCommand(serveCmd)
 
+	serveCmd.Flags().StringSlice("listen", []string{":3000"}, "addresses to serve repos on, like ':3000', '[::1]:8080' or 'unix:/run/roper.sock' (may be repeated)")
+	viper.BindPFlag("listen", serveCmd.Flags().Lookup("listen"))
+	serveCmd.Flags().String("tls-cert", "", "certificate file to serve HTTPS with, reloaded when it changes")
+	viper.BindPFlag("tls.cert", serveCmd.Flags().Lookup("tls-cert"))
+	serveCmd.Flags().String("tls-key", "", "key file for the TLS certificate, reloaded when it changes")
+	viper.BindPFlag("tls.key", serveCmd.Flags().Lookup("tls-key"))
+	serveCmd.Flags().StringSlice("redirect-http", nil, "addresses to redirect plain HTTP requests to HTTPS from, like ':80' (may be repeated)")
+	viper.BindPFlag("tls.redirect", serveCmd.Flags().Lookup("redirect-http"))
+	serveCmd.Flags().String("tls-client-ca", "", "CA bundle to verify client certificates against (empty doesn't ask for client certificates)")
+	viper.BindPFlag("tls.client_ca", serveCmd.Flags().Lookup("tls-client-ca"))
+	serveCmd.Flags().String("tls-client-auth", interfaces.ClientAuthRequire, "whether clients must present a certificate ('require'), or may ('optional')")
+	viper.BindPFlag("tls.client_auth", serveCmd.Flags().Lookup("tls-client-auth"))
+	serveCmd.Flags().Bool("uploads", false, "accept package uploads through the API")
+	viper.BindPFlag("api.uploads", serveCmd.Flags().Lookup("uploads"))
+	serveCmd.Flags().Int64("max-upload-size", 1<<30, "largest package the API accepts, in bytes (0 is no limit)")
+	viper.BindPFlag("api.max_upload_size", serveCmd.Flags().Lookup("max-upload-size"))
+
+	serveCmd.Flags().Duration("prune-interval", time.Hour, "how often to prune repos with a retention policy (0 disables)")
+	viper.BindPFlag("prune_interval", serveCmd.Flags().Lookup("prune-interval"))
+	serveCmd.Flags().Duration("mirror-interval", 6*time.Hour, "how often to sync mirrored repos (0 disables)")
+	viper.BindPFlag("mirror_interval", serveCmd.Flags().Lookup("mirror-interval"))
+
 	// Here you will define your flags and configuration settings.
 
 	// Cobra supports Persistent Flags which will work for this command
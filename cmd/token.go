@@ -0,0 +1,132 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var tokenDescription string
+
+// tokenCmd represents the token command
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage the API tokens of users",
+	Long: `
+API tokens let scripts and package managers authenticate as a user without
+their password.  A token is sent as a bearer token, or as the password of
+basic auth along with the name of the user it belongs to.`,
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create <user_name>",
+	Short: "Create a token for a user",
+	Long: `
+Create a token for a user, and print it.  Only a hash of the token is kept, so
+it can't be shown again.`,
+	Run: tokenCreateFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("create command requires 1 positional argument")
+		}
+		return nil
+	},
+}
+
+var tokenLsCmd = &cobra.Command{
+	Use:   "ls [user_name]",
+	Short: "List tokens",
+	Long: `
+List the tokens of a user, or of all users if no user is given.`,
+	Run: tokenLsFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("ls command takes at most 1 positional argument")
+		}
+		return nil
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <token_id>",
+	Short: "Revoke a token",
+	Run:   tokenRevokeFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("revoke command requires 1 positional argument")
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCmd.AddCommand(tokenLsCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
+
+	tokenCreateCmd.Flags().StringVar(&tokenDescription, "description", "", "what the token is for")
+}
+
+func tokenCreateFunc(cmd *cobra.Command, args []string) {
+	name := args[0]
+	secret, token, err := rc.CreateToken(name, tokenDescription)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"user":  name,
+			"error": err,
+		}).Error("Error creating token")
+		return
+	}
+	log.WithFields(log.Fields{
+		"user": name,
+		"id":   token.ID,
+	}).Info("Token created")
+	// the token goes to stdout on its own, so scripts can capture it
+	fmt.Println(secret)
+}
+
+func tokenLsFunc(cmd *cobra.Command, args []string) {
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	tokens, err := rc.GetTokens(name)
+	if err != nil {
+		log.WithField("error", err).Error("Error retrieving tokens")
+		return
+	}
+	for _, token := range tokens {
+		log.Infof("ID: %s | USER: %s | CREATED: %s | DESCRIPTION: %s",
+			token.ID, token.User, time.Unix(token.Created, 0).Format(time.RFC3339), token.Description)
+	}
+}
+
+func tokenRevokeFunc(cmd *cobra.Command, args []string) {
+	id := args[0]
+	if err := rc.RevokeToken(id); err != nil {
+		log.WithFields(log.Fields{
+			"id":    id,
+			"error": err,
+		}).Error("Error revoking token")
+		return
+	}
+	log.WithField("id", id).Info("Token revoked")
+}
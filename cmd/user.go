@@ -0,0 +1,265 @@
+// Copyright © 2016 Andrew Lapidas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	userAdmin         bool
+	userPasswordStdin bool
+)
+
+// userCmd represents the user command
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage the users of the web server",
+	Long: `
+Users authenticate to the web server with HTTP basic auth, using their
+password or one of their API tokens (see 'roper token'), or with a token as a
+bearer token.  Each user has a role on the repos they were granted one on:
+'read' can download a private repo, 'write' can also upload packages and
+rebuild the repo, and 'admin' can also remove it.  Admin users have the admin
+role on every repo, and can add repos through the API.  Repos that aren't
+private can be read without credentials.`,
+}
+
+var userAddCmd = &cobra.Command{
+	Use:   "add <user_name>",
+	Short: "Add a user",
+	Long: `
+Add a user.  Without --password-stdin, the user has no password, and can only
+authenticate with tokens.`,
+	Run: userAddFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("add command requires 1 positional argument")
+		}
+		return nil
+	},
+}
+
+var userSetCmd = &cobra.Command{
+	Use:   "set <user_name>",
+	Short: "Change the password or admin status of a user",
+	Long: `
+Change a user.  Only the flags that are given are changed.  An empty password
+on stdin removes the user's password.`,
+	Run: userSetFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("set command requires 1 positional argument")
+		}
+		return nil
+	},
+}
+
+var userRmCmd = &cobra.Command{
+	Use:   "rm <user_name>",
+	Short: "Remove a user and their tokens",
+	Run:   userRmFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("rm command requires 1 positional argument")
+		}
+		return nil
+	},
+}
+
+var userLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List users and their roles",
+	Run:   userLsFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 {
+			return errors.New("ls command takes no positional arguments")
+		}
+		return nil
+	},
+}
+
+var userGrantCmd = &cobra.Command{
+	Use:   "grant <user_name> <repo_name> <role>",
+	Short: "Give a user a role on a repo",
+	Long: `
+Give a user the 'read', 'write' or 'admin' role on a repo, replacing the role
+they had on it before.`,
+	Run: userGrantFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 3 {
+			return errors.New("grant command requires 3 positional arguments")
+		}
+		return nil
+	},
+}
+
+var userRevokeCmd = &cobra.Command{
+	Use:   "revoke <user_name> <repo_name>",
+	Short: "Take away a user's role on a repo",
+	Run:   userRevokeFunc,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("revoke command requires 2 positional arguments")
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(userCmd)
+	userCmd.AddCommand(userAddCmd)
+	userCmd.AddCommand(userSetCmd)
+	userCmd.AddCommand(userRmCmd)
+	userCmd.AddCommand(userLsCmd)
+	userCmd.AddCommand(userGrantCmd)
+	userCmd.AddCommand(userRevokeCmd)
+
+	for _, cmd := range []*cobra.Command{userAddCmd, userSetCmd} {
+		cmd.Flags().BoolVar(&userAdmin, "admin", false, "give the user the admin role on every repo")
+		cmd.Flags().BoolVar(&userPasswordStdin, "password-stdin", false, "read the user's password from the first line of stdin")
+	}
+}
+
+// readPassword reads a password from the first line of stdin
+func readPassword() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("unable to read password from stdin: %s", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func userAddFunc(cmd *cobra.Command, args []string) {
+	name := args[0]
+	password := ""
+	if userPasswordStdin {
+		var err error
+		if password, err = readPassword(); err != nil {
+			log.WithField("error", err).Error("Error adding user")
+			return
+		}
+	}
+	if err := rc.AddUser(name, password, userAdmin); err != nil {
+		log.WithFields(log.Fields{
+			"user":  name,
+			"error": err,
+		}).Error("Error adding user")
+		return
+	}
+	log.WithField("user", name).Info("User added")
+}
+
+func userSetFunc(cmd *cobra.Command, args []string) {
+	name := args[0]
+	if userPasswordStdin {
+		password, err := readPassword()
+		if err == nil {
+			err = rc.SetPassword(name, password)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"user":  name,
+				"error": err,
+			}).Error("Error setting password")
+			return
+		}
+	}
+	if cmd.Flags().Changed("admin") {
+		err := rc.UpdateUser(name, func(user *model.User) error {
+			user.Admin = userAdmin
+			return nil
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"user":  name,
+				"error": err,
+			}).Error("Error changing user")
+			return
+		}
+	}
+	log.WithField("user", name).Info("User changed")
+}
+
+func userRmFunc(cmd *cobra.Command, args []string) {
+	name := args[0]
+	if err := rc.RemoveUser(name); err != nil {
+		log.WithFields(log.Fields{
+			"user":  name,
+			"error": err,
+		}).Error("Error removing user")
+		return
+	}
+	log.WithField("user", name).Info("User removed")
+}
+
+func userLsFunc(cmd *cobra.Command, args []string) {
+	users, err := rc.GetUsers()
+	if err != nil {
+		log.WithField("error", err).Error("Error retrieving users")
+		return
+	}
+	for _, user := range users {
+		var roles []string
+		for repo, role := range user.Roles {
+			roles = append(roles, repo+"="+role)
+		}
+		sort.Strings(roles)
+		log.Infof("USER: %s | ADMIN: %t | PASSWORD: %t | ROLES: %s", user.Name, user.Admin, user.PasswordHash != "", strings.Join(roles, ","))
+	}
+}
+
+func userGrantFunc(cmd *cobra.Command, args []string) {
+	name, repo, role := args[0], args[1], args[2]
+	if err := rc.GrantRole(name, repo, role); err != nil {
+		log.WithFields(log.Fields{
+			"user":  name,
+			"repo":  repo,
+			"role":  role,
+			"error": err,
+		}).Error("Error granting role")
+		return
+	}
+	log.WithFields(log.Fields{
+		"user": name,
+		"repo": repo,
+		"role": role,
+	}).Info("Role granted")
+}
+
+func userRevokeFunc(cmd *cobra.Command, args []string) {
+	name, repo := args[0], args[1]
+	if err := rc.GrantRole(name, repo, ""); err != nil {
+		log.WithFields(log.Fields{
+			"user":  name,
+			"repo":  repo,
+			"error": err,
+		}).Error("Error revoking role")
+		return
+	}
+	log.WithFields(log.Fields{
+		"user": name,
+		"repo": repo,
+	}).Info("Role revoked")
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/deb"
+	"github.com/alapidas/roper/gpg"
+	"github.com/alapidas/roper/model"
+)
+
+// aptBuilder builds the Packages indexes and Release file of repos of debs
+type aptBuilder struct{}
+
+func (b *aptBuilder) IsPackage(relPath string) bool {
+	return filepath.Ext(relPath) == ".deb"
+}
+
+// ReadPackage reads the control file of a deb.  The upstream version and revision of the deb are
+// stored as the version and release of the package.
+func (b *aptBuilder) ReadPackage(pkg *model.Package, absPath string) error {
+	debPkg, err := deb.ReadFile(absPath)
+	if err != nil {
+		return err
+	}
+	pkg.Name = debPkg.Name
+	pkg.Epoch, pkg.Version, pkg.Release = deb.SplitVersion(debPkg.Version)
+	pkg.Arch = debPkg.Arch
+	pkg.SourceRPM = debPkg.Control.Get("Source")
+	pkg.Checksum = debPkg.Checksum
+	pkg.Provides = debDeps(debPkg.Control.Get("Provides"))
+	pkg.Requires = debDeps(debPkg.Control.Get("Pre-Depends"))
+	pkg.Requires = append(pkg.Requires, debDeps(debPkg.Control.Get("Depends"))...)
+	pkg.Conflicts = debDeps(debPkg.Control.Get("Conflicts"))
+	pkg.Obsoletes = debDeps(debPkg.Control.Get("Replaces"))
+	return nil
+}
+
+// Compare orders debs the way dpkg does
+func (b *aptBuilder) Compare(p1, p2 *model.Package) int {
+	return deb.CompareVersions(p1.DebVersion(), p2.DebVersion())
+}
+
+func (b *aptBuilder) Validate(repo *model.Repo) error {
+	if repo.Backend == model.BackendCreaterepo {
+		return fmt.Errorf("apt repos can't use the createrepo backend")
+	}
+	if repo.Verify.Keyring != "" {
+		return fmt.Errorf("package verification is only supported for yum repos")
+	}
+	return nil
+}
+
+// Build writes the Packages indexes and Release file of an apt repo
+func (b *aptBuilder) Build(repo *model.Repo, signer *gpg.Signer) error {
+	log.WithField("repo", repo.Name).Info("Generating apt indexes")
+	pkgs := make([]*deb.IndexedPackage, 0, len(repo.Packages))
+	for relPath, pkg := range repo.Packages {
+		debPkg, err := readDeb(repo, pkg)
+		if err != nil {
+			// don't let one bad file keep the rest of the repo from being published
+			log.WithFields(log.Fields{
+				"repo":  repo.Name,
+				"path":  relPath,
+				"error": err,
+			}).Warn("skipping unreadable package")
+			continue
+		}
+		pkgs = append(pkgs, &deb.IndexedPackage{Package: debPkg, Location: relPath})
+	}
+	opts := &deb.Options{
+		Suite:         repo.Apt.Suite,
+		Codename:      repo.Apt.Codename,
+		Component:     repo.Apt.Component,
+		Origin:        repo.Apt.Origin,
+		Label:         repo.Apt.Label,
+		Architectures: repo.Apt.Architectures,
+	}
+	if signer != nil {
+		opts.Signer = signer
+	}
+	if err := deb.Generate(repo.AbsPath, pkgs, opts); err != nil {
+		return fmt.Errorf("unable to generate indexes for repo %s: %s", repo.Name, err)
+	}
+	return nil
+}
+
+// readDeb reads the control file of a deb in a repo.  Like readRPM, the checksum stored in the db is
+// used if the file doesn't look like it has changed since it was last read.
+func readDeb(repo *model.Repo, pkg *model.Package) (*deb.Package, error) {
+	absPath := filepath.Join(repo.AbsPath, pkg.RelPath)
+	fi, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if pkg.Checksum == "" || !pkg.SameFile(fi, fileInode(fi)) {
+		return deb.ReadFile(absPath)
+	}
+	debPkg, err := deb.ReadControl(absPath)
+	if err != nil {
+		return nil, err
+	}
+	debPkg.Checksum = pkg.Checksum
+	return debPkg, nil
+}
+
+// debRelations maps deb version relations to the flags used for rpm deps.  Longer relations come
+// first, so they are matched before their prefixes.
+var debRelations = []struct {
+	rel   string
+	flags string
+}{
+	{">>", "GT"},
+	{">=", "GE"},
+	{"<<", "LT"},
+	{"<=", "LE"},
+	{"=", "EQ"},
+	// obsolete forms of <= and >=
+	{">", "GE"},
+	{"<", "LE"},
+}
+
+// debDeps parses a deb relationship field, like "libc6 (>= 2.17), foo | bar".  Only the first of a
+// set of alternatives is kept.
+func debDeps(field string) []model.Dependency {
+	var deps []model.Dependency
+	for _, entry := range strings.Split(field, ",") {
+		entry = strings.TrimSpace(strings.SplitN(entry, "|", 2)[0])
+		name, rel := entry, ""
+		if i := strings.Index(entry, "("); i >= 0 {
+			name = entry[:i]
+			rel = strings.TrimSpace(strings.Trim(entry[i:], "()"))
+		}
+		fields := strings.Fields(name)
+		if len(fields) == 0 {
+			continue
+		}
+		// drop any architecture qualifier, e.g. "python3:any"
+		dep := model.Dependency{Name: strings.SplitN(fields[0], ":", 2)[0]}
+		for _, r := range debRelations {
+			if strings.HasPrefix(rel, r.rel) {
+				dep.Flags = r.flags
+				dep.Version = strings.TrimSpace(rel[len(r.rel):])
+				break
+			}
+		}
+		deps = append(deps, dep)
+	}
+	return deps
+}
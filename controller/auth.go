@@ -0,0 +1,507 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alapidas/roper/model"
+	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	// ErrUnauthenticated is returned for requests that need credentials, and have none or bad ones
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrForbidden is returned for requests from users without the role they need
+	ErrForbidden = errors.New("permission denied")
+)
+
+const (
+	// passwordIterations is the number of PBKDF2 iterations new password hashes use
+	passwordIterations = 100000
+	// authCacheTTL is how long a checked password is remembered, so clients like yum, which send
+	// credentials with every request, don't pay for hashing each time
+	authCacheTTL = 5 * time.Minute
+	// authCacheSize is the number of checked passwords remembered before they are all forgotten
+	authCacheSize = 1024
+)
+
+// AddUser adds a user.  An empty password makes a user that can only authenticate with tokens.
+func (rc *RoperController) AddUser(name, password string, admin bool) error {
+	if name == "" || strings.ContainsAny(name, ":\n") {
+		return fmt.Errorf("invalid user name %q", name)
+	}
+	user := &model.User{Name: name, Admin: admin, Roles: map[string]string{}}
+	if password != "" {
+		hash, err := hashPassword(password)
+		if err != nil {
+			return fmt.Errorf("unable to add user %s: %s", name, err)
+		}
+		user.PasswordHash = hash
+	}
+	err := rc.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(user_bucket)).Get([]byte(name)) != nil {
+			return fmt.Errorf("user %s already exists", name)
+		}
+		return putUser(tx, user)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to add user %s: %s", name, err)
+	}
+	return nil
+}
+
+// UpdateUser changes an existing user.  The update function is passed the current user, and should
+// modify it in place.
+func (rc *RoperController) UpdateUser(name string, update func(user *model.User) error) error {
+	err := rc.db.Update(func(tx *bolt.Tx) error {
+		user, err := getUser(tx, name)
+		if err != nil {
+			return err
+		}
+		if err = update(user); err != nil {
+			return err
+		}
+		// the name identifies the user, and can't be changed here
+		user.Name = name
+		for repoName, role := range user.Roles {
+			if !model.ValidRole(role) {
+				return fmt.Errorf("invalid role %s on repo %s", role, repoName)
+			}
+		}
+		return putUser(tx, user)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update user %s: %s", name, err)
+	}
+	return nil
+}
+
+// SetPassword changes the password of a user.  An empty password removes it.
+func (rc *RoperController) SetPassword(name, password string) error {
+	hash := ""
+	if password != "" {
+		var err error
+		if hash, err = hashPassword(password); err != nil {
+			return fmt.Errorf("unable to set password of user %s: %s", name, err)
+		}
+	}
+	return rc.UpdateUser(name, func(user *model.User) error {
+		user.PasswordHash = hash
+		return nil
+	})
+}
+
+// GrantRole gives a user a role on a repo, replacing the role they had before.  An empty role takes
+// away the user's role on the repo.
+func (rc *RoperController) GrantRole(name, repoName, role string) error {
+	if role != "" {
+		if !model.ValidRole(role) {
+			return fmt.Errorf("invalid role %s", role)
+		}
+		if _, err := rc.GetRepo(repoName); err != nil {
+			return err
+		}
+	}
+	return rc.UpdateUser(name, func(user *model.User) error {
+		if role == "" {
+			delete(user.Roles, repoName)
+		} else {
+			user.Roles[repoName] = role
+		}
+		return nil
+	})
+}
+
+// RemoveUser removes a user, along with their tokens
+func (rc *RoperController) RemoveUser(name string) error {
+	err := rc.db.Update(func(tx *bolt.Tx) error {
+		if _, err := getUser(tx, name); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(user_bucket)).Delete([]byte(name)); err != nil {
+			return err
+		}
+		tokens, err := getTokens(tx, name)
+		if err != nil {
+			return err
+		}
+		for _, token := range tokens {
+			if err = tx.Bucket([]byte(token_bucket)).Delete([]byte(token.ID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to remove user %s: %s", name, err)
+	}
+	return nil
+}
+
+// GetUser returns a user
+func (rc *RoperController) GetUser(name string) (*model.User, error) {
+	var user *model.User
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		var err error
+		user, err = getUser(tx, name)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get user %s: %s", name, err)
+	}
+	return user, nil
+}
+
+// GetUsers returns all users, sorted by name
+func (rc *RoperController) GetUsers() ([]*model.User, error) {
+	var users []*model.User
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		var err error
+		users, err = getUsers(tx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get users: %s", err)
+	}
+	return users, nil
+}
+
+// CreateToken creates an API token for a user, and returns it along with its record.  The token itself
+// can't be recovered later.
+func (rc *RoperController) CreateToken(name, description string) (string, *model.Token, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create token: %s", err)
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create token: %s", err)
+	}
+	token := &model.Token{ID: id, User: name, Hash: hashSecret(secret), Description: description, Created: time.Now().Unix()}
+	err = rc.db.Update(func(tx *bolt.Tx) error {
+		if _, err := getUser(tx, name); err != nil {
+			return err
+		}
+		val, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(token_bucket)).Put([]byte(id), val)
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create token for user %s: %s", name, err)
+	}
+	return id + "." + secret, token, nil
+}
+
+// RevokeToken deletes an API token
+func (rc *RoperController) RevokeToken(id string) error {
+	err := rc.db.Update(func(tx *bolt.Tx) error {
+		tb := tx.Bucket([]byte(token_bucket))
+		if tb.Get([]byte(id)) == nil {
+			return fmt.Errorf("token %s not found in database", id)
+		}
+		return tb.Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("unable to revoke token: %s", err)
+	}
+	return nil
+}
+
+// GetTokens returns the tokens of a user, or of all users if name is empty, sorted by user and creation
+func (rc *RoperController) GetTokens(name string) ([]*model.Token, error) {
+	var tokens []*model.Token
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		var err error
+		tokens, err = getTokens(tx, name)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get tokens: %s", err)
+	}
+	return tokens, nil
+}
+
+// Authenticate returns the user a request is from, using HTTP basic or bearer auth.  The password of
+// basic auth can be the user's password or one of their tokens.  Requests without credentials are
+// anonymous, and have a nil user.  Requests with bad credentials get ErrUnauthenticated.
+func (rc *RoperController) Authenticate(r *http.Request) (*model.User, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, nil
+	}
+	var user *model.User
+	if name, password, ok := r.BasicAuth(); ok {
+		user = rc.checkPassword(name, password)
+	} else if strings.HasPrefix(header, "Bearer ") {
+		user = rc.checkToken("", strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	}
+	if user == nil {
+		return nil, ErrUnauthenticated
+	}
+	return user, nil
+}
+
+// Authorize returns the user a request is from, if they have a role on a repo, or ErrUnauthenticated or
+// ErrForbidden if they don't.  Everyone can read repos that aren't private, and private repos can be
+// read with a client certificate that matches one of their rules.  An empty repo name asks for a role
+// on every repo, which only admins have.
+func (rc *RoperController) Authorize(r *http.Request, repoName, role string) (*model.User, error) {
+	user, err := rc.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil && user.Can(repoName, role) {
+		return user, nil
+	}
+	cert := ClientCert(r)
+	if repoName != "" && role == model.RoleRead {
+		access, err := rc.repoAccess(repoName)
+		if err != nil {
+			return nil, err
+		}
+		if !access.Private || certMatches(cert, access.ClientCerts) {
+			return user, nil
+		}
+	}
+	// a client that proved who it is with a certificate has nothing more to offer
+	if user == nil && cert == nil {
+		return nil, ErrUnauthenticated
+	}
+	return nil, ErrForbidden
+}
+
+// Allowed returns whether a user, or an anonymous user if it is nil, has a role on a repo.  A verified
+// client certificate can also give read access.
+func Allowed(user *model.User, cert *x509.Certificate, repo *model.Repo, role string) bool {
+	if role == model.RoleRead && (!repo.Access.Private || certMatches(cert, repo.Access.ClientCerts)) {
+		return true
+	}
+	return user != nil && user.Can(repo.Name, role)
+}
+
+// repoAccess returns the access settings of a repo, without loading its packages.  Repos that don't
+// exist are private, so requests for them don't show whether they exist.
+func (rc *RoperController) repoAccess(repoName string) (model.AccessOptions, error) {
+	access := model.AccessOptions{Private: true}
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		val := tx.Bucket([]byte(repo_bucket)).Get([]byte(repoName))
+		if val == nil {
+			return nil
+		}
+		repo := &model.Repo{}
+		if err := json.Unmarshal(val, repo); err != nil {
+			return fmt.Errorf("error unmarshaling repo %s: %s", repoName, err)
+		}
+		access = repo.Access
+		return nil
+	})
+	return access, err
+}
+
+// checkPassword returns the user with a name, if the password is theirs or one of their tokens
+func (rc *RoperController) checkPassword(name, password string) *model.User {
+	if strings.Contains(password, ".") {
+		if user := rc.checkToken(name, password); user != nil {
+			return user
+		}
+	}
+	user, err := rc.GetUser(name)
+	if err != nil || user.PasswordHash == "" {
+		return nil
+	}
+	// checked passwords are remembered by a hash of the stored hash and the password, so changing the
+	// password forgets them
+	key := hashSecret(user.PasswordHash + "\x00" + password)
+	rc.authLock.Lock()
+	expires, ok := rc.authCache[key]
+	rc.authLock.Unlock()
+	if ok && time.Now().Before(expires) {
+		return user
+	}
+	if !verifyPassword(user.PasswordHash, password) {
+		return nil
+	}
+	rc.authLock.Lock()
+	if rc.authCache == nil || len(rc.authCache) >= authCacheSize {
+		rc.authCache = make(map[string]time.Time)
+	}
+	rc.authCache[key] = time.Now().Add(authCacheTTL)
+	rc.authLock.Unlock()
+	return user
+}
+
+// checkToken returns the user a token belongs to, if it is valid.  If name isn't empty, the token has
+// to belong to that user.
+func (rc *RoperController) checkToken(name, tokenString string) *model.User {
+	parts := strings.SplitN(tokenString, ".", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	var user *model.User
+	rc.db.View(func(tx *bolt.Tx) error {
+		val := tx.Bucket([]byte(token_bucket)).Get([]byte(parts[0]))
+		if val == nil {
+			return nil
+		}
+		token := &model.Token{}
+		if err := json.Unmarshal(val, token); err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hashSecret(parts[1]))) != 1 {
+			return nil
+		}
+		if name != "" && token.User != name {
+			return nil
+		}
+		user, _ = getUser(tx, token.User)
+		return nil
+	})
+	return user
+}
+
+// removeRoles takes away every user's role on a repo, given a transaction
+func removeRoles(tx *bolt.Tx, repoName string) error {
+	users, err := getUsers(tx)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if _, ok := user.Roles[repoName]; !ok {
+			continue
+		}
+		delete(user.Roles, repoName)
+		if err = putUser(tx, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getUser(tx *bolt.Tx, name string) (*model.User, error) {
+	val := tx.Bucket([]byte(user_bucket)).Get([]byte(name))
+	if val == nil {
+		return nil, fmt.Errorf("user %s not found in database", name)
+	}
+	user := &model.User{}
+	if err := json.Unmarshal(val, user); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal user %s: %s", name, err)
+	}
+	if user.Roles == nil {
+		user.Roles = map[string]string{}
+	}
+	return user, nil
+}
+
+func getUsers(tx *bolt.Tx) ([]*model.User, error) {
+	var users []*model.User
+	err := tx.Bucket([]byte(user_bucket)).ForEach(func(k, v []byte) error {
+		user, err := getUser(tx, string(k))
+		if err != nil {
+			return err
+		}
+		users = append(users, user)
+		return nil
+	})
+	return users, err
+}
+
+func putUser(tx *bolt.Tx, user *model.User) error {
+	val, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("unable to marshal user %s: %s", user.Name, err)
+	}
+	return tx.Bucket([]byte(user_bucket)).Put([]byte(user.Name), val)
+}
+
+func getTokens(tx *bolt.Tx, name string) ([]*model.Token, error) {
+	var tokens []*model.Token
+	err := tx.Bucket([]byte(token_bucket)).ForEach(func(k, v []byte) error {
+		token := &model.Token{}
+		if err := json.Unmarshal(v, token); err != nil {
+			return fmt.Errorf("unable to unmarshal token: %s", err)
+		}
+		if name == "" || token.User == name {
+			tokens = append(tokens, token)
+		}
+		return nil
+	})
+	sort.Stable(byUserAndCreated(tokens))
+	return tokens, err
+}
+
+// byUserAndCreated sorts tokens by user, oldest first
+type byUserAndCreated []*model.Token
+
+func (t byUserAndCreated) Len() int      { return len(t) }
+func (t byUserAndCreated) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t byUserAndCreated) Less(i, j int) bool {
+	if t[i].User != t[j].User {
+		return t[i].User < t[j].User
+	}
+	return t[i].Created < t[j].Created
+}
+
+// hashPassword returns a salted PBKDF2-SHA256 hash of a password, as
+// pbkdf2-sha256$<iterations>$<salt>$<hash>
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(password), salt, passwordIterations, sha256.Size, sha256.New)
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// verifyPassword returns whether a password matches a hash from hashPassword
+func verifyPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key := pbkdf2.Key([]byte(password), salt, iterations, len(want), sha256.New)
+	return subtle.ConstantTimeCompare(key, want) == 1
+}
+
+// hashSecret returns the hex SHA-256 of a secret
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
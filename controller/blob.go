@@ -0,0 +1,398 @@
+package controller
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/model"
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// BlobHardlink stores blobs as hardlinks of the repo files, which only works on one filesystem.
+	// Blobs are read-only, so repo files have to be replaced rather than rewritten in place.
+	BlobHardlink = "hardlink"
+	// BlobReflink stores blobs as copy-on-write clones of the repo files, so files rewritten in
+	// place don't change the blob.  It needs a filesystem that supports reflinks, like btrfs or xfs.
+	BlobReflink = "reflink"
+)
+
+// GCReport lists the blobs that were reclaimed by a garbage collection
+type GCReport struct {
+	Removed []string // checksums of the removed blobs
+	Freed   int64    // bytes freed, as long as nothing else links to the blobs
+}
+
+// SetBlobStore turns on the content addressable blob store in dir, where each unique package file is
+// kept once under its SHA-256, and the files in repos are links to it.  An empty dir turns the store
+// off.  link is BlobHardlink or BlobReflink, and defaults to BlobHardlink.
+func (rc *RoperController) SetBlobStore(dir, link string) error {
+	switch link {
+	case "":
+		link = BlobHardlink
+	case BlobHardlink, BlobReflink:
+	default:
+		return fmt.Errorf("unknown blob link type %s", link)
+	}
+	rc.blobDir, rc.blobLink = dir, link
+	return nil
+}
+
+// blobPath returns the path a blob is stored at, or "" if sum isn't a SHA-256
+func (rc *RoperController) blobPath(sum string) string {
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != 32 {
+		return ""
+	}
+	return filepath.Join(rc.blobDir, sum[:2], sum)
+}
+
+// inBlobStore returns whether the file of a package is already backed by the blob store.  With
+// reflinks, a file can't be told apart from a clone, so it is enough for the blob to exist.  This is
+// always true if the store is off.
+func (rc *RoperController) inBlobStore(absPath string, pkg *model.Package) bool {
+	if rc.blobDir == "" {
+		return true
+	}
+	blob := rc.blobPath(pkg.Checksum)
+	if blob == "" {
+		// nothing to store
+		return true
+	}
+	bi, err := os.Stat(blob)
+	if err != nil {
+		return false
+	}
+	if rc.blobLink == BlobReflink {
+		return true
+	}
+	fi, err := os.Stat(absPath)
+	return err == nil && os.SameFile(fi, bi)
+}
+
+// storeBlob puts the file of a package that was just read in the blob store.  The first copy of a
+// file becomes the blob, and later copies are replaced by links to it, in which case the file state
+// of the package is updated to match.  Blobs are made read-only, and an existing blob is checked
+// against its checksum before anything is linked to it, so a blob that was changed anyway is replaced
+// by the new copy rather than spread to more repos.  This does nothing if the store is off.
+func (rc *RoperController) storeBlob(absPath string, pkg *model.Package) error {
+	blob := rc.blobPath(pkg.Checksum)
+	if rc.blobDir == "" || blob == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		return fmt.Errorf("unable to create blob dir: %s", err)
+	}
+	bi, err := os.Stat(blob)
+	if err == nil {
+		if fi, ferr := os.Stat(absPath); ferr == nil && os.SameFile(fi, bi) {
+			return os.Chmod(blob, 0444)
+		}
+		if sum, serr := fileChecksum(blob); serr != nil || sum != pkg.Checksum {
+			log.WithFields(log.Fields{
+				"blob": pkg.Checksum,
+				"path": absPath,
+			}).Warn("Blob doesn't match its checksum, replacing it")
+			os.Remove(blob)
+			err = os.ErrNotExist
+		}
+	}
+	if os.IsNotExist(err) {
+		if err = rc.linkBlob(absPath, blob); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("unable to store %s as blob %s: %s", absPath, pkg.Checksum, err)
+		}
+		if err == nil {
+			return os.Chmod(blob, 0444)
+		}
+		// someone else stored the same file first
+		_, err = os.Stat(blob)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to stat blob %s: %s", pkg.Checksum, err)
+	}
+	// repo files are only ever replaced by renaming over them, so nothing sharing the old file changes
+	tmp := filepath.Join(filepath.Dir(absPath), "."+filepath.Base(absPath)+".blob")
+	os.Remove(tmp)
+	if err = rc.linkBlob(blob, tmp); err != nil {
+		return fmt.Errorf("unable to link blob %s to %s: %s", pkg.Checksum, absPath, err)
+	}
+	if err = os.Rename(tmp, absPath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to link blob %s to %s: %s", pkg.Checksum, absPath, err)
+	}
+	fi, err := os.Stat(absPath)
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %s", absPath, err)
+	}
+	pkg.Size = fi.Size()
+	pkg.ModTime = fi.ModTime().UnixNano()
+	pkg.Inode = fileInode(fi)
+	return nil
+}
+
+// detachBlob takes a blob out of the store if it was rewritten in place through a repo file that is
+// linked to it, which is found when the file no longer looks like it did when it was read.  Every
+// file linked to the blob changed with it, and those are re-read when they are next scanned, but the
+// blob can't be linked to again under a checksum it no longer has.  The changed file is stored under
+// its new checksum when it is re-read.
+func (rc *RoperController) detachBlob(absPath, sum string) {
+	blob := rc.blobPath(sum)
+	if rc.blobDir == "" || blob == "" {
+		return
+	}
+	bi, err := os.Stat(blob)
+	if err != nil {
+		return
+	}
+	if fi, err := os.Stat(absPath); err != nil || !os.SameFile(fi, bi) {
+		return
+	}
+	if got, err := fileChecksum(blob); err == nil && got == sum {
+		return
+	}
+	log.WithFields(log.Fields{
+		"blob": sum,
+		"path": absPath,
+	}).Warn("Blob was rewritten in place, removing it from the blob store")
+	if err = os.Remove(blob); err != nil && !os.IsNotExist(err) {
+		log.WithFields(log.Fields{
+			"blob":  sum,
+			"error": err,
+		}).Error("Unable to remove changed blob")
+	}
+}
+
+// linkBlob links src to dest, which must not exist, the way the blob store is configured to
+func (rc *RoperController) linkBlob(src, dest string) error {
+	if rc.blobLink == BlobReflink {
+		// clone to the side so a half written blob is never seen
+		tmp := dest + ".tmp"
+		os.Remove(tmp)
+		if err := reflink(src, tmp); err != nil {
+			return err
+		}
+		if _, err := os.Stat(dest); err == nil {
+			os.Remove(tmp)
+			return os.ErrExist
+		}
+		return os.Rename(tmp, dest)
+	}
+	return os.Link(src, dest)
+}
+
+// adjustBlobRefs adds delta to the reference counts of the blobs with the given checksums, given a
+// transaction.  Blobs that are in the store without a record get one when they are first referenced.
+func (rc *RoperController) adjustBlobRefs(tx *bolt.Tx, sums []string, delta int) error {
+	bb := tx.Bucket([]byte(blob_bucket))
+	for _, sum := range sums {
+		if sum == "" {
+			continue
+		}
+		blob := &model.Blob{}
+		if v := bb.Get([]byte(sum)); v != nil {
+			if err := json.Unmarshal(v, blob); err != nil {
+				return fmt.Errorf("unable to unmarshal blob %s: %s", sum, err)
+			}
+		} else if path := rc.blobPath(sum); delta > 0 && rc.blobDir != "" && path != "" {
+			fi, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			blob = &model.Blob{Checksum: sum, Size: fi.Size(), Created: time.Now().Unix()}
+		} else {
+			continue
+		}
+		blob.Refs += delta
+		if blob.Refs < 0 {
+			blob.Refs = 0
+		}
+		val, err := json.Marshal(blob)
+		if err != nil {
+			return err
+		}
+		if err = bb.Put([]byte(sum), val); err != nil {
+			return fmt.Errorf("unable to persist blob %s: %s", sum, err)
+		}
+	}
+	return nil
+}
+
+// putPackageRecord stores a package, and moves the blob reference of any package it replaces,
+// given a transaction
+func (rc *RoperController) putPackageRecord(tx *bolt.Tx, pp *model.PersistablePackage) error {
+	pb := tx.Bucket([]byte(pkg_bucket))
+	old := pb.Get([]byte(pp.RepoName + "::" + pp.RelPath))
+	pp.Added = addedTime(&pp.Package, old)
+	key, val, err := pp.Serial()
+	if err != nil {
+		return fmt.Errorf("unable to get serialized vals for package %s in repo %s: %s", pp.RelPath, pp.RepoName, err)
+	}
+	if old != nil {
+		if err = rc.adjustBlobRefs(tx, []string{recordChecksum(old)}, -1); err != nil {
+			return err
+		}
+	}
+	if err = pb.Put(key, val); err != nil {
+		return fmt.Errorf("unable to persist package %s: %s", key, err)
+	}
+	return rc.adjustBlobRefs(tx, []string{pp.Checksum}, 1)
+}
+
+// deletePackageRecord deletes a package and drops its blob reference, given a transaction
+func (rc *RoperController) deletePackageRecord(tx *bolt.Tx, key []byte) error {
+	pb := tx.Bucket([]byte(pkg_bucket))
+	if old := pb.Get(key); old != nil {
+		if err := rc.adjustBlobRefs(tx, []string{recordChecksum(old)}, -1); err != nil {
+			return err
+		}
+	}
+	if err := pb.Delete(key); err != nil {
+		return fmt.Errorf("unable to delete package %s: %s", key, err)
+	}
+	return nil
+}
+
+// addedTime returns when a package was added to its repo, given its previous record, if any.  A
+// package that was read again keeps the time of its record as long as its checksum is the same, and
+// records from before the time was kept fall back to the modification time of their file.
+func addedTime(pkg *model.Package, old []byte) int64 {
+	if pkg.Added != 0 {
+		return pkg.Added
+	}
+	if old != nil {
+		var rec struct {
+			Checksum       string
+			Added, ModTime int64
+		}
+		json.Unmarshal(old, &rec)
+		if rec.Checksum == pkg.Checksum {
+			if rec.Added != 0 {
+				return rec.Added
+			}
+			return rec.ModTime / int64(time.Second)
+		}
+	}
+	return time.Now().Unix()
+}
+
+// recordChecksum returns the checksum of a serialized package
+func recordChecksum(val []byte) string {
+	var rec struct{ Checksum string }
+	json.Unmarshal(val, &rec)
+	return rec.Checksum
+}
+
+// snapshotChecksums returns the checksums of the blobs the files of a snapshot are linked to
+func snapshotChecksums(snap *model.Snapshot) []string {
+	var sums []string
+	for _, pkg := range snap.Packages {
+		if pkg.Blob {
+			sums = append(sums, pkg.Checksum)
+		}
+	}
+	return sums
+}
+
+// GetBlobs returns the blobs recorded in the blob store, sorted by checksum
+func (rc *RoperController) GetBlobs() ([]*model.Blob, error) {
+	var blobs []*model.Blob
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(blob_bucket)).ForEach(func(k, v []byte) error {
+			blob := &model.Blob{}
+			if err := json.Unmarshal(v, blob); err != nil {
+				return fmt.Errorf("unable to unmarshal blob %s: %s", k, err)
+			}
+			blobs = append(blobs, blob)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get blobs: %s", err)
+	}
+	return blobs, nil
+}
+
+// GC deletes the blobs that no package or snapshot references, along with files in the store that
+// have no record, like the blobs of packages that were quarantined.  The files of repos and
+// snapshots are left alone, so they keep their data even if they still link to a removed blob.  It
+// must not run while packages are being added, which the exclusive lock on the database ensures
+// for the command line.
+func (rc *RoperController) GC(dryRun bool) (*GCReport, error) {
+	if rc.blobDir == "" {
+		return nil, fmt.Errorf("the blob store is not enabled")
+	}
+	garbage := make(map[string]int64)
+	known := make(map[string]bool)
+	blobs, err := rc.GetBlobs()
+	if err != nil {
+		return nil, err
+	}
+	for _, blob := range blobs {
+		known[blob.Checksum] = true
+		if blob.Refs <= 0 {
+			garbage[blob.Checksum] = blob.Size
+		}
+	}
+	var stray []string
+	err = filepath.Walk(rc.blobDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		sum := info.Name()
+		if rc.blobPath(sum) != path {
+			// left over from an interrupted clone
+			stray = append(stray, path)
+		} else if !known[sum] {
+			garbage[sum] = info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to walk blob store %s: %s", rc.blobDir, err)
+	}
+	report := &GCReport{}
+	for sum, size := range garbage {
+		report.Removed = append(report.Removed, sum)
+		report.Freed += size
+	}
+	sort.Strings(report.Removed)
+	if dryRun {
+		return report, nil
+	}
+	err = rc.db.Update(func(tx *bolt.Tx) error {
+		bb := tx.Bucket([]byte(blob_bucket))
+		for _, sum := range report.Removed {
+			if err := bb.Delete([]byte(sum)); err != nil {
+				return fmt.Errorf("unable to delete blob %s: %s", sum, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to delete blob records: %s", err)
+	}
+	for _, sum := range report.Removed {
+		if err := os.Remove(rc.blobPath(sum)); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("unable to remove blob %s: %s", sum, err)
+		}
+	}
+	for _, path := range stray {
+		os.Remove(path)
+	}
+	log.WithFields(log.Fields{
+		"blobs": len(report.Removed),
+		"bytes": report.Freed,
+	}).Info("Collected unreferenced blobs")
+	return report, nil
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/model"
+	"github.com/boltdb/bolt"
+)
+
+// RebuildMetadata builds the metadata of a repo, along with the virtual repos it is a member of
+func (rc *RoperController) RebuildMetadata(repoName string) error {
+	if _, err := rc.GetRepo(repoName); err != nil {
+		return err
+	}
+	return rc.buildMetadata(repoName)
+}
+
+// recordBuild stores the status of a metadata build.  Failing to store it doesn't fail the build.
+func (rc *RoperController) recordBuild(status *model.BuildStatus) {
+	err := rc.db.Update(func(tx *bolt.Tx) error {
+		val, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(build_bucket)).Put([]byte(status.Repo), val)
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"repo":  status.Repo,
+			"error": err,
+		}).Warn("unable to record metadata build status")
+	}
+}
+
+// GetBuildStatus returns the status of the last metadata build of a repo, or nil if its metadata
+// hasn't been built since build statuses were recorded
+func (rc *RoperController) GetBuildStatus(repoName string) (*model.BuildStatus, error) {
+	var status *model.BuildStatus
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		val := tx.Bucket([]byte(build_bucket)).Get([]byte(repoName))
+		if val == nil {
+			return nil
+		}
+		status = &model.BuildStatus{}
+		return json.Unmarshal(val, status)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get build status of repo %s: %s", repoName, err)
+	}
+	return status, nil
+}
+
+// FindPackages returns the packages whose name or NEVRA matches a glob, in one repo, or in all repos
+// if repoName is empty.  An empty pattern matches every package.  Packages are sorted by repo and path.
+func (rc *RoperController) FindPackages(repoName, pattern string) ([]*model.Package, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("bad pattern %s: %s", pattern, err)
+	}
+	var pkgs []*model.Package
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		if repoName != "" && tx.Bucket([]byte(repo_bucket)).Get([]byte(repoName)) == nil {
+			return fmt.Errorf("repo with name %s not found in database", repoName)
+		}
+		return forEachRepoKey(tx, pkg_bucket, repoName, func(v []byte) error {
+			pkg := &model.Package{}
+			if err := json.Unmarshal(v, pkg); err != nil {
+				return fmt.Errorf("unable to unmarshal package: %s", err)
+			}
+			if pattern == "" || matchName(pattern, pkg.Name) || matchName(pattern, pkg.NEVRA()) {
+				pkgs = append(pkgs, pkg)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find packages: %s", err)
+	}
+	return pkgs, nil
+}
+
+// matchName returns whether a name matches a glob, which has already been checked to be valid
+func matchName(pattern, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok && name != ""
+}
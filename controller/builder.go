@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/alapidas/roper/gpg"
+	"github.com/alapidas/roper/model"
+)
+
+// MetadataBuilder handles everything that depends on the format of a repo: which files are packages,
+// how they are read and ordered, and how the repo's metadata is generated.  Each repo type has its
+// own builder.
+type MetadataBuilder interface {
+	// IsPackage reports whether the file at relPath, relative to the root of a repo, is a package
+	IsPackage(relPath string) bool
+	// ReadPackage fills in the header data of pkg from the package file at absPath.  The path and
+	// file state of pkg are already set.
+	ReadPackage(pkg *model.Package, absPath string) error
+	// Compare orders two versions of a package, returning -1, 0 or 1
+	Compare(a, b *model.Package) int
+	// Validate checks the settings of a repo that are specific to the builder
+	Validate(repo *model.Repo) error
+	// Build generates the metadata for the packages of a repo, and swaps it into place atomically.
+	// The signer is nil if the repo isn't signed.
+	Build(repo *model.Repo, signer *gpg.Signer) error
+}
+
+// RegisterBuilder sets the builder used for repos of a type, replacing any existing builder
+func (rc *RoperController) RegisterBuilder(repoType string, builder MetadataBuilder) {
+	rc.builders[repoType] = builder
+}
+
+// builder returns the builder for a repo's type
+func (rc *RoperController) builder(repo *model.Repo) (MetadataBuilder, error) {
+	repoType := repo.Type
+	if repoType == "" {
+		repoType = model.TypeYum
+	}
+	builder, ok := rc.builders[repoType]
+	if !ok {
+		return nil, fmt.Errorf("unknown repo type %q", repo.Type)
+	}
+	return builder, nil
+}
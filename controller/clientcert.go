@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/alapidas/roper/model"
+)
+
+// ClientCert returns the client certificate of a request, if it was verified against the client CAs
+func ClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// validateCertRules checks that client certificate rules are of a known kind, and that their globs
+// are valid
+func validateCertRules(rules []string) error {
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return fmt.Errorf("client certificate rule %s isn't of the form kind:value", rule)
+		}
+		kind, value := parts[0], parts[1]
+		switch kind {
+		case model.CertRuleSubject, model.CertRuleOU:
+		case model.CertRuleCN, model.CertRuleSAN:
+			if _, err := path.Match(value, ""); err != nil {
+				return fmt.Errorf("bad pattern in client certificate rule %s: %s", rule, err)
+			}
+		default:
+			return fmt.Errorf("unknown kind of client certificate rule %s", rule)
+		}
+	}
+	return nil
+}
+
+// certMatches returns whether a certificate matches any of a list of rules.  A nil certificate matches
+// nothing.
+func certMatches(cert *x509.Certificate, rules []string) bool {
+	if cert == nil {
+		return false
+	}
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		kind, value := parts[0], parts[1]
+		switch kind {
+		case model.CertRuleSubject:
+			if cert.Subject.String() == value {
+				return true
+			}
+		case model.CertRuleCN:
+			if ok, _ := path.Match(value, cert.Subject.CommonName); ok {
+				return true
+			}
+		case model.CertRuleOU:
+			for _, ou := range cert.Subject.OrganizationalUnit {
+				if ou == value {
+					return true
+				}
+			}
+		case model.CertRuleSAN:
+			for _, name := range certSANs(cert) {
+				if ok, _ := path.Match(value, name); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// certSANs returns the subject alternative names of a certificate as strings
+func certSANs(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return append(names, certURIs(cert)...)
+}
+
+// oidSubjectAltName is the id of the subject alternative name extension
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// certURIs returns the URIs in the subject alternative names of a certificate.  They are read from
+// the extension, since older versions of crypto/x509 only parse the other kinds of names.
+func certURIs(cert *x509.Certificate) []string {
+	var uris []string
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var seq asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &seq); err != nil || !seq.IsCompound {
+			continue
+		}
+		rest := seq.Bytes
+		for len(rest) > 0 {
+			var name asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &name); err != nil {
+				break
+			}
+			// uniformResourceIdentifier [6] IA5String
+			if name.Class == asn1.ClassContextSpecific && name.Tag == 6 {
+				uris = append(uris, string(name.Bytes))
+			}
+		}
+	}
+	return uris
+}
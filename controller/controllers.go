@@ -2,13 +2,19 @@ package controller
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/gpg"
 	"github.com/alapidas/roper/model"
 	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/openpgp"
 	"gopkg.in/fsnotify.v1"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,9 +24,19 @@ import (
 )
 
 var (
-	repo_bucket = "repos"
-	pkg_bucket  = "packages"
-	buckets     = []string{repo_bucket, pkg_bucket}
+	repo_bucket        = "repos"
+	pkg_bucket         = "packages"
+	quarantine_bucket  = "quarantine"
+	errata_bucket      = "errata"
+	group_bucket       = "groups"
+	environment_bucket = "environments"
+	snapshot_bucket    = "snapshots"
+	promotion_bucket   = "promotions"
+	blob_bucket        = "blobs"
+	build_bucket       = "builds"
+	user_bucket        = "users"
+	token_bucket       = "tokens"
+	buckets            = []string{repo_bucket, pkg_bucket, quarantine_bucket, errata_bucket, group_bucket, environment_bucket, snapshot_bucket, promotion_bucket, blob_bucket, build_bucket, user_bucket, token_bucket}
 )
 
 /* Singleton Controllers */
@@ -30,6 +46,27 @@ type RoperController struct {
 	crPath string
 	lock *sync.Mutex
 	locks *repoLocker
+	signing SigningConfig
+	pruneInterval time.Duration
+	mirrorInterval time.Duration
+	builders map[string]MetadataBuilder
+	proxyLock sync.Mutex
+	proxies map[string]*proxy
+	snapshotDir string
+	blobDir string
+	blobLink string
+	listenerLock sync.Mutex
+	listeners []RepoListener
+	authLock sync.Mutex
+	authCache map[string]time.Time
+}
+
+// SigningConfig holds the global settings for signing repo metadata
+type SigningConfig struct {
+	// KeyID is the key used for repos that don't set their own
+	KeyID string
+	// Passphrase returns the passphrase for a key, or nil if there is none
+	Passphrase func(keyID string) ([]byte, error)
 }
 
 type RepoWatcher struct {
@@ -43,15 +80,16 @@ type repoLocker struct {
 	locks map[string]*sync.Mutex
 }
 
-// Create the lock if it doesn't exist
+// Create the lock if it doesn't exist.  Locks are kept once created, since a lock that is waited on
+// can't be dropped, and the map is only held while looking it up, so waiting doesn't block other repos.
 func (rl *repoLocker) lock(name string) {
 	rl.Lock()
-	defer rl.Unlock()
 	lock, ok := rl.locks[name]
 	if !ok {
 		lock = &sync.Mutex{}
 		rl.locks[name] = lock
 	}
+	rl.Unlock()
 	lock.Lock()
 }
 
@@ -64,7 +102,6 @@ func (rl *repoLocker) unlock(name string) error {
 		return fmt.Errorf("no lock exists with identifier %s", name)
 	}
 	lock.Unlock()
-	delete(rl.locks, name)
 	return nil
 }
 
@@ -73,15 +110,25 @@ func Init(dbPath, crPath string) (*RoperController, error) {
 	rc := &RoperController{}
 	rc.locks = &repoLocker{locks: map[string]*sync.Mutex{}}
 
-	// if crPath is passed in, assume it's correct.  Jesus take the wheel.
+	// if crPath is passed in, assume it's correct.  Jesus take the wheel.  createrepo is only needed
+	// for repos using the createrepo backend, so it's fine if we can't find it.
 	if crPath == "" {
 		var err error
 		crPath, err = exec.LookPath("createrepo")
 		if err != nil {
-			return nil, fmt.Errorf("unable to determine createrepo path: %s", err)
+			log.WithField("error", err).Warn("createrepo not found, only the native metadata backend will be available")
 		}
 	}
 	rc.crPath = crPath
+	rc.builders = map[string]MetadataBuilder{
+		model.TypeYum:  &yumBuilder{rc: rc},
+		model.TypeApt:  &aptBuilder{},
+		model.TypeHelm: &helmBuilder{},
+		model.TypeProxy: &proxyBuilder{yumBuilder{rc: rc}},
+		model.TypeVirtual: &virtualBuilder{yumBuilder{rc: rc}},
+	}
+	rc.proxies = make(map[string]*proxy)
+	rc.snapshotDir = filepath.Join(filepath.Dir(dbPath), "snapshots")
 
 	// Open the database
 	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
@@ -105,6 +152,23 @@ func Init(dbPath, crPath string) (*RoperController, error) {
 	return rc, err
 }
 
+// SetSigningConfig sets the global settings used for signing repo metadata
+func (rc *RoperController) SetSigningConfig(cfg SigningConfig) {
+	rc.signing = cfg
+}
+
+// SetPruneInterval sets how often the monitor prunes repos with a retention policy.  Repos are not
+// pruned by the monitor if the interval is 0.
+func (rc *RoperController) SetPruneInterval(interval time.Duration) {
+	rc.pruneInterval = interval
+}
+
+// SetMirrorInterval sets how often the monitor syncs mirrored repos with their remote repos.  Mirrors
+// are not synced by the monitor if the interval is 0.
+func (rc *RoperController) SetMirrorInterval(interval time.Duration) {
+	rc.mirrorInterval = interval
+}
+
 // Close will do things at the end of the program
 func (rc *RoperController) Close() error {
 	log.WithField("db", rc.db.Path()).Info("Closing database")
@@ -114,31 +178,156 @@ func (rc *RoperController) Close() error {
 	return nil
 }
 
-func (rc *RoperController) runCreaterepo(repoName string) error {
+// buildMetadata (re)builds the metadata for a repo, using the builder for the repo's type
+func (rc *RoperController) buildMetadata(repoName string) error {
+	if err := rc.buildRepoMetadata(repoName); err != nil {
+		return err
+	}
+	// virtual repos are never members, so this doesn't recurse
+	rc.buildVirtualRepos(repoName)
+	return nil
+}
+
+// buildRepoMetadata builds the metadata of a single repo
+func (rc *RoperController) buildRepoMetadata(repoName string) error {
 	rc.locks.lock(repoName)
 	defer rc.locks.unlock(repoName)
 	repo, err := rc.GetRepo(repoName)
 	if err != nil {
 		return err
 	}
-	cmd := strings.Fields(rc.crPath)
-	argz := []string{}
-	if len(cmd) > 1 {
-		argz = append(argz, cmd[1:]...)
+	status := &model.BuildStatus{Repo: repoName, Started: time.Now().Unix(), Packages: len(repo.Packages), Running: true}
+	rc.recordBuild(status)
+	started := time.Now()
+	err = rc.build(repo)
+	status.Running, status.Duration = false, time.Since(started)
+	if err != nil {
+		status.Error = err.Error()
 	}
-	argz = append(argz, repo.AbsPath)
-	cmdp := exec.Command(cmd[0], argz...)
-	log.WithField("repo", repo.Name).Info("Running createrepo")
-	cout, err := cmdp.CombinedOutput()
+	rc.recordBuild(status)
+	return err
+}
+
+// build builds and signs the metadata of a repo
+func (rc *RoperController) build(repo *model.Repo) error {
+	builder, err := rc.builder(repo)
 	if err != nil {
-		return fmt.Errorf("Error running createrepo: %s: %s", err, string(cout))
+		return err
+	}
+	signer, err := rc.signer(repo)
+	if err != nil {
+		return fmt.Errorf("unable to load signing key for repo %s: %s", repo.Name, err)
+	}
+	if err = builder.Build(repo, signer); err != nil {
+		return err
+	}
+	if signer != nil {
+		return writePublicKey(repo, signer)
 	}
 	return nil
 }
 
+// signer loads the key used to sign a repo's metadata.  A nil signer is returned if the repo isn't
+// signed.
+func (rc *RoperController) signer(repo *model.Repo) (*gpg.Signer, error) {
+	if repo.Signing.Keyring == "" {
+		return nil, nil
+	}
+	keyID := repo.Signing.KeyID
+	if keyID == "" {
+		keyID = rc.signing.KeyID
+	}
+	var passphrase []byte
+	if rc.signing.Passphrase != nil {
+		var err error
+		if passphrase, err = rc.signing.Passphrase(keyID); err != nil {
+			return nil, fmt.Errorf("unable to get passphrase: %s", err)
+		}
+	}
+	return gpg.LoadSigner(repo.Signing.Keyring, keyID, passphrase)
+}
+
+// PublicKeyFile returns the name of the file a repo's public signing key is published as, in the
+// root of the repo
+func PublicKeyFile(repoName string) string {
+	return "RPM-GPG-KEY-" + repoName
+}
+
+// writePublicKey publishes the public half of the signing key in the root of a repo
+func writePublicKey(repo *model.Repo, signer *gpg.Signer) error {
+	pub, err := signer.PublicKey()
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(filepath.Join(repo.AbsPath, PublicKeyFile(repo.Name)), pub); err != nil {
+		return fmt.Errorf("unable to write public key for repo %s: %s", repo.Name, err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file by writing a temporary file next to it and renaming it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// reservedRepoNames are the top level paths the web server uses for itself, so no repo can be served
+// under them
+var reservedRepoNames = map[string]bool{"api": true, "ui": true}
+
+// ValidateRepoName checks that a repo name can be used as a single path element, since repos are
+// served, and their snapshots stored, under their name
+func ValidateRepoName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid repo name %q, names can't be empty, . or .., or contain slashes", name)
+	}
+	return nil
+}
+
+// validateRepoSettings checks that the settings of a repo make sense for its type
+func (rc *RoperController) validateRepoSettings(repo *model.Repo) error {
+	if err := ValidateRepoName(repo.Name); err != nil {
+		return err
+	}
+	if reservedRepoNames[repo.Name] {
+		return fmt.Errorf("repo name %s is reserved", repo.Name)
+	}
+	if repo.Retention.KeepLast < 0 || repo.Retention.MaxAgeDays < 0 {
+		return fmt.Errorf("retention limits must not be negative")
+	}
+	if err := validateMirror(repo); err != nil {
+		return err
+	}
+	if err := validateCertRules(repo.Access.ClientCerts); err != nil {
+		return err
+	}
+	if err := rc.validateMembership(repo); err != nil {
+		return err
+	}
+	builder, err := rc.builder(repo)
+	if err != nil {
+		return err
+	}
+	return builder.Validate(repo)
+}
+
 // scanForNewFields will scan all known repos for new files, and return the names of any repos
 // that are otu of sync.  This does NOT check to see that the file is the same, just that a file
-// exists.
+// exists.  See scanForChangedFiles for that.
 func (rc *RoperController) scanForNewFiles() ([]*model.Repo, error) {
 
 	ErrNewFileFound := errors.New("new file found")
@@ -154,13 +343,20 @@ func (rc *RoperController) scanForNewFiles() ([]*model.Repo, error) {
 		for pkgPath, _ := range repo.Packages {
 			pkgsInRepo[pkgPath] = struct{}{}
 		}
+		held, err := rc.quarantineMap(repo.Name)
+		if err != nil {
+			return nil, err
+		}
+		builder, err := rc.builder(repo)
+		if err != nil {
+			return nil, err
+		}
 		// look at all the actual files
-		err := filepath.Walk(repo.AbsPath, func(filePath string, info os.FileInfo, err error) error {
+		err = filepath.Walk(repo.AbsPath, func(filePath string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
-			// skip dirs and non-RPMs
-			if info.IsDir() || filepath.Ext(filePath) != ".rpm" {
+			if info.IsDir() {
 				return nil
 			}
 			// get the relpath
@@ -168,6 +364,14 @@ func (rc *RoperController) scanForNewFiles() ([]*model.Repo, error) {
 			if err != nil {
 				return err
 			}
+			// skip non-packages
+			if !builder.IsPackage(relpath) {
+				return nil
+			}
+			// quarantined files are only new again if they've been replaced
+			if pkg, ok := held[relpath]; ok && pkg.SameFile(info, fileInode(info)) {
+				return nil
+			}
 			// new file
 			if _, ok := pkgsInRepo[relpath]; !ok && !info.IsDir() {
 				log.WithFields(log.Fields{
@@ -197,6 +401,104 @@ func (rc *RoperController) scanForNewFiles() ([]*model.Repo, error) {
 	return outOfSyncRepos, nil
 }
 
+// scanForChangedFiles looks for packages whose files have been replaced or modified in place in all
+// known repos, except for those in skip.  Files are only checksummed when their size, mtime or inode
+// differ from what is in the db.  Packages with new content are re-read and the metadata for their
+// repo is rebuilt, while packages that were only touched just have their file state updated.
+func (rc *RoperController) scanForChangedFiles(skip []*model.Repo) error {
+	skipNames := make(map[string]struct{}, len(skip))
+	for _, repo := range skip {
+		skipNames[repo.Name] = struct{}{}
+	}
+	repos, err := rc.GetRepos()
+	if err != nil {
+		return fmt.Errorf("unable to get repos: %s", err)
+	}
+	for _, repo := range repos {
+		if _, ok := skipNames[repo.Name]; ok {
+			continue
+		}
+		builder, err := rc.builder(repo)
+		if err != nil {
+			return err
+		}
+		changed, touched := 0, 0
+		var keyring openpgp.EntityList
+		var held []*model.QuarantinedPackage
+		for relPath, pkg := range repo.Packages {
+			absPath := filepath.Join(repo.AbsPath, relPath)
+			fi, err := os.Stat(absPath)
+			if err != nil {
+				// missing files are picked up by scanForNewFiles
+				continue
+			}
+			if pkg.SameFile(fi, fileInode(fi)) {
+				continue
+			}
+			sum, err := fileChecksum(absPath)
+			if err != nil {
+				return fmt.Errorf("unable to checksum %s in repo %s: %s", relPath, repo.Name, err)
+			}
+			if sum == pkg.Checksum {
+				pkg.Size = fi.Size()
+				pkg.ModTime = fi.ModTime().UnixNano()
+				pkg.Inode = fileInode(fi)
+				touched++
+				continue
+			}
+			log.WithFields(log.Fields{
+				"repo": repo.Name,
+				"path": relPath,
+			}).Info("changed file on disk detected")
+			changed++
+			rc.detachBlob(absPath, pkg.Checksum)
+			pkg = rc.readPackage(builder, repo, relPath)
+			if repo.Verify.Keyring != "" {
+				if keyring == nil {
+					if keyring, err = loadKeyring(repo); err != nil {
+						return err
+					}
+				}
+				if err = verifyPackage(keyring, repo.AbsPath, pkg); err != nil {
+					held = append(held, quarantined(pkg, err))
+					delete(repo.Packages, relPath)
+					continue
+				}
+			}
+			repo.Packages[relPath] = pkg
+		}
+		if changed == 0 && touched == 0 {
+			continue
+		}
+		if err := rc.PersistRepo(repo); err != nil {
+			return fmt.Errorf("unable to persist changed packages for repo %s: %s", repo.Name, err)
+		}
+		if err := rc.addQuarantined(held...); err != nil {
+			return err
+		}
+		if changed > 0 {
+			if err := rc.buildMetadata(repo.Name); err != nil {
+				return fmt.Errorf("unable to rebuild metadata for repo %s: %s", repo.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// fileChecksum returns the hex encoded SHA-256 of a file
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 // TODO: Spaghetti if/else whomp whomp fix this
 func (rc *RoperController) StartMonitor(shutdownChan chan struct{}, errChan chan error) {
 	// Start watchers for all the repos we know about.  Start a routine for each, and make sure they
@@ -205,6 +507,22 @@ func (rc *RoperController) StartMonitor(shutdownChan chan struct{}, errChan chan
 	// TODO: Make ticker interval a param
 	ticker := time.NewTicker(time.Second * 15)
 	defer ticker.Stop()
+	// a nil channel never fires, so pruning is off unless an interval is set
+	var pruneC <-chan time.Time
+	if rc.pruneInterval > 0 {
+		pruneTicker := time.NewTicker(rc.pruneInterval)
+		defer pruneTicker.Stop()
+		pruneC = pruneTicker.C
+	}
+	var mirrorC <-chan time.Time
+	if rc.mirrorInterval > 0 {
+		mirrorTicker := time.NewTicker(rc.mirrorInterval)
+		defer mirrorTicker.Stop()
+		mirrorC = mirrorTicker.C
+	}
+	// syncs can take a long time, so they run in the background, one at a time
+	mirroring := false
+	mirrorDone := make(chan struct{}, 1)
 
 	repos, err := rc.GetRepos()
 	if err != nil {
@@ -232,6 +550,13 @@ func (rc *RoperController) StartMonitor(shutdownChan chan struct{}, errChan chan
 				errChan <- err
 				return
 			}
+			// repos with new or missing files get fully rediscovered below, so only look for
+			// modified files in the rest
+			if err = rc.scanForChangedFiles(changedRepos); err != nil {
+				log.WithField("error", err).Error("error scanning repos for changed files")
+				errChan <- err
+				return
+			}
 			if len(changedRepos) > 0 {
 				// TODO: Don't shutdown and restart all watchers, just the affected ones
 				close(watcherShutdownChan)
@@ -242,8 +567,8 @@ func (rc *RoperController) StartMonitor(shutdownChan chan struct{}, errChan chan
 				discoverErrChan := make(chan error, len(changedRepos))
 				for _, rrepo := range changedRepos {
 					repo := *rrepo
+					discoverWg.Add(1)
 					go func() {
-						discoverWg.Add(1)
 						defer discoverWg.Done()
 						if err = rc.Discover(repo.Name, repo.AbsPath); err != nil {
 							log.WithFields(log.Fields{
@@ -280,6 +605,22 @@ func (rc *RoperController) StartMonitor(shutdownChan chan struct{}, errChan chan
 				}
 				go doStartWatchers(repos)
 			}
+		case <-pruneC:
+			log.Info("Pruning repos with a retention policy")
+			rc.pruneAll()
+		case <-mirrorC:
+			if mirroring {
+				log.Warn("Previous mirror sync is still running, skipping")
+				continue
+			}
+			log.Info("Syncing mirrored repos")
+			mirroring = true
+			go func() {
+				rc.syncMirrors()
+				mirrorDone <- struct{}{}
+			}()
+		case <-mirrorDone:
+			mirroring = false
 		case err := <-watcherErrChan:
 			log.WithField("error", err).Errorf("received error from watcher")
 			errChan <- err
@@ -351,13 +692,16 @@ func (rc *RoperController) startWatchers(shutdownChan chan struct{}, errChan cha
 								relPath, err := filepath.Rel(repoWatcher.absPath, evt.Name)
 								if err != nil {
 									log.WithField("error", err).Error("error getting rel path")
-								} else {
-									delete(repo.Packages, relPath)
-									if rc.PersistRepo(repo); err != nil {
-										log.WithField("error", err).Error("Unable to persist repo")
-									}
-									if err = rc.runCreaterepo(repo.Name); err != nil {
-										log.WithField("error", err).Errorf("Error running createrepo against repo")
+								} else if _, err = os.Stat(evt.Name); err == nil {
+									// replaced by renaming a new file over it, like an upload does, which
+									// the new file's record already covers.  The watch went with the old file.
+									log.WithField("pkg_path", evt.Name).Info("package replaced, watching new file")
+									repoWatcher.Add(evt.Name)
+								} else if dropped, err := rc.removePackage(repo.Name, relPath); err != nil {
+									log.WithField("error", err).Error("Unable to remove package")
+								} else if dropped {
+									if err = rc.buildMetadata(repo.Name); err != nil {
+										log.WithField("error", err).Errorf("Error building metadata for repo")
 									}
 								}
 							}
@@ -387,43 +731,74 @@ func (rc *RoperController) RemoveRepo(name string) error {
 		if err != nil {
 			return fmt.Errorf("unable to remove repo: %s", err)
 		}
-		pr := &model.PersistableRepo{*repo}
+		pr := &model.PersistableRepo{Repo: *repo}
 		var ppackages []*model.PersistablePackage
 		for _, pkg := range repo.Packages {
-			ppackages = append(ppackages, &model.PersistablePackage{*pkg})
+			ppackages = append(ppackages, &model.PersistablePackage{Package: *pkg})
 		}
 		if err = rc.removeRepo(tx, pr); err != nil {
 			return err
 		}
+		err = forEachRepoKey(tx, snapshot_bucket, name, func(v []byte) error {
+			snap := &model.Snapshot{}
+			if err := json.Unmarshal(v, snap); err != nil {
+				return fmt.Errorf("unable to unmarshal snapshot: %s", err)
+			}
+			return rc.adjustBlobRefs(tx, snapshotChecksums(snap), -1)
+		})
+		if err != nil {
+			return err
+		}
+		if err = tx.Bucket([]byte(build_bucket)).Delete([]byte(name)); err != nil {
+			return err
+		}
+		if err = removeRoles(tx, name); err != nil {
+			return err
+		}
+		for _, bucket := range []string{quarantine_bucket, errata_bucket, group_bucket, environment_bucket, snapshot_bucket} {
+			if err = deleteRepoKeys(tx, bucket, name); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	if err != nil {
 		return fmt.Errorf("unable to delete repo: %s", err)
 	}
+	rc.notify(RepoEvent{Type: RepoRemoved, Name: name})
+	// repos with names that can't be used for snapshots have none to delete
+	if snapDir, err := rc.SnapshotDir(name); err == nil {
+		if err = os.RemoveAll(snapDir); err != nil {
+			return fmt.Errorf("unable to delete snapshots of repo %s: %s", name, err)
+		}
+	}
+	rc.buildVirtualRepos(name)
 	return nil
 }
 
 // PersistRepo will persist a Repo.  This will persist the repo and all the packages.
 // If the repo already exists, it will first be purged, along with all its associated packages.
 func (rc *RoperController) PersistRepo(repo *model.Repo) error {
-	pr := &model.PersistableRepo{*repo}
+	pr := &model.PersistableRepo{Repo: *repo}
 	var ppackages []*model.PersistablePackage
 	for _, pkg := range repo.Packages {
-		ppackages = append(ppackages, &model.PersistablePackage{*pkg})
+		ppackages = append(ppackages, &model.PersistablePackage{Package: *pkg})
 	}
 	// open xn
 	rc.locks.lock(repo.Name)
-	defer rc.locks.unlock(repo.Name)
+	var old []byte
 	err := rc.db.Update(func(tx *bolt.Tx) error {
 		// TODO: this delete code can be consolidated into the internal function call
 		pb := tx.Bucket([]byte(pkg_bucket))
 		rb := tx.Bucket([]byte(repo_bucket))
-		// delete curr packages
+		// delete curr packages, remembering when they were added
+		oldRecords := make(map[string][]byte)
 		c := pb.Cursor()
 		prefix := []byte(pr.Name + "::")
-		for k, _ := c.Seek(prefix); bytes.HasPrefix(k, prefix); k, _ = c.Next() {
-			if err := pb.Delete(k); err != nil {
-				return fmt.Errorf("unable to delete package %s: %s", k, err)
+		for k, v := c.Seek(prefix); bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			oldRecords[string(k[len(prefix):])] = append([]byte(nil), v...)
+			if err := rc.deletePackageRecord(tx, k); err != nil {
+				return err
 			}
 		}
 		// delete repo
@@ -431,6 +806,7 @@ func (rc *RoperController) PersistRepo(repo *model.Repo) error {
 		if err != nil {
 			return fmt.Errorf("unable to get serialized vals for repo %s: %s", pr.Name, err)
 		}
+		old = append(old, rb.Get(prKey)...)
 		if err := rb.Delete(prKey); err != nil { // returns nil err on nonexistent key
 			return fmt.Errorf("unable to delete repo %s: %s", pr.Name, err)
 		}
@@ -440,24 +816,41 @@ func (rc *RoperController) PersistRepo(repo *model.Repo) error {
 		}
 		// add packages
 		for _, pp := range ppackages {
-			ppKey, ppVal, err := pp.Serial()
-			if err != nil {
-				return fmt.Errorf("unable to get serialized vals for package %s in repo %s: %s", pp.RelPath, pp.RepoName, err)
-			}
-			if err := pb.Put(ppKey, ppVal); err != nil {
-				return fmt.Errorf("unable to persist package %s: %s", ppKey, err)
+			pp.Added = addedTime(&pp.Package, oldRecords[pp.RelPath])
+			if err := rc.putPackageRecord(tx, pp); err != nil {
+				return err
 			}
 		}
 		return nil
 	})
+	rc.locks.unlock(repo.Name)
 	if err != nil {
 		return fmt.Errorf("unabel to persist repo %s: %s", repo.Name, err)
 	}
+	// listeners only hear about the settings of a repo, so they aren't told when just its packages change
+	if old == nil {
+		rc.notify(RepoEvent{Type: RepoAdded, Name: repo.Name, Repo: repoSettings(repo)})
+	} else if _, val, _ := pr.Serial(); !bytes.Equal(old, val) {
+		rc.notify(RepoEvent{Type: RepoChanged, Name: repo.Name, Repo: repoSettings(repo)})
+	}
 	return nil
 }
 
+// GetPackages returns the packages of a repo, sorted by path
 func (rc *RoperController) GetPackages(repoName string) ([]*model.Package, error) {
-	return nil, fmt.Errorf("not yet implemented")
+	var pkgs []*model.Package
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(repo_bucket)).Get([]byte(repoName)) == nil {
+			return fmt.Errorf("repo with name %s not found in database", repoName)
+		}
+		var err error
+		pkgs, err = rc.getPackagesForRepo(tx, repoName)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get packages for repo %s: %s", repoName, err)
+	}
+	return pkgs, nil
 }
 
 func (rc *RoperController) GetRepo(repoName string) (*model.Repo, error) {
@@ -513,8 +906,67 @@ func (rc *RoperController) DiscoverAllKnown() error {
 	return nil
 }
 
-// Discover will create a repo at a path, and walk it, adding packages that it finds.
+// AddRepo will add a new repo to roper, using the settings on the passed in repo, and discover it.
+func (rc *RoperController) AddRepo(repo *model.Repo) error {
+	if err := rc.validateRepoSettings(repo); err != nil {
+		return fmt.Errorf("invalid settings for repo %s: %s", repo.Name, err)
+	}
+	settings := *repo
+	settings.Packages = make(map[string]*model.Package)
+	return rc.discover(&settings, nil)
+}
+
+// UpdateRepoSettings changes the settings of an existing repo.  The update function is passed the
+// current repo, and should modify it in place.  The metadata for the repo is rebuilt afterwards, and
+// the repo is rediscovered if its verification settings changed.
+func (rc *RoperController) UpdateRepoSettings(name string, update func(repo *model.Repo)) error {
+	repo, err := rc.GetRepo(name)
+	if err != nil {
+		return err
+	}
+	path, typ, verify := repo.AbsPath, repo.Type, repo.Verify
+	update(repo)
+	// the name, path and type identify the repo, and can't be changed here
+	repo.Name, repo.AbsPath, repo.Type = name, path, typ
+	if err = rc.validateRepoSettings(repo); err != nil {
+		return fmt.Errorf("invalid settings for repo %s: %s", name, err)
+	}
+	if repo.Verify != verify {
+		// packages need to be checked against the new keyring, or released from quarantine
+		known := repo.Packages
+		repo.Packages = make(map[string]*model.Package)
+		return rc.discover(repo, known)
+	}
+	if err = rc.PersistRepo(repo); err != nil {
+		return err
+	}
+	if err = rc.buildMetadata(name); err != nil {
+		return fmt.Errorf("unable to rebuild metadata for repo %s: %s", name, err)
+	}
+	return nil
+}
+
+// Discover will create a repo at a path, and walk it, adding packages that it finds.  If the repo
+// already exists, its settings are kept, and packages whose files haven't changed are not re-read.
 func (rc *RoperController) Discover(name, path string) error {
+	repo := &model.Repo{Name: name, AbsPath: path}
+	var known map[string]*model.Package
+	if existing, err := rc.GetRepo(name); err == nil {
+		repo = existing
+		if repo.AbsPath == path {
+			known = repo.Packages
+		}
+		repo.AbsPath = path
+	}
+	repo.Packages = make(map[string]*model.Package)
+	return rc.discover(repo, known)
+}
+
+// discover walks the path of a repo, adding packages that it finds, and then persists it and builds
+// its metadata.  Packages in known are reused as-is if their file looks unchanged.  If the repo verifies
+// package signatures, packages that fail verification are quarantined instead of being added.
+func (rc *RoperController) discover(repo *model.Repo, known map[string]*model.Package) error {
+	name, path := repo.Name, repo.AbsPath
 	fi, err := os.Stat(path)
 	if os.IsNotExist(err) {
 		return fmt.Errorf("unable to discover repo at path %s: %s", path, err)
@@ -525,18 +977,30 @@ func (rc *RoperController) Discover(name, path string) error {
 	if name == "" {
 		return fmt.Errorf("provided blank name for repo")
 	}
+	builder, err := rc.builder(repo)
+	if err != nil {
+		return err
+	}
+	keyring, err := loadKeyring(repo)
+	if err != nil {
+		return err
+	}
+	held, err := rc.quarantineMap(name)
+	if err != nil {
+		return err
+	}
+	quarantine := make(map[string]*model.QuarantinedPackage)
 	log.WithFields(log.Fields{
 		"name": name,
 		"path": path,
 	}).Info("Discovering repo")
-	repo := &model.Repo{Name: name, AbsPath: path, Packages: make(map[string]*model.Package)}
 	// walk all the files under the parent
 	filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		// only doing files and RPMs
-		if info.IsDir() || filepath.Ext(filePath) != ".rpm" {
+		// only doing files and packages
+		if info.IsDir() {
 			return nil
 		}
 		// get the relpath
@@ -544,8 +1008,28 @@ func (rc *RoperController) Discover(name, path string) error {
 		if err != nil {
 			return err
 		}
-		pkg := model.Package{RelPath: relpath, RepoName: name}
-		if err = repo.AddPackage(&pkg); err != nil {
+		if !builder.IsPackage(relpath) {
+			return nil
+		}
+		pkg, ok := known[relpath]
+		if !ok || !pkg.SameFile(info, fileInode(info)) || (keyring != nil && pkg.SignedBy == "") || !rc.inBlobStore(filePath, pkg) {
+			// packages stay quarantined until they are approved, rejected or replaced
+			if qpkg, ok := held[relpath]; ok && keyring != nil && qpkg.SameFile(info, fileInode(info)) {
+				quarantine[relpath] = qpkg
+				return nil
+			}
+			if ok && !pkg.SameFile(info, fileInode(info)) {
+				rc.detachBlob(filePath, pkg.Checksum)
+			}
+			pkg = rc.readPackage(builder, repo, relpath)
+			if keyring != nil {
+				if err := verifyPackage(keyring, path, pkg); err != nil {
+					quarantine[relpath] = quarantined(pkg, err)
+					return nil
+				}
+			}
+		}
+		if err = repo.AddPackage(pkg); err != nil {
 			return fmt.Errorf("unable to add package %s to repo %s: %s", relpath, name, err)
 		}
 		return nil
@@ -554,7 +1038,10 @@ func (rc *RoperController) Discover(name, path string) error {
 	if err = rc.PersistRepo(repo); err != nil {
 		return fmt.Errorf("unable to persist repo %s: %s", repo.Name, err)
 	}
-	if err = rc.runCreaterepo(repo.Name); err != nil {
+	if err = rc.setQuarantine(name, quarantine); err != nil {
+		return err
+	}
+	if err = rc.buildMetadata(repo.Name); err != nil {
 		return fmt.Errorf("Error discovering repo: %s", err)
 	}
 	log.WithFields(log.Fields{
@@ -564,6 +1051,35 @@ func (rc *RoperController) Discover(name, path string) error {
 	return nil
 }
 
+// readPackage builds a package from the package file at relPath in a repo, and puts the file in the
+// blob store if it is on.  Files that can't be parsed are still returned, but without any header data.
+func (rc *RoperController) readPackage(builder MetadataBuilder, repo *model.Repo, relPath string) *model.Package {
+	pkg := &model.Package{RelPath: relPath, RepoName: repo.Name}
+	absPath := filepath.Join(repo.AbsPath, relPath)
+	// stat before reading, so a change while we read will be noticed on the next scan
+	if fi, err := os.Stat(absPath); err == nil {
+		pkg.Size = fi.Size()
+		pkg.ModTime = fi.ModTime().UnixNano()
+		pkg.Inode = fileInode(fi)
+	}
+	if err := builder.ReadPackage(pkg, absPath); err != nil {
+		log.WithFields(log.Fields{
+			"repo":  repo.Name,
+			"path":  relPath,
+			"error": err,
+		}).Warn("unable to read package header")
+		return pkg
+	}
+	if err := rc.storeBlob(absPath, pkg); err != nil {
+		log.WithFields(log.Fields{
+			"repo":  repo.Name,
+			"path":  relPath,
+			"error": err,
+		}).Warn("unable to store package in blob store")
+	}
+	return pkg
+}
+
 // getRepo is an internal API method that gets a repo, given a transaction
 func (rc *RoperController) getRepo(tx *bolt.Tx, repoName string) (*model.Repo, error) {
 	repo := &model.Repo{}
@@ -592,8 +1108,8 @@ func (rc *RoperController) removeRepo(tx *bolt.Tx, pr *model.PersistableRepo) er
 	c := pb.Cursor()
 	prefix := []byte(pr.Name + "::")
 	for k, _ := c.Seek(prefix); bytes.HasPrefix(k, prefix); k, _ = c.Next() {
-		if err := pb.Delete(k); err != nil {
-			return fmt.Errorf("unable to delete package %s: %s", k, err)
+		if err := rc.deletePackageRecord(tx, k); err != nil {
+			return err
 		}
 	}
 	// delete repo
@@ -607,6 +1123,25 @@ func (rc *RoperController) removeRepo(tx *bolt.Tx, pr *model.PersistableRepo) er
 	return nil
 }
 
+// deleteRepoKeys is an internal API method that deletes all the records of a repo from a bucket whose
+// keys are prefixed with the repo name, given a transaction
+func deleteRepoKeys(tx *bolt.Tx, bucketName, repoName string) error {
+	b := tx.Bucket([]byte(bucketName))
+	c := b.Cursor()
+	prefix := []byte(repoName + "::")
+	// collect the keys first, since deleting under a cursor can skip entries
+	var keys [][]byte
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return fmt.Errorf("unable to delete %s from %s: %s", k, bucketName, err)
+		}
+	}
+	return nil
+}
+
 // getPackagesForRepo is an internal API method used for getting packages inside of another xn
 func (rc *RoperController) getPackagesForRepo(tx *bolt.Tx, repoName string) ([]*model.Package, error) {
 	pb := tx.Bucket([]byte(pkg_bucket))
@@ -651,7 +1186,7 @@ func (rc *RoperController) dumpPackages() {
 		return pb.ForEach(func(k, v []byte) error {
 			pkg := &model.Package{}
 			if err := json.Unmarshal(v, pkg); err != nil {
-				fmt.Errorf("unable to unmarshal package: %s", err)
+				return fmt.Errorf("unable to unmarshal package: %s", err)
 			}
 			log.WithFields(log.Fields{
 				"key":   string(k[:]),
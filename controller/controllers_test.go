@@ -1,12 +1,31 @@
 package controller
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"github.com/alapidas/roper/gpg"
+	"github.com/alapidas/roper/mirror"
 	"github.com/alapidas/roper/model"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
 	. "gopkg.in/check.v1"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func Test(t *testing.T) { TestingT(t) }
@@ -21,7 +40,7 @@ type TheSuite struct {
 var _ = Suite(&TheSuite{})
 
 func (suite *TheSuite) mkPkg(pkg_path, repoName string) (*model.Package, error) {
-	p := &model.Package{RepoName: repoName, RelPath: pkg_path}
+	p := &model.Package{RepoName: repoName, RelPath: pkg_path, Added: time.Now().Unix()}
 	if err := os.MkdirAll(
 		filepath.Join(suite.repoPath, filepath.Dir(pkg_path)),
 		0700,
@@ -145,4 +164,1650 @@ func (suite *TheSuite) TestRemoveRepo(c *C) {
 	repos, err = suite.rc.GetRepos()
 	c.Assert(err, IsNil)
 	c.Assert(len(repos), Equals, 0)
-}
\ No newline at end of file
+}
+// copyTestPkgs copies RPMs from the docker test repo into the suite's repo path
+func (suite *TheSuite) copyTestPkgs(c *C, names ...string) {
+	srcDir := filepath.Join("..", "hack", "test_repos", "docker", "7", "Packages")
+	dstDir := filepath.Join(suite.repoPath, "Packages")
+	c.Assert(os.MkdirAll(dstDir, 0700), IsNil)
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(srcDir, name))
+		c.Assert(err, IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(dstDir, name), data, 0600), IsNil)
+	}
+}
+
+func (suite *TheSuite) TestDiscoverNative(c *C) {
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm")
+	err := suite.rc.AddRepo(&model.Repo{Name: "TestRepo", AbsPath: suite.repoPath, Backend: model.BackendNative})
+	c.Assert(err, IsNil)
+
+	repo, err := suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Backend, Equals, model.BackendNative)
+	c.Assert(len(repo.Packages), Equals, 1)
+	_, err = os.Stat(filepath.Join(suite.repoPath, "repodata", "repomd.xml"))
+	c.Assert(err, IsNil)
+
+	// rediscovering keeps the repo settings
+	c.Assert(suite.rc.Discover("TestRepo", suite.repoPath), IsNil)
+	repo, err = suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Backend, Equals, model.BackendNative)
+}
+
+func (suite *TheSuite) TestAddRepoBadBackend(c *C) {
+	err := suite.rc.AddRepo(&model.Repo{Name: "TestRepo", AbsPath: suite.repoPath, Backend: "nope"})
+	c.Assert(err, NotNil)
+}
+
+func (suite *TheSuite) TestDiscoverPackageData(c *C) {
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm")
+	_, err := suite.mkPkg("Packages/broken.rpm", "TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(suite.rc.Discover("TestRepo", suite.repoPath), IsNil)
+
+	repo, err := suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(len(repo.Packages), Equals, 2)
+
+	pkg, err := repo.GetPackage("Packages/docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm")
+	c.Assert(err, IsNil)
+	c.Assert(pkg.NEVRA(), Equals, "docker-engine-selinux-1.9.0-1.el7.centos.noarch")
+	c.Assert(pkg.SourceRPM, Equals, "docker-engine-selinux-1.9.0-1.el7.centos.src.rpm")
+	c.Assert(pkg.Checksum, Equals, "3e5802011a2148068771817f81fecfe1458c460fe071dc11f517f4c39d214c4d")
+	c.Assert(pkg.Size, Equals, int64(21716))
+	c.Assert(pkg.BuildTime, Equals, int64(1446573681))
+	c.Assert(pkg.Provides, DeepEquals, []model.Dependency{
+		{Name: "docker-engine-selinux", Flags: "EQ", Version: "1.9.0", Release: "1.el7.centos"},
+	})
+	c.Assert(pkg.Conflicts, DeepEquals, []model.Dependency{{Name: "docker-selinux"}})
+	c.Assert(len(pkg.Requires) > 0, Equals, true)
+	c.Assert(len(pkg.Files) > 0, Equals, true)
+
+	// files that aren't really RPMs are tracked, but have no header data
+	broken, err := repo.GetPackage("Packages/broken.rpm")
+	c.Assert(err, IsNil)
+	c.Assert(broken.NEVRA(), Equals, "")
+}
+
+func (suite *TheSuite) TestScanForChangedFiles(c *C) {
+	name := "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm"
+	suite.copyTestPkgs(c, name)
+	c.Assert(suite.rc.Discover("TestRepo", suite.repoPath), IsNil)
+	relPath := filepath.Join("Packages", name)
+	absPath := filepath.Join(suite.repoPath, relPath)
+	repomdPath := filepath.Join(suite.repoPath, "repodata", "repomd.xml")
+	origRepomd, err := ioutil.ReadFile(repomdPath)
+	c.Assert(err, IsNil)
+
+	// nothing changed, nothing to do
+	c.Assert(suite.rc.scanForChangedFiles(nil), IsNil)
+	repo, err := suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	orig := repo.Packages[relPath]
+
+	// touching the file only updates its state
+	later := time.Now().Add(time.Hour)
+	c.Assert(os.Chtimes(absPath, later, later), IsNil)
+	c.Assert(suite.rc.scanForChangedFiles(nil), IsNil)
+	repo, err = suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	touched := repo.Packages[relPath]
+	c.Assert(touched.Checksum, Equals, orig.Checksum)
+	c.Assert(touched.ModTime, Equals, later.UnixNano())
+
+	// overwriting the file in place picks up the new package
+	data, err := ioutil.ReadFile(filepath.Join("..", "hack", "test_repos", "docker", "7", "Packages", "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm"))
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(absPath, data, 0600), IsNil)
+	c.Assert(suite.rc.scanForChangedFiles(nil), IsNil)
+	repo, err = suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	replaced := repo.Packages[relPath]
+	c.Assert(replaced.Version, Equals, "1.9.1")
+	c.Assert(replaced.Checksum, Not(Equals), orig.Checksum)
+	newRepomd, err := ioutil.ReadFile(repomdPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(newRepomd), Not(Equals), string(origRepomd))
+
+	// skipped repos aren't looked at
+	c.Assert(ioutil.WriteFile(absPath, []byte("junk"), 0600), IsNil)
+	c.Assert(suite.rc.scanForChangedFiles([]*model.Repo{repo}), IsNil)
+	repo, err = suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages[relPath].Checksum, Equals, replaced.Checksum)
+}
+
+func (suite *TheSuite) TestCreaterepoArgs(c *C) {
+	repo := &model.Repo{Name: "TestRepo", AbsPath: "/repos/test", Createrepo: model.CreaterepoOptions{
+		Update:      true,
+		CacheDir:    "/var/cache/roper",
+		Checksum:    "sha",
+		Workers:     4,
+		Deltas:      true,
+		GroupFile:   "comps.xml",
+		Distro:      []string{"cpe:/o:centos:centos:7,CentOS 7"},
+		Content:     []string{"binary-x86_64"},
+		RetainOldMD: 2,
+	}}
+	c.Assert(createrepoArgs(repo), DeepEquals, []string{
+		"--update",
+		"--cachedir", "/var/cache/roper",
+		"--checksum", "sha",
+		"--workers", "4",
+		"--deltas",
+		"--groupfile", "/repos/test/comps.xml",
+		"--distro", "cpe:/o:centos:centos:7,CentOS 7",
+		"--content", "binary-x86_64",
+		"--retain-old-md", "2",
+	})
+	c.Assert(createrepoArgs(&model.Repo{}), DeepEquals, []string{})
+
+	// the native backend can't do everything createrepo can
+	c.Assert(suite.rc.validateRepoSettings(repo), NotNil)
+	repo.Backend = model.BackendCreaterepo
+	c.Assert(suite.rc.validateRepoSettings(repo), IsNil)
+}
+
+func (suite *TheSuite) TestRepoNames(c *C) {
+	// repos are served, and their snapshots stored, under their name
+	for _, name := range []string{"", ".", "..", "a/../../x", `a\b`} {
+		c.Assert(suite.rc.AddRepo(&model.Repo{Name: name, AbsPath: suite.repoPath}), NotNil)
+		_, err := suite.rc.SnapshotDir(name)
+		c.Assert(err, NotNil)
+	}
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "..repo", AbsPath: suite.repoPath}), IsNil)
+	snapDir, err := suite.rc.SnapshotDir("..repo")
+	c.Assert(err, IsNil)
+	c.Assert(filepath.Dir(snapDir), Equals, suite.rc.snapshotDir)
+}
+
+func (suite *TheSuite) TestUpdateRepoSettings(c *C) {
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm")
+	c.Assert(suite.rc.Discover("TestRepo", suite.repoPath), IsNil)
+
+	err := suite.rc.UpdateRepoSettings("TestRepo", func(repo *model.Repo) {
+		repo.Createrepo.Workers = 2
+		repo.Createrepo.Content = []string{"binary-noarch"}
+		repo.AbsPath = "/somewhere/else"
+	})
+	c.Assert(err, IsNil)
+	repo, err := suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.AbsPath, Equals, suite.repoPath)
+	c.Assert(repo.Createrepo.Workers, Equals, 2)
+	c.Assert(len(repo.Packages), Equals, 1)
+	repomd, err := ioutil.ReadFile(filepath.Join(suite.repoPath, "repodata", "repomd.xml"))
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(repomd), "<content>binary-noarch</content>"), Equals, true)
+
+	// bad settings are rejected and not persisted
+	err = suite.rc.UpdateRepoSettings("TestRepo", func(repo *model.Repo) {
+		repo.Createrepo.Deltas = true
+	})
+	c.Assert(err, NotNil)
+	repo, err = suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Createrepo.Deltas, Equals, false)
+}
+
+// writeTestKeyring writes a new unencrypted private key to an armored keyring file
+func writeTestKeyring(c *C, path string) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("Roper Test", "", "roper@example.com", nil)
+	c.Assert(err, IsNil)
+	f, err := os.Create(path)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	w, err := armor.Encode(f, openpgp.PrivateKeyType, nil)
+	c.Assert(err, IsNil)
+	c.Assert(entity.SerializePrivate(w, nil), IsNil)
+	c.Assert(w.Close(), IsNil)
+	return entity
+}
+
+func (suite *TheSuite) TestSignedMetadata(c *C) {
+	keyring := filepath.Join(c.MkDir(), "secring.asc")
+	writeTestKeyring(c, keyring)
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm")
+	err := suite.rc.AddRepo(&model.Repo{
+		Name:    "TestRepo",
+		AbsPath: suite.repoPath,
+		Signing: model.SigningOptions{Keyring: keyring},
+	})
+	c.Assert(err, IsNil)
+
+	// the published public key verifies the signature
+	pub, err := os.Open(filepath.Join(suite.repoPath, PublicKeyFile("TestRepo")))
+	c.Assert(err, IsNil)
+	defer pub.Close()
+	pubring, err := openpgp.ReadArmoredKeyRing(pub)
+	c.Assert(err, IsNil)
+	repomd, err := os.Open(filepath.Join(suite.repoPath, "repodata", "repomd.xml"))
+	c.Assert(err, IsNil)
+	defer repomd.Close()
+	sig, err := os.Open(filepath.Join(suite.repoPath, "repodata", "repomd.xml.asc"))
+	c.Assert(err, IsNil)
+	defer sig.Close()
+	_, err = openpgp.CheckArmoredDetachedSignature(pubring, repomd, sig)
+	c.Assert(err, IsNil)
+
+	// a key that isn't in the keyring fails the build
+	suite.rc.SetSigningConfig(SigningConfig{KeyID: "DEADBEEF"})
+	c.Assert(suite.rc.buildMetadata("TestRepo"), NotNil)
+}
+
+func (suite *TheSuite) TestQuarantine(c *C) {
+	// the docker packages are signed, but not with the EPEL key
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm", "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm")
+	epelPkg := filepath.Join("..", "hack", "test_repos", "epel", "7", "x86_64", "j", "jq-1.3-2.el7.x86_64.rpm")
+	data, err := ioutil.ReadFile(epelPkg)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(suite.repoPath, "jq-1.3-2.el7.x86_64.rpm"), data, 0644), IsNil)
+
+	err = suite.rc.AddRepo(&model.Repo{
+		Name:    "TestRepo",
+		AbsPath: suite.repoPath,
+		Verify:  model.VerifyOptions{Keyring: filepath.Join("..", "hack", "test_repos", "epel", "RPM-GPG-KEY-EPEL-7")},
+	})
+	c.Assert(err, IsNil)
+	repo, err := suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 1)
+	c.Assert(repo.Packages["jq-1.3-2.el7.x86_64.rpm"].SignedBy, Not(Equals), "")
+	held, err := suite.rc.GetQuarantine("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(held, HasLen, 2)
+	c.Assert(held[0].Reason, Matches, "package is signed with unknown key .*")
+
+	// quarantined files aren't new files
+	changed, err := suite.rc.scanForNewFiles()
+	c.Assert(err, IsNil)
+	c.Assert(changed, HasLen, 0)
+	c.Assert(suite.rc.Discover("TestRepo", suite.repoPath), IsNil)
+	held, err = suite.rc.GetQuarantine("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(held, HasLen, 2)
+
+	approved := "Packages/docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm"
+	rejected := "Packages/docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm"
+	c.Assert(suite.rc.ApproveQuarantined("TestRepo", approved), IsNil)
+	c.Assert(suite.rc.RejectQuarantined("TestRepo", rejected), IsNil)
+	c.Assert(suite.rc.ApproveQuarantined("TestRepo", rejected), NotNil)
+	_, err = os.Stat(filepath.Join(suite.repoPath, rejected))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	repo, err = suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 2)
+	c.Assert(repo.Packages[approved].SignedBy, Equals, model.SignerApproved)
+	held, err = suite.rc.GetQuarantine("")
+	c.Assert(err, IsNil)
+	c.Assert(held, HasLen, 0)
+	primary := readPrimary(c, suite.repoPath)
+	c.Assert(strings.Contains(primary, approved), Equals, true)
+
+	// turning verification off releases everything
+	c.Assert(ioutil.WriteFile(filepath.Join(suite.repoPath, rejected), data, 0644), IsNil)
+	err = suite.rc.UpdateRepoSettings("TestRepo", func(repo *model.Repo) {
+		repo.Verify.Keyring = ""
+	})
+	c.Assert(err, IsNil)
+	repo, err = suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 3)
+}
+
+// readPrimary returns the uncompressed primary.xml of a repo
+func readPrimary(c *C, repoPath string) string {
+	matches, err := filepath.Glob(filepath.Join(repoPath, "repodata", "*primary.xml.gz"))
+	c.Assert(err, IsNil)
+	c.Assert(matches, HasLen, 1)
+	f, err := os.Open(matches[0])
+	c.Assert(err, IsNil)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(gz)
+	c.Assert(err, IsNil)
+	return string(data)
+}
+
+func (suite *TheSuite) TestPruneCandidates(c *C) {
+	now := time.Now()
+	day := int64(24 * time.Hour / time.Second)
+	repo := &model.Repo{Name: "TestRepo", Packages: map[string]*model.Package{}}
+	for _, pkg := range []*model.Package{
+		// the file's modification time doesn't matter once the package has a time it was added
+		{RelPath: "foo-1.9-1.x86_64.rpm", Name: "foo", Version: "1.9", Release: "1", Arch: "x86_64", Added: now.Unix() - 40*day, ModTime: now.UnixNano() - int64(400*24*time.Hour)},
+		{RelPath: "foo-1.10-1.x86_64.rpm", Name: "foo", Version: "1.10", Release: "1", Arch: "x86_64", Added: now.Unix() - 50*day},
+		{RelPath: "foo-1.10-2.x86_64.rpm", Name: "foo", Version: "1.10", Release: "2", Arch: "x86_64", Added: now.Unix() - 45*day},
+		{RelPath: "foo-1.8-1.i686.rpm", Name: "foo", Version: "1.8", Release: "1", Arch: "i686", Added: now.Unix() - 60*day},
+		// recorded before the time was kept, so aged by the file
+		{RelPath: "bar-1.0-1.noarch.rpm", Name: "bar", Version: "1.0", Release: "1", Arch: "noarch", ModTime: now.UnixNano()},
+		{RelPath: "bar-1:0.1-1.noarch.rpm", Name: "bar", Epoch: 1, Version: "0.1", Release: "1", Arch: "noarch", Added: now.Unix()},
+		{RelPath: "garbage.rpm"},
+	} {
+		pkg.RepoName = repo.Name
+		repo.Packages[pkg.RelPath] = pkg
+	}
+	paths := func(pkgs []*model.Package) []string {
+		out := []string{}
+		for _, pkg := range pkgs {
+			out = append(out, pkg.RelPath)
+		}
+		return out
+	}
+
+	c.Assert(pruneCandidates(repo, &yumBuilder{}, now), HasLen, 0)
+	repo.Retention = model.RetentionOptions{KeepLast: 2}
+	c.Assert(paths(pruneCandidates(repo, &yumBuilder{}, now)), DeepEquals, []string{"foo-1.9-1.x86_64.rpm"})
+	repo.Retention = model.RetentionOptions{KeepLast: 1}
+	c.Assert(paths(pruneCandidates(repo, &yumBuilder{}, now)), DeepEquals, []string{"bar-1.0-1.noarch.rpm", "foo-1.10-1.x86_64.rpm", "foo-1.9-1.x86_64.rpm"})
+	// the newest version is kept no matter how old it is
+	repo.Retention = model.RetentionOptions{MaxAgeDays: 42}
+	c.Assert(paths(pruneCandidates(repo, &yumBuilder{}, now)), DeepEquals, []string{"foo-1.10-1.x86_64.rpm"})
+	repo.Retention = model.RetentionOptions{KeepLast: 3, MaxAgeDays: 30}
+	c.Assert(paths(pruneCandidates(repo, &yumBuilder{}, now)), DeepEquals, []string{"foo-1.10-1.x86_64.rpm", "foo-1.9-1.x86_64.rpm"})
+}
+
+func (suite *TheSuite) TestPrune(c *C) {
+	suite.copyTestPkgs(c,
+		"docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm",
+		"docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm",
+		"docker-engine-selinux-1.9.0-1.el7.centos.src.rpm",
+		"docker-engine-selinux-1.9.1-1.el7.centos.src.rpm")
+	err := suite.rc.AddRepo(&model.Repo{
+		Name:      "TestRepo",
+		AbsPath:   suite.repoPath,
+		Retention: model.RetentionOptions{KeepLast: 1},
+	})
+	c.Assert(err, IsNil)
+	old := []string{
+		"Packages/docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm",
+		"Packages/docker-engine-selinux-1.9.0-1.el7.centos.src.rpm",
+	}
+
+	pruned, err := suite.rc.Prune("TestRepo", true)
+	c.Assert(err, IsNil)
+	c.Assert(pruned, HasLen, 2)
+	c.Assert([]string{pruned[0].RelPath, pruned[1].RelPath}, DeepEquals, old)
+	repo, err := suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 4)
+
+	pruned, err = suite.rc.Prune("TestRepo", false)
+	c.Assert(err, IsNil)
+	c.Assert(pruned, HasLen, 2)
+	repo, err = suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 2)
+	for _, relPath := range old {
+		_, err = os.Stat(filepath.Join(suite.repoPath, relPath))
+		c.Assert(os.IsNotExist(err), Equals, true)
+		c.Assert(strings.Contains(readPrimary(c, suite.repoPath), relPath), Equals, false)
+	}
+}
+
+func (suite *TheSuite) TestPackageAdded(c *C) {
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm")
+	c.Assert(suite.rc.Discover("TestRepo", suite.repoPath), IsNil)
+	relPath := "Packages/docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm"
+	repo, err := suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	added := repo.Packages[relPath].Added
+	c.Assert(added, Not(Equals), int64(0))
+
+	// reading the repo again keeps the time the package was added
+	repo.Packages[relPath].Added = 0
+	c.Assert(suite.rc.PersistRepo(repo), IsNil)
+	repo.Packages[relPath].Added = added - 100
+	c.Assert(suite.rc.PersistRepo(repo), IsNil)
+	repo, err = suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages[relPath].Added, Equals, added-100)
+	repo.Packages[relPath].Added = 0
+	c.Assert(suite.rc.PersistRepo(repo), IsNil)
+	repo, err = suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages[relPath].Added, Equals, added-100)
+
+	// a changed file is added again
+	repo.Packages[relPath].Added = 0
+	repo.Packages[relPath].Checksum = "changed"
+	c.Assert(suite.rc.PersistRepo(repo), IsNil)
+	repo, err = suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages[relPath].Added >= added, Equals, true)
+}
+
+func (suite *TheSuite) TestPruneAll(c *C) {
+	pkgs := []string{
+		"docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm",
+		"docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm",
+	}
+	suite.copyTestPkgs(c, pkgs...)
+	for _, dir := range []string{suite.repoPath2, filepath.Join(suite.repoPath2, "Packages")} {
+		c.Assert(os.MkdirAll(dir, 0700), IsNil)
+	}
+	for _, name := range pkgs {
+		data, err := ioutil.ReadFile(filepath.Join(suite.repoPath, "Packages", name))
+		c.Assert(err, IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(suite.repoPath2, "Packages", name), data, 0600), IsNil)
+	}
+	retention := model.RetentionOptions{KeepLast: 1}
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "Broken", AbsPath: suite.repoPath2, Retention: retention}), IsNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "TestRepo", AbsPath: suite.repoPath, Retention: retention}), IsNil)
+	// the broken repo sorts first, and can't be pruned
+	c.Assert(os.RemoveAll(suite.repoPath2), IsNil)
+	c.Assert(ioutil.WriteFile(suite.repoPath2, nil, 0600), IsNil)
+	_, err := suite.rc.Prune("Broken", true)
+	c.Assert(err, IsNil)
+	_, err = suite.rc.Prune("Broken", false)
+	c.Assert(err, NotNil)
+
+	suite.rc.pruneAll()
+	repo, err := suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 1)
+	c.Assert(repo.Packages["Packages/docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm"], NotNil)
+}
+
+func (suite *TheSuite) TestErrata(c *C) {
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm")
+	c.Assert(suite.rc.Discover("TestRepo", suite.repoPath), IsNil)
+	repomdPath := filepath.Join(suite.repoPath, "repodata", "repomd.xml")
+
+	adv := &model.Advisory{
+		ID:         "ROPER-2016:0001",
+		RepoName:   "TestRepo",
+		Type:       model.AdvisorySecurity,
+		Severity:   "Important",
+		Title:      "docker-engine-selinux security update",
+		References: []model.Reference{{Type: "cve", ID: "CVE-2016-0001"}},
+		Packages:   []string{"docker-engine-selinux-1.9.1-1.el7.centos.noarch"},
+	}
+	c.Assert(suite.rc.AddAdvisory(adv), IsNil)
+	repomd, err := ioutil.ReadFile(repomdPath)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(repomd), `type="updateinfo"`), Equals, true)
+
+	// adding it again updates it
+	adv2 := *adv
+	adv2.Issued = 0
+	c.Assert(suite.rc.AddAdvisory(&adv2), IsNil)
+	advs, err := suite.rc.GetAdvisories("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(advs, HasLen, 1)
+	c.Assert(advs[0].Issued, Equals, adv.Issued)
+	c.Assert(advs[0].Updated, Not(Equals), int64(0))
+
+	repo, err := suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	updates, err := suite.rc.updates(repo)
+	c.Assert(err, IsNil)
+	c.Assert(updates, HasLen, 1)
+	c.Assert(updates[0].Packages[0].Filename, Equals, "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm")
+	c.Assert(updates[0].Packages[0].Src, Equals, "docker-engine-selinux-1.9.1-1.el7.centos.src.rpm")
+
+	adv.Type = "important"
+	c.Assert(suite.rc.AddAdvisory(adv), NotNil)
+
+	c.Assert(suite.rc.RemoveAdvisory("TestRepo", "ROPER-2016:0001"), IsNil)
+	c.Assert(suite.rc.RemoveAdvisory("TestRepo", "ROPER-2016:0001"), NotNil)
+	repomd, err = ioutil.ReadFile(repomdPath)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(repomd), `type="updateinfo"`), Equals, false)
+}
+
+func (suite *TheSuite) TestGroups(c *C) {
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm")
+	c.Assert(suite.rc.Discover("TestRepo", suite.repoPath), IsNil)
+	repomdPath := filepath.Join(suite.repoPath, "repodata", "repomd.xml")
+
+	err := suite.rc.CreateGroup(&model.Group{ID: "docker", RepoName: "TestRepo", Name: "Docker", UserVisible: true})
+	c.Assert(err, IsNil)
+	c.Assert(suite.rc.CreateGroup(&model.Group{ID: "docker", RepoName: "TestRepo"}), NotNil)
+	c.Assert(suite.rc.CreateGroup(&model.Group{ID: "docker", RepoName: "NoRepo"}), NotNil)
+	err = suite.rc.UpdateGroup("TestRepo", "docker", func(group *model.Group) error {
+		group.Packages = append(group.Packages, model.GroupPackage{Name: "docker-engine-selinux", Type: model.GroupMandatory})
+		return nil
+	})
+	c.Assert(err, IsNil)
+	err = suite.rc.UpdateGroup("TestRepo", "docker", func(group *model.Group) error {
+		group.Packages = append(group.Packages, model.GroupPackage{Name: "docker-engine", Type: model.GroupConditional})
+		return nil
+	})
+	c.Assert(err, NotNil)
+	c.Assert(suite.rc.CreateEnvironment(&model.Environment{ID: "host", RepoName: "TestRepo", Groups: []string{"nope"}}), NotNil)
+	c.Assert(suite.rc.CreateEnvironment(&model.Environment{ID: "host", RepoName: "TestRepo", Groups: []string{"docker"}}), IsNil)
+
+	// a group file would be replaced by the managed groups, so the two can't be combined
+	err = suite.rc.UpdateRepoSettings("TestRepo", func(repo *model.Repo) { repo.Createrepo.GroupFile = "comps.xml" })
+	c.Assert(err, ErrorMatches, ".*can't be combined.*")
+
+	repomd, err := ioutil.ReadFile(repomdPath)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(repomd), `type="group"`), Equals, true)
+	matches, err := filepath.Glob(filepath.Join(suite.repoPath, "repodata", "*-comps.xml"))
+	c.Assert(err, IsNil)
+	c.Assert(matches, HasLen, 1)
+	comps, err := ioutil.ReadFile(matches[0])
+	c.Assert(err, IsNil)
+	c.Assert(string(comps), Matches, `(?s).*<packagereq type="mandatory">docker-engine-selinux</packagereq>.*`)
+	c.Assert(string(comps), Matches, `(?s).*<groupid>docker</groupid>.*`)
+
+	// removing the group removes it from the environment too
+	c.Assert(suite.rc.RemoveGroup("TestRepo", "docker"), IsNil)
+	envs, err := suite.rc.GetEnvironments("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(envs, HasLen, 1)
+	c.Assert(envs[0].Groups, HasLen, 0)
+	c.Assert(suite.rc.RemoveEnvironment("TestRepo", "host"), IsNil)
+	repomd, err = ioutil.ReadFile(repomdPath)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(repomd), `type="group"`), Equals, false)
+	c.Assert(ioutil.WriteFile(filepath.Join(suite.repoPath, "comps.xml"), []byte("<comps></comps>\n"), 0644), IsNil)
+	err = suite.rc.UpdateRepoSettings("TestRepo", func(repo *model.Repo) { repo.Createrepo.GroupFile = "comps.xml" })
+	c.Assert(err, IsNil)
+	c.Assert(suite.rc.CreateGroup(&model.Group{ID: "docker", RepoName: "TestRepo"}), ErrorMatches, ".*can't be combined.*")
+	c.Assert(suite.rc.CreateEnvironment(&model.Environment{ID: "host", RepoName: "TestRepo"}), ErrorMatches, ".*can't be combined.*")
+}
+
+func (suite *TheSuite) TestAptRepo(c *C) {
+	srcDir := filepath.Join("..", "hack", "test_repos", "apt", "pool", "main")
+	dstDir := filepath.Join(suite.repoPath, "pool", "main")
+	c.Assert(os.MkdirAll(dstDir, 0700), IsNil)
+	names := []string{"roper-doc_1.0-1_all.deb", "roper-hello_0.9-1_amd64.deb", "roper-hello_1.1-1_amd64.deb"}
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(srcDir, name))
+		c.Assert(err, IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(dstDir, name), data, 0600), IsNil)
+	}
+	// rpms aren't part of an apt repo
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm")
+
+	// apt repos are always built natively
+	err := suite.rc.AddRepo(&model.Repo{Name: "AptRepo", AbsPath: suite.repoPath, Type: model.TypeApt, Backend: model.BackendCreaterepo})
+	c.Assert(err, NotNil)
+	err = suite.rc.AddRepo(&model.Repo{
+		Name:      "AptRepo",
+		AbsPath:   suite.repoPath,
+		Type:      model.TypeApt,
+		Apt:       model.AptOptions{Suite: "testing", Origin: "roper"},
+		Retention: model.RetentionOptions{KeepLast: 1},
+	})
+	c.Assert(err, IsNil)
+	repo, err := suite.rc.GetRepo("AptRepo")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 3)
+	hello := repo.Packages["pool/main/roper-hello_0.9-1_amd64.deb"]
+	c.Assert(hello.Name, Equals, "roper-hello")
+	c.Assert(hello.DebVersion(), Equals, "1:0.9-1")
+	c.Assert(hello.Requires, DeepEquals, []model.Dependency{{Name: "libc6", Flags: "GE", Version: "2.17"}})
+	_, err = os.Stat(filepath.Join(suite.repoPath, "repodata"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	suiteDir := filepath.Join(suite.repoPath, "dists", "testing")
+	index, err := ioutil.ReadFile(filepath.Join(suiteDir, "main", "binary-amd64", "Packages"))
+	c.Assert(err, IsNil)
+	for _, name := range names {
+		c.Assert(strings.Contains(string(index), "Filename: pool/main/"+name), Equals, true)
+	}
+	release, err := ioutil.ReadFile(filepath.Join(suiteDir, "Release"))
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(release), "Origin: roper"), Equals, true)
+
+	// the epoch makes 0.9 the newest version
+	pruned, err := suite.rc.Prune("AptRepo", true)
+	c.Assert(err, IsNil)
+	c.Assert(pruned, HasLen, 1)
+	c.Assert(pruned[0].RelPath, Equals, "pool/main/roper-hello_1.1-1_amd64.deb")
+
+	c.Assert(suite.rc.CreateGroup(&model.Group{ID: "hello", RepoName: "AptRepo", Name: "Hello"}), NotNil)
+	err = suite.rc.UpdateRepoSettings("AptRepo", func(repo *model.Repo) {
+		repo.Verify.Keyring = "/nonexistent"
+	})
+	c.Assert(err, NotNil)
+}
+
+// fakeBuilder is a MetadataBuilder for repos of "<name>-<version>.pkg" files.  It records the packages
+// it was asked to build instead of writing any metadata.
+type fakeBuilder struct {
+	builds [][]string
+}
+
+func (b *fakeBuilder) IsPackage(relPath string) bool {
+	return filepath.Ext(relPath) == ".pkg"
+}
+
+func (b *fakeBuilder) ReadPackage(pkg *model.Package, absPath string) error {
+	base := strings.TrimSuffix(filepath.Base(absPath), ".pkg")
+	i := strings.LastIndex(base, "-")
+	if i < 0 {
+		return fmt.Errorf("bad package name %s", base)
+	}
+	pkg.Name, pkg.Version, pkg.Arch = base[:i], base[i+1:], "noarch"
+	sum, err := fileChecksum(absPath)
+	pkg.Checksum = sum
+	return err
+}
+
+func (b *fakeBuilder) Compare(p1, p2 *model.Package) int {
+	v1, _ := strconv.Atoi(p1.Version)
+	v2, _ := strconv.Atoi(p2.Version)
+	return v1 - v2
+}
+
+func (b *fakeBuilder) Validate(repo *model.Repo) error {
+	if repo.Backend != "" {
+		return fmt.Errorf("fake repos have no backends")
+	}
+	return nil
+}
+
+func (b *fakeBuilder) Build(repo *model.Repo, signer *gpg.Signer) error {
+	var relPaths []string
+	for relPath := range repo.Packages {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+	b.builds = append(b.builds, relPaths)
+	return nil
+}
+
+func (suite *TheSuite) TestMetadataBuilder(c *C) {
+	builder := &fakeBuilder{}
+	suite.rc.RegisterBuilder("fake", builder)
+	for _, name := range []string{"foo-1.pkg", "foo-2.pkg", "README"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(suite.repoPath, name), []byte(name), 0600), IsNil)
+	}
+
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "Bogus", AbsPath: suite.repoPath, Type: "bogus"}), NotNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "Fake", AbsPath: suite.repoPath, Type: "fake", Backend: model.BackendNative}), NotNil)
+	c.Assert(builder.builds, HasLen, 0)
+
+	err := suite.rc.AddRepo(&model.Repo{
+		Name:      "Fake",
+		AbsPath:   suite.repoPath,
+		Type:      "fake",
+		Retention: model.RetentionOptions{KeepLast: 1},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(builder.builds, DeepEquals, [][]string{{"foo-1.pkg", "foo-2.pkg"}})
+	repo, err := suite.rc.GetRepo("Fake")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages["foo-2.pkg"].Name, Equals, "foo")
+	c.Assert(repo.Packages["foo-2.pkg"].Checksum, Not(Equals), "")
+
+	// new files are found by the monitor's scan, and picked up by rediscovery
+	c.Assert(ioutil.WriteFile(filepath.Join(suite.repoPath, "foo-10.pkg"), []byte("foo-10"), 0600), IsNil)
+	outOfSync, err := suite.rc.scanForNewFiles()
+	c.Assert(err, IsNil)
+	c.Assert(outOfSync, HasLen, 1)
+	c.Assert(suite.rc.Discover("Fake", suite.repoPath), IsNil)
+	c.Assert(builder.builds[len(builder.builds)-1], DeepEquals, []string{"foo-1.pkg", "foo-10.pkg", "foo-2.pkg"})
+
+	// retention uses the builder's version ordering
+	pruned, err := suite.rc.Prune("Fake", false)
+	c.Assert(err, IsNil)
+	c.Assert(pruned, HasLen, 2)
+	c.Assert([]string{pruned[0].RelPath, pruned[1].RelPath}, DeepEquals, []string{"foo-1.pkg", "foo-2.pkg"})
+	c.Assert(builder.builds[len(builder.builds)-1], DeepEquals, []string{"foo-10.pkg"})
+}
+
+func (suite *TheSuite) TestHelmRepo(c *C) {
+	srcDir := filepath.Join("..", "hack", "test_repos", "helm")
+	copyChart := func(name, dst string) {
+		data, err := ioutil.ReadFile(filepath.Join(srcDir, name))
+		c.Assert(err, IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(suite.repoPath, dst), data, 0600), IsNil)
+	}
+	readIndex := func() string {
+		data, err := ioutil.ReadFile(filepath.Join(suite.repoPath, "index.yaml"))
+		c.Assert(err, IsNil)
+		return string(data)
+	}
+	copyChart("roper-web-0.1.0.tgz", "roper-web-0.1.0.tgz")
+
+	err := suite.rc.AddRepo(&model.Repo{Name: "Charts", AbsPath: suite.repoPath, Type: model.TypeHelm, Signing: model.SigningOptions{Keyring: "/nonexistent"}})
+	c.Assert(err, NotNil)
+	err = suite.rc.AddRepo(&model.Repo{Name: "Charts", AbsPath: suite.repoPath, Type: model.TypeHelm, Helm: model.HelmOptions{URL: "http://charts.example.com/Charts"}})
+	c.Assert(err, IsNil)
+	repo, err := suite.rc.GetRepo("Charts")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 1)
+	c.Assert(repo.Packages["roper-web-0.1.0.tgz"].Version, Equals, "0.1.0")
+	c.Assert(strings.Contains(readIndex(), "- http://charts.example.com/Charts/roper-web-0.1.0.tgz"), Equals, true)
+
+	// the monitor picks up new charts
+	copyChart("roper-worker-1.0.0-rc.1.tgz", "roper-worker-1.0.0-rc.1.tgz")
+	outOfSync, err := suite.rc.scanForNewFiles()
+	c.Assert(err, IsNil)
+	c.Assert(outOfSync, HasLen, 1)
+	c.Assert(suite.rc.Discover("Charts", suite.repoPath), IsNil)
+	c.Assert(strings.Contains(readIndex(), "roper-worker"), Equals, true)
+
+	// and charts that are replaced in place
+	oldDigest := repo.Packages["roper-web-0.1.0.tgz"].Checksum
+	copyChart("roper-web-0.2.0.tgz", "roper-web-0.1.0.tgz")
+	c.Assert(suite.rc.scanForChangedFiles(nil), IsNil)
+	index := readIndex()
+	c.Assert(strings.Contains(index, oldDigest), Equals, false)
+	c.Assert(strings.Contains(index, "version: 0.2.0"), Equals, true)
+}
+
+func (suite *TheSuite) TestMirror(c *C) {
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "hack", "test_repos"))))
+	defer server.Close()
+	upstream := server.URL + "/docker/7/"
+	mirrorPath := filepath.Join(suite.repoPath, "mirror")
+
+	_, err := suite.rc.AddMirror(&model.Repo{Name: "Mirror", AbsPath: mirrorPath, Mirror: model.MirrorOptions{URL: "ftp://example.com/"}})
+	c.Assert(err, NotNil)
+	_, err = suite.rc.AddMirror(&model.Repo{Name: "Mirror", AbsPath: mirrorPath, Type: model.TypeApt, Mirror: model.MirrorOptions{URL: upstream}})
+	c.Assert(err, NotNil)
+	// the web server's own paths can't be mirrored to either
+	_, err = suite.rc.AddMirror(&model.Repo{Name: "api", AbsPath: mirrorPath, Mirror: model.MirrorOptions{URL: upstream}})
+	c.Assert(err, NotNil)
+	_, err = os.Stat(mirrorPath)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// only the selinux packages listed in the test repo's metadata are actually there
+	report, err := suite.rc.AddMirror(&model.Repo{Name: "Mirror", AbsPath: mirrorPath, Mirror: model.MirrorOptions{URL: upstream, Delete: true}})
+	c.Assert(err, ErrorMatches, "unable to download 8 packages from .*")
+	c.Assert(report.Downloaded, HasLen, 4)
+	repo, err := suite.rc.GetRepo("Mirror")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Mirror.URL, Equals, upstream)
+	c.Assert(repo.Packages, HasLen, 4)
+	pkg := repo.Packages[filepath.Join("Packages", "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm")]
+	c.Assert(pkg, NotNil)
+	c.Assert(pkg.Name, Equals, "docker-engine-selinux")
+	_, err = os.Stat(filepath.Join(mirrorPath, "repodata", "repomd.xml"))
+	c.Assert(err, IsNil)
+
+	_, err = suite.rc.AddMirror(&model.Repo{Name: "Mirror", AbsPath: suite.repoPath2, Mirror: model.MirrorOptions{URL: upstream}})
+	c.Assert(err, ErrorMatches, "repo Mirror already exists")
+
+	// packages that aren't upstream are deleted, and ones that went missing locally come back
+	extra := filepath.Join(mirrorPath, "Packages", "old-1.0-1.noarch.rpm")
+	c.Assert(ioutil.WriteFile(extra, []byte("old"), 0644), IsNil)
+	c.Assert(os.Remove(filepath.Join(mirrorPath, pkg.RelPath)), IsNil)
+	report, err = suite.rc.SyncMirror("Mirror")
+	c.Assert(err, NotNil)
+	c.Assert(report.Downloaded, DeepEquals, []string{pkg.RelPath})
+	c.Assert(report.Deleted, DeepEquals, []string{filepath.Join("Packages", "old-1.0-1.noarch.rpm")})
+	repo, err = suite.rc.GetRepo("Mirror")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 4)
+
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "Local", AbsPath: suite.repoPath2}), IsNil)
+	_, err = suite.rc.SyncMirror("Local")
+	c.Assert(err, ErrorMatches, "repo Local is not a mirror")
+}
+
+func (suite *TheSuite) TestProxy(c *C) {
+	var lock sync.Mutex
+	hits := map[string]int{}
+	files := http.FileServer(http.Dir(filepath.Join("..", "hack", "test_repos")))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits[strings.TrimPrefix(r.URL.Path, "/docker/7/")]++
+		lock.Unlock()
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	err := suite.rc.AddRepo(&model.Repo{Name: "Proxy", AbsPath: suite.repoPath, Type: model.TypeProxy})
+	c.Assert(err, NotNil)
+	err = suite.rc.AddRepo(&model.Repo{Name: "Proxy", AbsPath: suite.repoPath, Type: model.TypeProxy, Proxy: model.ProxyOptions{URL: server.URL + "/nothing/"}})
+	c.Assert(err, NotNil)
+	err = suite.rc.AddRepo(&model.Repo{Name: "Proxy", AbsPath: suite.repoPath, Type: model.TypeProxy, Proxy: model.ProxyOptions{URL: server.URL + "/docker/7/"}})
+	c.Assert(err, IsNil)
+	c.Assert(hits["repodata/repomd.xml"], Equals, 1)
+	repo, err := suite.rc.GetRepo("Proxy")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 0)
+
+	handler := suite.rc.ProxyHandler(repo)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/"+path, nil))
+		return rec
+	}
+
+	// metadata is served from the cache until the TTL runs out
+	want, err := ioutil.ReadFile(filepath.Join("..", "hack", "test_repos", "docker", "7", "repodata", "repomd.xml"))
+	c.Assert(err, IsNil)
+	rec := get("repodata/repomd.xml")
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), Equals, string(want))
+	c.Assert(hits["repodata/repomd.xml"], Equals, 1)
+	suite.rc.proxy(repo).nextCheck = time.Time{}
+	c.Assert(get("repodata/repomd.xml").Code, Equals, http.StatusOK)
+	c.Assert(hits["repodata/repomd.xml"], Equals, 2)
+
+	// packages are fetched, stored and added to the repo
+	relPath := filepath.Join("Packages", "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm")
+	want, err = ioutil.ReadFile(filepath.Join("..", "hack", "test_repos", "docker", "7", relPath))
+	c.Assert(err, IsNil)
+	rec = get(filepath.ToSlash(relPath))
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(rec.Body.Len(), Equals, len(want))
+	got, err := ioutil.ReadFile(filepath.Join(suite.repoPath, relPath))
+	c.Assert(err, IsNil)
+	c.Assert(len(got), Equals, len(want))
+	repo, err = suite.rc.GetRepo("Proxy")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 1)
+	c.Assert(repo.Packages[relPath].Name, Equals, "docker-engine-selinux")
+	outOfSync, err := suite.rc.scanForNewFiles()
+	c.Assert(err, IsNil)
+	c.Assert(outOfSync, HasLen, 0)
+
+	// files the remote repo doesn't have are remembered
+	missing := "Packages/docker-engine-1.7.0-1.el7.centos.x86_64.rpm"
+	c.Assert(get(missing).Code, Equals, http.StatusNotFound)
+	c.Assert(get(missing).Code, Equals, http.StatusNotFound)
+	c.Assert(hits[missing], Equals, 1)
+	c.Assert(get("Packages/").Code, Equals, http.StatusNotFound)
+
+	// files the remote metadata doesn't list aren't fetched or remembered, except for GPG keys
+	unlisted := "Packages/docker-engine-1.9.1-1.el7.centos.noarch.rpm"
+	c.Assert(get(unlisted).Code, Equals, http.StatusNotFound)
+	c.Assert(hits[unlisted], Equals, 0)
+	c.Assert(suite.rc.proxy(repo).missing, HasLen, 1)
+	c.Assert(get("RPM-GPG-KEY-docker").Code, Equals, http.StatusNotFound)
+	c.Assert(hits["RPM-GPG-KEY-docker"], Equals, 1)
+	c.Assert(suite.rc.proxy(repo).missing, HasLen, 1)
+
+	err = suite.rc.UpdateRepoSettings("Proxy", func(repo *model.Repo) { repo.Verify.Keyring = "/nonexistent" })
+	c.Assert(err, NotNil)
+}
+
+func (suite *TheSuite) TestVirtualRepo(c *C) {
+	srcDir := filepath.Join("..", "hack", "test_repos", "docker", "7", "Packages")
+	copyRPM := func(repoPath, name string) {
+		data, err := ioutil.ReadFile(filepath.Join(srcDir, name))
+		c.Assert(err, IsNil)
+		c.Assert(os.MkdirAll(filepath.Join(repoPath, "Packages"), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(repoPath, "Packages", name), data, 0644), IsNil)
+	}
+	copyRPM(suite.repoPath, "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm")
+	copyRPM(suite.repoPath, "docker-engine-selinux-1.9.0-1.el7.centos.src.rpm")
+	copyRPM(suite.repoPath2, "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm")
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "Base", AbsPath: suite.repoPath}), IsNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "Updates", AbsPath: suite.repoPath2}), IsNil)
+	virtPath := c.MkDir()
+	locations := func() []string {
+		pkgs, err := mirror.ReadPackages(virtPath)
+		c.Assert(err, IsNil)
+		var locs []string
+		for _, pkg := range pkgs {
+			locs = append(locs, pkg.Location)
+		}
+		return locs
+	}
+
+	for _, opts := range []model.VirtualOptions{
+		{},
+		{Members: []string{"Updates", "Nothing"}},
+		{Members: []string{"Updates", "Updates"}},
+		{Members: []string{"Updates"}, Resolve: "oldest"},
+	} {
+		err := suite.rc.AddRepo(&model.Repo{Name: "All", AbsPath: virtPath, Type: model.TypeVirtual, Virtual: opts})
+		c.Assert(err, NotNil)
+	}
+
+	// the first member with a package name.arch provides it
+	err := suite.rc.AddRepo(&model.Repo{Name: "All", AbsPath: virtPath, Type: model.TypeVirtual, Virtual: model.VirtualOptions{Members: []string{"Updates", "Base"}}})
+	c.Assert(err, IsNil)
+	c.Assert(locations(), DeepEquals, []string{
+		"Base/Packages/docker-engine-selinux-1.9.0-1.el7.centos.src.rpm",
+		"Updates/Packages/docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm",
+	})
+	_, err = os.Stat(filepath.Join(virtPath, "Packages"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// or the newest version in any member
+	err = suite.rc.UpdateRepoSettings("All", func(repo *model.Repo) {
+		repo.Virtual.Members = []string{"Base", "Updates"}
+		repo.Virtual.Resolve = model.ResolveNewest
+	})
+	c.Assert(err, IsNil)
+	c.Assert(locations(), DeepEquals, []string{
+		"Base/Packages/docker-engine-selinux-1.9.0-1.el7.centos.src.rpm",
+		"Updates/Packages/docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm",
+	})
+
+	// changes to members show up in the virtual repo
+	err = suite.rc.UpdateRepoSettings("All", func(repo *model.Repo) {
+		repo.Virtual.Members = []string{"Updates", "Base"}
+		repo.Virtual.Resolve = model.ResolvePriority
+	})
+	c.Assert(err, IsNil)
+	copyRPM(suite.repoPath2, "docker-engine-selinux-1.9.1-1.el7.centos.src.rpm")
+	c.Assert(suite.rc.Discover("Updates", suite.repoPath2), IsNil)
+	c.Assert(locations(), DeepEquals, []string{
+		"Updates/Packages/docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm",
+		"Updates/Packages/docker-engine-selinux-1.9.1-1.el7.centos.src.rpm",
+	})
+
+	// member files are served under the member's name
+	repo, err := suite.rc.GetRepo("All")
+	c.Assert(err, IsNil)
+	handler := suite.rc.FallbackHandler(repo)
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/"+path, nil))
+		return rec.Code
+	}
+	c.Assert(get("Updates/Packages/docker-engine-selinux-1.9.1-1.el7.centos.src.rpm"), Equals, http.StatusOK)
+	c.Assert(get("Base/Packages/docker-engine-selinux-1.9.0-1.el7.centos.src.rpm"), Equals, http.StatusOK)
+	c.Assert(get("Updates/Packages/nothing.rpm"), Equals, http.StatusNotFound)
+	c.Assert(get("Other/Packages/docker-engine-selinux-1.9.1-1.el7.centos.src.rpm"), Equals, http.StatusNotFound)
+
+	c.Assert(suite.rc.RemoveRepo("Updates"), IsNil)
+	c.Assert(locations(), DeepEquals, []string{
+		"Base/Packages/docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm",
+		"Base/Packages/docker-engine-selinux-1.9.0-1.el7.centos.src.rpm",
+	})
+}
+
+func (suite *TheSuite) TestMergePackages(c *C) {
+	member := func(name string, pkgs ...*model.Package) *model.Repo {
+		repo := &model.Repo{Name: name, Packages: make(map[string]*model.Package)}
+		for _, pkg := range pkgs {
+			pkg.RelPath = pkg.Name + "-" + pkg.Version + "." + pkg.Arch + ".rpm"
+			repo.Packages[pkg.RelPath] = pkg
+		}
+		return repo
+	}
+	a := member("A",
+		&model.Package{Name: "foo", Version: "1.0", Release: "1", Arch: "x86_64"},
+		&model.Package{Name: "foo", Version: "0.9", Release: "1", Arch: "x86_64"},
+		&model.Package{Name: "bar", Version: "2.0", Release: "1", Arch: "noarch"},
+		&model.Package{Name: "same", Version: "1.0", Release: "1", Arch: "noarch"},
+	)
+	b := member("B",
+		&model.Package{Name: "foo", Version: "1.10", Release: "1", Arch: "x86_64"},
+		&model.Package{Name: "bar", Version: "1.0", Release: "1", Arch: "noarch"},
+		&model.Package{Name: "baz", Version: "1.0", Release: "1", Arch: "noarch"},
+		&model.Package{Name: "same", Version: "1.0", Release: "1", Arch: "noarch"},
+	)
+	merged := func(resolve string) []string {
+		var got []string
+		for _, mp := range mergePackages([]*model.Repo{a, b}, resolve) {
+			got = append(got, mp.repo.Name+"/"+mp.pkg.RelPath)
+		}
+		sort.Strings(got)
+		return got
+	}
+	// the first member providing a name.arch wins, with all its versions
+	c.Assert(merged(model.ResolvePriority), DeepEquals, []string{
+		"A/bar-2.0.noarch.rpm", "A/foo-0.9.x86_64.rpm", "A/foo-1.0.x86_64.rpm", "A/same-1.0.noarch.rpm", "B/baz-1.0.noarch.rpm",
+	})
+	// the highest version in any member wins, and ties go to the first member
+	c.Assert(merged(model.ResolveNewest), DeepEquals, []string{
+		"A/bar-2.0.noarch.rpm", "A/same-1.0.noarch.rpm", "B/baz-1.0.noarch.rpm", "B/foo-1.10.x86_64.rpm",
+	})
+}
+
+func (suite *TheSuite) TestVirtualRepoPrivateMembers(c *C) {
+	data, err := ioutil.ReadFile(filepath.Join("..", "hack", "test_repos", "docker", "7", "Packages", "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm"))
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(suite.repoPath, "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm"), data, 0644), IsNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "Internal", AbsPath: suite.repoPath, Access: model.AccessOptions{Private: true}}), IsNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "Public", AbsPath: suite.repoPath2}), IsNil)
+	virtPath := c.MkDir()
+
+	// public virtual repos would list the packages of private members to everyone
+	virtual := &model.Repo{Name: "All", AbsPath: virtPath, Type: model.TypeVirtual, Virtual: model.VirtualOptions{Members: []string{"Public", "Internal"}}}
+	c.Assert(suite.rc.AddRepo(virtual), NotNil)
+	virtual.Access.Private = true
+	c.Assert(suite.rc.AddRepo(virtual), IsNil)
+	c.Assert(suite.rc.UpdateRepoSettings("All", func(repo *model.Repo) { repo.Access.Private = false }), NotNil)
+	c.Assert(suite.rc.UpdateRepoSettings("Public", func(repo *model.Repo) { repo.Access.Private = true }), IsNil)
+
+	// readers of the virtual repo also need to be able to read the member
+	c.Assert(suite.rc.AddUser("dev", "secret", false), IsNil)
+	c.Assert(suite.rc.GrantRole("dev", "All", model.RoleRead), IsNil)
+	repo, err := suite.rc.GetRepo("All")
+	c.Assert(err, IsNil)
+	handler := suite.rc.FallbackHandler(repo)
+	get := func(user string) int {
+		r := httptest.NewRequest("GET", "/Internal/docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm", nil)
+		if user != "" {
+			r.SetBasicAuth(user, "secret")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	c.Assert(get(""), Equals, http.StatusUnauthorized)
+	c.Assert(get("dev"), Equals, http.StatusForbidden)
+	c.Assert(suite.rc.GrantRole("dev", "Internal", model.RoleRead), IsNil)
+	c.Assert(get("dev"), Equals, http.StatusOK)
+}
+
+func (suite *TheSuite) TestSnapshots(c *C) {
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm", "docker-engine-selinux-1.9.0-1.el7.centos.src.rpm")
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "Repo", AbsPath: suite.repoPath}), IsNil)
+	srcPath := filepath.Join("Packages", "docker-engine-selinux-1.9.0-1.el7.centos.src.rpm")
+
+	snap, err := suite.rc.CreateSnapshot("Repo", "")
+	c.Assert(err, IsNil)
+	c.Assert(snap.ID, Equals, 1)
+	c.Assert(snap.Packages, HasLen, 2)
+	// without the blob store, the files are copies that hold no blob references
+	for _, pkg := range snap.Packages {
+		c.Assert(pkg.Blob, Equals, false)
+	}
+	repoSnaps, err := suite.rc.SnapshotDir("Repo")
+	c.Assert(err, IsNil)
+	snapDir := filepath.Join(repoSnaps, "1")
+	live, err := os.Stat(filepath.Join(suite.repoPath, srcPath))
+	c.Assert(err, IsNil)
+	cloned, err := os.Stat(filepath.Join(snapDir, srcPath))
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(live, cloned), Equals, false)
+	c.Assert(cloned.Mode().Perm(), Equals, os.FileMode(0444))
+	liveData, err := ioutil.ReadFile(filepath.Join(suite.repoPath, srcPath))
+	c.Assert(err, IsNil)
+	snapRepomd, err := ioutil.ReadFile(filepath.Join(snapDir, "repodata", "repomd.xml"))
+	c.Assert(err, IsNil)
+
+	_, err = suite.rc.CreateSnapshot("Repo", "42")
+	c.Assert(err, NotNil)
+	snap, err = suite.rc.CreateSnapshot("Repo", "release")
+	c.Assert(err, IsNil)
+	c.Assert(snap.ID, Equals, 2)
+	_, err = suite.rc.CreateSnapshot("Repo", "release")
+	c.Assert(err, NotNil)
+	_, err = suite.rc.CreateSnapshot("Nothing", "")
+	c.Assert(err, NotNil)
+
+	// snapshots don't change with the repo, even when a file is rewritten in place
+	f, err := os.OpenFile(filepath.Join(suite.repoPath, srcPath), os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	_, err = f.WriteAt([]byte("rewritten"), 0)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(snapDir, srcPath))
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(data, liveData), Equals, true)
+	c.Assert(os.Remove(filepath.Join(suite.repoPath, srcPath)), IsNil)
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm")
+	c.Assert(suite.rc.Discover("Repo", suite.repoPath), IsNil)
+	_, err = os.Stat(filepath.Join(snapDir, srcPath))
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadFile(filepath.Join(snapDir, "repodata", "repomd.xml"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, string(snapRepomd))
+	data, err = ioutil.ReadFile(filepath.Join(suite.repoPath, "repodata", "repomd.xml"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Not(Equals), string(snapRepomd))
+
+	added, removed, err := suite.rc.DiffSnapshots("Repo", "1", "")
+	c.Assert(err, IsNil)
+	c.Assert(added, HasLen, 1)
+	c.Assert(added[0].NEVRA, Equals, "docker-engine-selinux-1.9.1-1.el7.centos.noarch")
+	c.Assert(removed, HasLen, 1)
+	c.Assert(removed[0].RelPath, Equals, srcPath)
+	added, removed, err = suite.rc.DiffSnapshots("Repo", "1", "release")
+	c.Assert(err, IsNil)
+	c.Assert(added, HasLen, 0)
+	c.Assert(removed, HasLen, 0)
+
+	c.Assert(suite.rc.RemoveSnapshot("Repo", "release"), IsNil)
+	_, err = os.Stat(filepath.Join(repoSnaps, "2"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+	snaps, err := suite.rc.GetSnapshots("Repo")
+	c.Assert(err, IsNil)
+	c.Assert(snaps, HasLen, 1)
+	c.Assert(suite.rc.RemoveSnapshot("Repo", "release"), NotNil)
+
+	c.Assert(suite.rc.RemoveRepo("Repo"), IsNil)
+	_, err = os.Stat(repoSnaps)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	snaps, err = suite.rc.GetSnapshots("Repo")
+	c.Assert(err, IsNil)
+	c.Assert(snaps, HasLen, 0)
+}
+
+func (suite *TheSuite) TestPromote(c *C) {
+	suite.copyTestPkgs(c,
+		"docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm",
+		"docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm",
+		"docker-engine-selinux-1.9.1-1.el7.centos.src.rpm")
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "dev", AbsPath: suite.repoPath}), IsNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "staging", AbsPath: suite.repoPath2}), IsNil)
+
+	_, err := suite.rc.Promote("nothing-*", "dev", "staging", PromoteOptions{})
+	c.Assert(err, NotNil)
+	_, err = suite.rc.Promote("*", "dev", "dev", PromoteOptions{})
+	c.Assert(err, NotNil)
+
+	promotion, err := suite.rc.Promote("docker-engine-selinux-1.9.1-*.noarch", "dev", "staging", PromoteOptions{User: "tester"})
+	c.Assert(err, IsNil)
+	c.Assert(promotion.Packages, DeepEquals, []string{"docker-engine-selinux-1.9.1-1.el7.centos.noarch"})
+	relPath := "Packages/docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm"
+	repo, err := suite.rc.GetRepo("staging")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 1)
+	c.Assert(repo.Packages[relPath].RepoName, Equals, "staging")
+	src, err := os.Stat(filepath.Join(suite.repoPath, relPath))
+	c.Assert(err, IsNil)
+	dest, err := os.Stat(filepath.Join(suite.repoPath2, relPath))
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(src, dest), Equals, true)
+	_, err = os.Stat(filepath.Join(suite.repoPath2, "repodata", "repomd.xml"))
+	c.Assert(err, IsNil)
+
+	// promoting again changes nothing, and copies are real copies
+	promotion, err = suite.rc.Promote("docker-engine-selinux-1.9.1-*", "dev", "staging", PromoteOptions{Copy: true})
+	c.Assert(err, IsNil)
+	c.Assert(promotion.Packages, DeepEquals, []string{"docker-engine-selinux-1.9.1-1.el7.centos.src"})
+	src, err = os.Stat(filepath.Join(suite.repoPath, "Packages", "docker-engine-selinux-1.9.1-1.el7.centos.src.rpm"))
+	c.Assert(err, IsNil)
+	dest, err = os.Stat(filepath.Join(suite.repoPath2, "Packages", "docker-engine-selinux-1.9.1-1.el7.centos.src.rpm"))
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(src, dest), Equals, false)
+
+	// a dry run only reports what would be promoted
+	promotion, err = suite.rc.Promote("*", "dev", "staging", PromoteOptions{DryRun: true})
+	c.Assert(err, IsNil)
+	c.Assert(promotion.Packages, DeepEquals, []string{"docker-engine-selinux-1.9.0-1.el7.centos.noarch"})
+	repo, err = suite.rc.GetRepo("staging")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages, HasLen, 2)
+
+	promotions, err := suite.rc.GetPromotions("dev")
+	c.Assert(err, IsNil)
+	c.Assert(promotions, HasLen, 2)
+	c.Assert(promotions[0].User, Equals, "tester")
+	c.Assert(promotions[0].From, Equals, "dev")
+	c.Assert(promotions[0].To, Equals, "staging")
+	promotions, err = suite.rc.GetPromotions("other")
+	c.Assert(err, IsNil)
+	c.Assert(promotions, HasLen, 0)
+}
+
+func (suite *TheSuite) TestMissingDeps(c *C) {
+	pkg := func(name, version string, requires ...model.Dependency) *model.Package {
+		return &model.Package{Name: name, Version: version, Release: "1", Arch: "x86_64", RelPath: name + "-" + version + ".rpm", Requires: requires}
+	}
+	app := pkg("app", "2.0",
+		model.Dependency{Name: "lib", Flags: "GE", Version: "1.5"},
+		model.Dependency{Name: "/usr/bin/tool"},
+		model.Dependency{Name: "glibc"},
+		model.Dependency{Name: "rpmlib(PayloadIsXz)"})
+	lib1, lib2 := pkg("lib", "1.0"), pkg("lib", "1.6", model.Dependency{Name: "base"})
+	lib3 := pkg("lib", "1.7", model.Dependency{Name: "base"})
+	tool := pkg("tools", "1.0")
+	tool.Files = []string{"/usr/bin/tool"}
+	base := pkg("base", "1.0")
+	source := &model.Repo{Packages: map[string]*model.Package{}}
+	for _, p := range []*model.Package{app, lib1, lib2, lib3, tool, base} {
+		source.Packages[p.RelPath] = p
+	}
+	nevras := func(pkgs []*model.Package) []string {
+		var ns []string
+		for _, p := range pkgs {
+			ns = append(ns, p.NEVRA())
+		}
+		return ns
+	}
+
+	// the newest provider is pulled in, along with what it requires
+	target := &model.Repo{Packages: map[string]*model.Package{}}
+	c.Assert(nevras(missingDeps(source, target, []*model.Package{app})), DeepEquals, []string{
+		"lib-1.7-1.x86_64", "tools-1.0-1.x86_64", "base-1.0-1.x86_64",
+	})
+
+	// requirements the target satisfies are left alone
+	target.Packages = map[string]*model.Package{lib2.RelPath: lib2, base.RelPath: base}
+	c.Assert(nevras(missingDeps(source, target, []*model.Package{app})), DeepEquals, []string{"tools-1.0-1.x86_64"})
+	target.Packages = map[string]*model.Package{lib1.RelPath: lib1}
+	c.Assert(nevras(missingDeps(source, target, []*model.Package{app})), DeepEquals, []string{
+		"lib-1.7-1.x86_64", "tools-1.0-1.x86_64", "base-1.0-1.x86_64",
+	})
+}
+
+func (suite *TheSuite) TestBlobStore(c *C) {
+	blobDir := c.MkDir()
+	c.Assert(suite.rc.SetBlobStore(blobDir, "symlink"), NotNil)
+	c.Assert(suite.rc.SetBlobStore(blobDir, ""), IsNil)
+	shared := "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm"
+	other := "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm"
+	suite.copyTestPkgs(c, shared, other)
+	data, err := ioutil.ReadFile(filepath.Join(suite.repoPath, "Packages", shared))
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(suite.repoPath2, shared), data, 0644), IsNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "A", AbsPath: suite.repoPath}), IsNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "B", AbsPath: suite.repoPath2}), IsNil)
+
+	// both copies are the same file now
+	a, err := os.Stat(filepath.Join(suite.repoPath, "Packages", shared))
+	c.Assert(err, IsNil)
+	b, err := os.Stat(filepath.Join(suite.repoPath2, shared))
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(a, b), Equals, true)
+	repo, err := suite.rc.GetRepo("B")
+	c.Assert(err, IsNil)
+	c.Assert(repo.Packages[shared].SameFile(b, fileInode(b)), Equals, true)
+	sharedSum := repo.Packages[shared].Checksum
+	blob, err := os.Stat(suite.rc.blobPath(sharedSum))
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(a, blob), Equals, true)
+
+	refs := func() map[string]int {
+		blobs, err := suite.rc.GetBlobs()
+		c.Assert(err, IsNil)
+		counts := make(map[string]int)
+		for _, blob := range blobs {
+			counts[blob.Checksum] = blob.Refs
+		}
+		return counts
+	}
+	counts := refs()
+	c.Assert(counts, HasLen, 2)
+	c.Assert(counts[sharedSum], Equals, 2)
+
+	// rediscovering doesn't change the counts, but snapshots and removals do
+	c.Assert(suite.rc.Discover("A", suite.repoPath), IsNil)
+	c.Assert(refs()[sharedSum], Equals, 2)
+	snap, err := suite.rc.CreateSnapshot("A", "")
+	c.Assert(err, IsNil)
+	c.Assert(refs()[sharedSum], Equals, 3)
+	// snapshots link packages to their read-only blobs instead of copying them
+	snapDir, err := suite.rc.SnapshotDir("A")
+	c.Assert(err, IsNil)
+	snapped, err := os.Stat(filepath.Join(snapDir, "1", "Packages", shared))
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(snapped, blob), Equals, true)
+	for _, pkg := range snap.Packages {
+		c.Assert(pkg.Blob, Equals, true)
+	}
+	c.Assert(suite.rc.RemoveRepo("B"), IsNil)
+	c.Assert(refs()[sharedSum], Equals, 2)
+	c.Assert(os.Remove(filepath.Join(suite.repoPath, "Packages", shared)), IsNil)
+	c.Assert(suite.rc.Discover("A", suite.repoPath), IsNil)
+	c.Assert(refs()[sharedSum], Equals, 1)
+
+	// only blobs nothing references are collected, along with files that have no record
+	stray := strings.Repeat("ab", 32)
+	c.Assert(os.MkdirAll(filepath.Dir(suite.rc.blobPath(stray)), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(suite.rc.blobPath(stray), []byte("stray"), 0644), IsNil)
+	report, err := suite.rc.GC(false)
+	c.Assert(err, IsNil)
+	c.Assert(report.Removed, DeepEquals, []string{stray})
+	c.Assert(suite.rc.RemoveSnapshot("A", "1"), IsNil)
+	report, err = suite.rc.GC(true)
+	c.Assert(err, IsNil)
+	c.Assert(report.Removed, DeepEquals, []string{sharedSum})
+	_, err = os.Stat(suite.rc.blobPath(sharedSum))
+	c.Assert(err, IsNil)
+	report, err = suite.rc.GC(false)
+	c.Assert(err, IsNil)
+	c.Assert(report.Removed, DeepEquals, []string{sharedSum})
+	c.Assert(report.Freed, Equals, int64(len(data)))
+	_, err = os.Stat(suite.rc.blobPath(sharedSum))
+	c.Assert(os.IsNotExist(err), Equals, true)
+	counts = refs()
+	c.Assert(counts, HasLen, 1)
+	for _, n := range counts {
+		c.Assert(n, Equals, 1)
+	}
+}
+
+func (suite *TheSuite) TestBlobStoreInPlaceWrites(c *C) {
+	c.Assert(suite.rc.SetBlobStore(c.MkDir(), ""), IsNil)
+	shared := "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm"
+	suite.copyTestPkgs(c, shared)
+	data, err := ioutil.ReadFile(filepath.Join(suite.repoPath, "Packages", shared))
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(suite.repoPath2, shared), data, 0644), IsNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "A", AbsPath: suite.repoPath}), IsNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "B", AbsPath: suite.repoPath2}), IsNil)
+	repo, err := suite.rc.GetRepo("B")
+	c.Assert(err, IsNil)
+	sharedSum := repo.Packages[shared].Checksum
+	blob, err := os.Stat(suite.rc.blobPath(sharedSum))
+	c.Assert(err, IsNil)
+	c.Assert(blob.Mode().Perm(), Equals, os.FileMode(0444))
+
+	// rewrite a payload byte of the file through repo A, like a writer that ignores permissions would
+	rewrite := func(path string) {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		c.Assert(err, IsNil)
+		_, err = f.WriteAt([]byte{^data[len(data)-1]}, int64(len(data)-1))
+		c.Assert(err, IsNil)
+		c.Assert(f.Close(), IsNil)
+	}
+	rewrite(filepath.Join(suite.repoPath, "Packages", shared))
+	c.Assert(suite.rc.scanForChangedFiles(nil), IsNil)
+	_, err = os.Stat(suite.rc.blobPath(sharedSum))
+	c.Assert(os.IsNotExist(err), Equals, true)
+	repo, err = suite.rc.GetRepo("A")
+	c.Assert(err, IsNil)
+	changedSum := repo.Packages[filepath.Join("Packages", shared)].Checksum
+	c.Assert(changedSum, Not(Equals), sharedSum)
+	_, err = os.Stat(suite.rc.blobPath(changedSum))
+	c.Assert(err, IsNil)
+
+	// a clean copy of the original becomes its blob again, rather than being linked to the changed one
+	dirC := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dirC, shared), data, 0644), IsNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "C", AbsPath: dirC}), IsNil)
+	sum, err := fileChecksum(suite.rc.blobPath(sharedSum))
+	c.Assert(err, IsNil)
+	c.Assert(sum, Equals, sharedSum)
+
+	// a blob that changed without being noticed is replaced before anything else is linked to it
+	rewrite(suite.rc.blobPath(sharedSum))
+	dirD := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dirD, shared), data, 0644), IsNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "D", AbsPath: dirD}), IsNil)
+	sum, err = fileChecksum(suite.rc.blobPath(sharedSum))
+	c.Assert(err, IsNil)
+	c.Assert(sum, Equals, sharedSum)
+	d, err := os.Stat(filepath.Join(dirD, shared))
+	c.Assert(err, IsNil)
+	blob, err = os.Stat(suite.rc.blobPath(sharedSum))
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(d, blob), Equals, true)
+}
+
+func (suite *TheSuite) TestWatcherKeepsReplacedPackages(c *C) {
+	name := "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm"
+	relPath := filepath.Join("Packages", name)
+	suite.copyTestPkgs(c, name)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "Repo", AbsPath: suite.repoPath}), IsNil)
+	repos, err := suite.rc.GetRepos()
+	c.Assert(err, IsNil)
+	shutdown := make(chan struct{})
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		suite.rc.startWatchers(shutdown, errs, repos)
+		close(done)
+	}()
+	defer func() {
+		close(shutdown)
+		<-done
+	}()
+	// the watches are added in the background
+	time.Sleep(200 * time.Millisecond)
+	hasPackage := func() bool {
+		repo, err := suite.rc.GetRepo("Repo")
+		c.Assert(err, IsNil)
+		_, ok := repo.Packages[relPath]
+		return ok
+	}
+
+	// an upload renamed over the package keeps its record
+	data, err := ioutil.ReadFile(filepath.Join(suite.repoPath, relPath))
+	c.Assert(err, IsNil)
+	_, err = suite.rc.UploadPackage("Repo", "Packages/"+name, bytes.NewReader(data), UploadOptions{Overwrite: true})
+	c.Assert(err, IsNil)
+	time.Sleep(200 * time.Millisecond)
+	c.Assert(hasPackage(), Equals, true)
+
+	// the new file is watched, so removing it drops the record
+	c.Assert(os.Remove(filepath.Join(suite.repoPath, relPath)), IsNil)
+	for i := 0; i < 50 && hasPackage(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(hasPackage(), Equals, false)
+	removed, err := suite.rc.removePackage("Repo", relPath)
+	c.Assert(err, IsNil)
+	c.Assert(removed, Equals, false)
+}
+
+func (suite *TheSuite) TestCleanUploadPath(c *C) {
+	for relPath, cleaned := range map[string]string{
+		"a.rpm":             "a.rpm",
+		"/Packages/a.rpm":   "Packages/a.rpm",
+		"Packages/a.rpm":    "Packages/a.rpm",
+		"../a.rpm":          "",
+		"Packages/../a.rpm": "",
+		"Packages//a.rpm":   "",
+		"repodata/a.rpm":    "",
+		".upstream/a.rpm":   "",
+		"Packages/.a.rpm":   "",
+		"a\\..\\b.rpm":      "",
+		"":                  "",
+	} {
+		got, err := cleanUploadPath(relPath)
+		c.Assert(got, Equals, cleaned, Commentf("path %s", relPath))
+		c.Assert(err == nil, Equals, cleaned != "", Commentf("path %s", relPath))
+	}
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "api", AbsPath: suite.repoPath}), NotNil)
+}
+
+func (suite *TheSuite) TestFindPackagesAndBuildStatus(c *C) {
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm", "docker-engine-selinux-1.9.1-1.el7.centos.src.rpm")
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "TestRepo", AbsPath: suite.repoPath}), IsNil)
+
+	pkgs, err := suite.rc.GetPackages("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(pkgs, HasLen, 2)
+	c.Assert(pkgs[0].RelPath, Equals, "Packages/docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm")
+	_, err = suite.rc.GetPackages("Nothing")
+	c.Assert(err, NotNil)
+
+	pkgs, err = suite.rc.FindPackages("", "docker-engine-selinux")
+	c.Assert(err, IsNil)
+	c.Assert(pkgs, HasLen, 2)
+	pkgs, err = suite.rc.FindPackages("TestRepo", "*-1.9.1-*")
+	c.Assert(err, IsNil)
+	c.Assert(pkgs, HasLen, 1)
+	c.Assert(pkgs[0].Arch, Equals, "src")
+	pkgs, err = suite.rc.FindPackages("TestRepo", "jq")
+	c.Assert(err, IsNil)
+	c.Assert(pkgs, HasLen, 0)
+	_, err = suite.rc.FindPackages("TestRepo", "[")
+	c.Assert(err, NotNil)
+	_, err = suite.rc.FindPackages("Nothing", "")
+	c.Assert(err, NotNil)
+
+	status, err := suite.rc.GetBuildStatus("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(status, NotNil)
+	c.Assert(status.Running, Equals, false)
+	c.Assert(status.Packages, Equals, 2)
+	c.Assert(status.Error, Equals, "")
+	c.Assert(suite.rc.RebuildMetadata("Nothing"), NotNil)
+
+	// the status goes with the repo
+	c.Assert(suite.rc.RemoveRepo("TestRepo"), IsNil)
+	status, err = suite.rc.GetBuildStatus("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(status, IsNil)
+}
+
+func (suite *TheSuite) TestRepoLocker(c *C) {
+	rl := &repoLocker{locks: make(map[string]*sync.Mutex)}
+	rl.lock("A")
+	locked := make(chan struct{})
+	go func() {
+		rl.lock("A")
+		close(locked)
+	}()
+	// waiting on one repo doesn't hold up the others
+	rl.lock("B")
+	c.Assert(rl.unlock("B"), IsNil)
+	select {
+	case <-locked:
+		c.Fatal("lock was taken twice")
+	case <-time.After(20 * time.Millisecond):
+	}
+	c.Assert(rl.unlock("A"), IsNil)
+	<-locked
+	c.Assert(rl.unlock("A"), IsNil)
+	c.Assert(rl.unlock("C"), NotNil)
+}
+
+func (suite *TheSuite) TestRepoEvents(c *C) {
+	var events []string
+	suite.rc.AddRepoListener(func(event RepoEvent) {
+		path := ""
+		if event.Repo != nil {
+			c.Assert(event.Repo.Packages, IsNil)
+			path = event.Repo.AbsPath
+		}
+		events = append(events, fmt.Sprintf("%s %s %s", event.Type, event.Name, path))
+	})
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "TestRepo", AbsPath: suite.repoPath}), IsNil)
+	// new packages don't change the repo's settings
+	suite.copyTestPkgs(c, "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm")
+	c.Assert(suite.rc.Discover("TestRepo", suite.repoPath), IsNil)
+	other := c.MkDir()
+	c.Assert(suite.rc.Discover("TestRepo", other), IsNil)
+	c.Assert(suite.rc.RemoveRepo("TestRepo"), IsNil)
+	c.Assert(events, DeepEquals, []string{
+		"added TestRepo " + suite.repoPath,
+		"changed TestRepo " + other,
+		"removed TestRepo ",
+	})
+}
+
+func (suite *TheSuite) TestUsersAndTokens(c *C) {
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "TestRepo", AbsPath: suite.repoPath, Access: model.AccessOptions{Private: true}}), IsNil)
+	c.Assert(suite.rc.AddUser("dev", "secret", false), IsNil)
+	c.Assert(suite.rc.AddUser("dev", "other", false), NotNil)
+	c.Assert(suite.rc.AddUser("a:b", "secret", false), NotNil)
+	c.Assert(suite.rc.GrantRole("dev", "TestRepo", "owner"), NotNil)
+	c.Assert(suite.rc.GrantRole("dev", "Nothing", model.RoleRead), NotNil)
+	c.Assert(suite.rc.GrantRole("dev", "TestRepo", model.RoleWrite), IsNil)
+	user, err := suite.rc.GetUser("dev")
+	c.Assert(err, IsNil)
+	c.Assert(user.PasswordHash, Not(Equals), "secret")
+	c.Assert(user.Can("TestRepo", model.RoleRead), Equals, true)
+	c.Assert(user.Can("TestRepo", model.RoleAdmin), Equals, false)
+
+	req := func(user, password string) *http.Request {
+		r := httptest.NewRequest("GET", "/TestRepo/repodata/repomd.xml", nil)
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+		return r
+	}
+	_, err = suite.rc.Authorize(req("", ""), "TestRepo", model.RoleRead)
+	c.Assert(err, Equals, ErrUnauthenticated)
+	_, err = suite.rc.Authorize(req("dev", "wrong"), "TestRepo", model.RoleRead)
+	c.Assert(err, Equals, ErrUnauthenticated)
+	user, err = suite.rc.Authorize(req("dev", "secret"), "TestRepo", model.RoleWrite)
+	c.Assert(err, IsNil)
+	c.Assert(user.Name, Equals, "dev")
+	// a remembered password still has to be the current one
+	c.Assert(suite.rc.SetPassword("dev", "changed"), IsNil)
+	_, err = suite.rc.Authorize(req("dev", "secret"), "TestRepo", model.RoleRead)
+	c.Assert(err, Equals, ErrUnauthenticated)
+	_, err = suite.rc.Authorize(req("dev", "changed"), "TestRepo", model.RoleAdmin)
+	c.Assert(err, Equals, ErrForbidden)
+	_, err = suite.rc.Authorize(req("dev", "changed"), "", model.RoleAdmin)
+	c.Assert(err, Equals, ErrForbidden)
+
+	token, record, err := suite.rc.CreateToken("dev", "ci")
+	c.Assert(err, IsNil)
+	c.Assert(record.Hash, Not(Equals), token)
+	bearer := req("", "")
+	bearer.Header.Set("Authorization", "Bearer "+token)
+	_, err = suite.rc.Authorize(bearer, "TestRepo", model.RoleRead)
+	c.Assert(err, IsNil)
+	_, err = suite.rc.Authorize(req("dev", token), "TestRepo", model.RoleRead)
+	c.Assert(err, IsNil)
+	_, err = suite.rc.Authorize(req("dev", token+"x"), "TestRepo", model.RoleRead)
+	c.Assert(err, Equals, ErrUnauthenticated)
+	tokens, err := suite.rc.GetTokens("dev")
+	c.Assert(err, IsNil)
+	c.Assert(tokens, HasLen, 1)
+	c.Assert(suite.rc.RevokeToken(record.ID), IsNil)
+	_, err = suite.rc.Authorize(bearer, "TestRepo", model.RoleRead)
+	c.Assert(err, Equals, ErrUnauthenticated)
+
+	// public repos can be read by anyone, but not written
+	c.Assert(suite.rc.UpdateRepoSettings("TestRepo", func(repo *model.Repo) { repo.Access.Private = false }), IsNil)
+	user, err = suite.rc.Authorize(req("", ""), "TestRepo", model.RoleRead)
+	c.Assert(err, IsNil)
+	c.Assert(user, IsNil)
+	_, err = suite.rc.Authorize(req("", ""), "TestRepo", model.RoleWrite)
+	c.Assert(err, Equals, ErrUnauthenticated)
+
+	// roles go with the repo, and tokens with the user
+	_, _, err = suite.rc.CreateToken("dev", "")
+	c.Assert(err, IsNil)
+	c.Assert(suite.rc.RemoveRepo("TestRepo"), IsNil)
+	user, err = suite.rc.GetUser("dev")
+	c.Assert(err, IsNil)
+	c.Assert(user.Roles, HasLen, 0)
+	c.Assert(suite.rc.RemoveUser("dev"), IsNil)
+	tokens, err = suite.rc.GetTokens("")
+	c.Assert(err, IsNil)
+	c.Assert(tokens, HasLen, 0)
+	_, _, err = suite.rc.CreateToken("dev", "")
+	c.Assert(err, NotNil)
+}
+
+func (suite *TheSuite) TestClientCertAccess(c *C) {
+	san, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte("spiffe://example.com/build")}})
+	c.Assert(err, IsNil)
+	cert := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "host1.build.example.com", OrganizationalUnit: []string{"build", "ci"}, Organization: []string{"Example"}},
+		DNSNames:    []string{"host1.build.example.com", "host1"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		Extensions:  []pkix.Extension{{Id: oidSubjectAltName, Value: san}},
+	}
+	for rule, matches := range map[string]bool{
+		"ou:build":                       true,
+		"ou:web":                         false,
+		"cn:*.build.example.com":         true,
+		"cn:host1":                       false,
+		"san:host1":                      true,
+		"san:10.0.0.*":                   true,
+		"san:spiffe://example.com/build": true,
+		"san:*.web.example.com":          false,
+		"subject:CN=host1.build.example.com,OU=build+OU=ci,O=Example": true,
+		"subject:CN=host1.build.example.com":                          false,
+	} {
+		c.Assert(validateCertRules([]string{rule}), IsNil, Commentf("rule %s", rule))
+		c.Assert(certMatches(cert, []string{rule}), Equals, matches, Commentf("rule %s", rule))
+	}
+	c.Assert(certMatches(nil, []string{"ou:build"}), Equals, false)
+	for _, rule := range []string{"build", "ou:", "org:Example", "cn:["} {
+		c.Assert(validateCertRules([]string{rule}), NotNil, Commentf("rule %s", rule))
+	}
+
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "TestRepo", AbsPath: suite.repoPath, Access: model.AccessOptions{Private: true, ClientCerts: []string{"ou:build"}}}), IsNil)
+	c.Assert(suite.rc.AddRepo(&model.Repo{Name: "Bad", AbsPath: suite.repoPath, Access: model.AccessOptions{ClientCerts: []string{"org:Example"}}}), NotNil)
+	req := func(cert *x509.Certificate) *http.Request {
+		r := httptest.NewRequest("GET", "/TestRepo/repodata/repomd.xml", nil)
+		if cert != nil {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		return r
+	}
+	_, err = suite.rc.Authorize(req(cert), "TestRepo", model.RoleRead)
+	c.Assert(err, IsNil)
+	_, err = suite.rc.Authorize(req(cert), "TestRepo", model.RoleWrite)
+	c.Assert(err, Equals, ErrForbidden)
+	_, err = suite.rc.Authorize(req(nil), "TestRepo", model.RoleRead)
+	c.Assert(err, Equals, ErrUnauthenticated)
+	// certificates that match no rule are refused, rather than asked for credentials
+	web := &x509.Certificate{Subject: pkix.Name{CommonName: "web1", OrganizationalUnit: []string{"web"}}}
+	_, err = suite.rc.Authorize(req(web), "TestRepo", model.RoleRead)
+	c.Assert(err, Equals, ErrForbidden)
+	// unverified certificates count for nothing
+	r := req(nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	_, err = suite.rc.Authorize(r, "TestRepo", model.RoleRead)
+	c.Assert(err, Equals, ErrUnauthenticated)
+
+	repo, err := suite.rc.GetRepo("TestRepo")
+	c.Assert(err, IsNil)
+	c.Assert(Allowed(nil, cert, repo, model.RoleRead), Equals, true)
+	c.Assert(Allowed(nil, web, repo, model.RoleRead), Equals, false)
+}
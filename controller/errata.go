@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/model"
+	"github.com/alapidas/roper/repodata"
+	"github.com/alapidas/roper/rpm"
+	"github.com/boltdb/bolt"
+)
+
+// AddAdvisory adds an advisory to the errata of a repo, and rebuilds the repo metadata.  An existing
+// advisory with the same ID is replaced, and marked as updated.
+func (rc *RoperController) AddAdvisory(adv *model.Advisory) error {
+	if err := validateAdvisory(adv); err != nil {
+		return fmt.Errorf("invalid advisory %s: %s", adv.ID, err)
+	}
+	repo, err := rc.GetRepo(adv.RepoName)
+	if err != nil {
+		return err
+	}
+	if !repo.IsYum() {
+		return fmt.Errorf("errata are only supported for yum repos")
+	}
+	existing, err := rc.GetAdvisories(adv.RepoName)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	if adv.Issued == 0 {
+		adv.Issued = now
+	}
+	for _, old := range existing {
+		if old.ID == adv.ID {
+			adv.Issued, adv.Updated = old.Issued, now
+		}
+	}
+	err = rc.db.Update(func(tx *bolt.Tx) error {
+		eb := tx.Bucket([]byte(errata_bucket))
+		pa := &model.PersistableAdvisory{Advisory: *adv}
+		key, val, err := pa.Serial()
+		if err != nil {
+			return fmt.Errorf("unable to get serialized vals for advisory %s: %s", adv.ID, err)
+		}
+		return eb.Put(key, val)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to persist advisory %s: %s", adv.ID, err)
+	}
+	log.WithFields(log.Fields{
+		"repo":     adv.RepoName,
+		"advisory": adv.ID,
+	}).Info("added advisory")
+	if err = rc.buildMetadata(adv.RepoName); err != nil {
+		return fmt.Errorf("unable to rebuild metadata for repo %s: %s", adv.RepoName, err)
+	}
+	return nil
+}
+
+// GetAdvisories returns the errata of a repo, or of all repos if repoName is empty
+func (rc *RoperController) GetAdvisories(repoName string) ([]*model.Advisory, error) {
+	advs := []*model.Advisory{}
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		return forEachRepoKey(tx, errata_bucket, repoName, func(v []byte) error {
+			adv := &model.Advisory{}
+			if err := json.Unmarshal(v, adv); err != nil {
+				return fmt.Errorf("unable to unmarshal advisory: %s", err)
+			}
+			advs = append(advs, adv)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get errata: %s", err)
+	}
+	return advs, nil
+}
+
+// RemoveAdvisory removes an advisory from the errata of a repo, and rebuilds the repo metadata
+func (rc *RoperController) RemoveAdvisory(repoName, id string) error {
+	err := rc.db.Update(func(tx *bolt.Tx) error {
+		eb := tx.Bucket([]byte(errata_bucket))
+		key := []byte(repoName + "::" + id)
+		if eb.Get(key) == nil {
+			return fmt.Errorf("advisory %s not found in repo %s", id, repoName)
+		}
+		return eb.Delete(key)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to remove advisory: %s", err)
+	}
+	if err = rc.buildMetadata(repoName); err != nil {
+		return fmt.Errorf("unable to rebuild metadata for repo %s: %s", repoName, err)
+	}
+	return nil
+}
+
+// validateAdvisory checks that an advisory has everything updateinfo.xml needs
+func validateAdvisory(adv *model.Advisory) error {
+	if adv.ID == "" {
+		return errors.New("advisory has no ID")
+	}
+	if adv.RepoName == "" {
+		return errors.New("advisory has no repo")
+	}
+	switch adv.Type {
+	case model.AdvisorySecurity, model.AdvisoryBugfix, model.AdvisoryEnhancement, model.AdvisoryNewPackage:
+	default:
+		return fmt.Errorf("unknown advisory type %q", adv.Type)
+	}
+	for _, nevra := range adv.Packages {
+		if _, err := rpm.ParseNEVRA(nevra); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updates builds the updateinfo entries for the errata of a repo.  Packages are matched to the repo
+// by NEVRA to find their file names.  Packages that aren't in the repo are still listed, since
+// clients only act on the ones they can find.
+func (rc *RoperController) updates(repo *model.Repo) ([]*repodata.Update, error) {
+	advs, err := rc.GetAdvisories(repo.Name)
+	if err != nil {
+		return nil, err
+	}
+	byNEVRA := make(map[string]*model.Package, len(repo.Packages))
+	for _, pkg := range repo.Packages {
+		if nevra := pkg.NEVRA(); nevra != "" {
+			byNEVRA[nevra] = pkg
+		}
+	}
+	updates := make([]*repodata.Update, 0, len(advs))
+	for _, adv := range advs {
+		u := &repodata.Update{
+			ID:          adv.ID,
+			Type:        adv.Type,
+			Severity:    adv.Severity,
+			Title:       adv.Title,
+			Description: adv.Description,
+			From:        "roper",
+			Issued:      time.Unix(adv.Issued, 0),
+			Collection:  repo.Name,
+		}
+		if adv.Updated != 0 {
+			u.Updated = time.Unix(adv.Updated, 0)
+		}
+		for _, ref := range adv.References {
+			u.References = append(u.References, repodata.Reference{Type: ref.Type, ID: ref.ID, Href: ref.Href, Title: ref.Title})
+		}
+		for _, nevra := range adv.Packages {
+			p, err := rpm.ParseNEVRA(nevra)
+			if err != nil {
+				return nil, fmt.Errorf("bad package in advisory %s: %s", adv.ID, err)
+			}
+			up := repodata.UpdatePackage{
+				Name:     p.Name,
+				Epoch:    p.Epoch,
+				Version:  p.Version,
+				Release:  p.Release,
+				Arch:     p.Arch,
+				Filename: fmt.Sprintf("%s-%s-%s.%s.rpm", p.Name, p.Version, p.Release, p.Arch),
+			}
+			if pkg, ok := byNEVRA[p.NEVRA()]; ok {
+				up.Filename = filepath.Base(pkg.RelPath)
+				up.Src = pkg.SourceRPM
+			}
+			u.Packages = append(u.Packages, up)
+		}
+		updates = append(updates, u)
+	}
+	return updates, nil
+}
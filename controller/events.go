@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"github.com/alapidas/roper/model"
+)
+
+// RepoEventType says how a repo changed
+type RepoEventType int
+
+const (
+	RepoAdded   RepoEventType = iota // the repo was added
+	RepoChanged                      // the settings of the repo, like its path or type, changed
+	RepoRemoved                      // the repo was removed
+)
+
+func (t RepoEventType) String() string {
+	switch t {
+	case RepoAdded:
+		return "added"
+	case RepoChanged:
+		return "changed"
+	case RepoRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// RepoEvent describes a change to the set of repos
+type RepoEvent struct {
+	Type RepoEventType
+	Name string
+	Repo *model.Repo // settings of the repo after the change, without packages, nil if it was removed
+}
+
+// RepoListener is called with every change to the set of repos, after the change is in the database.
+// Listeners are called in the order they were added, by the goroutine making the change, so they should
+// be quick, and mustn't change repos themselves.
+type RepoListener func(event RepoEvent)
+
+// AddRepoListener adds a listener for changes to the set of repos
+func (rc *RoperController) AddRepoListener(listener RepoListener) {
+	rc.listenerLock.Lock()
+	defer rc.listenerLock.Unlock()
+	rc.listeners = append(rc.listeners, listener)
+}
+
+// notify tells the listeners about a change to the set of repos
+func (rc *RoperController) notify(event RepoEvent) {
+	rc.listenerLock.Lock()
+	listeners := append([]RepoListener{}, rc.listeners...)
+	rc.listenerLock.Unlock()
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// repoSettings returns a copy of the settings of a repo, without its packages
+func repoSettings(repo *model.Repo) *model.Repo {
+	settings := *repo
+	settings.Packages = nil
+	return &settings
+}
@@ -0,0 +1,425 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/model"
+	"github.com/alapidas/roper/repodata"
+	"github.com/boltdb/bolt"
+)
+
+// CreateGroup adds a new package group to a repo, and rebuilds the repo metadata
+func (rc *RoperController) CreateGroup(group *model.Group) error {
+	if err := validateGroup(group); err != nil {
+		return fmt.Errorf("invalid group %s: %s", group.ID, err)
+	}
+	if err := rc.checkGroupFile(group.RepoName); err != nil {
+		return fmt.Errorf("unable to create group %s: %s", group.ID, err)
+	}
+	err := rc.updateGroups(group.RepoName, func(tx *bolt.Tx) error {
+		if _, err := getGroup(tx, group.RepoName, group.ID); err == nil {
+			return fmt.Errorf("group %s already exists in repo %s", group.ID, group.RepoName)
+		}
+		return putGroup(tx, group)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create group %s: %s", group.ID, err)
+	}
+	return nil
+}
+
+// UpdateGroup changes an existing group of a repo, and rebuilds the repo metadata.  The update
+// function is passed the current group, and should modify it in place.
+func (rc *RoperController) UpdateGroup(repoName, id string, update func(group *model.Group) error) error {
+	err := rc.updateGroups(repoName, func(tx *bolt.Tx) error {
+		group, err := getGroup(tx, repoName, id)
+		if err != nil {
+			return err
+		}
+		if err = update(group); err != nil {
+			return err
+		}
+		// the repo and id identify the group, and can't be changed here
+		group.RepoName, group.ID = repoName, id
+		if err = validateGroup(group); err != nil {
+			return err
+		}
+		return putGroup(tx, group)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update group %s: %s", id, err)
+	}
+	return nil
+}
+
+// RemoveGroup removes a group from a repo, along with any references to it from environments, and
+// rebuilds the repo metadata
+func (rc *RoperController) RemoveGroup(repoName, id string) error {
+	err := rc.updateGroups(repoName, func(tx *bolt.Tx) error {
+		gb := tx.Bucket([]byte(group_bucket))
+		key := []byte(repoName + "::" + id)
+		if gb.Get(key) == nil {
+			return fmt.Errorf("group %s not found in repo %s", id, repoName)
+		}
+		if err := gb.Delete(key); err != nil {
+			return err
+		}
+		envs, err := getEnvironments(tx, repoName)
+		if err != nil {
+			return err
+		}
+		for _, env := range envs {
+			groups, options := without(env.Groups, id), without(env.Options, id)
+			if len(groups) == len(env.Groups) && len(options) == len(env.Options) {
+				continue
+			}
+			env.Groups, env.Options = groups, options
+			if err = putEnvironment(tx, env); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to remove group %s: %s", id, err)
+	}
+	return nil
+}
+
+// GetGroups returns the groups of a repo, or of all repos if repoName is empty
+func (rc *RoperController) GetGroups(repoName string) ([]*model.Group, error) {
+	var groups []*model.Group
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		var err error
+		groups, err = getGroups(tx, repoName)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get groups: %s", err)
+	}
+	return groups, nil
+}
+
+// CreateEnvironment adds a new environment to a repo, and rebuilds the repo metadata
+func (rc *RoperController) CreateEnvironment(env *model.Environment) error {
+	if err := rc.checkGroupFile(env.RepoName); err != nil {
+		return fmt.Errorf("unable to create environment %s: %s", env.ID, err)
+	}
+	err := rc.updateGroups(env.RepoName, func(tx *bolt.Tx) error {
+		if err := validateEnvironment(tx, env); err != nil {
+			return err
+		}
+		if _, err := getEnvironment(tx, env.RepoName, env.ID); err == nil {
+			return fmt.Errorf("environment %s already exists in repo %s", env.ID, env.RepoName)
+		}
+		return putEnvironment(tx, env)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create environment %s: %s", env.ID, err)
+	}
+	return nil
+}
+
+// UpdateEnvironment changes an existing environment of a repo, and rebuilds the repo metadata.  The
+// update function is passed the current environment, and should modify it in place.
+func (rc *RoperController) UpdateEnvironment(repoName, id string, update func(env *model.Environment) error) error {
+	err := rc.updateGroups(repoName, func(tx *bolt.Tx) error {
+		env, err := getEnvironment(tx, repoName, id)
+		if err != nil {
+			return err
+		}
+		if err = update(env); err != nil {
+			return err
+		}
+		env.RepoName, env.ID = repoName, id
+		if err = validateEnvironment(tx, env); err != nil {
+			return err
+		}
+		return putEnvironment(tx, env)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update environment %s: %s", id, err)
+	}
+	return nil
+}
+
+// RemoveEnvironment removes an environment from a repo, and rebuilds the repo metadata
+func (rc *RoperController) RemoveEnvironment(repoName, id string) error {
+	err := rc.updateGroups(repoName, func(tx *bolt.Tx) error {
+		eb := tx.Bucket([]byte(environment_bucket))
+		key := []byte(repoName + "::" + id)
+		if eb.Get(key) == nil {
+			return fmt.Errorf("environment %s not found in repo %s", id, repoName)
+		}
+		return eb.Delete(key)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to remove environment %s: %s", id, err)
+	}
+	return nil
+}
+
+// GetEnvironments returns the environments of a repo, or of all repos if repoName is empty
+func (rc *RoperController) GetEnvironments(repoName string) ([]*model.Environment, error) {
+	var envs []*model.Environment
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		var err error
+		envs, err = getEnvironments(tx, repoName)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get environments: %s", err)
+	}
+	return envs, nil
+}
+
+// updateGroups runs a change to the groups or environments of a repo in a transaction, and then
+// rebuilds the repo metadata
+func (rc *RoperController) updateGroups(repoName string, update func(tx *bolt.Tx) error) error {
+	repo, err := rc.GetRepo(repoName)
+	if err != nil {
+		return err
+	}
+	if !repo.IsYum() {
+		return fmt.Errorf("package groups are only supported for yum repos")
+	}
+	if err := rc.db.Update(update); err != nil {
+		return err
+	}
+	if err := rc.buildMetadata(repoName); err != nil {
+		return fmt.Errorf("unable to rebuild metadata for repo %s: %s", repoName, err)
+	}
+	return nil
+}
+
+// checkGroupFile fails if a repo has a group file configured, since the comps.xml of the groups
+// managed by roper would replace it
+func (rc *RoperController) checkGroupFile(repoName string) error {
+	repo, err := rc.repoSettings(repoName)
+	if err != nil {
+		return err
+	}
+	if repo.Createrepo.GroupFile != "" {
+		return fmt.Errorf("repo %s has a group file, which can't be combined with groups managed by roper", repoName)
+	}
+	return nil
+}
+
+// hasGroups returns whether a repo has any groups or environments managed by roper
+func (rc *RoperController) hasGroups(repoName string) (bool, error) {
+	groups, err := rc.GetGroups(repoName)
+	if err != nil {
+		return false, err
+	}
+	envs, err := rc.GetEnvironments(repoName)
+	if err != nil {
+		return false, err
+	}
+	return len(groups) > 0 || len(envs) > 0, nil
+}
+
+// writeComps writes the comps.xml for the groups and environments of a repo to a temporary file, and
+// returns its path.  An empty path is returned if the repo has no groups or environments.
+func (rc *RoperController) writeComps(repo *model.Repo) (string, error) {
+	groups, err := rc.GetGroups(repo.Name)
+	if err != nil {
+		return "", err
+	}
+	envs, err := rc.GetEnvironments(repo.Name)
+	if err != nil {
+		return "", err
+	}
+	if len(groups) == 0 && len(envs) == 0 {
+		return "", nil
+	}
+	if repo.Createrepo.GroupFile != "" {
+		log.WithField("repo", repo.Name).Warn("repo has both a group file and groups, using the groups")
+	}
+	var rgroups []*repodata.Group
+	for _, g := range groups {
+		rg := &repodata.Group{ID: g.ID, Name: g.Name, Description: g.Description, Default: g.Default, UserVisible: g.UserVisible}
+		for _, pkg := range g.Packages {
+			rg.Packages = append(rg.Packages, repodata.GroupPackage{Name: pkg.Name, Type: pkg.Type, Requires: pkg.Requires})
+		}
+		rgroups = append(rgroups, rg)
+	}
+	var renvs []*repodata.Environment
+	for _, e := range envs {
+		renvs = append(renvs, &repodata.Environment{ID: e.ID, Name: e.Name, Description: e.Description, Groups: e.Groups, Options: e.Options})
+	}
+	data, err := repodata.Comps(rgroups, renvs)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile("", "roper-comps-")
+	if err != nil {
+		return "", fmt.Errorf("unable to write comps.xml for repo %s: %s", repo.Name, err)
+	}
+	defer f.Close()
+	if _, err = f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("unable to write comps.xml for repo %s: %s", repo.Name, err)
+	}
+	return f.Name(), nil
+}
+
+// validateGroup checks that a group can be written to comps.xml
+func validateGroup(group *model.Group) error {
+	if err := validateGroupID(group.ID); err != nil {
+		return err
+	}
+	seen := make(map[string]struct{}, len(group.Packages))
+	for _, pkg := range group.Packages {
+		if pkg.Name == "" {
+			return errors.New("package with no name")
+		}
+		if _, ok := seen[pkg.Name]; ok {
+			return fmt.Errorf("package %s is in the group more than once", pkg.Name)
+		}
+		seen[pkg.Name] = struct{}{}
+		switch pkg.Type {
+		case model.GroupMandatory, model.GroupDefault, model.GroupOptional:
+			if pkg.Requires != "" {
+				return fmt.Errorf("only conditional packages can require another package, not %s", pkg.Name)
+			}
+		case model.GroupConditional:
+			if pkg.Requires == "" {
+				return fmt.Errorf("conditional package %s must require another package", pkg.Name)
+			}
+		default:
+			return fmt.Errorf("unknown type %q for package %s", pkg.Type, pkg.Name)
+		}
+	}
+	return nil
+}
+
+// validateEnvironment checks that an environment can be written to comps.xml, and that its groups
+// exist, given a transaction
+func validateEnvironment(tx *bolt.Tx, env *model.Environment) error {
+	if err := validateGroupID(env.ID); err != nil {
+		return err
+	}
+	for _, id := range append(append([]string{}, env.Groups...), env.Options...) {
+		if _, err := getGroup(tx, env.RepoName, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateGroupID(id string) error {
+	if id == "" || strings.ContainsAny(id, " \t\n") {
+		return fmt.Errorf("invalid id %q", id)
+	}
+	return nil
+}
+
+// without returns ids with id removed
+func without(ids []string, id string) []string {
+	out := []string{}
+	for _, i := range ids {
+		if i != id {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// getGroup is an internal API method that gets a group, given a transaction
+func getGroup(tx *bolt.Tx, repoName, id string) (*model.Group, error) {
+	v := tx.Bucket([]byte(group_bucket)).Get([]byte(repoName + "::" + id))
+	if v == nil {
+		return nil, fmt.Errorf("group %s not found in repo %s", id, repoName)
+	}
+	group := &model.Group{}
+	if err := json.Unmarshal(v, group); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal group: %s", err)
+	}
+	return group, nil
+}
+
+// getGroups is an internal API method that gets the groups of a repo, or of all repos if repoName is
+// empty, given a transaction
+func getGroups(tx *bolt.Tx, repoName string) ([]*model.Group, error) {
+	groups := []*model.Group{}
+	err := forEachRepoKey(tx, group_bucket, repoName, func(v []byte) error {
+		group := &model.Group{}
+		if err := json.Unmarshal(v, group); err != nil {
+			return fmt.Errorf("unable to unmarshal group: %s", err)
+		}
+		groups = append(groups, group)
+		return nil
+	})
+	return groups, err
+}
+
+// putGroup is an internal API method that stores a group, given a transaction
+func putGroup(tx *bolt.Tx, group *model.Group) error {
+	pg := &model.PersistableGroup{Group: *group}
+	key, val, err := pg.Serial()
+	if err != nil {
+		return fmt.Errorf("unable to get serialized vals for group %s: %s", group.ID, err)
+	}
+	return tx.Bucket([]byte(group_bucket)).Put(key, val)
+}
+
+// getEnvironment is an internal API method that gets an environment, given a transaction
+func getEnvironment(tx *bolt.Tx, repoName, id string) (*model.Environment, error) {
+	v := tx.Bucket([]byte(environment_bucket)).Get([]byte(repoName + "::" + id))
+	if v == nil {
+		return nil, fmt.Errorf("environment %s not found in repo %s", id, repoName)
+	}
+	env := &model.Environment{}
+	if err := json.Unmarshal(v, env); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal environment: %s", err)
+	}
+	return env, nil
+}
+
+// getEnvironments is an internal API method that gets the environments of a repo, or of all repos if
+// repoName is empty, given a transaction
+func getEnvironments(tx *bolt.Tx, repoName string) ([]*model.Environment, error) {
+	envs := []*model.Environment{}
+	err := forEachRepoKey(tx, environment_bucket, repoName, func(v []byte) error {
+		env := &model.Environment{}
+		if err := json.Unmarshal(v, env); err != nil {
+			return fmt.Errorf("unable to unmarshal environment: %s", err)
+		}
+		envs = append(envs, env)
+		return nil
+	})
+	return envs, err
+}
+
+// putEnvironment is an internal API method that stores an environment, given a transaction
+func putEnvironment(tx *bolt.Tx, env *model.Environment) error {
+	pe := &model.PersistableEnvironment{Environment: *env}
+	key, val, err := pe.Serial()
+	if err != nil {
+		return fmt.Errorf("unable to get serialized vals for environment %s: %s", env.ID, err)
+	}
+	return tx.Bucket([]byte(environment_bucket)).Put(key, val)
+}
+
+// forEachRepoKey is an internal API method that calls fn with the value of every record of a repo in
+// a bucket whose keys are prefixed with the repo name, or every record if repoName is empty
+func forEachRepoKey(tx *bolt.Tx, bucketName, repoName string, fn func(v []byte) error) error {
+	var prefix []byte
+	if repoName != "" {
+		prefix = []byte(repoName + "::")
+	}
+	c := tx.Bucket([]byte(bucketName)).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/gpg"
+	"github.com/alapidas/roper/helm"
+	"github.com/alapidas/roper/model"
+)
+
+// helmBuilder builds the index.yaml of repos of helm charts
+type helmBuilder struct{}
+
+func (b *helmBuilder) IsPackage(relPath string) bool {
+	return filepath.Ext(relPath) == ".tgz"
+}
+
+func (b *helmBuilder) ReadPackage(pkg *model.Package, absPath string) error {
+	chart, err := helm.ReadFile(absPath)
+	if err != nil {
+		return err
+	}
+	pkg.Name = chart.Name
+	pkg.Version = chart.Version
+	pkg.Checksum = chart.Digest
+	for _, dep := range chart.Dependencies {
+		pkg.Requires = append(pkg.Requires, model.Dependency{Name: dep.Name, Version: dep.Version})
+	}
+	return nil
+}
+
+// Compare orders charts by semantic version
+func (b *helmBuilder) Compare(p1, p2 *model.Package) int {
+	return helm.CompareVersions(p1.Version, p2.Version)
+}
+
+func (b *helmBuilder) Validate(repo *model.Repo) error {
+	if repo.Backend == model.BackendCreaterepo {
+		return fmt.Errorf("helm repos can't use the createrepo backend")
+	}
+	if repo.Verify.Keyring != "" {
+		return fmt.Errorf("package verification is only supported for yum repos")
+	}
+	if repo.Signing.Keyring != "" {
+		return fmt.Errorf("signing is not supported for helm repos")
+	}
+	return nil
+}
+
+// Build writes the index.yaml of a helm repo
+func (b *helmBuilder) Build(repo *model.Repo, signer *gpg.Signer) error {
+	log.WithField("repo", repo.Name).Info("Generating helm index")
+	charts := make([]*helm.IndexedChart, 0, len(repo.Packages))
+	for relPath, pkg := range repo.Packages {
+		chart, err := readChart(repo, pkg)
+		if err != nil {
+			// don't let one bad file keep the rest of the repo from being published
+			log.WithFields(log.Fields{
+				"repo":  repo.Name,
+				"path":  relPath,
+				"error": err,
+			}).Warn("skipping unreadable chart")
+			continue
+		}
+		charts = append(charts, &helm.IndexedChart{Chart: chart, Location: relPath})
+	}
+	if err := helm.Generate(repo.AbsPath, charts, &helm.Options{BaseURL: repo.Helm.URL}); err != nil {
+		return fmt.Errorf("unable to generate index for repo %s: %s", repo.Name, err)
+	}
+	return nil
+}
+
+// readChart reads the Chart.yaml of a chart in a repo.  Like readRPM, the digest stored in the db is
+// used if the file doesn't look like it has changed since it was last read.
+func readChart(repo *model.Repo, pkg *model.Package) (*helm.Chart, error) {
+	absPath := filepath.Join(repo.AbsPath, pkg.RelPath)
+	fi, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if pkg.Checksum == "" || !pkg.SameFile(fi, fileInode(fi)) {
+		return helm.ReadFile(absPath)
+	}
+	chart, err := helm.ReadMetadata(absPath)
+	if err != nil {
+		return nil, err
+	}
+	chart.Digest = pkg.Checksum
+	return chart, nil
+}
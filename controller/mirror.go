@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/mirror"
+	"github.com/alapidas/roper/model"
+)
+
+// validateMirror checks the mirror settings of a repo
+func validateMirror(repo *model.Repo) error {
+	if !repo.Mirror.Enabled() {
+		return nil
+	}
+	if !repo.IsYum() {
+		return fmt.Errorf("mirroring is only supported for yum repos")
+	}
+	return validateRemoteURL(repo.Mirror.URL)
+}
+
+// validateRemoteURL checks the url of a remote repo
+func validateRemoteURL(remoteURL string) error {
+	if remoteURL == "" {
+		return fmt.Errorf("no remote url given")
+	}
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return fmt.Errorf("invalid remote url %s: %s", remoteURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("remote url %s must be http or https", remoteURL)
+	}
+	return nil
+}
+
+// AddMirror adds a new repo that mirrors a remote yum repo.  The packages of the remote repo are
+// downloaded into the path of the repo, which is created if needed, and the repo is then discovered
+// like any other.  Packages that failed to download are reported as an error, after the rest have
+// been added.
+func (rc *RoperController) AddMirror(repo *model.Repo) (*mirror.Report, error) {
+	if _, err := rc.GetRepo(repo.Name); err == nil {
+		return nil, fmt.Errorf("repo %s already exists", repo.Name)
+	}
+	if !repo.Mirror.Enabled() {
+		return nil, fmt.Errorf("no mirror url given for repo %s", repo.Name)
+	}
+	if err := rc.validateRepoSettings(repo); err != nil {
+		return nil, fmt.Errorf("invalid settings for repo %s: %s", repo.Name, err)
+	}
+	if err := os.MkdirAll(repo.AbsPath, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create directory for repo %s: %s", repo.Name, err)
+	}
+	report, err := syncMirror(repo)
+	if err != nil {
+		return nil, err
+	}
+	settings := *repo
+	settings.Packages = make(map[string]*model.Package)
+	if err = rc.discover(&settings, nil); err != nil {
+		return report, err
+	}
+	return report, reportError(repo, report)
+}
+
+// SyncMirror downloads the packages of a mirrored repo that are new in its remote repo, and deletes
+// the ones that are gone if the mirror is set to, then rediscovers the repo.  Packages that failed
+// to download are reported as an error, after the rest have been added.
+func (rc *RoperController) SyncMirror(name string) (*mirror.Report, error) {
+	repo, err := rc.GetRepo(name)
+	if err != nil {
+		return nil, err
+	}
+	if !repo.Mirror.Enabled() {
+		return nil, fmt.Errorf("repo %s is not a mirror", name)
+	}
+	report, err := syncMirror(repo)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Downloaded) > 0 || len(report.Deleted) > 0 {
+		if err = rc.Discover(repo.Name, repo.AbsPath); err != nil {
+			return report, err
+		}
+	}
+	return report, reportError(repo, report)
+}
+
+// syncMirrors syncs every mirrored repo.  A failed sync is logged, and doesn't stop the others.
+func (rc *RoperController) syncMirrors() {
+	repos, err := rc.GetRepos()
+	if err != nil {
+		log.WithField("error", err).Error("unable to get repos to sync")
+		return
+	}
+	for _, repo := range repos {
+		if !repo.Mirror.Enabled() {
+			continue
+		}
+		if _, err = rc.SyncMirror(repo.Name); err != nil {
+			log.WithFields(log.Fields{
+				"repo":  repo.Name,
+				"error": err,
+			}).Error("error syncing mirror")
+		}
+	}
+}
+
+// syncMirror downloads the remote packages of a repo into its path
+func syncMirror(repo *model.Repo) (*mirror.Report, error) {
+	log.WithFields(log.Fields{
+		"repo": repo.Name,
+		"url":  repo.Mirror.URL,
+	}).Info("Syncing mirror")
+	report, err := mirror.Sync(repo.Mirror.URL, repo.AbsPath, &mirror.Options{
+		Delete: repo.Mirror.Delete,
+		// like readRPM, the checksum in the db is trusted if the file hasn't changed since it was read
+		KnownChecksum: func(relPath string, fi os.FileInfo) string {
+			pkg, ok := repo.Packages[filepath.ToSlash(relPath)]
+			if !ok || !pkg.SameFile(fi, fileInode(fi)) {
+				return ""
+			}
+			return pkg.Checksum
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to sync repo %s from %s: %s", repo.Name, repo.Mirror.URL, err)
+	}
+	log.WithFields(log.Fields{
+		"repo":       repo.Name,
+		"downloaded": len(report.Downloaded),
+		"deleted":    len(report.Deleted),
+		"failed":     len(report.Failed),
+	}).Info("Synced mirror")
+	return report, nil
+}
+
+// reportError turns the failed downloads of a sync into an error
+func reportError(repo *model.Repo, report *mirror.Report) error {
+	if len(report.Failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("unable to download %d packages from %s", len(report.Failed), repo.Mirror.URL)
+}
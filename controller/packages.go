@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/alapidas/roper/model"
+	"github.com/alapidas/roper/rpm"
+	"github.com/boltdb/bolt"
+)
+
+// PackageHeader reads the full header of a package in a yum repo, for details like the description and
+// changelog that aren't kept in the database
+func (rc *RoperController) PackageHeader(repoName, relPath string) (*rpm.Package, error) {
+	repo, err := rc.repoSettings(repoName)
+	if err != nil {
+		return nil, err
+	}
+	if !repo.IsYum() && repo.Type != model.TypeProxy {
+		return nil, fmt.Errorf("repo %s doesn't hold rpms", repoName)
+	}
+	hdr, err := rpm.ReadHeaders(filepath.Join(repo.AbsPath, filepath.FromSlash(relPath)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header of package %s in repo %s: %s", relPath, repoName, err)
+	}
+	return hdr, nil
+}
+
+// persistPackage adds a single package to an existing repo, without rewriting the rest of its packages.
+// Callers hold the repo lock, so the metadata isn't built while the package is half added.
+func (rc *RoperController) persistPackage(pkg *model.Package) error {
+	err := rc.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(repo_bucket)).Get([]byte(pkg.RepoName)) == nil {
+			return fmt.Errorf("repo with name %s not found in database", pkg.RepoName)
+		}
+		return rc.putPackageRecord(tx, &model.PersistablePackage{Package: *pkg})
+	})
+	if err != nil {
+		return fmt.Errorf("unable to persist package %s in repo %s: %s", pkg.RelPath, pkg.RepoName, err)
+	}
+	return nil
+}
+
+// removePackage removes a single package from a repo if its file is gone, without rewriting the rest
+// of its packages, and returns whether it was removed.  The file is checked under the repo lock, so a
+// file that was just replaced, like by an upload, keeps the record written for it.
+func (rc *RoperController) removePackage(repoName, relPath string) (bool, error) {
+	rc.locks.lock(repoName)
+	defer rc.locks.unlock(repoName)
+	repo, err := rc.repoSettings(repoName)
+	if err != nil {
+		return false, err
+	}
+	if _, err = os.Stat(filepath.Join(repo.AbsPath, relPath)); !os.IsNotExist(err) {
+		return false, nil
+	}
+	key, _, err := (&model.PersistablePackage{Package: model.Package{RepoName: repoName, RelPath: relPath}}).Serial()
+	if err != nil {
+		return false, err
+	}
+	removed := false
+	err = rc.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(pkg_bucket)).Get(key) == nil {
+			return nil
+		}
+		removed = true
+		return rc.deletePackageRecord(tx, key)
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to remove package %s from repo %s: %s", relPath, repoName, err)
+	}
+	return removed, nil
+}
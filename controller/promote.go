@@ -0,0 +1,377 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/model"
+	"github.com/alapidas/roper/rpm"
+	"github.com/boltdb/bolt"
+)
+
+// PromoteOptions control how packages are promoted
+type PromoteOptions struct {
+	WithDeps bool   // also promote the packages the matched ones require that the target is missing
+	Copy     bool   // copy the files instead of hardlinking them
+	DryRun   bool   // only work out what would be promoted
+	User     string // who is promoting, for the audit record
+}
+
+// Promote copies the packages of a repo whose NEVRA matches a pattern into another repo, at the same
+// path.  The pattern is a NEVRA or a glob.  Files are hardlinked unless opts.Copy is set, or they
+// can't be.  The new packages are recorded in the target repo along with an audit record in a single
+// transaction, and the target metadata is rebuilt once.  Packages the target already has are skipped.
+func (rc *RoperController) Promote(pattern, from, to string, opts PromoteOptions) (*model.Promotion, error) {
+	if from == to {
+		return nil, fmt.Errorf("can't promote packages from a repo to itself")
+	}
+	source, err := rc.GetRepo(from)
+	if err != nil {
+		return nil, err
+	}
+	target, err := rc.GetRepo(to)
+	if err != nil {
+		return nil, err
+	}
+	for _, repo := range []*model.Repo{source, target} {
+		if !repo.IsYum() {
+			return nil, fmt.Errorf("promotion is only supported between yum repos, and %s is a %s repo", repo.Name, repo.Type)
+		}
+	}
+	matched, err := matchPackages(source, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no packages in repo %s match %s", from, pattern)
+	}
+	pkgs := missingPackages(target, matched)
+	if opts.WithDeps {
+		pkgs = append(pkgs, missingDeps(source, target, pkgs)...)
+	}
+	promotion := &model.Promotion{From: from, To: to, Pattern: pattern, User: opts.User, Time: time.Now().UnixNano()}
+	for _, pkg := range pkgs {
+		promotion.Packages = append(promotion.Packages, pkg.NEVRA())
+	}
+	if opts.DryRun || len(pkgs) == 0 {
+		return promotion, nil
+	}
+	records, err := rc.promotePackages(source, target, pkgs, promotion, opts.Copy)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"from":     from,
+		"to":       to,
+		"user":     opts.User,
+		"packages": len(records),
+	}).Info("Promoted packages")
+	if err = rc.buildMetadata(to); err != nil {
+		return promotion, fmt.Errorf("unable to rebuild metadata for repo %s: %s", to, err)
+	}
+	return promotion, nil
+}
+
+// promotePackages places the files of packages from the source repo in the target repo, and records
+// them along with the promotion in a single transaction.  The files are removed again if anything
+// fails.
+func (rc *RoperController) promotePackages(source, target *model.Repo, pkgs []*model.Package, promotion *model.Promotion, copy bool) ([]*model.Package, error) {
+	// hold the repo lock so the metadata isn't built from half-promoted packages
+	rc.locks.lock(target.Name)
+	defer rc.locks.unlock(target.Name)
+	for _, pkg := range pkgs {
+		if existing, ok := target.Packages[pkg.RelPath]; ok {
+			return nil, fmt.Errorf("repo %s already has a different package at %s (%s)", target.Name, pkg.RelPath, existing.NEVRA())
+		}
+	}
+	keyring, err := loadKeyring(target)
+	if err != nil {
+		return nil, err
+	}
+
+	// place the files, and take them back out if anything goes wrong before the records are written
+	var placed []string
+	cleanup := func() {
+		for _, p := range placed {
+			os.Remove(p)
+		}
+	}
+	var records []*model.Package
+	for _, pkg := range pkgs {
+		dest := filepath.Join(target.AbsPath, pkg.RelPath)
+		if err = placeFile(filepath.Join(source.AbsPath, pkg.RelPath), dest, copy); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("unable to promote %s: %s", pkg.NEVRA(), err)
+		}
+		placed = append(placed, dest)
+		record := *pkg
+		record.RepoName = target.Name
+		// the package is new to the target, however old its file is
+		record.Added = 0
+		fi, err := os.Stat(dest)
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("unable to promote %s: %s", pkg.NEVRA(), err)
+		}
+		record.Size, record.ModTime, record.Inode = fi.Size(), fi.ModTime().UnixNano(), fileInode(fi)
+		if keyring != nil {
+			// the target only takes packages it would have accepted itself
+			if err = verifyPackage(keyring, target.AbsPath, &record); err != nil {
+				cleanup()
+				return nil, fmt.Errorf("unable to promote %s: repo %s doesn't trust it: %s", pkg.NEVRA(), target.Name, err)
+			}
+		}
+		records = append(records, &record)
+	}
+	err = rc.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(repo_bucket)).Get([]byte(target.Name)) == nil {
+			return fmt.Errorf("repo with name %s not found in database", target.Name)
+		}
+		for _, record := range records {
+			if err := rc.putPackageRecord(tx, &model.PersistablePackage{Package: *record}); err != nil {
+				return err
+			}
+		}
+		val, err := json.Marshal(promotion)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(promotion_bucket)).Put(promotionKey(promotion), val)
+	})
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("unable to record promotion to repo %s: %s", target.Name, err)
+	}
+	return records, nil
+}
+
+// GetPromotions returns the promotions into or out of a repo, or all promotions if repoName is empty,
+// oldest first
+func (rc *RoperController) GetPromotions(repoName string) ([]*model.Promotion, error) {
+	var promotions []*model.Promotion
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		return forEachRepoKey(tx, promotion_bucket, "", func(v []byte) error {
+			promotion := &model.Promotion{}
+			if err := json.Unmarshal(v, promotion); err != nil {
+				return fmt.Errorf("unable to unmarshal promotion: %s", err)
+			}
+			if repoName == "" || promotion.From == repoName || promotion.To == repoName {
+				promotions = append(promotions, promotion)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get promotions: %s", err)
+	}
+	sort.Sort(byPromotionTime(promotions))
+	return promotions, nil
+}
+
+type byPromotionTime []*model.Promotion
+
+func (p byPromotionTime) Len() int           { return len(p) }
+func (p byPromotionTime) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byPromotionTime) Less(i, j int) bool { return p[i].Time < p[j].Time }
+
+// promotionKey is the key of a promotion in the promotion bucket
+func promotionKey(promotion *model.Promotion) []byte {
+	return []byte(fmt.Sprintf("%s::%020d", promotion.To, promotion.Time))
+}
+
+// matchPackages returns the packages of a repo whose NEVRA matches a NEVRA or glob, sorted by path
+func matchPackages(repo *model.Repo, pattern string) ([]*model.Package, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("bad pattern %s: %s", pattern, err)
+	}
+	var matched []*model.Package
+	for _, pkg := range sortedPackages(repo) {
+		nevra := pkg.NEVRA()
+		if nevra == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, nevra); ok || nevra == pattern {
+			matched = append(matched, pkg)
+		}
+	}
+	return matched, nil
+}
+
+// missingPackages returns the packages that a repo doesn't already have the same version of
+func missingPackages(repo *model.Repo, pkgs []*model.Package) []*model.Package {
+	have := make(map[string]bool, len(repo.Packages))
+	for _, pkg := range repo.Packages {
+		have[pkg.NEVRA()] = true
+	}
+	var missing []*model.Package
+	for _, pkg := range pkgs {
+		if !have[pkg.NEVRA()] {
+			missing = append(missing, pkg)
+		}
+	}
+	return missing
+}
+
+// missingDeps returns the packages of source that are needed to satisfy the requirements of pkgs that
+// the target can't, following the requirements of the added packages too.  When several packages
+// of source provide a requirement, the newest is used.  Requirements nothing in either repo provides
+// are assumed to come from somewhere else, like the base OS.
+func missingDeps(source, target *model.Repo, pkgs []*model.Package) []*model.Package {
+	available := newDepIndex()
+	for _, pkg := range target.Packages {
+		available.add(pkg)
+	}
+	for _, pkg := range pkgs {
+		available.add(pkg)
+	}
+	candidates := newDepIndex()
+	for _, pkg := range sortedPackages(source) {
+		candidates.add(pkg)
+	}
+	var deps []*model.Package
+	queue := append([]*model.Package{}, pkgs...)
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, req := range pkg.Requires {
+			if strings.HasPrefix(req.Name, "rpmlib(") || len(available.providers(req)) > 0 {
+				continue
+			}
+			providers := candidates.providers(req)
+			if len(providers) == 0 {
+				log.WithFields(log.Fields{
+					"package":     pkg.NEVRA(),
+					"requirement": req.Name,
+				}).Debug("requirement not provided by the source repo")
+				continue
+			}
+			newest := providers[0]
+			for _, p := range providers[1:] {
+				if rpm.CompareEVR(p.Epoch, p.Version, p.Release, newest.Epoch, newest.Version, newest.Release) > 0 {
+					newest = p
+				}
+			}
+			log.WithFields(log.Fields{
+				"package":    pkg.NEVRA(),
+				"dependency": newest.NEVRA(),
+			}).Info("Promoting dependency")
+			available.add(newest)
+			deps = append(deps, newest)
+			queue = append(queue, newest)
+		}
+	}
+	return deps
+}
+
+// depIndex finds the packages that provide a requirement, by their provides, names and files
+type depIndex struct {
+	provides map[string][]depProvider
+	files    map[string][]*model.Package
+}
+
+type depProvider struct {
+	pkg *model.Package
+	dep model.Dependency
+}
+
+func newDepIndex() *depIndex {
+	return &depIndex{provides: make(map[string][]depProvider), files: make(map[string][]*model.Package)}
+}
+
+func (idx *depIndex) add(pkg *model.Package) {
+	self := model.Dependency{Name: pkg.Name, Flags: "EQ", Epoch: strconv.Itoa(pkg.Epoch), Version: pkg.Version, Release: pkg.Release}
+	idx.provides[pkg.Name] = append(idx.provides[pkg.Name], depProvider{pkg, self})
+	for _, dep := range pkg.Provides {
+		idx.provides[dep.Name] = append(idx.provides[dep.Name], depProvider{pkg, dep})
+	}
+	for _, f := range pkg.Files {
+		idx.files[f] = append(idx.files[f], pkg)
+	}
+}
+
+// providers returns the packages that satisfy a requirement
+func (idx *depIndex) providers(req model.Dependency) []*model.Package {
+	var pkgs []*model.Package
+	seen := make(map[*model.Package]bool)
+	for _, p := range idx.provides[req.Name] {
+		if !seen[p.pkg] && satisfies(p.dep, req) {
+			seen[p.pkg] = true
+			pkgs = append(pkgs, p.pkg)
+		}
+	}
+	if strings.HasPrefix(req.Name, "/") {
+		for _, pkg := range idx.files[req.Name] {
+			if !seen[pkg] {
+				seen[pkg] = true
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	return pkgs
+}
+
+// satisfies returns whether a provide satisfies a requirement of the same name.  Unversioned
+// provides satisfy any requirement, and versioned provides are taken to be exact versions.
+func satisfies(provide, req model.Dependency) bool {
+	if req.Flags == "" || provide.Flags == "" {
+		return true
+	}
+	epoch := func(s string) int {
+		e, _ := strconv.Atoi(s)
+		return e
+	}
+	release := provide.Release
+	if req.Release == "" {
+		// a requirement without a release matches every release
+		release = ""
+	}
+	cmp := rpm.CompareEVR(epoch(provide.Epoch), provide.Version, release, epoch(req.Epoch), req.Version, req.Release)
+	switch req.Flags {
+	case "EQ":
+		return cmp == 0
+	case "LT":
+		return cmp < 0
+	case "LE":
+		return cmp <= 0
+	case "GT":
+		return cmp > 0
+	case "GE":
+		return cmp >= 0
+	}
+	return false
+}
+
+// sortedPackages returns the packages of a repo sorted by path
+func sortedPackages(repo *model.Repo) []*model.Package {
+	pkgs := make([]*model.Package, 0, len(repo.Packages))
+	for _, pkg := range repo.Packages {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Sort(byRelPath(pkgs))
+	return pkgs
+}
+
+// placeFile hardlinks or copies a file to dest, creating its dir.  Files are copied if they can't be
+// linked.  Existing files are never replaced.
+func placeFile(src, dest string, copy bool) error {
+	if _, err := os.Lstat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if !copy {
+		if err := os.Link(src, dest); err == nil {
+			return nil
+		}
+	}
+	return copyFile(src, dest)
+}
@@ -0,0 +1,291 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/gpg"
+	"github.com/alapidas/roper/mirror"
+	"github.com/alapidas/roper/model"
+)
+
+// ProxyMetadataDir is the dir of a proxy repo that the remote metadata is cached in.  It is kept out
+// of repodata/, so that requests for the metadata always reach the proxy and the TTL can be checked.
+const ProxyMetadataDir = ".upstream"
+
+// proxyBuilder handles proxy repos.  Their packages are rpms, like those of a yum repo, but their
+// metadata is the remote repo's, so building it only refreshes the cached remote metadata.
+type proxyBuilder struct {
+	yumBuilder
+}
+
+func (b *proxyBuilder) Validate(repo *model.Repo) error {
+	if err := validateRemoteURL(repo.Proxy.URL); err != nil {
+		return err
+	}
+	if repo.Proxy.MetadataTTL < 0 || repo.Proxy.NegativeTTL < 0 {
+		return fmt.Errorf("proxy TTLs must not be negative")
+	}
+	if repo.Backend == model.BackendCreaterepo {
+		return fmt.Errorf("proxy repos serve the remote metadata, and can't use the createrepo backend")
+	}
+	if repo.Signing.Keyring != "" {
+		return fmt.Errorf("proxy repos serve the remote metadata, and can't sign it")
+	}
+	if repo.Verify.Keyring != "" {
+		return fmt.Errorf("package verification is not supported for proxy repos")
+	}
+	return nil
+}
+
+func (b *proxyBuilder) Build(repo *model.Repo, signer *gpg.Signer) error {
+	p := b.rc.proxy(repo)
+	if err := p.refreshMetadata(); err != nil {
+		if !p.hasMetadata() {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"repo":  repo.Name,
+			"error": err,
+		}).Warn("unable to refresh remote metadata, keeping the cached metadata")
+	}
+	return nil
+}
+
+// ProxyHandler returns the handler for requests to a proxy repo that miss the files on disk.  The
+// remote metadata is fetched when the cached copy is older than the repo's metadata TTL, and the
+// packages it lists are fetched from the remote repo, stored in the repo and added to it.  Other files
+// aren't fetched, except for GPG keys.  Packages the remote repo doesn't have are remembered for the
+// repo's negative TTL.
+func (rc *RoperController) ProxyHandler(repo *model.Repo) http.Handler {
+	return rc.proxy(repo)
+}
+
+// proxy fetches the metadata and packages of a proxy repo from its remote repo as they are needed
+type proxy struct {
+	rc     *RoperController
+	client *http.Client
+	mdLock sync.Mutex // held while the remote metadata is refreshed
+
+	sync.Mutex
+	repo      model.Repo                 // settings of the repo, without packages
+	nextCheck time.Time                  // when the remote metadata should next be checked
+	remote    map[string]*mirror.Package // packages in the cached remote metadata, by location
+	missing   map[string]time.Time       // packages the remote repo didn't have, and when
+	inflight  map[string]*proxyFetch     // files being fetched
+}
+
+// proxyFetch is a fetch of a file in progress, which other requests for the file wait on
+type proxyFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// proxy returns the proxy of a repo, updating its settings from the repo.  Changing the remote repo
+// forgets everything that is cached in memory.
+func (rc *RoperController) proxy(repo *model.Repo) *proxy {
+	rc.proxyLock.Lock()
+	p, ok := rc.proxies[repo.Name]
+	if !ok {
+		p = &proxy{rc: rc, client: http.DefaultClient}
+		rc.proxies[repo.Name] = p
+	}
+	rc.proxyLock.Unlock()
+
+	settings := *repo
+	settings.Packages = nil
+	p.Lock()
+	defer p.Unlock()
+	if !ok || p.repo.AbsPath != settings.AbsPath || p.repo.Proxy != settings.Proxy {
+		p.nextCheck = time.Time{}
+		p.remote = nil
+		p.missing = make(map[string]time.Time)
+		p.inflight = make(map[string]*proxyFetch)
+	}
+	p.repo = settings
+	return p
+}
+
+// settings returns the current settings of the proxy's repo
+func (p *proxy) settings() model.Repo {
+	p.Lock()
+	defer p.Unlock()
+	return p.repo
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	relPath := path.Clean("/" + r.URL.Path)[1:]
+	repo := p.settings()
+	if relPath == "" || strings.HasSuffix(r.URL.Path, "/") {
+		// dirs that aren't on disk yet have nothing in them
+		http.NotFound(w, r)
+		return
+	}
+	if path.Dir(relPath) == path.Dir(mirror.RepomdPath) {
+		if err := p.refreshMetadata(); err != nil {
+			log.WithFields(log.Fields{
+				"repo":  repo.Name,
+				"error": err,
+			}).Warn("unable to refresh remote metadata, serving the cached metadata")
+		}
+		http.ServeFile(w, r, filepath.Join(repo.AbsPath, ProxyMetadataDir, filepath.FromSlash(relPath)))
+		return
+	}
+	err := p.fetch(relPath)
+	if _, ok := err.(*mirror.NotFoundError); ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"repo":  repo.Name,
+			"path":  relPath,
+			"error": err,
+		}).Error("unable to fetch file from remote repo")
+		http.Error(w, "unable to fetch file from remote repo", http.StatusBadGateway)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(repo.AbsPath, filepath.FromSlash(relPath)))
+}
+
+// hasMetadata returns whether the repo has cached remote metadata
+func (p *proxy) hasMetadata() bool {
+	repo := p.settings()
+	_, err := os.Stat(filepath.Join(repo.AbsPath, ProxyMetadataDir, filepath.FromSlash(mirror.RepomdPath)))
+	return err == nil
+}
+
+// refreshMetadata fetches the remote metadata if the cached copy is older than the metadata TTL.  If
+// the fetch fails, the next one is tried after the negative TTL, and the cached copy is used until
+// then.
+func (p *proxy) refreshMetadata() error {
+	p.mdLock.Lock()
+	defer p.mdLock.Unlock()
+	p.Lock()
+	repo, due := p.repo, !time.Now().Before(p.nextCheck)
+	p.Unlock()
+	if !due {
+		return nil
+	}
+	dir := filepath.Join(repo.AbsPath, ProxyMetadataDir)
+	log.WithFields(log.Fields{
+		"repo": repo.Name,
+		"url":  repo.Proxy.URL,
+	}).Info("Refreshing remote metadata")
+	fetchErr := mirror.FetchMetadata(p.client, repo.Proxy.URL, dir)
+	next := time.Now().Add(repo.Proxy.MetadataTTLOrDefault())
+	if fetchErr != nil {
+		fetchErr = fmt.Errorf("unable to fetch metadata of proxy repo %s: %s", repo.Name, fetchErr)
+		next = time.Now().Add(repo.Proxy.NegativeTTLOrDefault())
+	}
+	// the cached metadata is read even if the fetch failed, since it may not have been read yet
+	pkgs, readErr := mirror.ReadPackages(dir)
+	p.Lock()
+	defer p.Unlock()
+	p.nextCheck = next
+	if readErr == nil {
+		p.remote = make(map[string]*mirror.Package, len(pkgs))
+		for _, pkg := range pkgs {
+			p.remote[pkg.Location] = pkg
+		}
+	}
+	if fetchErr != nil {
+		return fetchErr
+	}
+	// new metadata may list files that were missing before
+	p.missing = make(map[string]time.Time)
+	return readErr
+}
+
+// proxyKeyPrefix is the prefix of the names of the GPG keys at the root of a remote repo, which are
+// fetched even though the remote metadata doesn't list them
+const proxyKeyPrefix = "RPM-GPG-KEY"
+
+// listed returns whether a file can be fetched from the remote repo.  Only the packages in the remote
+// metadata and the GPG keys are, so clients can't fill the repo with arbitrary remote files.
+func (p *proxy) listed(relPath string) bool {
+	if path.Dir(relPath) == "." && strings.HasPrefix(relPath, proxyKeyPrefix) {
+		return true
+	}
+	p.Lock()
+	defer p.Unlock()
+	return p.remote[relPath] != nil
+}
+
+// fetch makes sure a file of the repo is on disk, fetching it from the remote repo if it isn't.
+// Concurrent fetches of the same file share one download.
+func (p *proxy) fetch(relPath string) error {
+	if err := p.refreshMetadata(); err != nil {
+		log.WithField("error", err).Warn("unable to refresh remote metadata, using the cached metadata")
+	}
+	if !p.listed(relPath) {
+		return &mirror.NotFoundError{URL: relPath}
+	}
+	p.Lock()
+	if at, ok := p.missing[relPath]; ok {
+		if time.Since(at) < p.repo.Proxy.NegativeTTLOrDefault() {
+			p.Unlock()
+			return &mirror.NotFoundError{URL: relPath}
+		}
+		delete(p.missing, relPath)
+	}
+	if call, ok := p.inflight[relPath]; ok {
+		p.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &proxyFetch{done: make(chan struct{})}
+	p.inflight[relPath] = call
+	p.Unlock()
+
+	call.err = p.download(relPath)
+
+	p.Lock()
+	delete(p.inflight, relPath)
+	// only packages in the metadata are remembered, so the map can't grow past it
+	if _, ok := call.err.(*mirror.NotFoundError); ok && p.remote[relPath] != nil {
+		p.missing[relPath] = time.Now()
+	}
+	p.Unlock()
+	close(call.done)
+	return call.err
+}
+
+// download fetches a file from the remote repo into the repo.  Packages are checked against the
+// checksums in the remote metadata, and are added to the repo once they are in place.  GPG keys have
+// no checksum, and aren't packages.
+func (p *proxy) download(relPath string) error {
+	p.Lock()
+	repo, pkg := p.repo, p.remote[relPath]
+	p.Unlock()
+	known := pkg != nil
+	if !known {
+		pkg = &mirror.Package{Location: relPath}
+	}
+	dest := filepath.Join(repo.AbsPath, filepath.FromSlash(relPath))
+	if _, err := os.Stat(dest); err == nil {
+		// fetched by someone else since the request missed
+		return nil
+	}
+	if err := mirror.Download(p.client, repo.Proxy.URL, pkg, dest); err != nil {
+		return err
+	}
+	builder, err := p.rc.builder(&repo)
+	if err != nil {
+		return err
+	}
+	if !known || !builder.IsPackage(relPath) {
+		return nil
+	}
+	// hold the repo lock so the metadata isn't built while the package is half added, like uploads
+	p.rc.locks.lock(repo.Name)
+	defer p.rc.locks.unlock(repo.Name)
+	return p.rc.persistPackage(p.rc.readPackage(builder, &repo, relPath))
+}
@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/gpg"
+	"github.com/alapidas/roper/model"
+	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/openpgp"
+)
+
+// loadKeyring loads the trusted keys used to verify packages added to a repo.  A nil keyring is
+// returned if the repo doesn't verify signatures.
+func loadKeyring(repo *model.Repo) (openpgp.EntityList, error) {
+	if repo.Verify.Keyring == "" {
+		return nil, nil
+	}
+	keyring, err := gpg.ReadKeyring(repo.Verify.Keyring)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load verification keyring for repo %s: %s", repo.Name, err)
+	}
+	return keyring, nil
+}
+
+// verifyPackage checks the signature of a package that was just read against a keyring, and records
+// the key that signed it on the package.  An error means the package should be quarantined.
+func verifyPackage(keyring openpgp.EntityList, repoPath string, pkg *model.Package) error {
+	if pkg.Name == "" {
+		return errors.New("package header could not be read")
+	}
+	keyID, err := gpg.VerifyRPM(filepath.Join(repoPath, pkg.RelPath), keyring)
+	if err != nil {
+		return err
+	}
+	pkg.SignedBy = keyID
+	return nil
+}
+
+// quarantined builds the quarantine record for a package that failed verification
+func quarantined(pkg *model.Package, reason error) *model.QuarantinedPackage {
+	log.WithFields(log.Fields{
+		"repo":   pkg.RepoName,
+		"path":   pkg.RelPath,
+		"reason": reason,
+	}).Warn("quarantining package")
+	return &model.QuarantinedPackage{Package: *pkg, Reason: reason.Error(), Time: time.Now().Unix()}
+}
+
+// GetQuarantine returns the quarantined packages of a repo, or of all repos if repoName is empty
+func (rc *RoperController) GetQuarantine(repoName string) ([]*model.QuarantinedPackage, error) {
+	var pkgs []*model.QuarantinedPackage
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		var err error
+		pkgs, err = rc.getQuarantine(tx, repoName)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get quarantined packages: %s", err)
+	}
+	return pkgs, nil
+}
+
+// quarantineMap returns the quarantined packages of a repo keyed by relative path
+func (rc *RoperController) quarantineMap(repoName string) (map[string]*model.QuarantinedPackage, error) {
+	pkgs, err := rc.GetQuarantine(repoName)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]*model.QuarantinedPackage, len(pkgs))
+	for _, pkg := range pkgs {
+		held[pkg.RelPath] = pkg
+	}
+	return held, nil
+}
+
+// setQuarantine replaces all the quarantined packages of a repo
+func (rc *RoperController) setQuarantine(repoName string, pkgs map[string]*model.QuarantinedPackage) error {
+	err := rc.db.Update(func(tx *bolt.Tx) error {
+		if err := deleteRepoKeys(tx, quarantine_bucket, repoName); err != nil {
+			return err
+		}
+		for _, pkg := range pkgs {
+			if err := rc.putQuarantined(tx, pkg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to persist quarantined packages for repo %s: %s", repoName, err)
+	}
+	return nil
+}
+
+// addQuarantined adds packages to the quarantine, replacing any existing records for the same files
+func (rc *RoperController) addQuarantined(pkgs ...*model.QuarantinedPackage) error {
+	err := rc.db.Update(func(tx *bolt.Tx) error {
+		for _, pkg := range pkgs {
+			if err := rc.putQuarantined(tx, pkg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to persist quarantined packages: %s", err)
+	}
+	return nil
+}
+
+// ApproveQuarantined releases a quarantined package into its repo, and rebuilds the repo metadata.
+// The file must not have changed since it was quarantined.
+func (rc *RoperController) ApproveQuarantined(repoName, relPath string) error {
+	held, err := rc.quarantineMap(repoName)
+	if err != nil {
+		return err
+	}
+	qpkg, ok := held[relPath]
+	if !ok {
+		return fmt.Errorf("package %s is not quarantined in repo %s", relPath, repoName)
+	}
+	repo, err := rc.GetRepo(repoName)
+	if err != nil {
+		return err
+	}
+	builder, err := rc.builder(repo)
+	if err != nil {
+		return err
+	}
+	pkg := rc.readPackage(builder, repo, relPath)
+	if pkg.Name == "" {
+		return fmt.Errorf("package %s in repo %s can't be read, and can only be rejected", relPath, repoName)
+	}
+	if pkg.Checksum != qpkg.Checksum {
+		return fmt.Errorf("package %s in repo %s has changed since it was quarantined", relPath, repoName)
+	}
+	pkg.SignedBy = model.SignerApproved
+	if err = repo.AddPackage(pkg); err != nil {
+		return err
+	}
+	if err = rc.PersistRepo(repo); err != nil {
+		return err
+	}
+	if err = rc.deleteQuarantined(repoName, relPath); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"repo": repoName,
+		"path": relPath,
+	}).Info("approved quarantined package")
+	if err = rc.buildMetadata(repoName); err != nil {
+		return fmt.Errorf("unable to rebuild metadata for repo %s: %s", repoName, err)
+	}
+	return nil
+}
+
+// RejectQuarantined deletes a quarantined package from disk, and removes it from the quarantine
+func (rc *RoperController) RejectQuarantined(repoName, relPath string) error {
+	held, err := rc.quarantineMap(repoName)
+	if err != nil {
+		return err
+	}
+	if _, ok := held[relPath]; !ok {
+		return fmt.Errorf("package %s is not quarantined in repo %s", relPath, repoName)
+	}
+	repo, err := rc.GetRepo(repoName)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(filepath.Join(repo.AbsPath, relPath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove package %s from repo %s: %s", relPath, repoName, err)
+	}
+	if err = rc.deleteQuarantined(repoName, relPath); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"repo": repoName,
+		"path": relPath,
+	}).Info("rejected quarantined package")
+	return nil
+}
+
+// deleteQuarantined removes a single package from the quarantine
+func (rc *RoperController) deleteQuarantined(repoName, relPath string) error {
+	err := rc.db.Update(func(tx *bolt.Tx) error {
+		qb := tx.Bucket([]byte(quarantine_bucket))
+		return qb.Delete([]byte(repoName + "::" + relPath))
+	})
+	if err != nil {
+		return fmt.Errorf("unable to remove package %s in repo %s from quarantine: %s", relPath, repoName, err)
+	}
+	return nil
+}
+
+// getQuarantine is an internal API method that gets the quarantined packages of a repo, or of all
+// repos if repoName is empty, given a transaction
+func (rc *RoperController) getQuarantine(tx *bolt.Tx, repoName string) ([]*model.QuarantinedPackage, error) {
+	pkgs := []*model.QuarantinedPackage{}
+	err := forEachRepoKey(tx, quarantine_bucket, repoName, func(v []byte) error {
+		pkg := &model.QuarantinedPackage{}
+		if err := json.Unmarshal(v, pkg); err != nil {
+			return fmt.Errorf("unable to unmarshal quarantined package: %s", err)
+		}
+		pkgs = append(pkgs, pkg)
+		return nil
+	})
+	return pkgs, err
+}
+
+// putQuarantined is an internal API method that stores a quarantined package, given a transaction
+func (rc *RoperController) putQuarantined(tx *bolt.Tx, pkg *model.QuarantinedPackage) error {
+	qb := tx.Bucket([]byte(quarantine_bucket))
+	pq := &model.PersistableQuarantinedPackage{QuarantinedPackage: *pkg}
+	key, val, err := pq.Serial()
+	if err != nil {
+		return fmt.Errorf("unable to get serialized vals for quarantined package %s in repo %s: %s", pkg.RelPath, pkg.RepoName, err)
+	}
+	if err = qb.Put(key, val); err != nil {
+		return fmt.Errorf("unable to persist quarantined package %s: %s", key, err)
+	}
+	return nil
+}
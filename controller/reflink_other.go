@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package controller
+
+import (
+	"errors"
+)
+
+// reflink isn't supported outside of linux
+func reflink(src, dest string) error {
+	return errors.New("reflinks are only supported on linux")
+}
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/model"
+)
+
+// Prune removes the packages of a repo that fall outside of its retention policy, deleting their
+// files and rebuilding the metadata.  The pruned packages are returned.  With dryRun, nothing is
+// changed, and the packages that would be pruned are returned.
+func (rc *RoperController) Prune(repoName string, dryRun bool) ([]*model.Package, error) {
+	repo, err := rc.GetRepo(repoName)
+	if err != nil {
+		return nil, err
+	}
+	builder, err := rc.builder(repo)
+	if err != nil {
+		return nil, err
+	}
+	pruned := pruneCandidates(repo, builder, time.Now())
+	if dryRun || len(pruned) == 0 {
+		return pruned, nil
+	}
+	for _, pkg := range pruned {
+		log.WithFields(log.Fields{
+			"repo":  repo.Name,
+			"path":  pkg.RelPath,
+			"nevra": pkg.NEVRA(),
+		}).Info("pruning package")
+		err := os.Remove(filepath.Join(repo.AbsPath, pkg.RelPath))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("unable to remove package %s from repo %s: %s", pkg.RelPath, repo.Name, err)
+		}
+		delete(repo.Packages, pkg.RelPath)
+	}
+	if err = rc.PersistRepo(repo); err != nil {
+		return nil, err
+	}
+	if err = rc.buildMetadata(repo.Name); err != nil {
+		return nil, fmt.Errorf("unable to rebuild metadata for repo %s: %s", repo.Name, err)
+	}
+	return pruned, nil
+}
+
+// pruneAll prunes every repo that has a retention policy.  Failures are logged, so one broken repo
+// doesn't stop the others from being pruned.
+func (rc *RoperController) pruneAll() {
+	repos, err := rc.GetRepos()
+	if err != nil {
+		log.WithField("error", err).Error("unable to get repos to prune")
+		return
+	}
+	for _, repo := range repos {
+		if !repo.Retention.Enabled() {
+			continue
+		}
+		if _, err = rc.Prune(repo.Name, false); err != nil {
+			log.WithFields(log.Fields{
+				"repo":  repo.Name,
+				"error": err,
+			}).Error("unable to prune repo")
+		}
+	}
+}
+
+// pruneCandidates returns the packages of a repo that fall outside of its retention policy, sorted
+// by path.  Packages without header data are never pruned, since their versions are unknown.
+// Versions are ordered by the repo's builder, and aged by when they were added to the repo, not by
+// their file's modification time, which promoted and mirrored files carry over from their source.
+func pruneCandidates(repo *model.Repo, builder MetadataBuilder, now time.Time) []*model.Package {
+	opts := repo.Retention
+	if !opts.Enabled() {
+		return nil
+	}
+	groups := make(map[string][]*model.Package)
+	for _, pkg := range repo.Packages {
+		if pkg.Name == "" {
+			continue
+		}
+		key := pkg.Name + "." + pkg.Arch
+		groups[key] = append(groups[key], pkg)
+	}
+	cutoff := now.Add(-time.Duration(opts.MaxAgeDays) * 24 * time.Hour).Unix()
+	pruned := []*model.Package{}
+	for _, pkgs := range groups {
+		sort.Sort(sort.Reverse(byVersion{pkgs, builder}))
+		// pkgs[0] is the newest, and is always kept
+		for i, pkg := range pkgs[1:] {
+			added := pkg.Added
+			if added == 0 {
+				// recorded before the time was kept
+				added = pkg.ModTime / int64(time.Second)
+			}
+			if (opts.KeepLast > 0 && i+1 >= opts.KeepLast) || (opts.MaxAgeDays > 0 && added < cutoff) {
+				pruned = append(pruned, pkg)
+			}
+		}
+	}
+	sort.Sort(byRelPath(pruned))
+	return pruned
+}
+
+// byVersion sorts versions of a package using a builder's ordering
+type byVersion struct {
+	pkgs    []*model.Package
+	builder MetadataBuilder
+}
+
+func (p byVersion) Len() int      { return len(p.pkgs) }
+func (p byVersion) Swap(i, j int) { p.pkgs[i], p.pkgs[j] = p.pkgs[j], p.pkgs[i] }
+func (p byVersion) Less(i, j int) bool {
+	c := p.builder.Compare(p.pkgs[i], p.pkgs[j])
+	if c == 0 {
+		// the same version in two places, keep the ordering stable
+		return p.pkgs[i].RelPath > p.pkgs[j].RelPath
+	}
+	return c < 0
+}
+
+type byRelPath []*model.Package
+
+func (p byRelPath) Len() int           { return len(p) }
+func (p byRelPath) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byRelPath) Less(i, j int) bool { return p[i].RelPath < p[j].RelPath }
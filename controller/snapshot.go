@@ -0,0 +1,329 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/model"
+	"github.com/boltdb/bolt"
+)
+
+// SetSnapshotDir sets the dir snapshots are stored in.  Each repo gets a dir under it, with a dir for
+// each snapshot named by its id.
+func (rc *RoperController) SetSnapshotDir(dir string) {
+	rc.snapshotDir = dir
+}
+
+// SnapshotDir returns the dir the snapshots of a repo are stored in.  It fails for repo names that
+// would point outside the snapshot store.
+func (rc *RoperController) SnapshotDir(repoName string) (string, error) {
+	dir := filepath.Join(rc.snapshotDir, repoName)
+	if err := ValidateRepoName(repoName); err != nil || filepath.Dir(dir) != filepath.Clean(rc.snapshotDir) {
+		return "", fmt.Errorf("repo name %q can't be used for snapshots", repoName)
+	}
+	return dir, nil
+}
+
+// CreateSnapshot freezes the current packages and metadata of a repo.  The files of the repo are
+// cloned into the snapshot store, so later changes to the repo, even files rewritten in place, don't
+// affect the snapshot.  Packages are linked to their blobs when the blob store is on, and those
+// blobs are referenced by the snapshot.  The label is optional.
+func (rc *RoperController) CreateSnapshot(repoName, label string) (*model.Snapshot, error) {
+	if _, err := strconv.Atoi(label); err == nil {
+		return nil, fmt.Errorf("snapshot label %s can't be a number", label)
+	}
+	// hold the repo lock so the metadata isn't rebuilt while it is cloned
+	rc.locks.lock(repoName)
+	defer rc.locks.unlock(repoName)
+	repo, err := rc.GetRepo(repoName)
+	if err != nil {
+		return nil, err
+	}
+	if repo.Type == model.TypeProxy || repo.Type == model.TypeVirtual {
+		return nil, fmt.Errorf("snapshots are not supported for %s repos", repo.Type)
+	}
+	builder, err := rc.builder(repo)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := rc.GetSnapshots(repoName)
+	if err != nil {
+		return nil, err
+	}
+	snap := &model.Snapshot{ID: 1, RepoName: repoName, Label: label, Created: time.Now().Unix()}
+	for _, s := range existing {
+		if s.ID >= snap.ID {
+			snap.ID = s.ID + 1
+		}
+		if label != "" && s.Label == label {
+			return nil, fmt.Errorf("repo %s already has a snapshot labeled %s", repoName, label)
+		}
+	}
+	snap.Packages = snapshotPackages(repo)
+
+	snapDir, err := rc.SnapshotDir(repoName)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(snapDir, strconv.Itoa(snap.ID))
+	tmp := filepath.Join(snapDir, fmt.Sprintf(".tmp-%d", snap.ID))
+	os.RemoveAll(tmp)
+	linked, err := rc.cloneRepo(repo, builder, tmp)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, fmt.Errorf("unable to snapshot repo %s: %s", repoName, err)
+	}
+	for i := range snap.Packages {
+		snap.Packages[i].Blob = linked[snap.Packages[i].RelPath]
+	}
+	if err = os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		return nil, fmt.Errorf("unable to snapshot repo %s: %s", repoName, err)
+	}
+	err = rc.db.Update(func(tx *bolt.Tx) error {
+		val, err := json.Marshal(snap)
+		if err != nil {
+			return err
+		}
+		if err = tx.Bucket([]byte(snapshot_bucket)).Put(snapshotKey(repoName, snap.ID), val); err != nil {
+			return err
+		}
+		return rc.adjustBlobRefs(tx, snapshotChecksums(snap), 1)
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("unable to persist snapshot of repo %s: %s", repoName, err)
+	}
+	log.WithFields(log.Fields{
+		"repo":     repoName,
+		"id":       snap.ID,
+		"packages": len(snap.Packages),
+	}).Info("Created snapshot")
+	return snap, nil
+}
+
+// GetSnapshots returns the snapshots of a repo, oldest first
+func (rc *RoperController) GetSnapshots(repoName string) ([]*model.Snapshot, error) {
+	var snaps []*model.Snapshot
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		return forEachRepoKey(tx, snapshot_bucket, repoName, func(v []byte) error {
+			snap := &model.Snapshot{}
+			if err := json.Unmarshal(v, snap); err != nil {
+				return fmt.Errorf("unable to unmarshal snapshot: %s", err)
+			}
+			snaps = append(snaps, snap)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get snapshots of repo %s: %s", repoName, err)
+	}
+	return snaps, nil
+}
+
+// GetSnapshot returns a snapshot of a repo by its id or label
+func (rc *RoperController) GetSnapshot(repoName, ref string) (*model.Snapshot, error) {
+	snaps, err := rc.GetSnapshots(repoName)
+	if err != nil {
+		return nil, err
+	}
+	for _, snap := range snaps {
+		if strconv.Itoa(snap.ID) == ref || (snap.Label != "" && snap.Label == ref) {
+			return snap, nil
+		}
+	}
+	return nil, fmt.Errorf("repo %s has no snapshot %s", repoName, ref)
+}
+
+// RemoveSnapshot deletes a snapshot of a repo, by its id or label
+func (rc *RoperController) RemoveSnapshot(repoName, ref string) error {
+	snap, err := rc.GetSnapshot(repoName, ref)
+	if err != nil {
+		return err
+	}
+	snapDir, err := rc.SnapshotDir(repoName)
+	if err != nil {
+		return err
+	}
+	err = rc.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(snapshot_bucket)).Delete(snapshotKey(repoName, snap.ID)); err != nil {
+			return err
+		}
+		return rc.adjustBlobRefs(tx, snapshotChecksums(snap), -1)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to delete snapshot %d of repo %s: %s", snap.ID, repoName, err)
+	}
+	if err = os.RemoveAll(filepath.Join(snapDir, strconv.Itoa(snap.ID))); err != nil {
+		return fmt.Errorf("unable to delete files of snapshot %d of repo %s: %s", snap.ID, repoName, err)
+	}
+	return nil
+}
+
+// DiffSnapshots compares two snapshots of a repo, given by id or label, and returns the packages that
+// were added and removed between them.  If to is empty, the first snapshot is compared to the repo as
+// it is now.  A package that was rebuilt with the same version shows up as both removed and added.
+func (rc *RoperController) DiffSnapshots(repoName, from, to string) (added, removed []model.SnapshotPackage, err error) {
+	fromSnap, err := rc.GetSnapshot(repoName, from)
+	if err != nil {
+		return nil, nil, err
+	}
+	var toPkgs []model.SnapshotPackage
+	if to == "" {
+		repo, err := rc.GetRepo(repoName)
+		if err != nil {
+			return nil, nil, err
+		}
+		toPkgs = snapshotPackages(repo)
+	} else {
+		toSnap, err := rc.GetSnapshot(repoName, to)
+		if err != nil {
+			return nil, nil, err
+		}
+		toPkgs = toSnap.Packages
+	}
+	added, removed = diffPackages(fromSnap.Packages, toPkgs)
+	return added, removed, nil
+}
+
+// diffPackages returns the packages in b that aren't in a, and the packages in a that aren't in b.
+// Packages are matched by NEVRA, or by path if they have none, and checksum.
+func diffPackages(a, b []model.SnapshotPackage) (added, removed []model.SnapshotPackage) {
+	key := func(pkg model.SnapshotPackage) string {
+		id := pkg.NEVRA
+		if id == "" {
+			id = pkg.RelPath
+		}
+		return id + " " + pkg.Checksum
+	}
+	inA := make(map[string]bool, len(a))
+	for _, pkg := range a {
+		inA[key(pkg)] = true
+	}
+	inB := make(map[string]bool, len(b))
+	for _, pkg := range b {
+		inB[key(pkg)] = true
+		if !inA[key(pkg)] {
+			added = append(added, pkg)
+		}
+	}
+	for _, pkg := range a {
+		if !inB[key(pkg)] {
+			removed = append(removed, pkg)
+		}
+	}
+	return added, removed
+}
+
+// snapshotPackages lists the packages of a repo, sorted by path
+func snapshotPackages(repo *model.Repo) []model.SnapshotPackage {
+	pkgs := make([]model.SnapshotPackage, 0, len(repo.Packages))
+	for _, pkg := range repo.Packages {
+		pkgs = append(pkgs, model.SnapshotPackage{RelPath: pkg.RelPath, NEVRA: pkg.NEVRA(), Checksum: pkg.Checksum})
+	}
+	sort.Sort(bySnapshotPath(pkgs))
+	return pkgs
+}
+
+type bySnapshotPath []model.SnapshotPackage
+
+func (p bySnapshotPath) Len() int           { return len(p) }
+func (p bySnapshotPath) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p bySnapshotPath) Less(i, j int) bool { return p[i].RelPath < p[j].RelPath }
+
+// snapshotKey is the key of a snapshot in the snapshot bucket.  Ids are zero padded so snapshots are
+// kept in order.
+func snapshotKey(repoName string, id int) []byte {
+	return []byte(fmt.Sprintf("%s::%010d", repoName, id))
+}
+
+// cloneRepo copies the files of a repo into dir, so later changes to the repo don't affect the copies,
+// and returns the packages whose copies are links to the blob store.  Blobs are read-only, so with the
+// blob store on, package files are hardlinked to their blobs.  Other files, and packages that can't be
+// linked, are reflinked where the filesystem supports it, so they share their data with the repo
+// until either side changes, and copied otherwise.  Repo files aren't hardlinked, since a file
+// rewritten in place would change in the snapshot too.  Package files are only cloned if they are in
+// the repo, so quarantined and unreadable files are left out, and hidden files and dirs, like those
+// used while metadata is being written, are skipped.  The clones are read-only.
+func (rc *RoperController) cloneRepo(repo *model.Repo, builder MetadataBuilder, dir string) (map[string]bool, error) {
+	linked := make(map[string]bool)
+	err := filepath.Walk(repo.AbsPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(repo.AbsPath, filePath)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return os.MkdirAll(dir, 0755)
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dest := filepath.Join(dir, relPath)
+		if info.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		pkg, ok := repo.Packages[relPath]
+		if builder.IsPackage(relPath) && !ok {
+			return nil
+		}
+		if ok && rc.linkSnapshotBlob(filePath, pkg, dest) {
+			linked[relPath] = true
+			return nil
+		}
+		if err = reflink(filePath, dest); err != nil {
+			if err = copyFile(filePath, dest); err != nil {
+				return err
+			}
+		}
+		return os.Chmod(dest, 0444)
+	})
+	return linked, err
+}
+
+// linkSnapshotBlob hardlinks the blob of a package to dest, and returns whether it did.  The blob has
+// to back the package's file, and still have the package's size.
+func (rc *RoperController) linkSnapshotBlob(absPath string, pkg *model.Package, dest string) bool {
+	blob := rc.blobPath(pkg.Checksum)
+	if rc.blobDir == "" || blob == "" || !rc.inBlobStore(absPath, pkg) {
+		return false
+	}
+	if bi, err := os.Stat(blob); err != nil || bi.Size() != pkg.Size || bi.Mode().Perm() != 0444 {
+		return false
+	}
+	return os.Link(blob, dest) == nil
+}
+
+// copyFile copies the contents of a file
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
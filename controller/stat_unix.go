@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package controller
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode number of a file
+func fileInode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
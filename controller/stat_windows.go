@@ -0,0 +1,10 @@
+package controller
+
+import (
+	"os"
+)
+
+// fileInode returns 0, since there are no inodes to speak of on windows
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/gpg"
+	"github.com/alapidas/roper/model"
+)
+
+var (
+	// ErrPackageExists is returned for uploads to a path that already has a file, unless overwriting
+	ErrPackageExists = errors.New("a file already exists at that path")
+	// ErrPackageTooLarge is returned for uploads over the size limit
+	ErrPackageTooLarge = errors.New("package is larger than the upload limit")
+)
+
+// InvalidPackageError is returned for uploads that aren't a package the repo accepts
+type InvalidPackageError struct {
+	Reason string
+}
+
+func (e *InvalidPackageError) Error() string {
+	return "invalid package: " + e.Reason
+}
+
+// UploadOptions control how an uploaded package is stored
+type UploadOptions struct {
+	MaxSize   int64 // largest package accepted, in bytes, 0 means no limit
+	Overwrite bool  // replace a file that is already at the path
+}
+
+// UploadPackage writes a package to relPath in a yum repo, and adds it to the repo right away instead
+// of waiting for the next scan.  The upload is written to a temporary file in the repo and checked
+// before it is moved into place, so clients never see a partial or invalid package.  Repos that verify
+// signatures reject unsigned packages outright rather than quarantining them.
+func (rc *RoperController) UploadPackage(repoName, relPath string, r io.Reader, opts UploadOptions) (*model.Package, error) {
+	repo, err := rc.GetRepo(repoName)
+	if err != nil {
+		return nil, err
+	}
+	if !repo.IsYum() {
+		return nil, fmt.Errorf("uploads are only supported for yum repos, and %s is a %s repo", repoName, repo.Type)
+	}
+	relPath, err = cleanUploadPath(relPath)
+	if err != nil {
+		return nil, &InvalidPackageError{err.Error()}
+	}
+	builder, err := rc.builder(repo)
+	if err != nil {
+		return nil, err
+	}
+	if !builder.IsPackage(relPath) {
+		return nil, &InvalidPackageError{fmt.Sprintf("%s is not an rpm", relPath)}
+	}
+	dest := filepath.Join(repo.AbsPath, filepath.FromSlash(relPath))
+	if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create dir for %s: %s", relPath, err)
+	}
+	// the temp file doesn't look like a package, so scans ignore it
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".upload-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create upload file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err = writeUpload(tmp, r, opts.MaxSize); err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return nil, fmt.Errorf("unable to write upload file: %s", err)
+	}
+	check := &model.Package{RelPath: relPath, RepoName: repoName}
+	if err = builder.ReadPackage(check, tmp.Name()); err != nil || check.Name == "" {
+		return nil, &InvalidPackageError{fmt.Sprintf("unable to read rpm header: %v", err)}
+	}
+	keyring, err := loadKeyring(repo)
+	if err != nil {
+		return nil, err
+	}
+	var signedBy string
+	if keyring != nil {
+		if signedBy, err = gpg.VerifyRPM(tmp.Name(), keyring); err != nil {
+			return nil, &InvalidPackageError{fmt.Sprintf("signature not trusted by repo %s: %s", repoName, err)}
+		}
+	}
+
+	// hold the repo lock so the metadata isn't built while the package is half added
+	rc.locks.lock(repoName)
+	if opts.Overwrite {
+		err = os.Rename(tmp.Name(), dest)
+	} else if err = os.Link(tmp.Name(), dest); os.IsExist(err) {
+		err = ErrPackageExists
+	}
+	if err != nil {
+		rc.locks.unlock(repoName)
+		if err == ErrPackageExists {
+			return nil, err
+		}
+		return nil, fmt.Errorf("unable to move upload into place at %s: %s", relPath, err)
+	}
+	pkg := rc.readPackage(builder, repo, relPath)
+	pkg.SignedBy = signedBy
+	err = rc.persistPackage(pkg)
+	rc.locks.unlock(repoName)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"repo":  repoName,
+		"path":  relPath,
+		"nevra": pkg.NEVRA(),
+	}).Info("Uploaded package")
+	if err = rc.buildMetadata(repoName); err != nil {
+		return pkg, fmt.Errorf("unable to rebuild metadata for repo %s: %s", repoName, err)
+	}
+	return pkg, nil
+}
+
+// writeUpload copies an upload to a file and syncs it, failing if it is over maxSize bytes
+func writeUpload(f *os.File, r io.Reader, maxSize int64) error {
+	defer f.Close()
+	if maxSize > 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return fmt.Errorf("unable to write upload: %s", err)
+	}
+	if maxSize > 0 && n > maxSize {
+		return ErrPackageTooLarge
+	}
+	if err = f.Sync(); err != nil {
+		return fmt.Errorf("unable to write upload: %s", err)
+	}
+	return f.Close()
+}
+
+// cleanUploadPath checks that an upload path stays inside its repo and away from roper's own files,
+// and returns it cleaned
+func cleanUploadPath(relPath string) (string, error) {
+	cleaned := path.Clean("/" + strings.Replace(relPath, "\\", "/", -1))[1:]
+	if cleaned == "" || cleaned != strings.TrimPrefix(relPath, "/") {
+		return "", fmt.Errorf("bad package path %s", relPath)
+	}
+	for _, part := range strings.Split(cleaned, "/") {
+		if strings.HasPrefix(part, ".") || part == "repodata" {
+			return "", fmt.Errorf("package path %s can't be in a hidden dir or repodata", relPath)
+		}
+	}
+	return cleaned, nil
+}
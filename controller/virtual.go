@@ -0,0 +1,311 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/gpg"
+	"github.com/alapidas/roper/model"
+	"github.com/alapidas/roper/repodata"
+	"github.com/alapidas/roper/rpm"
+	"github.com/boltdb/bolt"
+)
+
+// virtualBuilder builds the metadata of virtual repos from the packages of their members.  A virtual
+// repo has no packages of its own, and the locations in its metadata are the paths of the packages in
+// their member repos, under the name of the member.
+type virtualBuilder struct {
+	yumBuilder
+}
+
+func (b *virtualBuilder) IsPackage(relPath string) bool {
+	return false
+}
+
+func (b *virtualBuilder) Validate(repo *model.Repo) error {
+	opts := repo.Virtual
+	switch opts.Resolve {
+	case "", model.ResolvePriority, model.ResolveNewest:
+	default:
+		return fmt.Errorf("unknown conflict resolution %q", opts.Resolve)
+	}
+	if len(opts.Members) == 0 {
+		return fmt.Errorf("virtual repos need at least one member")
+	}
+	seen := make(map[string]bool)
+	for _, name := range opts.Members {
+		if seen[name] {
+			return fmt.Errorf("repo %s is listed as a member more than once", name)
+		}
+		seen[name] = true
+		// members are served under their name, next to the metadata
+		if name == repo.Name || name == repodata.Dir {
+			return fmt.Errorf("repo %s can't be a member", name)
+		}
+		member, err := b.rc.repoSettings(name)
+		if err != nil {
+			return err
+		}
+		if !member.IsYum() {
+			return fmt.Errorf("member %s is not a yum repo", name)
+		}
+		// the merged metadata lists the member's packages to everyone who can read the virtual repo
+		if member.Access.Private && !repo.Access.Private {
+			return fmt.Errorf("private repo %s can only be a member of private virtual repos", name)
+		}
+	}
+	if repo.Backend == model.BackendCreaterepo {
+		return fmt.Errorf("virtual repos can't use the createrepo backend")
+	}
+	if repo.Verify.Keyring != "" {
+		return fmt.Errorf("package verification is not supported for virtual repos")
+	}
+	return nil
+}
+
+func (b *virtualBuilder) Build(repo *model.Repo, signer *gpg.Signer) error {
+	log.WithField("repo", repo.Name).Info("Merging member metadata")
+	var members []*model.Repo
+	for _, name := range repo.Virtual.Members {
+		member, err := b.rc.GetRepo(name)
+		if err != nil {
+			// a removed member just drops out until the virtual repo is changed
+			log.WithFields(log.Fields{
+				"repo":   repo.Name,
+				"member": name,
+				"error":  err,
+			}).Warn("skipping missing member")
+			continue
+		}
+		members = append(members, member)
+	}
+	var pkgs []*repodata.Package
+	for _, mp := range mergePackages(members, repo.Virtual.Resolve) {
+		rpmPkg, err := readRPM(mp.repo, mp.pkg)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"repo":   repo.Name,
+				"member": mp.repo.Name,
+				"path":   mp.pkg.RelPath,
+				"error":  err,
+			}).Warn("skipping unreadable package")
+			continue
+		}
+		pkgs = append(pkgs, &repodata.Package{Package: rpmPkg, Location: virtualLocation(mp.repo.Name, mp.pkg.RelPath)})
+	}
+	sort.Sort(byLocation(pkgs))
+	opts := repo.Createrepo
+	genOpts := &repodata.Options{
+		Distro:      opts.Distro,
+		Content:     opts.Content,
+		RetainOldMD: opts.RetainOldMD,
+	}
+	if signer != nil {
+		genOpts.Signer = signer
+	}
+	if err := repodata.Generate(repo.AbsPath, pkgs, genOpts); err != nil {
+		return fmt.Errorf("unable to generate metadata for repo %s: %s", repo.Name, err)
+	}
+	return nil
+}
+
+// memberPackage is a package of a member of a virtual repo
+type memberPackage struct {
+	repo *model.Repo
+	pkg  *model.Package
+}
+
+// mergePackages picks the packages of the members of a virtual repo to publish.  Packages without
+// header data are left out, since they can't be resolved.  With priority resolution, each name.arch
+// comes from the first member that has it, with all of its versions there.  With newest resolution,
+// only the highest version of each name.arch across all members is published, from the first member
+// that has it.
+func mergePackages(members []*model.Repo, resolve string) []*memberPackage {
+	var merged []*memberPackage
+	owner := make(map[string]string)          // name.arch -> member providing it
+	newest := make(map[string]*memberPackage) // name.arch -> highest version seen
+	var keys []string                         // name.archs in the order they were first seen
+	for _, member := range members {
+		relPaths := make([]string, 0, len(member.Packages))
+		for relPath := range member.Packages {
+			relPaths = append(relPaths, relPath)
+		}
+		sort.Strings(relPaths)
+		for _, relPath := range relPaths {
+			pkg := member.Packages[relPath]
+			if pkg.Name == "" {
+				continue
+			}
+			key := pkg.Name + "." + pkg.Arch
+			if resolve == model.ResolveNewest {
+				best, ok := newest[key]
+				if !ok {
+					keys = append(keys, key)
+				}
+				if !ok || rpm.CompareEVR(pkg.Epoch, pkg.Version, pkg.Release, best.pkg.Epoch, best.pkg.Version, best.pkg.Release) > 0 {
+					newest[key] = &memberPackage{repo: member, pkg: pkg}
+				}
+				continue
+			}
+			if name, ok := owner[key]; ok && name != member.Name {
+				continue
+			}
+			owner[key] = member.Name
+			merged = append(merged, &memberPackage{repo: member, pkg: pkg})
+		}
+	}
+	for _, key := range keys {
+		merged = append(merged, newest[key])
+	}
+	return merged
+}
+
+// virtualLocation is the location of a member's package in the metadata of a virtual repo
+func virtualLocation(member, relPath string) string {
+	return path.Join(member, filepath.ToSlash(relPath))
+}
+
+// VirtualHandler returns the handler for requests to a virtual repo that miss the files on disk,
+// which serves the packages of its members
+func (rc *RoperController) VirtualHandler(repo *model.Repo) http.Handler {
+	name := repo.Name
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(path.Clean("/" + r.URL.Path)[1:], "/", 2)
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		virtual, err := rc.repoSettings(name)
+		if err != nil || !isMember(virtual, parts[0]) {
+			http.NotFound(w, r)
+			return
+		}
+		member, err := rc.repoSettings(parts[0])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		// reading the virtual repo doesn't give access to the files of its members
+		switch _, err = rc.Authorize(r, member.Name, model.RoleRead); err {
+		case nil:
+		case ErrUnauthenticated:
+			w.Header().Set("WWW-Authenticate", `Basic realm="roper"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case ErrForbidden:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		default:
+			log.WithFields(log.Fields{
+				"repo":   name,
+				"member": member.Name,
+				"error":  err,
+			}).Error("Error authorizing request")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(member.AbsPath, filepath.FromSlash(parts[1])))
+	})
+}
+
+// FallbackHandler returns the handler for requests to a repo that miss the files on disk, or nil if
+// the repo's files are all on disk
+func (rc *RoperController) FallbackHandler(repo *model.Repo) http.Handler {
+	switch repo.Type {
+	case model.TypeProxy:
+		return rc.ProxyHandler(repo)
+	case model.TypeVirtual:
+		return rc.VirtualHandler(repo)
+	}
+	return nil
+}
+
+// isMember returns whether a repo is a member of a virtual repo
+func isMember(virtual *model.Repo, name string) bool {
+	for _, member := range virtual.Virtual.Members {
+		if member == name {
+			return true
+		}
+	}
+	return false
+}
+
+// buildVirtualRepos rebuilds the metadata of the virtual repos that a repo is a member of.  Failures
+// are logged, so a broken virtual repo doesn't affect its members.
+func (rc *RoperController) buildVirtualRepos(member string) {
+	virtuals, err := rc.virtualRepos(member)
+	if err != nil {
+		log.WithField("error", err).Error("unable to find virtual repos")
+		return
+	}
+	for _, virtual := range virtuals {
+		if err = rc.buildMetadata(virtual.Name); err != nil {
+			log.WithFields(log.Fields{
+				"repo":   virtual.Name,
+				"member": member,
+				"error":  err,
+			}).Error("unable to rebuild virtual repo")
+		}
+	}
+}
+
+// virtualRepos gets the settings of the virtual repos that a repo is a member of
+func (rc *RoperController) virtualRepos(member string) ([]*model.Repo, error) {
+	var virtuals []*model.Repo
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(repo_bucket)).ForEach(func(k, v []byte) error {
+			repo := &model.Repo{}
+			if err := json.Unmarshal(v, repo); err != nil {
+				return fmt.Errorf("error unmarshaling repo %s: %s", k, err)
+			}
+			if repo.Type == model.TypeVirtual && isMember(repo, member) {
+				virtuals = append(virtuals, repo)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get virtual repos of %s: %s", member, err)
+	}
+	return virtuals, nil
+}
+
+// validateMembership checks that a private repo isn't a member of public virtual repos, which would
+// list its packages to everyone
+func (rc *RoperController) validateMembership(repo *model.Repo) error {
+	if !repo.Access.Private {
+		return nil
+	}
+	virtuals, err := rc.virtualRepos(repo.Name)
+	if err != nil {
+		return err
+	}
+	for _, virtual := range virtuals {
+		if !virtual.Access.Private {
+			return fmt.Errorf("repo %s is a member of public virtual repo %s, which would list its packages", repo.Name, virtual.Name)
+		}
+	}
+	return nil
+}
+
+// repoSettings gets the settings of a repo, without reading its packages
+func (rc *RoperController) repoSettings(repoName string) (*model.Repo, error) {
+	repo := &model.Repo{}
+	err := rc.db.View(func(tx *bolt.Tx) error {
+		repoBytes := tx.Bucket([]byte(repo_bucket)).Get([]byte(repoName))
+		if repoBytes == nil {
+			return fmt.Errorf("repo with name %s not found in database", repoName)
+		}
+		return json.Unmarshal(repoBytes, repo)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get repo %s from database: %s", repoName, err)
+	}
+	return repo, nil
+}
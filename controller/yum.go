@@ -0,0 +1,298 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/gpg"
+	"github.com/alapidas/roper/model"
+	"github.com/alapidas/roper/repodata"
+	"github.com/alapidas/roper/rpm"
+)
+
+// yumBuilder builds yum metadata for repos of RPMs, with either the native generator or createrepo
+type yumBuilder struct {
+	rc *RoperController
+}
+
+func (b *yumBuilder) IsPackage(relPath string) bool {
+	return filepath.Ext(relPath) == ".rpm"
+}
+
+func (b *yumBuilder) ReadPackage(pkg *model.Package, absPath string) error {
+	rpmPkg, err := rpm.ReadFile(absPath)
+	if err != nil {
+		return err
+	}
+	pkg.Name = rpmPkg.Name
+	pkg.Epoch = rpmPkg.Epoch
+	pkg.Version = rpmPkg.Version
+	pkg.Release = rpmPkg.Release
+	pkg.Arch = rpmPkg.Arch
+	pkg.SourceRPM = rpmPkg.SourceRPM
+	pkg.Checksum = rpmPkg.Checksum
+	pkg.BuildTime = rpmPkg.BuildTime
+	pkg.Provides = modelDeps(rpmPkg.Provides)
+	pkg.Requires = modelDeps(rpmPkg.Requires)
+	pkg.Conflicts = modelDeps(rpmPkg.Conflicts)
+	pkg.Obsoletes = modelDeps(rpmPkg.Obsoletes)
+	for _, f := range rpmPkg.Files {
+		pkg.Files = append(pkg.Files, f.Path)
+	}
+	return nil
+}
+
+func (b *yumBuilder) Compare(p1, p2 *model.Package) int {
+	return rpm.CompareEVR(p1.Epoch, p1.Version, p1.Release, p2.Epoch, p2.Version, p2.Release)
+}
+
+func (b *yumBuilder) Validate(repo *model.Repo) error {
+	opts := repo.Createrepo
+	if opts.Workers < 0 {
+		return fmt.Errorf("workers must not be negative")
+	}
+	if opts.RetainOldMD < 0 {
+		return fmt.Errorf("retain-old-md must not be negative")
+	}
+	switch repo.Backend {
+	case model.BackendCreaterepo:
+	case "", model.BackendNative:
+		if opts.Checksum != "" && opts.Checksum != "sha256" {
+			return fmt.Errorf("the native backend only supports sha256 checksums, not %s", opts.Checksum)
+		}
+		if opts.Deltas {
+			return fmt.Errorf("the native backend does not support deltas")
+		}
+	default:
+		return fmt.Errorf("unknown metadata backend %q", repo.Backend)
+	}
+	if opts.GroupFile != "" {
+		managed, err := b.rc.hasGroups(repo.Name)
+		if err != nil {
+			return err
+		}
+		if managed {
+			return fmt.Errorf("repo %s has groups managed by roper, which can't be combined with a group file", repo.Name)
+		}
+	}
+	return nil
+}
+
+// Build builds the repo metadata with the backend configured for the repo, along with the errata and
+// groups managed by roper
+func (b *yumBuilder) Build(repo *model.Repo, signer *gpg.Signer) error {
+	updates, err := b.rc.updates(repo)
+	if err != nil {
+		return err
+	}
+	// groups managed by roper are written out as a group file for the backend to pick up
+	compsPath, err := b.rc.writeComps(repo)
+	if err != nil {
+		return err
+	}
+	if compsPath != "" {
+		defer os.Remove(compsPath)
+		repo.Createrepo.GroupFile = compsPath
+	}
+	switch repo.Backend {
+	case model.BackendCreaterepo:
+		err = b.runCreaterepo(repo)
+		if err == nil && len(updates) > 0 {
+			err = repodata.AddUpdateinfo(repo.AbsPath, updates)
+		}
+		if err == nil && signer != nil {
+			err = signRepomd(repo, signer)
+		}
+		return err
+	case "", model.BackendNative:
+		return b.runNative(repo, signer, updates)
+	}
+	return fmt.Errorf("unknown metadata backend %q for repo %s", repo.Backend, repo.Name)
+}
+
+// runNative builds the repo metadata with the built in generator.  The native generator is always
+// incremental, since the checksums of unchanged packages are never recomputed.
+func (b *yumBuilder) runNative(repo *model.Repo, signer *gpg.Signer, updates []*repodata.Update) error {
+	log.WithField("repo", repo.Name).Info("Generating repo metadata")
+	opts := repo.Createrepo
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// read the packages with a pool of workers
+	relPaths := make(chan string)
+	results := make(chan *repodata.Package)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for relPath := range relPaths {
+				rpmPkg, err := readRPM(repo, repo.Packages[relPath])
+				if err != nil {
+					// don't let one bad file keep the rest of the repo from being published
+					log.WithFields(log.Fields{
+						"repo":  repo.Name,
+						"path":  relPath,
+						"error": err,
+					}).Warn("skipping unreadable package")
+					continue
+				}
+				results <- &repodata.Package{Package: rpmPkg, Location: relPath}
+			}
+		}()
+	}
+	go func() {
+		for relPath, _ := range repo.Packages {
+			relPaths <- relPath
+		}
+		close(relPaths)
+		wg.Wait()
+		close(results)
+	}()
+	pkgs := make([]*repodata.Package, 0, len(repo.Packages))
+	for pkg := range results {
+		pkgs = append(pkgs, pkg)
+	}
+
+	// keep the metadata order stable between runs
+	sort.Sort(byLocation(pkgs))
+	genOpts := &repodata.Options{
+		GroupFile:   repoFilePath(repo, opts.GroupFile),
+		Distro:      opts.Distro,
+		Content:     opts.Content,
+		Updates:     updates,
+		RetainOldMD: opts.RetainOldMD,
+	}
+	if signer != nil {
+		genOpts.Signer = signer
+	}
+	if err := repodata.Generate(repo.AbsPath, pkgs, genOpts); err != nil {
+		return fmt.Errorf("unable to generate metadata for repo %s: %s", repo.Name, err)
+	}
+	return nil
+}
+
+// repoFilePath resolves a path that may be relative to the root of a repo
+func repoFilePath(repo *model.Repo, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(repo.AbsPath, path)
+}
+
+// readRPM reads the RPM for a package in a repo.  The checksum stored in the db is used if the file
+// doesn't look like it has changed since it was last read, so unchanged files are never re-hashed.
+func readRPM(repo *model.Repo, pkg *model.Package) (*rpm.Package, error) {
+	absPath := filepath.Join(repo.AbsPath, pkg.RelPath)
+	fi, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if pkg.Checksum == "" || !pkg.SameFile(fi, fileInode(fi)) {
+		return rpm.ReadFile(absPath)
+	}
+	rpmPkg, err := rpm.ReadHeaders(absPath)
+	if err != nil {
+		return nil, err
+	}
+	rpmPkg.Checksum = pkg.Checksum
+	return rpmPkg, nil
+}
+
+type byLocation []*repodata.Package
+
+func (p byLocation) Len() int           { return len(p) }
+func (p byLocation) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byLocation) Less(i, j int) bool { return p[i].Location < p[j].Location }
+
+// runCreaterepo builds the repo metadata by running the external createrepo executable
+func (b *yumBuilder) runCreaterepo(repo *model.Repo) error {
+	if b.rc.crPath == "" {
+		return fmt.Errorf("repo %s uses the createrepo backend, but no createrepo executable is configured", repo.Name)
+	}
+	cmd := strings.Fields(b.rc.crPath)
+	argz := []string{}
+	if len(cmd) > 1 {
+		argz = append(argz, cmd[1:]...)
+	}
+	argz = append(argz, createrepoArgs(repo)...)
+	argz = append(argz, repo.AbsPath)
+	cmdp := exec.Command(cmd[0], argz...)
+	log.WithField("repo", repo.Name).Info("Running createrepo")
+	cout, err := cmdp.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Error running createrepo: %s: %s", err, string(cout))
+	}
+	return nil
+}
+
+// createrepoArgs builds the createrepo command line flags for a repo's options
+func createrepoArgs(repo *model.Repo) []string {
+	opts := repo.Createrepo
+	argz := []string{}
+	if opts.Update {
+		argz = append(argz, "--update")
+	}
+	if opts.CacheDir != "" {
+		argz = append(argz, "--cachedir", opts.CacheDir)
+	}
+	if opts.Checksum != "" {
+		argz = append(argz, "--checksum", opts.Checksum)
+	}
+	if opts.Workers > 0 {
+		argz = append(argz, "--workers", strconv.Itoa(opts.Workers))
+	}
+	if opts.Deltas {
+		argz = append(argz, "--deltas")
+	}
+	if opts.GroupFile != "" {
+		argz = append(argz, "--groupfile", repoFilePath(repo, opts.GroupFile))
+	}
+	for _, distro := range opts.Distro {
+		argz = append(argz, "--distro", distro)
+	}
+	for _, content := range opts.Content {
+		argz = append(argz, "--content", content)
+	}
+	if opts.RetainOldMD > 0 {
+		argz = append(argz, "--retain-old-md", strconv.Itoa(opts.RetainOldMD))
+	}
+	return argz
+}
+
+// signRepomd signs the repomd.xml of a repo in place.  This is only needed for metadata that wasn't
+// built by the native generator.
+func signRepomd(repo *model.Repo, signer *gpg.Signer) error {
+	repomdPath := filepath.Join(repo.AbsPath, repodata.Dir, "repomd.xml")
+	data, err := ioutil.ReadFile(repomdPath)
+	if err != nil {
+		return fmt.Errorf("unable to read repomd.xml for repo %s: %s", repo.Name, err)
+	}
+	sig, err := signer.DetachSign(data)
+	if err != nil {
+		return fmt.Errorf("unable to sign repomd.xml for repo %s: %s", repo.Name, err)
+	}
+	return writeFileAtomic(filepath.Join(repo.AbsPath, repodata.Dir, repodata.SignatureFile), sig)
+}
+
+func modelDeps(deps []rpm.Dependency) []model.Dependency {
+	var out []model.Dependency
+	for _, dep := range deps {
+		md := model.Dependency{Name: dep.Name, Flags: dep.Sense()}
+		if md.Flags != "" {
+			md.Epoch, md.Version, md.Release = rpm.EVR(dep.Version)
+		}
+		out = append(out, md)
+	}
+	return out
+}
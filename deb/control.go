@@ -0,0 +1,99 @@
+package deb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Field is a single field of a control paragraph.  Multi-line values keep their continuation lines,
+// without the leading space.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Paragraph is a set of control fields, in the order they appeared.  The same format is used by
+// control files, Packages indexes and Release files.
+type Paragraph struct {
+	Fields []Field
+}
+
+// ParseParagraph parses the first paragraph of control data
+func ParseParagraph(data []byte) (*Paragraph, error) {
+	p := &Paragraph{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(p.Fields) > 0 {
+				break
+			}
+			continue
+		}
+		if line[0] == '#' {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(p.Fields) == 0 {
+				return nil, fmt.Errorf("continuation line before first field: %q", line)
+			}
+			last := &p.Fields[len(p.Fields)-1]
+			last.Value += "\n" + line[1:]
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("malformed field: %q", line)
+		}
+		p.Fields = append(p.Fields, Field{Name: line[:i], Value: strings.TrimSpace(line[i+1:])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.Fields) == 0 {
+		return nil, fmt.Errorf("empty control paragraph")
+	}
+	return p, nil
+}
+
+// Get returns the value of a field, or an empty string if it isn't set.  Field names are case
+// insensitive.
+func (p *Paragraph) Get(name string) string {
+	for _, f := range p.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Set sets the value of a field, replacing an existing value or adding it to the end
+func (p *Paragraph) Set(name, value string) {
+	for i, f := range p.Fields {
+		if strings.EqualFold(f.Name, name) {
+			p.Fields[i].Value = value
+			return
+		}
+	}
+	p.Fields = append(p.Fields, Field{Name: name, Value: value})
+}
+
+// WriteTo writes the paragraph in control file format, without a trailing blank line
+func (p *Paragraph) WriteTo(w io.Writer) (int64, error) {
+	buf := &bytes.Buffer{}
+	for _, f := range p.Fields {
+		buf.WriteString(f.Name)
+		buf.WriteString(":")
+		// multi-line values like checksum lists start on the next line
+		if f.Value != "" && !strings.HasPrefix(f.Value, "\n") {
+			buf.WriteString(" ")
+		}
+		buf.WriteString(strings.Replace(f.Value, "\n", "\n ", -1))
+		buf.WriteString("\n")
+	}
+	return buf.WriteTo(w)
+}
@@ -0,0 +1,182 @@
+// Package deb reads Debian binary packages, and generates the indexes of an APT repository for them.
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	arMagic      = "!<arch>\n"
+	arHeaderSize = 60
+)
+
+// Package holds the information from a .deb file that roper cares about
+type Package struct {
+	Name    string
+	Version string // [epoch:]upstream[-revision]
+	Arch    string // "all" for architecture independent packages
+
+	// Control is the whole control file of the package
+	Control *Paragraph
+
+	// These are only set when reading from a file
+	Size     int64
+	ModTime  int64
+	Checksum string // hex encoded SHA-256 of the whole file
+}
+
+// ReadFile reads the control file of a .deb, and computes its checksum
+func ReadFile(path string) (*Package, error) {
+	return readFile(path, true)
+}
+
+// ReadControl reads the control file of a .deb without checksumming it.  The Checksum of the returned
+// package will be empty.
+func ReadControl(path string) (*Package, error) {
+	return readFile(path, false)
+}
+
+func readFile(path string, checksum bool) (*Package, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open deb %s: %s", path, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat deb %s: %s", path, err)
+	}
+	var r io.Reader = f
+	hash := sha256.New()
+	if checksum {
+		r = io.TeeReader(f, hash)
+	}
+	pkg, err := Read(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read deb %s: %s", path, err)
+	}
+	pkg.Size = fi.Size()
+	pkg.ModTime = fi.ModTime().Unix()
+	if checksum {
+		if _, err = io.Copy(hash, f); err != nil {
+			return nil, fmt.Errorf("unable to checksum deb %s: %s", path, err)
+		}
+		pkg.Checksum = hex.EncodeToString(hash.Sum(nil))
+	}
+	return pkg, nil
+}
+
+// Read reads the control file of a .deb from r.  The reader is left positioned after the control
+// archive.
+func Read(r io.Reader) (*Package, error) {
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("unable to read ar magic: %s", err)
+	}
+	if string(magic) != arMagic {
+		return nil, fmt.Errorf("not a deb file (bad ar magic %q)", magic)
+	}
+	for {
+		name, size, err := readArHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		data := io.LimitReader(r, size)
+		if !strings.HasPrefix(name, "control.tar") {
+			if _, err = io.Copy(ioutil.Discard, data); err != nil {
+				return nil, fmt.Errorf("unable to read ar member %s: %s", name, err)
+			}
+			if size%2 == 1 {
+				io.ReadFull(r, make([]byte, 1))
+			}
+			continue
+		}
+		control, err := readControlTar(name, data)
+		if err != nil {
+			return nil, err
+		}
+		// drain the rest of the member, so r is left at a known spot
+		io.Copy(ioutil.Discard, data)
+		p, err := ParseParagraph(control)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse control file: %s", err)
+		}
+		pkg := &Package{
+			Name:    p.Get("Package"),
+			Version: p.Get("Version"),
+			Arch:    p.Get("Architecture"),
+			Control: p,
+		}
+		if pkg.Name == "" || pkg.Version == "" || pkg.Arch == "" {
+			return nil, fmt.Errorf("control file is missing Package, Version or Architecture")
+		}
+		return pkg, nil
+	}
+}
+
+// readArHeader reads the header of the next ar member, returning its name and size
+func readArHeader(r io.Reader) (string, int64, error) {
+	hdr := make([]byte, arHeaderSize)
+	if _, err := io.ReadFull(r, hdr); err == io.EOF {
+		return "", 0, fmt.Errorf("no control archive found")
+	} else if err != nil {
+		return "", 0, fmt.Errorf("unable to read ar header: %s", err)
+	}
+	if string(hdr[58:60]) != "`\n" {
+		return "", 0, fmt.Errorf("bad ar header magic")
+	}
+	name := strings.TrimRight(strings.TrimSpace(string(hdr[0:16])), "/")
+	size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, fmt.Errorf("bad size in ar header for %s", name)
+	}
+	return name, size, nil
+}
+
+// readControlTar returns the contents of the control file in a control archive
+func readControlTar(name string, r io.Reader) ([]byte, error) {
+	var err error
+	switch path.Ext(name) {
+	case ".tar":
+	case ".gz":
+		if r, err = gzip.NewReader(r); err != nil {
+			return nil, fmt.Errorf("unable to decompress %s: %s", name, err)
+		}
+	case ".xz":
+		if r, err = xz.NewReader(r); err != nil {
+			return nil, fmt.Errorf("unable to decompress %s: %s", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported control archive compression %s", name)
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no control file in %s", name)
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %s", name, err)
+		}
+		if path.Clean(hdr.Name) != "control" {
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if _, err = io.Copy(buf, io.LimitReader(tr, 1024*1024)); err != nil {
+			return nil, fmt.Errorf("unable to read control file: %s", err)
+		}
+		return buf.Bytes(), nil
+	}
+}
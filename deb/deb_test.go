@@ -0,0 +1,166 @@
+package deb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TheSuite struct{}
+
+var _ = Suite(&TheSuite{})
+
+var poolDir = filepath.Join("..", "hack", "test_repos", "apt", "pool", "main")
+
+func (suite *TheSuite) TestReadFile(c *C) {
+	// control.tar.gz
+	pkg, err := ReadFile(filepath.Join(poolDir, "roper-doc_1.0-1_all.deb"))
+	c.Assert(err, IsNil)
+	c.Assert(pkg.Name, Equals, "roper-doc")
+	c.Assert(pkg.Version, Equals, "1.0-1")
+	c.Assert(pkg.Arch, Equals, "all")
+	c.Assert(pkg.Control.Get("depends"), Equals, "libc6 (>= 2.17)")
+	c.Assert(strings.Contains(pkg.Control.Get("Description"), "\n"), Equals, true)
+
+	data, err := ioutil.ReadFile(filepath.Join(poolDir, "roper-doc_1.0-1_all.deb"))
+	c.Assert(err, IsNil)
+	sum := sha256.Sum256(data)
+	c.Assert(pkg.Checksum, Equals, hex.EncodeToString(sum[:]))
+	c.Assert(pkg.Size, Equals, int64(len(data)))
+
+	// control.tar.xz
+	pkg, err = ReadControl(filepath.Join(poolDir, "roper-hello_1.1-1_amd64.deb"))
+	c.Assert(err, IsNil)
+	c.Assert(pkg.Version, Equals, "1.1-1")
+	c.Assert(pkg.Arch, Equals, "amd64")
+	c.Assert(pkg.Checksum, Equals, "")
+
+	_, err = ReadFile(filepath.Join("..", "hack", "test_repos", "docker", "7", "Packages", "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm"))
+	c.Assert(err, NotNil)
+}
+
+func (suite *TheSuite) TestCompareVersions(c *C) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0-1", "1.0-2", -1},
+		{"1.10", "1.9", 1},
+		{"1:0.9-1", "1.1-1", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0a", "1.0", 1},
+		{"1.0+b1", "1.0", 1},
+		{"1.0.0", "1.0", 1},
+		{"2.0-1ubuntu1", "2.0-1", 1},
+		{"001", "1", 0},
+	} {
+		c.Check(CompareVersions(tc.a, tc.b), Equals, tc.want, Commentf("%s vs %s", tc.a, tc.b))
+		c.Check(CompareVersions(tc.b, tc.a), Equals, -tc.want, Commentf("%s vs %s", tc.b, tc.a))
+	}
+}
+
+func (suite *TheSuite) TestParagraph(c *C) {
+	p, err := ParseParagraph([]byte("Package: foo\nDescription: short\n long line\n .\n more\n\nPackage: bar\n"))
+	c.Assert(err, IsNil)
+	c.Assert(p.Get("package"), Equals, "foo")
+	c.Assert(p.Get("Description"), Equals, "short\nlong line\n.\nmore")
+	buf := &bytes.Buffer{}
+	p.WriteTo(buf)
+	c.Assert(buf.String(), Equals, "Package: foo\nDescription: short\n long line\n .\n more\n")
+
+	_, err = ParseParagraph([]byte(" leading continuation\n"))
+	c.Assert(err, NotNil)
+	_, err = ParseParagraph([]byte("\n\n"))
+	c.Assert(err, NotNil)
+}
+
+type fakeSigner struct{}
+
+func (fakeSigner) DetachSign(data []byte) ([]byte, error) {
+	return []byte("detached"), nil
+}
+
+func (fakeSigner) ClearSign(data []byte) ([]byte, error) {
+	return append([]byte("clear\n"), data...), nil
+}
+
+func (suite *TheSuite) TestGenerate(c *C) {
+	repoPath := c.MkDir()
+	var pkgs []*IndexedPackage
+	for _, name := range []string{"roper-doc_1.0-1_all.deb", "roper-hello_1.1-1_amd64.deb"} {
+		pkg, err := ReadFile(filepath.Join(poolDir, name))
+		c.Assert(err, IsNil)
+		pkgs = append(pkgs, &IndexedPackage{Package: pkg, Location: "pool/main/" + name})
+	}
+	date := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	opts := &Options{Origin: "roper", Label: "Roper", Date: date}
+	c.Assert(Generate(repoPath, pkgs, opts), IsNil)
+
+	suiteDir := filepath.Join(repoPath, "dists", "stable")
+	index, err := ioutil.ReadFile(filepath.Join(suiteDir, "main", "binary-amd64", "Packages"))
+	c.Assert(err, IsNil)
+	stanzas := strings.Split(string(index), "\n\n")
+	c.Assert(stanzas, HasLen, 2)
+	first, err := ParseParagraph([]byte(stanzas[0]))
+	c.Assert(err, IsNil)
+	c.Assert(first.Get("Package"), Equals, "roper-doc")
+	c.Assert(first.Get("Filename"), Equals, "pool/main/roper-doc_1.0-1_all.deb")
+	c.Assert(first.Get("SHA256"), Equals, pkgs[0].Checksum)
+	// the description stays last, the way apt expects
+	c.Assert(first.Fields[len(first.Fields)-1].Name, Equals, "Description")
+
+	f, err := os.Open(filepath.Join(suiteDir, "main", "binary-amd64", "Packages.gz"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	c.Assert(err, IsNil)
+	gzIndex, err := ioutil.ReadAll(gzr)
+	c.Assert(err, IsNil)
+	c.Assert(string(gzIndex), Equals, string(index))
+
+	data, err := ioutil.ReadFile(filepath.Join(suiteDir, ReleaseFile))
+	c.Assert(err, IsNil)
+	release, err := ParseParagraph(data)
+	c.Assert(err, IsNil)
+	c.Assert(release.Get("Origin"), Equals, "roper")
+	c.Assert(release.Get("Suite"), Equals, "stable")
+	c.Assert(release.Get("Codename"), Equals, "stable")
+	c.Assert(release.Get("Components"), Equals, "main")
+	c.Assert(release.Get("Architectures"), Equals, "amd64")
+	c.Assert(release.Get("Date"), Equals, "Tue, 01 Mar 2016 12:00:00 UTC")
+	sum := sha256.Sum256(index)
+	c.Assert(strings.Contains(release.Get("SHA256"), hex.EncodeToString(sum[:])), Equals, true)
+	c.Assert(strings.Contains(release.Get("SHA256"), "main/binary-amd64/Packages.gz"), Equals, true)
+
+	_, err = os.Stat(filepath.Join(suiteDir, InReleaseFile))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// regenerating with a signer swaps in the new suite
+	opts.Signer = fakeSigner{}
+	c.Assert(Generate(repoPath, pkgs[:1], opts), IsNil)
+	inRelease, err := ioutil.ReadFile(filepath.Join(suiteDir, InReleaseFile))
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(string(inRelease), "clear\n"), Equals, true)
+	_, err = os.Stat(filepath.Join(suiteDir, SignatureFile))
+	c.Assert(err, IsNil)
+	// only architecture independent packages are left
+	_, err = os.Stat(filepath.Join(suiteDir, "main", "binary-all", "Packages"))
+	c.Assert(err, IsNil)
+	_, err = os.Stat(filepath.Join(suiteDir, "main", "binary-amd64"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+	entries, err := ioutil.ReadDir(filepath.Join(repoPath, "dists"))
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+}
@@ -0,0 +1,286 @@
+package deb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DistsDir is the name of the directory holding the indexes of an APT repository
+const DistsDir = "dists"
+
+// Files written to the suite directory
+const (
+	ReleaseFile   = "Release"
+	SignatureFile = "Release.gpg"
+	InReleaseFile = "InRelease"
+)
+
+// IndexedPackage is a deb along with its location in the repo
+type IndexedPackage struct {
+	*Package
+	Location string // path relative to the root of the repo
+}
+
+// Options control index generation
+type Options struct {
+	Suite     string // defaults to "stable"
+	Codename  string // defaults to the suite
+	Component string // defaults to "main"
+	Origin    string
+	Label     string
+	// Architectures to write indexes for.  Defaults to the architectures of the packages, or "all" if
+	// every package is architecture independent.
+	Architectures []string
+	// Signer, if set, is used to write Release.gpg and InRelease
+	Signer Signer
+	// Date is written to the Release file.  Defaults to the current time.
+	Date time.Time
+}
+
+// Signer creates detached and inline signatures
+type Signer interface {
+	DetachSign(data []byte) ([]byte, error)
+	ClearSign(data []byte) ([]byte, error)
+}
+
+func (opts *Options) suite() string {
+	if opts.Suite == "" {
+		return "stable"
+	}
+	return opts.Suite
+}
+
+func (opts *Options) component() string {
+	if opts.Component == "" {
+		return "main"
+	}
+	return opts.Component
+}
+
+// Generate writes the Packages indexes and Release file for the given packages into
+// dists/<suite> of repoPath.  Like yum metadata, the suite is written to a temporary directory first
+// and then swapped into place.
+func Generate(repoPath string, pkgs []*IndexedPackage, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	date := opts.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	suite, component := opts.suite(), opts.component()
+	distsDir := filepath.Join(repoPath, DistsDir)
+	if err := os.MkdirAll(distsDir, 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %s", distsDir, err)
+	}
+	tmpDir, err := ioutil.TempDir(distsDir, "."+suite+"-")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary index dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archs := opts.Architectures
+	if len(archs) == 0 {
+		archs = packageArchs(pkgs)
+	}
+	sorted := make([]*IndexedPackage, len(pkgs))
+	copy(sorted, pkgs)
+	sort.Sort(byLocation(sorted))
+
+	var indexes []*indexFile
+	for _, arch := range archs {
+		data := packagesIndex(sorted, arch)
+		dir := filepath.Join(component, "binary-"+arch)
+		if err = os.MkdirAll(filepath.Join(tmpDir, dir), 0755); err != nil {
+			return fmt.Errorf("unable to create index dir %s: %s", dir, err)
+		}
+		written, err := writeIndex(tmpDir, filepath.Join(dir, "Packages"), data)
+		if err != nil {
+			return err
+		}
+		indexes = append(indexes, written...)
+	}
+
+	release := &Paragraph{}
+	if opts.Origin != "" {
+		release.Set("Origin", opts.Origin)
+	}
+	if opts.Label != "" {
+		release.Set("Label", opts.Label)
+	}
+	codename := opts.Codename
+	if codename == "" {
+		codename = suite
+	}
+	release.Set("Suite", suite)
+	release.Set("Codename", codename)
+	release.Set("Date", date.UTC().Format(time.RFC1123))
+	release.Set("Architectures", strings.Join(archs, " "))
+	release.Set("Components", component)
+	sums := &bytes.Buffer{}
+	for _, idx := range indexes {
+		fmt.Fprintf(sums, "\n%s %16d %s", idx.sha256, idx.size, idx.path)
+	}
+	release.Set("SHA256", sums.String())
+	buf := &bytes.Buffer{}
+	if _, err = release.WriteTo(buf); err != nil {
+		return fmt.Errorf("unable to build Release: %s", err)
+	}
+	releaseData := buf.Bytes()
+	if err = ioutil.WriteFile(filepath.Join(tmpDir, ReleaseFile), releaseData, 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %s", ReleaseFile, err)
+	}
+	if opts.Signer != nil {
+		sig, err := opts.Signer.DetachSign(releaseData)
+		if err != nil {
+			return fmt.Errorf("unable to sign %s: %s", ReleaseFile, err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(tmpDir, SignatureFile), sig, 0644); err != nil {
+			return fmt.Errorf("unable to write %s: %s", SignatureFile, err)
+		}
+		inRelease, err := opts.Signer.ClearSign(releaseData)
+		if err != nil {
+			return fmt.Errorf("unable to sign %s: %s", InReleaseFile, err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(tmpDir, InReleaseFile), inRelease, 0644); err != nil {
+			return fmt.Errorf("unable to write %s: %s", InReleaseFile, err)
+		}
+	}
+	if err = os.Chmod(tmpDir, 0755); err != nil {
+		return fmt.Errorf("unable to set permissions on index dir: %s", err)
+	}
+	return publish(filepath.Join(distsDir, suite), tmpDir)
+}
+
+// packageArchs returns the sorted architectures of a set of packages, leaving out "all" unless it is
+// the only one
+func packageArchs(pkgs []*IndexedPackage) []string {
+	seen := make(map[string]struct{})
+	for _, pkg := range pkgs {
+		if pkg.Arch != "all" {
+			seen[pkg.Arch] = struct{}{}
+		}
+	}
+	if len(seen) == 0 {
+		return []string{"all"}
+	}
+	archs := make([]string, 0, len(seen))
+	for arch := range seen {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+	return archs
+}
+
+// packagesIndex builds the Packages index for an architecture.  Architecture independent packages are
+// included in every index.
+func packagesIndex(pkgs []*IndexedPackage, arch string) []byte {
+	buf := &bytes.Buffer{}
+	for _, pkg := range pkgs {
+		if pkg.Arch != arch && pkg.Arch != "all" {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		stanza(pkg).WriteTo(buf)
+	}
+	return buf.Bytes()
+}
+
+// stanza returns the Packages entry for a package: its control file, with the location, size and
+// checksum added before the description
+func stanza(pkg *IndexedPackage) *Paragraph {
+	p := &Paragraph{}
+	var desc *Field
+	for i, f := range pkg.Control.Fields {
+		switch strings.ToLower(f.Name) {
+		case "description":
+			desc = &pkg.Control.Fields[i]
+		case "filename", "size", "md5sum", "sha1", "sha256":
+			// stale values from a control file shouldn't override the real ones
+		default:
+			p.Fields = append(p.Fields, f)
+		}
+	}
+	p.Set("Filename", filepath.ToSlash(pkg.Location))
+	p.Set("Size", fmt.Sprintf("%d", pkg.Size))
+	p.Set("SHA256", pkg.Checksum)
+	if desc != nil {
+		p.Fields = append(p.Fields, *desc)
+	}
+	return p
+}
+
+// indexFile is an entry in the checksum list of a Release file
+type indexFile struct {
+	path   string // relative to the suite dir, with forward slashes
+	size   int64
+	sha256 string
+}
+
+// writeIndex writes an index and a gzipped copy of it, and returns the Release entries for both
+func writeIndex(dir, name string, data []byte) ([]*indexFile, error) {
+	gzBuf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(gzBuf)
+	if _, err := gzw.Write(data); err != nil {
+		return nil, fmt.Errorf("unable to compress %s: %s", name, err)
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, fmt.Errorf("unable to compress %s: %s", name, err)
+	}
+	var files []*indexFile
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{name, data},
+		{name + ".gz", gzBuf.Bytes()},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f.name), f.data, 0644); err != nil {
+			return nil, fmt.Errorf("unable to write %s: %s", f.name, err)
+		}
+		sum := sha256.Sum256(f.data)
+		files = append(files, &indexFile{
+			path:   filepath.ToSlash(f.name),
+			size:   int64(len(f.data)),
+			sha256: hex.EncodeToString(sum[:]),
+		})
+	}
+	return files, nil
+}
+
+// publish swaps a newly written suite directory into place
+func publish(finalDir, newDir string) error {
+	oldDir := filepath.Join(filepath.Dir(finalDir), "."+filepath.Base(finalDir)+".old")
+	if err := os.RemoveAll(oldDir); err != nil {
+		return fmt.Errorf("unable to remove stale index dir %s: %s", oldDir, err)
+	}
+	if err := os.Rename(finalDir, oldDir); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to move aside old indexes: %s", err)
+	}
+	if err := os.Rename(newDir, finalDir); err != nil {
+		// try to put the old indexes back
+		os.Rename(oldDir, finalDir)
+		return fmt.Errorf("unable to move new indexes into place: %s", err)
+	}
+	if err := os.RemoveAll(oldDir); err != nil {
+		return fmt.Errorf("unable to remove old indexes: %s", err)
+	}
+	return nil
+}
+
+type byLocation []*IndexedPackage
+
+func (p byLocation) Len() int           { return len(p) }
+func (p byLocation) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byLocation) Less(i, j int) bool { return p[i].Location < p[j].Location }
@@ -0,0 +1,149 @@
+// Package gpg wraps the openpgp library for the signing and verification roper needs.
+package gpg
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Signer signs data with a private key
+type Signer struct {
+	entity *openpgp.Entity
+}
+
+// ReadKeyring reads an armored or binary keyring file
+func ReadKeyring(path string) (openpgp.EntityList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read keyring %s: %s", path, err)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse keyring %s: %s", path, err)
+	}
+	return keyring, nil
+}
+
+// KeyID formats a key id the way gpg does in its long form
+func KeyID(id uint64) string {
+	return fmt.Sprintf("%016X", id)
+}
+
+// matchesKeyID reports whether a key id matches a user supplied id.  The user supplied id may be the
+// short or long form, or a full fingerprint, with or without a leading 0x.
+func matchesKeyID(entity *openpgp.Entity, want string) bool {
+	want = strings.ToUpper(strings.TrimPrefix(strings.ToLower(want), "0x"))
+	fpr := strings.ToUpper(fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint))
+	if strings.HasSuffix(fpr, want) {
+		return true
+	}
+	for _, sub := range entity.Subkeys {
+		if strings.HasSuffix(strings.ToUpper(fmt.Sprintf("%x", sub.PublicKey.Fingerprint)), want) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadSigner loads a private key from a keyring file.  If keyID is empty, the first private key in
+// the keyring is used.  The passphrase is only needed if the key is encrypted.
+func LoadSigner(keyringPath, keyID string, passphrase []byte) (*Signer, error) {
+	keyring, err := ReadKeyring(keyringPath)
+	if err != nil {
+		return nil, err
+	}
+	var entity *openpgp.Entity
+	for _, e := range keyring {
+		if e.PrivateKey == nil {
+			continue
+		}
+		if keyID == "" || matchesKeyID(e, keyID) {
+			entity = e
+			break
+		}
+	}
+	if entity == nil {
+		if keyID == "" {
+			return nil, fmt.Errorf("no private keys found in keyring %s", keyringPath)
+		}
+		return nil, fmt.Errorf("private key %s not found in keyring %s", keyID, keyringPath)
+	}
+	// decrypt the primary key and any subkeys
+	keys := []*packet.PrivateKey{entity.PrivateKey}
+	for _, sub := range entity.Subkeys {
+		if sub.PrivateKey != nil {
+			keys = append(keys, sub.PrivateKey)
+		}
+	}
+	for _, key := range keys {
+		if !key.Encrypted {
+			continue
+		}
+		if passphrase == nil {
+			return nil, fmt.Errorf("key %s is encrypted, but no passphrase was given", KeyID(key.KeyId))
+		}
+		if err := key.Decrypt(passphrase); err != nil {
+			return nil, fmt.Errorf("unable to decrypt key %s: %s", KeyID(key.KeyId), err)
+		}
+	}
+	return &Signer{entity: entity}, nil
+}
+
+// KeyID returns the id of the signer's primary key
+func (s *Signer) KeyID() string {
+	return KeyID(s.entity.PrimaryKey.KeyId)
+}
+
+// DetachSign returns an armored detached signature of data
+func (s *Signer) DetachSign(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := openpgp.ArmoredDetachSign(buf, s.entity, bytes.NewReader(data), nil); err != nil {
+		return nil, fmt.Errorf("unable to sign data: %s", err)
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// ClearSign returns data wrapped in an inline, cleartext signature, as used by an APT InRelease file
+func (s *Signer) ClearSign(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := clearsign.Encode(buf, s.entity.PrivateKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign data: %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, fmt.Errorf("unable to sign data: %s", err)
+	}
+	if err = w.Close(); err != nil {
+		return nil, fmt.Errorf("unable to sign data: %s", err)
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// PublicKey returns the armored public key of the signer
+func (s *Signer) PublicKey() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to armor public key: %s", err)
+	}
+	if err = s.entity.Serialize(w); err != nil {
+		return nil, fmt.Errorf("unable to serialize public key: %s", err)
+	}
+	if err = w.Close(); err != nil {
+		return nil, fmt.Errorf("unable to armor public key: %s", err)
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
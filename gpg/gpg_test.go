@@ -0,0 +1,123 @@
+package gpg
+
+import (
+	"bytes"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TheSuite struct {
+	entity      *openpgp.Entity
+	keyringPath string
+}
+
+var _ = Suite(&TheSuite{})
+
+// writeTestKeyring writes a new unencrypted private key to an armored keyring file
+func writeTestKeyring(path string) (*openpgp.Entity, error) {
+	entity, err := openpgp.NewEntity("Roper Test", "", "roper@example.com", nil)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	w, err := armor.Encode(f, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = entity.SerializePrivate(w, nil); err != nil {
+		return nil, err
+	}
+	return entity, w.Close()
+}
+
+func (suite *TheSuite) SetUpSuite(c *C) {
+	suite.keyringPath = filepath.Join(c.MkDir(), "secring.asc")
+	entity, err := writeTestKeyring(suite.keyringPath)
+	c.Assert(err, IsNil)
+	suite.entity = entity
+}
+
+func (suite *TheSuite) TestSignAndVerify(c *C) {
+	signer, err := LoadSigner(suite.keyringPath, "", nil)
+	c.Assert(err, IsNil)
+	c.Assert(signer.KeyID(), Equals, KeyID(suite.entity.PrimaryKey.KeyId))
+
+	data := []byte("<repomd></repomd>")
+	sig, err := signer.DetachSign(data)
+	c.Assert(err, IsNil)
+
+	// the public key is all a client needs to check the signature
+	pub, err := signer.PublicKey()
+	c.Assert(err, IsNil)
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(pub))
+	c.Assert(err, IsNil)
+	c.Assert(keyring[0].PrivateKey, IsNil)
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(data), bytes.NewReader(sig))
+	c.Assert(err, IsNil)
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader([]byte("tampered")), bytes.NewReader(sig))
+	c.Assert(err, NotNil)
+}
+
+func (suite *TheSuite) TestClearSign(c *C) {
+	signer, err := LoadSigner(suite.keyringPath, "", nil)
+	c.Assert(err, IsNil)
+	data := []byte("Origin: roper\nSuite: stable\n")
+	signed, err := signer.ClearSign(data)
+	c.Assert(err, IsNil)
+
+	block, _ := clearsign.Decode(signed)
+	c.Assert(block, NotNil)
+	c.Assert(string(block.Plaintext), Equals, string(data))
+	_, err = openpgp.CheckDetachedSignature(openpgp.EntityList{suite.entity}, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body)
+	c.Assert(err, IsNil)
+}
+
+func (suite *TheSuite) TestLoadSignerByKeyID(c *C) {
+	long := KeyID(suite.entity.PrimaryKey.KeyId)
+	for _, id := range []string{long, long[8:], "0x" + long} {
+		_, err := LoadSigner(suite.keyringPath, id, nil)
+		c.Assert(err, IsNil)
+	}
+	_, err := LoadSigner(suite.keyringPath, "DEADBEEF", nil)
+	c.Assert(err, NotNil)
+	_, err = LoadSigner(filepath.Join(c.MkDir(), "nope"), "", nil)
+	c.Assert(err, NotNil)
+}
+
+func (suite *TheSuite) TestVerifyRPM(c *C) {
+	testRepos := filepath.Join("..", "hack", "test_repos")
+	keyring, err := ReadKeyring(filepath.Join(testRepos, "epel", "RPM-GPG-KEY-EPEL-7"))
+	c.Assert(err, IsNil)
+
+	keyID, err := VerifyRPM(filepath.Join(testRepos, "epel", "7", "x86_64", "j", "jq-1.3-2.el7.x86_64.rpm"), keyring)
+	c.Assert(err, IsNil)
+	c.Assert(keyID, Equals, KeyID(keyring[0].PrimaryKey.KeyId))
+
+	// a signed package with its payload changed fails, even though its signed header is untouched
+	data, err := ioutil.ReadFile(filepath.Join(testRepos, "epel", "7", "x86_64", "j", "jq-1.3-2.el7.x86_64.rpm"))
+	c.Assert(err, IsNil)
+	data[len(data)-100] ^= 0xff
+	tampered := filepath.Join(c.MkDir(), "jq-1.3-2.el7.x86_64.rpm")
+	c.Assert(ioutil.WriteFile(tampered, data, 0644), IsNil)
+	_, err = VerifyRPM(tampered, keyring)
+	c.Assert(err, ErrorMatches, "bad payload signature: .*")
+
+	// docker packages are signed, but not with the EPEL key
+	_, err = VerifyRPM(filepath.Join(testRepos, "docker", "7", "Packages", "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm"), keyring)
+	unknown, ok := err.(*ErrUnknownKey)
+	c.Assert(ok, Equals, true)
+	c.Assert(unknown.KeyID, HasLen, 16)
+}
@@ -0,0 +1,139 @@
+package gpg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/alapidas/roper/rpm"
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// ErrUnsigned is returned when verifying a package that has no signature
+var ErrUnsigned = errors.New("package is not signed")
+
+// ErrUnknownKey is returned when verifying a package signed with a key that isn't in the keyring
+type ErrUnknownKey struct {
+	KeyID string
+}
+
+func (e *ErrUnknownKey) Error() string {
+	return fmt.Sprintf("package is signed with unknown key %s", e.KeyID)
+}
+
+// VerifyRPM checks the signature of an RPM file against a keyring, returning the id of the key that
+// signed it.  Header-only signatures are preferred, and header+payload signatures are used for
+// packages that don't have them.  A header-only signature doesn't cover the payload, so the payload is
+// also checked against the digest in the signed header, or, for older packages without one, against a
+// header+payload signature from the same key.
+func VerifyRPM(path string, keyring openpgp.EntityList) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to open rpm %s: %s", path, err)
+	}
+	defer f.Close()
+	// this leaves f positioned at the start of the payload
+	pkg, err := rpm.Read(f)
+	if err != nil {
+		return "", fmt.Errorf("unable to read rpm %s: %s", path, err)
+	}
+	for _, tag := range []int{rpm.SigTagRSA, rpm.SigTagDSA} {
+		if sig := pkg.Signature.Bytes(tag); sig != nil {
+			keyID, err := checkSignature(keyring, bytes.NewReader(pkg.Header.Raw), sig)
+			if err != nil {
+				return "", err
+			}
+			if err = checkPayload(pkg, f, keyring, keyID); err != nil {
+				return "", err
+			}
+			return keyID, nil
+		}
+	}
+	if sig := payloadSignature(pkg); sig != nil {
+		return checkSignature(keyring, io.MultiReader(bytes.NewReader(pkg.Header.Raw), f), sig)
+	}
+	return "", ErrUnsigned
+}
+
+// payloadSignature returns the header+payload signature of a package, or nil if it doesn't have one
+func payloadSignature(pkg *rpm.Package) []byte {
+	for _, tag := range []int{rpm.SigTagPGP, rpm.SigTagGPG} {
+		if sig := pkg.Signature.Bytes(tag); sig != nil {
+			return sig
+		}
+	}
+	return nil
+}
+
+// payloadHashes are the PGP hash algorithms rpm uses for payload digests
+var payloadHashes = map[int64]func() hash.Hash{8: sha256.New, 9: sha512.New384, 10: sha512.New}
+
+// checkPayload checks the payload of a package, read from payload, whose header was signed by keyID
+func checkPayload(pkg *rpm.Package, payload io.Reader, keyring openpgp.EntityList, keyID string) error {
+	if digest := pkg.Header.String(rpm.TagPayloadDigest); digest != "" {
+		algo := int64(8)
+		if pkg.Header.Has(rpm.TagPayloadAlgo) {
+			algo = pkg.Header.Int(rpm.TagPayloadAlgo)
+		}
+		newHash, ok := payloadHashes[algo]
+		if !ok {
+			return fmt.Errorf("unsupported payload digest algorithm %d", algo)
+		}
+		h := newHash()
+		if _, err := io.Copy(h, payload); err != nil {
+			return fmt.Errorf("unable to read payload: %s", err)
+		}
+		if hex.EncodeToString(h.Sum(nil)) != digest {
+			return errors.New("payload doesn't match the digest in the signed header")
+		}
+		return nil
+	}
+	sig := payloadSignature(pkg)
+	if sig == nil {
+		return errors.New("signature doesn't cover the payload")
+	}
+	payloadKeyID, err := checkSignature(keyring, io.MultiReader(bytes.NewReader(pkg.Header.Raw), payload), sig)
+	if err != nil {
+		return fmt.Errorf("bad payload signature: %s", err)
+	}
+	if payloadKeyID != keyID {
+		return fmt.Errorf("payload is signed with key %s, and the header with key %s", payloadKeyID, keyID)
+	}
+	return nil
+}
+
+// checkSignature checks a binary detached signature of signed
+func checkSignature(keyring openpgp.EntityList, signed io.Reader, sig []byte) (string, error) {
+	signer, err := openpgp.CheckDetachedSignature(keyring, signed, bytes.NewReader(sig))
+	if err == pgperrors.ErrUnknownIssuer {
+		return "", &ErrUnknownKey{KeyID: issuer(sig)}
+	} else if err != nil {
+		return "", fmt.Errorf("bad signature: %s", err)
+	}
+	return KeyID(signer.PrimaryKey.KeyId), nil
+}
+
+// issuer returns the id of the key that made a signature, or an empty string if it can't be found
+func issuer(sig []byte) string {
+	p, err := packet.Read(bytes.NewReader(sig))
+	if err != nil {
+		return ""
+	}
+	switch s := p.(type) {
+	case *packet.Signature:
+		if s.IssuerKeyId != nil {
+			return KeyID(*s.IssuerKeyId)
+		}
+	case *packet.SignatureV3:
+		return KeyID(s.IssuerKeyId)
+	}
+	return ""
+}
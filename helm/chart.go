@@ -0,0 +1,138 @@
+// Package helm reads Helm chart archives, and generates the index.yaml of a chart repository for them.
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Metadata is the contents of a Chart.yaml
+type Metadata struct {
+	APIVersion   string            `yaml:"apiVersion,omitempty"`
+	Name         string            `yaml:"name"`
+	Version      string            `yaml:"version"`
+	KubeVersion  string            `yaml:"kubeVersion,omitempty"`
+	Description  string            `yaml:"description,omitempty"`
+	Type         string            `yaml:"type,omitempty"`
+	Keywords     []string          `yaml:"keywords,omitempty"`
+	Home         string            `yaml:"home,omitempty"`
+	Sources      []string          `yaml:"sources,omitempty"`
+	Dependencies []Dependency      `yaml:"dependencies,omitempty"`
+	Maintainers  []Maintainer      `yaml:"maintainers,omitempty"`
+	Icon         string            `yaml:"icon,omitempty"`
+	AppVersion   string            `yaml:"appVersion,omitempty"`
+	Deprecated   bool              `yaml:"deprecated,omitempty"`
+	Annotations  map[string]string `yaml:"annotations,omitempty"`
+}
+
+// Dependency is a chart that a chart depends on
+type Dependency struct {
+	Name       string   `yaml:"name"`
+	Version    string   `yaml:"version,omitempty"`
+	Repository string   `yaml:"repository,omitempty"`
+	Condition  string   `yaml:"condition,omitempty"`
+	Tags       []string `yaml:"tags,omitempty"`
+	Alias      string   `yaml:"alias,omitempty"`
+}
+
+// Maintainer is a maintainer of a chart
+type Maintainer struct {
+	Name  string `yaml:"name"`
+	Email string `yaml:"email,omitempty"`
+	URL   string `yaml:"url,omitempty"`
+}
+
+// Chart holds the information from a chart archive that roper cares about
+type Chart struct {
+	Metadata
+
+	// These are only set when reading from a file
+	Size    int64
+	ModTime int64  // unix time in nanoseconds
+	Digest  string // hex encoded SHA-256 of the whole archive
+}
+
+// ReadFile reads the Chart.yaml of a chart archive, and computes its digest
+func ReadFile(path string) (*Chart, error) {
+	return readFile(path, true)
+}
+
+// ReadMetadata reads the Chart.yaml of a chart archive without computing its digest.  The Digest of
+// the returned chart will be empty.
+func ReadMetadata(path string) (*Chart, error) {
+	return readFile(path, false)
+}
+
+func readFile(path string, digest bool) (*Chart, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open chart %s: %s", path, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat chart %s: %s", path, err)
+	}
+	var r io.Reader = f
+	hash := sha256.New()
+	if digest {
+		r = io.TeeReader(f, hash)
+	}
+	chart, err := Read(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read chart %s: %s", path, err)
+	}
+	chart.Size = fi.Size()
+	chart.ModTime = fi.ModTime().UnixNano()
+	if digest {
+		if _, err = io.Copy(hash, f); err != nil {
+			return nil, fmt.Errorf("unable to checksum chart %s: %s", path, err)
+		}
+		chart.Digest = hex.EncodeToString(hash.Sum(nil))
+	}
+	return chart, nil
+}
+
+// Read reads the Chart.yaml of the chart archive in r.  Only the Chart.yaml in the top level
+// directory of the archive is used, so the charts of dependencies are ignored.
+func Read(r io.Reader) (*Chart, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress chart: %s", err)
+	}
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no Chart.yaml found")
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read chart archive: %s", err)
+		}
+		parts := strings.Split(path.Clean(strings.TrimPrefix(hdr.Name, "./")), "/")
+		if len(parts) != 2 || parts[1] != "Chart.yaml" {
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if _, err = io.Copy(buf, io.LimitReader(tr, 1024*1024)); err != nil {
+			return nil, fmt.Errorf("unable to read Chart.yaml: %s", err)
+		}
+		chart := &Chart{}
+		if err = yaml.Unmarshal(buf.Bytes(), &chart.Metadata); err != nil {
+			return nil, fmt.Errorf("unable to parse Chart.yaml: %s", err)
+		}
+		if chart.Name == "" || chart.Version == "" {
+			return nil, fmt.Errorf("Chart.yaml is missing name or version")
+		}
+		return chart, nil
+	}
+}
@@ -0,0 +1,115 @@
+package helm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TheSuite struct{}
+
+var _ = Suite(&TheSuite{})
+
+var chartDir = filepath.Join("..", "hack", "test_repos", "helm")
+
+func (suite *TheSuite) TestReadFile(c *C) {
+	path := filepath.Join(chartDir, "roper-web-0.2.0.tgz")
+	chart, err := ReadFile(path)
+	c.Assert(err, IsNil)
+	// the Chart.yaml of the redis subchart is ignored
+	c.Assert(chart.Name, Equals, "roper-web")
+	c.Assert(chart.Version, Equals, "0.2.0")
+	c.Assert(chart.AppVersion, Equals, "1.16.0")
+	c.Assert(chart.Keywords, DeepEquals, []string{"roper", "test"})
+	c.Assert(chart.Maintainers, DeepEquals, []Maintainer{{Name: "Roper Test", Email: "roper@example.com"}})
+	c.Assert(chart.Dependencies, DeepEquals, []Dependency{{Name: "redis", Version: "9.9.9"}})
+
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	sum := sha256.Sum256(data)
+	c.Assert(chart.Digest, Equals, hex.EncodeToString(sum[:]))
+	c.Assert(chart.Size, Equals, int64(len(data)))
+
+	chart, err = ReadMetadata(path)
+	c.Assert(err, IsNil)
+	c.Assert(chart.Digest, Equals, "")
+
+	_, err = ReadFile(filepath.Join("..", "hack", "test_repos", "apt", "pool", "main", "roper-doc_1.0-1_all.deb"))
+	c.Assert(err, NotNil)
+}
+
+func (suite *TheSuite) TestCompareVersions(c *C) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"v1.0.0", "1.0.0", 0},
+		{"1.0.0+build1", "1.0.0+build2", 0},
+		{"1.2", "1.2.0", 0},
+		{"0.10.0", "0.9.0", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0-beta.11", 1},
+		{"latest", "0.0.1", -1},
+	} {
+		c.Check(CompareVersions(tc.a, tc.b), Equals, tc.want, Commentf("%s vs %s", tc.a, tc.b))
+		c.Check(CompareVersions(tc.b, tc.a), Equals, -tc.want, Commentf("%s vs %s", tc.b, tc.a))
+	}
+}
+
+func (suite *TheSuite) TestGenerate(c *C) {
+	repoPath := c.MkDir()
+	var charts []*IndexedChart
+	for _, name := range []string{"roper-web-0.1.0.tgz", "roper-web-0.2.0.tgz", "roper-worker-1.0.0-rc.1.tgz"} {
+		chart, err := ReadFile(filepath.Join(chartDir, name))
+		c.Assert(err, IsNil)
+		charts = append(charts, &IndexedChart{Chart: chart, Location: "charts/" + name})
+	}
+	generated := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	c.Assert(Generate(repoPath, charts, &Options{Generated: generated}), IsNil)
+
+	data, err := ioutil.ReadFile(filepath.Join(repoPath, IndexFile))
+	c.Assert(err, IsNil)
+	idx := &index{}
+	c.Assert(yaml.Unmarshal(data, idx), IsNil)
+	c.Assert(idx.APIVersion, Equals, "v1")
+	c.Assert(idx.Generated, Equals, "2016-03-01T12:00:00Z")
+	c.Assert(idx.Entries, HasLen, 2)
+	web := idx.Entries["roper-web"]
+	c.Assert(web, HasLen, 2)
+	// newest first
+	c.Assert(web[0].Version, Equals, "0.2.0")
+	c.Assert(web[0].URLs, DeepEquals, []string{"charts/roper-web-0.2.0.tgz"})
+	c.Assert(web[0].Digest, Equals, charts[1].Digest)
+	c.Assert(web[0].Description, Equals, "A test chart for roper")
+	c.Assert(web[1].Version, Equals, "0.1.0")
+	c.Assert(strings.Contains(string(data), "appVersion: 1.16.0"), Equals, true)
+
+	c.Assert(Generate(repoPath, charts[:1], &Options{BaseURL: "https://charts.example.com/roper/"}), IsNil)
+	data, err = ioutil.ReadFile(filepath.Join(repoPath, IndexFile))
+	c.Assert(err, IsNil)
+	idx = &index{}
+	c.Assert(yaml.Unmarshal(data, idx), IsNil)
+	c.Assert(idx.Entries, HasLen, 1)
+	c.Assert(idx.Entries["roper-web"][0].URLs, DeepEquals, []string{"https://charts.example.com/roper/charts/roper-web-0.1.0.tgz"})
+
+	// no temporary files are left behind
+	entries, err := ioutil.ReadDir(repoPath)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	_, err = os.Stat(filepath.Join(repoPath, IndexFile))
+	c.Assert(err, IsNil)
+}
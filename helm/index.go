@@ -0,0 +1,114 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// IndexFile is the name of the index of a chart repository, in the root of the repository
+const IndexFile = "index.yaml"
+
+// IndexedChart is a chart along with its location in the repo
+type IndexedChart struct {
+	*Chart
+	Location string // path relative to the root of the repo
+}
+
+// Options control index generation
+type Options struct {
+	// BaseURL is prepended to the location of each chart.  If empty, the urls in the index are
+	// relative to the repo, which helm resolves against the url the repo was added with.
+	BaseURL string
+	// Generated is written to the index.  Defaults to the current time.
+	Generated time.Time
+}
+
+// index is the layout of index.yaml
+type index struct {
+	APIVersion string                     `yaml:"apiVersion"`
+	Entries    map[string][]*chartVersion `yaml:"entries"`
+	Generated  string                     `yaml:"generated"`
+}
+
+// chartVersion is an entry in index.yaml: the chart's metadata, along with where to get it
+type chartVersion struct {
+	Metadata `yaml:",inline"`
+	URLs     []string `yaml:"urls"`
+	Created  string   `yaml:"created"`
+	Digest   string   `yaml:"digest"`
+}
+
+// Generate writes the index.yaml for the given charts into the root of repoPath.  Each chart's
+// versions are listed newest first.  The index is written to a temporary file, and then renamed
+// into place.
+func Generate(repoPath string, charts []*IndexedChart, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	generated := opts.Generated
+	if generated.IsZero() {
+		generated = time.Now()
+	}
+	idx := &index{
+		APIVersion: "v1",
+		Entries:    make(map[string][]*chartVersion),
+		Generated:  generated.UTC().Format(time.RFC3339Nano),
+	}
+	sorted := make([]*IndexedChart, len(charts))
+	copy(sorted, charts)
+	sort.Sort(byVersion(sorted))
+	for _, chart := range sorted {
+		url := filepath.ToSlash(chart.Location)
+		if opts.BaseURL != "" {
+			url = strings.TrimRight(opts.BaseURL, "/") + "/" + url
+		}
+		idx.Entries[chart.Name] = append(idx.Entries[chart.Name], &chartVersion{
+			Metadata: chart.Metadata,
+			URLs:     []string{url},
+			Created:  time.Unix(0, chart.ModTime).UTC().Format(time.RFC3339Nano),
+			Digest:   chart.Digest,
+		})
+	}
+	data, err := yaml.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("unable to marshal %s: %s", IndexFile, err)
+	}
+	tmp, err := ioutil.TempFile(repoPath, "."+IndexFile+"-")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary index: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write %s: %s", IndexFile, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write %s: %s", IndexFile, err)
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("unable to set permissions on %s: %s", IndexFile, err)
+	}
+	if err = os.Rename(tmp.Name(), filepath.Join(repoPath, IndexFile)); err != nil {
+		return fmt.Errorf("unable to move %s into place: %s", IndexFile, err)
+	}
+	return nil
+}
+
+// byVersion sorts charts newest first, keeping the order of equal versions stable by location
+type byVersion []*IndexedChart
+
+func (c byVersion) Len() int      { return len(c) }
+func (c byVersion) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c byVersion) Less(i, j int) bool {
+	if cmp := CompareVersions(c[i].Version, c[j].Version); cmp != 0 {
+		return cmp > 0
+	}
+	return c[i].Location < c[j].Location
+}
@@ -0,0 +1,99 @@
+package helm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version.  Build metadata is ignored, since it doesn't affect ordering.
+type semver struct {
+	parts [3]int64
+	pre   []string
+}
+
+// parseVersion parses a semantic version, allowing a leading "v" and missing minor or patch numbers
+// the way helm does
+func parseVersion(v string) (*semver, bool) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.Index(v, "+"); i >= 0 {
+		v = v[:i]
+	}
+	sv := &semver{}
+	if i := strings.Index(v, "-"); i >= 0 {
+		sv.pre = strings.Split(v[i+1:], ".")
+		v = v[:i]
+	}
+	nums := strings.Split(v, ".")
+	if len(nums) > 3 {
+		return nil, false
+	}
+	for i, n := range nums {
+		part, err := strconv.ParseInt(n, 10, 64)
+		if err != nil || part < 0 {
+			return nil, false
+		}
+		sv.parts[i] = part
+	}
+	return sv, true
+}
+
+// CompareVersions compares two chart versions by semantic version precedence, returning -1, 0 or 1.
+// Versions that aren't semantic versions sort before those that are, and are compared as strings.
+func CompareVersions(a, b string) int {
+	sa, okA := parseVersion(a)
+	sb, okB := parseVersion(b)
+	switch {
+	case !okA && !okB:
+		return strings.Compare(a, b)
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+	for i := range sa.parts {
+		if sa.parts[i] != sb.parts[i] {
+			return sign(sa.parts[i] - sb.parts[i])
+		}
+	}
+	// a pre-release sorts before the release itself
+	switch {
+	case len(sa.pre) == 0 && len(sb.pre) == 0:
+		return 0
+	case len(sa.pre) == 0:
+		return 1
+	case len(sb.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(sa.pre) && i < len(sb.pre); i++ {
+		if c := comparePre(sa.pre[i], sb.pre[i]); c != 0 {
+			return c
+		}
+	}
+	return sign(int64(len(sa.pre) - len(sb.pre)))
+}
+
+// comparePre compares pre-release identifiers.  Numeric identifiers are compared as numbers, and sort
+// before alphanumeric ones.
+func comparePre(a, b string) int {
+	na, errA := strconv.ParseInt(a, 10, 64)
+	nb, errB := strconv.ParseInt(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return sign(na - nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int64) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
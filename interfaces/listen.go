@@ -0,0 +1,196 @@
+package interfaces
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// UnixPrefix marks a listen address as the path of a unix socket
+const UnixPrefix = "unix:"
+
+// ListenConfig holds the addresses the web server listens on, and its TLS settings
+type ListenConfig struct {
+	// Addrs are host:port pairs, like ":3000" or "[::1]:3000", or unix socket paths prefixed with
+	// "unix:"
+	Addrs []string
+	// TLSCert and TLSKey are the files with the certificate chain and key to serve HTTPS with.  Both
+	// are reloaded when they change, without restarting the server.
+	TLSCert string
+	TLSKey  string
+	// RedirectAddrs are addresses that serve plain HTTP redirects to HTTPS
+	RedirectAddrs []string
+	// ClientCA is a file with the CA certificates that client certificates are verified against.  If
+	// it is set, clients have to present a certificate, unless ClientAuth is ClientAuthOptional.
+	ClientCA string
+	// ClientAuth is one of the ClientAuth* constants, empty means ClientAuthRequire
+	ClientAuth string
+}
+
+// Ways the web server asks for client certificates
+const (
+	ClientAuthRequire  = "require"  // refuse connections without a valid client certificate
+	ClientAuthOptional = "optional" // verify client certificates that are given, and allow connections without one
+)
+
+// TLS returns whether the web server serves HTTPS
+func (cfg ListenConfig) TLS() bool {
+	return cfg.TLSCert != "" || cfg.TLSKey != ""
+}
+
+// Validate checks that the listen settings make sense
+func (cfg ListenConfig) Validate() error {
+	if len(cfg.Addrs) == 0 {
+		return fmt.Errorf("no listen addresses given")
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return fmt.Errorf("TLS needs both a certificate and a key")
+	}
+	if len(cfg.RedirectAddrs) > 0 && !cfg.TLS() {
+		return fmt.Errorf("redirecting to HTTPS needs TLS")
+	}
+	if cfg.ClientCA != "" && !cfg.TLS() {
+		return fmt.Errorf("client certificates need TLS")
+	}
+	if cfg.ClientAuth != "" && cfg.ClientAuth != ClientAuthRequire && cfg.ClientAuth != ClientAuthOptional {
+		return fmt.Errorf("unknown client auth %s", cfg.ClientAuth)
+	}
+	return nil
+}
+
+// clientTLSConfig sets up a TLS config to verify client certificates, if a CA bundle is configured
+func (cfg ListenConfig) clientTLSConfig(tlsConfig *tls.Config) error {
+	if cfg.ClientCA == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(cfg.ClientCA)
+	if err != nil {
+		return fmt.Errorf("unable to read client CA bundle: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in client CA bundle %s", cfg.ClientCA)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	if cfg.ClientAuth == ClientAuthOptional {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return nil
+}
+
+// listen opens a listener for a tcp or unix socket address.  Stale unix sockets are removed first.
+func listen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, UnixPrefix) {
+		path := strings.TrimPrefix(addr, UnixPrefix)
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			// a server that exited without cleaning up, unless someone is still listening
+			if conn, err := net.Dial("unix", path); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("unix socket %s is in use", path)
+			}
+			os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// certReloader serves a certificate from files, and loads them again when they change
+type certReloader struct {
+	certFile, keyFile string
+
+	lock    sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// newCertReloader loads a certificate and key, which must be valid to start with
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// reload loads the certificate if either file has changed since it was last loaded.  The old
+// certificate is kept if the new one can't be loaded, which happens while the files are being
+// replaced one at a time.
+func (cr *certReloader) reload() error {
+	ci, err := os.Stat(cr.certFile)
+	if err != nil {
+		return fmt.Errorf("unable to stat certificate %s: %s", cr.certFile, err)
+	}
+	ki, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to stat key %s: %s", cr.keyFile, err)
+	}
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	if cr.cert != nil && ci.ModTime().Equal(cr.certMod) && ki.ModTime().Equal(cr.keyMod) {
+		return nil
+	}
+	// only try each version of the files once
+	cr.certMod, cr.keyMod = ci.ModTime(), ki.ModTime()
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load certificate %s and key %s: %s", cr.certFile, cr.keyFile, err)
+	}
+	if cr.cert != nil {
+		log.WithField("cert", cr.certFile).Info("Reloaded TLS certificate")
+	}
+	cr.cert = &cert
+	return nil
+}
+
+// GetCertificate is a tls.Config GetCertificate func that returns the current certificate
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := cr.reload(); err != nil {
+		log.WithField("error", err).Warn("Keeping the current TLS certificate")
+	}
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	return cr.cert, nil
+}
+
+// redirectHandler redirects requests to the same url over HTTPS.  httpsPort is the port HTTPS is
+// served on, and is left out of urls if it is 443.
+func redirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.Trim(r.Host, "[]")
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			// IPv6 literal
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// httpsPort returns the port of the first tcp address HTTPS is served on
+func httpsPort(addrs []string) string {
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, UnixPrefix) {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(addr); err == nil {
+			return port
+		}
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package interfaces
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+)
+
+// Router serves the dirs of repos, and the routes of RoutedDirConfigs, at their prefixes.  Dirs can be
+// set and removed while it serves, so repos can come and go without restarting the web server.  When
+// the web UI is one of the routes, requests for / are redirected to it.
+type Router struct {
+	sync.RWMutex
+	routes  map[string]http.Handler
+	dirs    map[string][]DirConfig // by the name they were set under
+	handler http.Handler
+}
+
+// NewRouter returns a router serving dirs, which are all set under the empty name
+func NewRouter(dirs DirConfigs) *Router {
+	rt := &Router{routes: map[string]http.Handler{}, dirs: map[string][]DirConfig{}}
+	if rd, ok := dirs.(RoutedDirConfigs); ok {
+		rt.routes = rd.Routes()
+	}
+	rt.dirs[""] = dirs.Configs()
+	rt.rebuild()
+	return rt
+}
+
+// Set serves dirs under a name, like the name of the repo they belong to, replacing the dirs that were
+// set under the name before
+func (rt *Router) Set(name string, dirs []DirConfig) {
+	rt.Lock()
+	defer rt.Unlock()
+	rt.dirs[name] = dirs
+	rt.rebuild()
+	var prefixes []string
+	for _, dir := range dirs {
+		prefixes = append(prefixes, dir.TopLevel())
+	}
+	log.WithFields(log.Fields{
+		"name":     name,
+		"prefixes": prefixes,
+	}).Info("Serving dirs")
+}
+
+// Remove stops serving the dirs set under a name
+func (rt *Router) Remove(name string) {
+	rt.Lock()
+	defer rt.Unlock()
+	delete(rt.dirs, name)
+	rt.rebuild()
+	log.WithField("name", name).Info("Stopped serving dirs")
+}
+
+// Prefixes returns the prefixes of the dirs being served, sorted
+func (rt *Router) Prefixes() []string {
+	rt.RLock()
+	defer rt.RUnlock()
+	var prefixes []string
+	for _, dirs := range rt.dirs {
+		for _, dir := range dirs {
+			prefixes = append(prefixes, dir.TopLevel())
+		}
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// rebuild replaces the handler with one for the current dirs.  Requests already being served finish
+// with the old handler.
+func (rt *Router) rebuild() {
+	r := mux.NewRouter()
+	for prefix, handler := range rt.routes {
+		r.PathPrefix("/" + prefix + "/").Handler(handler)
+	}
+	if _, ok := rt.routes[UIPrefix]; ok {
+		r.Path("/").Handler(http.RedirectHandler("/"+UIPrefix+"/", http.StatusFound))
+	}
+	var configs []DirConfig
+	for _, dirs := range rt.dirs {
+		configs = append(configs, dirs...)
+	}
+	// routes match in the order they are added, so nested prefixes, like a repo's snapshots, have to
+	// come before the prefix of their parent.  Prefixes are sorted first so the order doesn't depend on
+	// the map.
+	sort.Sort(byTopLevel(configs))
+	sort.Stable(byDepth(configs))
+	for _, dir := range configs {
+		handler := http.StripPrefix("/"+dir.TopLevel()+"/", dirHandler(dir))
+		r.PathPrefix("/" + dir.TopLevel() + "/").Handler(handler)
+	}
+	rt.handler = r
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.RLock()
+	handler := rt.handler
+	rt.RUnlock()
+	handler.ServeHTTP(w, r)
+}
+
+type byTopLevel []DirConfig
+
+func (d byTopLevel) Len() int           { return len(d) }
+func (d byTopLevel) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d byTopLevel) Less(i, j int) bool { return d[i].TopLevel() < d[j].TopLevel() }
@@ -0,0 +1,51 @@
+//go:build ignore
+// +build ignore
+
+// templates_gen.go writes templates.go, which holds the templates of the web UI, so the binary doesn't
+// need the templates dir.  Run it with go generate after changing a template.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	files, err := filepath.Glob(filepath.Join("templates", "*.html"))
+	if err != nil {
+		log.Fatalf("unable to list templates: %s", err)
+	}
+	sort.Strings(files)
+	buf := &bytes.Buffer{}
+	buf.WriteString("// Code generated by templates_gen.go from templates/*.html; DO NOT EDIT.\n\n")
+	buf.WriteString("package interfaces\n\n")
+	buf.WriteString("// uiTemplateFiles are the templates of the web UI, by file name\n")
+	buf.WriteString("var uiTemplateFiles = map[string]string{\n")
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatalf("unable to read template %s: %s", file, err)
+		}
+		text := string(data)
+		quoted := "`" + text + "`"
+		if strings.Contains(text, "`") {
+			quoted = strconv.Quote(text)
+		}
+		fmt.Fprintf(buf, "%q: %s,\n", filepath.Base(file), quoted)
+	}
+	buf.WriteString("}\n")
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("unable to format templates.go: %s", err)
+	}
+	if err = ioutil.WriteFile("templates.go", src, 0644); err != nil {
+		log.Fatalf("unable to write templates.go: %s", err)
+	}
+}
@@ -0,0 +1,398 @@
+package interfaces
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alapidas/roper/controller"
+	"github.com/alapidas/roper/model"
+	"github.com/alapidas/roper/rpm"
+	"github.com/gorilla/mux"
+)
+
+// UIPrefix is the path the web UI is served under.  Requests for / are redirected to it.
+const UIPrefix = "ui"
+
+//go:generate go run templates_gen.go
+
+var uiTemplates = parseTemplates(template.New("ui").Funcs(template.FuncMap{
+	"uiURL":      func() string { return "/" + UIPrefix + "/" },
+	"repoURL":    func(name string) string { return "/" + UIPrefix + "/repos/" + escapeElement(name) },
+	"filesURL":   func(name string) string { return "/" + escapeElement(name) + "/" },
+	"packageURL": packageURL,
+	"downloadURL": func(pkg *model.Package) string {
+		return "/" + escapeElement(pkg.RepoName) + "/" + escapePath(pkg.RelPath)
+	},
+	"base": path.Base,
+	"size": humanSize,
+	"date": func(t int64) string { return time.Unix(t, 0).UTC().Format("2006-01-02 15:04 MST") },
+	"day":  func(t int64) string { return time.Unix(t, 0).UTC().Format("2006-01-02") },
+	"evr":  evr,
+	"dep":  formatDep,
+}))
+
+// parseTemplates adds the templates in templates.go, which is generated from the templates dir
+func parseTemplates(t *template.Template) *template.Template {
+	names := make([]string, 0, len(uiTemplateFiles))
+	for name := range uiTemplateFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		template.Must(t.New(name).Parse(uiTemplateFiles[name]))
+	}
+	return t
+}
+
+// uiRepo describes a repo on the pages of the UI
+type uiRepo struct {
+	Name     string
+	Type     string
+	Private  bool
+	Packages int
+	Size     int64 // total size of the packages, in bytes
+	Build    *model.BuildStatus
+}
+
+// uiDeps are the dependencies of a package of one kind, like its requires
+type uiDeps struct {
+	Kind string
+	Deps []model.Dependency
+}
+
+// uiPage is what the templates of the UI are rendered with
+type uiPage struct {
+	Title    string
+	Query    string
+	Error    string
+	Repos    []*uiRepo
+	Repo     *uiRepo
+	Packages []*model.Package
+
+	Package     *model.Package
+	Deps        []uiDeps
+	Header      *rpm.Package // nil for packages whose header can't be read
+	HeaderError string
+}
+
+// ui serves a browsable HTML view of the repos and packages of a controller.  Pages only show the repos
+// the request can read, like the API.
+type ui struct {
+	rc     *controller.RoperController
+	router *mux.Router
+}
+
+// NewUI returns the handler of the web UI, to be served at UIPrefix
+func NewUI(rc *controller.RoperController) http.Handler {
+	u := &ui{rc: rc, router: mux.NewRouter()}
+	r := u.router.PathPrefix("/" + UIPrefix).Subrouter()
+	r.HandleFunc("/", u.listRepos).Methods("GET")
+	r.HandleFunc("/search", u.search).Methods("GET")
+	r.HandleFunc("/repos/{name}", u.showRepo).Methods("GET")
+	r.HandleFunc("/repos/{name}/packages/{relpath:.+}", u.showPackage).Methods("GET")
+	u.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.renderError(w, http.StatusNotFound, "No such page.")
+	})
+	return u
+}
+
+func (u *ui) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.router.ServeHTTP(w, r)
+}
+
+// listRepos shows the repos the request can read
+func (u *ui) listRepos(w http.ResponseWriter, r *http.Request) {
+	user, ok := u.authenticate(w, r)
+	if !ok {
+		return
+	}
+	repos, err := u.rc.GetRepos()
+	if err != nil {
+		u.internalError(w, err)
+		return
+	}
+	page := &uiPage{Title: "Repos"}
+	for _, repo := range repos {
+		if !controller.Allowed(user, controller.ClientCert(r), repo, model.RoleRead) {
+			continue
+		}
+		desc, err := u.describeRepo(repo)
+		if err != nil {
+			u.internalError(w, err)
+			return
+		}
+		page.Repos = append(page.Repos, desc)
+	}
+	u.render(w, "repos", page)
+}
+
+// showRepo shows a repo and its packages, optionally only the ones matching the q query parameter
+func (u *ui) showRepo(w http.ResponseWriter, r *http.Request) {
+	repo, ok := u.repo(w, r)
+	if !ok {
+		return
+	}
+	desc, err := u.describeRepo(repo)
+	if err != nil {
+		u.internalError(w, err)
+		return
+	}
+	pkgs, err := u.rc.FindPackages(repo.Name, searchPattern(r.URL.Query().Get("q")))
+	if err != nil {
+		u.renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	u.render(w, "repo", &uiPage{Title: repo.Name, Repo: desc, Packages: pkgs})
+}
+
+// showPackage shows the details of the package at a path in a repo.  The description and changelog of
+// rpms are read from their header, as they aren't kept in the database.
+func (u *ui) showPackage(w http.ResponseWriter, r *http.Request) {
+	repo, ok := u.repo(w, r)
+	if !ok {
+		return
+	}
+	relPath := mux.Vars(r)["relpath"]
+	pkg, ok := repo.Packages[relPath]
+	if !ok {
+		u.renderError(w, http.StatusNotFound, fmt.Sprintf("No package at %s in repo %s.", relPath, repo.Name))
+		return
+	}
+	page := &uiPage{Title: pkg.NEVRA(), Package: pkg}
+	if page.Title == "" {
+		page.Title = path.Base(pkg.RelPath)
+	}
+	for _, deps := range []uiDeps{
+		{"Requires", pkg.Requires},
+		{"Provides", pkg.Provides},
+		{"Conflicts", pkg.Conflicts},
+		{"Obsoletes", pkg.Obsoletes},
+	} {
+		if len(deps.Deps) > 0 {
+			page.Deps = append(page.Deps, deps)
+		}
+	}
+	if pkg.IsRPM() {
+		hdr, err := u.rc.PackageHeader(repo.Name, relPath)
+		if err != nil {
+			// the file may have been removed since it was discovered, the rest of the page still stands
+			log.WithFields(log.Fields{
+				"repo":  repo.Name,
+				"path":  relPath,
+				"error": err,
+			}).Warn("Unable to read package header for UI")
+			page.HeaderError = err.Error()
+		}
+		page.Header = hdr
+	}
+	u.render(w, "package", page)
+}
+
+// search shows the packages matching the q query parameter in all the repos the request can read.  Terms
+// without wildcards match anywhere in the name or NEVRA of a package.
+func (u *ui) search(w http.ResponseWriter, r *http.Request) {
+	user, ok := u.authenticate(w, r)
+	if !ok {
+		return
+	}
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	page := &uiPage{Title: "Search", Query: query}
+	if query == "" {
+		u.render(w, "search", page)
+		return
+	}
+	repos, err := u.rc.GetRepos()
+	if err != nil {
+		u.internalError(w, err)
+		return
+	}
+	readable := map[string]bool{}
+	for _, repo := range repos {
+		readable[repo.Name] = controller.Allowed(user, controller.ClientCert(r), repo, model.RoleRead)
+	}
+	pkgs, err := u.rc.FindPackages("", searchPattern(query))
+	if err != nil {
+		u.renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, pkg := range pkgs {
+		if readable[pkg.RepoName] {
+			page.Packages = append(page.Packages, pkg)
+		}
+	}
+	u.render(w, "search", page)
+}
+
+// repo looks up the repo named in the request, if the request can read it, and renders an error page if
+// it can't or there is no such repo
+func (u *ui) repo(w http.ResponseWriter, r *http.Request) (*model.Repo, bool) {
+	name := mux.Vars(r)["name"]
+	if _, err := u.rc.Authorize(r, name, model.RoleRead); err != nil {
+		u.authError(w, err)
+		return nil, false
+	}
+	repo, err := u.rc.GetRepo(name)
+	if err != nil {
+		u.renderError(w, http.StatusNotFound, fmt.Sprintf("No repo named %s.", name))
+		return nil, false
+	}
+	return repo, true
+}
+
+// describeRepo describes a repo along with its last build
+func (u *ui) describeRepo(repo *model.Repo) (*uiRepo, error) {
+	status, err := u.rc.GetBuildStatus(repo.Name)
+	if err != nil {
+		return nil, err
+	}
+	desc := &uiRepo{
+		Name:     repo.Name,
+		Type:     repo.Type,
+		Private:  repo.Access.Private,
+		Packages: len(repo.Packages),
+		Build:    status,
+	}
+	if desc.Type == "" {
+		desc.Type = model.TypeYum
+	}
+	for _, pkg := range repo.Packages {
+		desc.Size += pkg.Size
+	}
+	return desc, nil
+}
+
+// authenticate returns the user a request is from, nil for anonymous requests, and renders an error page
+// if the request has bad credentials
+func (u *ui) authenticate(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	user, err := u.rc.Authenticate(r)
+	if err != nil {
+		u.authError(w, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// authError renders the error page for a request that failed authentication or authorization.  Browsers
+// are asked for credentials when there weren't any, or they were wrong.
+func (u *ui) authError(w http.ResponseWriter, err error) {
+	switch err {
+	case controller.ErrUnauthenticated:
+		w.Header().Set("WWW-Authenticate", `Basic realm="roper"`)
+		u.renderError(w, http.StatusUnauthorized, "Sign in to see this page.")
+	case controller.ErrForbidden:
+		u.renderError(w, http.StatusForbidden, "You don't have access to this page.")
+	default:
+		u.internalError(w, err)
+	}
+}
+
+// internalError logs an unexpected error, and renders a generic error page, since the error may show
+// paths on the server
+func (u *ui) internalError(w http.ResponseWriter, err error) {
+	log.WithField("error", err).Error("Error serving UI request")
+	u.renderError(w, http.StatusInternalServerError, "Something went wrong on the server.")
+}
+
+// renderError renders the error page with a status
+func (u *ui) renderError(w http.ResponseWriter, status int, msg string) {
+	u.renderStatus(w, status, "error", &uiPage{Title: http.StatusText(status), Error: msg})
+}
+
+func (u *ui) render(w http.ResponseWriter, name string, page *uiPage) {
+	u.renderStatus(w, http.StatusOK, name, page)
+}
+
+// renderStatus renders a template to a buffer first, so a failing template doesn't leave half a page
+func (u *ui) renderStatus(w http.ResponseWriter, status int, name string, page *uiPage) {
+	buf := &bytes.Buffer{}
+	if err := uiTemplates.ExecuteTemplate(buf, name, page); err != nil {
+		log.WithFields(log.Fields{
+			"template": name,
+			"error":    err,
+		}).Error("Unable to render UI template")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// searchPattern returns the glob for a search term, which matches anywhere in a name unless the term
+// has wildcards of its own
+func searchPattern(term string) string {
+	if term == "" || strings.ContainsAny(term, "*?[") {
+		return term
+	}
+	return "*" + term + "*"
+}
+
+// packageURL returns the path of the page of a package
+func packageURL(pkg *model.Package) string {
+	return "/" + UIPrefix + "/repos/" + escapeElement(pkg.RepoName) + "/packages/" + escapePath(pkg.RelPath)
+}
+
+// escapePath escapes each element of a slash separated path
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = escapeElement(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// escapeElement escapes a single element of a path, including any slashes in it
+func escapeElement(elem string) string {
+	return strings.Replace((&url.URL{Path: elem}).EscapedPath(), "/", "%2F", -1)
+}
+
+// humanSize formats a size in bytes with binary units
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// evr returns the [epoch:]version-release of a package
+func evr(pkg *model.Package) string {
+	if pkg.Name == "" {
+		return ""
+	}
+	if pkg.Epoch == 0 {
+		return pkg.Version + "-" + pkg.Release
+	}
+	return fmt.Sprintf("%d:%s-%s", pkg.Epoch, pkg.Version, pkg.Release)
+}
+
+var depOperators = map[string]string{"EQ": "=", "LT": "<", "GT": ">", "LE": "<=", "GE": ">="}
+
+// formatDep formats a dependency like rpm does, as "name >= epoch:version-release"
+func formatDep(dep model.Dependency) string {
+	op, ok := depOperators[dep.Flags]
+	if !ok {
+		return dep.Name
+	}
+	v := dep.Version
+	if dep.Epoch != "" && dep.Epoch != "0" {
+		v = dep.Epoch + ":" + v
+	}
+	if dep.Release != "" {
+		v += "-" + dep.Release
+	}
+	return fmt.Sprintf("%s %s %s", dep.Name, op, v)
+}
@@ -1,45 +1,176 @@
 package interfaces
 
 import (
+	"crypto/tls"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"github.com/gorilla/mux"
+	"net"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 )
 
 type DirConfigs interface {
 	Configs() []DirConfig
 }
 
+// RoutedDirConfigs are DirConfigs that also serve handlers of their own, like the API.  Each handler
+// is served at a top level prefix, which is not stripped from requests, and takes precedence over
+// dirs.
+type RoutedDirConfigs interface {
+	DirConfigs
+	Routes() map[string]http.Handler
+}
+
 type DirConfig interface {
 	TopLevel() string
 	AbsPath() string
 }
 
-// StartWeb simply provides a web server for the files in repos
-func StartWeb(shutdownChan chan struct{}, errChan chan error, dirs DirConfigs) {
-	r := mux.NewRouter()
-	prefixes := make([]string, len(dirs.Configs()))
-	for _, dir := range dirs.Configs() {
-		prefixes = append(prefixes, dir.TopLevel())
-		handler := http.StripPrefix("/"+dir.TopLevel()+"/", http.FileServer(http.Dir(dir.AbsPath()+"/")))
-		r.PathPrefix("/" + dir.TopLevel() + "/").Handler(handler)
+// FallbackDirConfig is a DirConfig for a dir that doesn't hold all of its files, like the cache of a
+// proxy repo.  Requests for files that aren't in the dir are passed to the fallback handler, with the
+// prefix stripped.  A nil fallback serves the dir like any other.
+type FallbackDirConfig interface {
+	DirConfig
+	Fallback() http.Handler
+}
+
+// AuthDirConfig is a DirConfig whose requests are checked before they are served.  Authorize returns 0
+// to serve a request, or the status to refuse it with.  Requests refused with 401 are asked for
+// credentials, which clients like yum can send with basic auth.
+type AuthDirConfig interface {
+	DirConfig
+	Authorize(r *http.Request) int
+}
+
+// authHandler refuses the requests an AuthDirConfig doesn't authorize
+type authHandler struct {
+	dir     AuthDirConfig
+	handler http.Handler
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if status := h.dir.Authorize(r); status != 0 {
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Basic realm="roper"`)
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
 	}
-	http.Handle("/", r)
+	h.handler.ServeHTTP(w, r)
+}
 
-	log.WithFields(log.Fields{
-		"prefixes": prefixes,
-	}).Infof("Starting web server for repos at prefixes on port 3000")
+// fallbackHandler serves the files in a dir, and passes requests for anything else to a fallback
+type fallbackHandler struct {
+	root     string
+	files    http.Handler
+	fallback http.Handler
+}
+
+func (h *fallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	relPath := path.Clean("/" + r.URL.Path)
+	if _, err := os.Stat(filepath.Join(h.root, filepath.FromSlash(relPath))); os.IsNotExist(err) {
+		h.fallback.ServeHTTP(w, r)
+		return
+	}
+	h.files.ServeHTTP(w, r)
+}
+
+type byDepth []DirConfig
+
+func (d byDepth) Len() int      { return len(d) }
+func (d byDepth) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d byDepth) Less(i, j int) bool {
+	return strings.Count(d[i].TopLevel(), "/") > strings.Count(d[j].TopLevel(), "/")
+}
+
+// dirHandler returns the handler for the files of a dir
+func dirHandler(dir DirConfig) http.Handler {
+	var handler http.Handler = http.FileServer(http.Dir(dir.AbsPath() + "/"))
+	if fd, ok := dir.(FallbackDirConfig); ok && fd.Fallback() != nil {
+		handler = &fallbackHandler{root: dir.AbsPath(), files: handler, fallback: fd.Fallback()}
+	}
+	if ad, ok := dir.(AuthDirConfig); ok {
+		handler = &authHandler{dir: ad, handler: handler}
+	}
+	return handler
+}
+
+// StartWeb serves the dirs of a router on the addresses in cfg, until it is told to shut down or one of
+// its listeners fails, which is reported on errChan
+func StartWeb(shutdownChan chan struct{}, errChan chan error, router *Router, cfg ListenConfig) {
+	if err := cfg.Validate(); err != nil {
+		reportError(errChan, fmt.Errorf("bad web server settings: %s", err))
+		return
+	}
+	srv := &http.Server{Handler: router}
+	redirect := &http.Server{Handler: redirectHandler(httpsPort(cfg.Addrs))}
+	defer srv.Close()
+	defer redirect.Close()
+	if cfg.TLS() {
+		certs, err := newCertReloader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			reportError(errChan, err)
+			return
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+		if err = cfg.clientTLSConfig(srv.TLSConfig); err != nil {
+			reportError(errChan, err)
+			return
+		}
+	}
 
-	webDoneChan := make(chan error)
-	go func() {
-		webDoneChan <- http.ListenAndServe(":3000", nil)
-	}()
+	webDoneChan := make(chan error, len(cfg.Addrs)+len(cfg.RedirectAddrs))
+	for _, addr := range cfg.Addrs {
+		l, err := listen(addr)
+		if err != nil {
+			reportError(errChan, fmt.Errorf("unable to listen on %s: %s", addr, err))
+			return
+		}
+		go func(l net.Listener) {
+			if cfg.TLS() {
+				webDoneChan <- srv.ServeTLS(l, "", "")
+			} else {
+				webDoneChan <- srv.Serve(l)
+			}
+		}(l)
+	}
+	for _, addr := range cfg.RedirectAddrs {
+		l, err := listen(addr)
+		if err != nil {
+			reportError(errChan, fmt.Errorf("unable to listen on %s: %s", addr, err))
+			return
+		}
+		go func(l net.Listener) {
+			webDoneChan <- redirect.Serve(l)
+		}(l)
+	}
+
+	log.WithFields(log.Fields{
+		"prefixes": router.Prefixes(),
+		"addrs":    cfg.Addrs,
+		"tls":      cfg.TLS(),
+		"clientca": cfg.ClientCA,
+		"redirect": cfg.RedirectAddrs,
+	}).Infof("Starting web server for repos at prefixes")
 
 	select {
 	case err := <-webDoneChan:
 		log.Warnf("Web server exited: %s", err)
+		reportError(errChan, fmt.Errorf("web server exited: %s", err))
 	case <-shutdownChan:
 		log.Warn("Web server received shutdown signal")
 	}
 	return
 }
+
+// reportError passes an error to the server, unless it is already shutting down for another error
+func reportError(errChan chan error, err error) {
+	select {
+	case errChan <- err:
+	default:
+		log.WithField("error", err).Error("Web server error")
+	}
+}
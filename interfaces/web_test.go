@@ -2,8 +2,30 @@ package interfaces
 
 import (
 
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/alapidas/roper/controller"
+	"github.com/alapidas/roper/model"
 )
 
 func Test(t *testing.T) { TestingT(t) }
@@ -20,3 +42,517 @@ func (suite *TheSuite) SetUpTest(c *C) {
 }
 
 func (suite *TheSuite) TestWebServer(c *C) {}
+
+type testDirConfig struct {
+	absPath  string
+	fallback http.Handler
+}
+
+func (d testDirConfig) TopLevel() string       { return "Repo" }
+func (d testDirConfig) AbsPath() string        { return d.absPath }
+func (d testDirConfig) Fallback() http.Handler { return d.fallback }
+
+func (suite *TheSuite) TestFallback(c *C) {
+	root := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(root, "Packages"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "Packages", "a.rpm"), []byte("local"), 0644), IsNil)
+	var missed []string
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		missed = append(missed, r.URL.Path)
+		w.Write([]byte("remote"))
+	})
+
+	get := func(h http.Handler, path string) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Body.String()
+	}
+	h := http.StripPrefix("/Repo/", dirHandler(testDirConfig{absPath: root, fallback: fallback}))
+	c.Assert(get(h, "/Repo/Packages/a.rpm"), Equals, "local")
+	c.Assert(get(h, "/Repo/Packages/b.rpm"), Equals, "remote")
+	c.Assert(get(h, "/Repo/repodata/repomd.xml"), Equals, "remote")
+	c.Assert(missed, DeepEquals, []string{"Packages/b.rpm", "repodata/repomd.xml"})
+
+	// without a fallback, misses are just not found
+	h = http.StripPrefix("/Repo/", dirHandler(testDirConfig{absPath: root}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/Repo/Packages/b.rpm", nil))
+	c.Assert(rec.Code, Equals, http.StatusNotFound)
+}
+
+type prefixDirConfig string
+
+func (d prefixDirConfig) TopLevel() string { return string(d) }
+func (d prefixDirConfig) AbsPath() string  { return "" }
+
+func (suite *TheSuite) TestByDepth(c *C) {
+	configs := []DirConfig{prefixDirConfig("A"), prefixDirConfig("A/snapshots"), prefixDirConfig("B"), prefixDirConfig("B/snapshots")}
+	sort.Stable(byDepth(configs))
+	var order []string
+	for _, dir := range configs {
+		order = append(order, dir.TopLevel())
+	}
+	c.Assert(order, DeepEquals, []string{"A/snapshots", "B/snapshots", "A", "B"})
+}
+
+// writeCert writes a new self signed certificate and key for a host name
+func writeCert(c *C, certFile, keyFile, host string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	c.Assert(ioutil.WriteFile(certFile, certPEM, 0644), IsNil)
+	c.Assert(ioutil.WriteFile(keyFile, keyPEM, 0600), IsNil)
+}
+
+func (suite *TheSuite) TestCertReloader(c *C) {
+	dir := c.MkDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_, err := newCertReloader(certFile, keyFile)
+	c.Assert(err, NotNil)
+	writeCert(c, certFile, keyFile, "one.example.com")
+	cr, err := newCertReloader(certFile, keyFile)
+	c.Assert(err, IsNil)
+	name := func() string {
+		cert, err := cr.GetCertificate(nil)
+		c.Assert(err, IsNil)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		c.Assert(err, IsNil)
+		return leaf.Subject.CommonName
+	}
+	c.Assert(name(), Equals, "one.example.com")
+
+	// new files are picked up, and broken ones leave the old certificate in place
+	later := time.Now().Add(time.Minute)
+	writeCert(c, certFile, keyFile, "two.example.com")
+	c.Assert(os.Chtimes(certFile, later, later), IsNil)
+	c.Assert(os.Chtimes(keyFile, later, later), IsNil)
+	c.Assert(name(), Equals, "two.example.com")
+	c.Assert(ioutil.WriteFile(keyFile, []byte("garbage"), 0600), IsNil)
+	later = later.Add(time.Minute)
+	c.Assert(os.Chtimes(keyFile, later, later), IsNil)
+	c.Assert(name(), Equals, "two.example.com")
+}
+
+func (suite *TheSuite) TestRedirect(c *C) {
+	get := func(port, host, target string) string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", target, nil)
+		req.Host = host
+		redirectHandler(port).ServeHTTP(rec, req)
+		c.Assert(rec.Code, Equals, http.StatusMovedPermanently)
+		return rec.Header().Get("Location")
+	}
+	c.Assert(get("443", "repo.example.com", "/Repo/repodata/repomd.xml?x=1"), Equals, "https://repo.example.com/Repo/repodata/repomd.xml?x=1")
+	c.Assert(get("8443", "repo.example.com:8080", "/Repo/"), Equals, "https://repo.example.com:8443/Repo/")
+	c.Assert(get("8443", "[::1]:8080", "/"), Equals, "https://[::1]:8443/")
+	c.Assert(get("", "[::1]", "/"), Equals, "https://[::1]/")
+	c.Assert(httpsPort([]string{"unix:/run/roper.sock", "[::]:8443", ":443"}), Equals, "8443")
+}
+
+func (suite *TheSuite) TestListenConfig(c *C) {
+	c.Assert(ListenConfig{}.Validate(), NotNil)
+	c.Assert(ListenConfig{Addrs: []string{":3000"}, TLSCert: "cert.pem"}.Validate(), NotNil)
+	c.Assert(ListenConfig{Addrs: []string{":3000"}, RedirectAddrs: []string{":80"}}.Validate(), NotNil)
+	c.Assert(ListenConfig{Addrs: []string{":3000"}}.Validate(), IsNil)
+	c.Assert(ListenConfig{Addrs: []string{":443"}, TLSCert: "cert.pem", TLSKey: "key.pem", RedirectAddrs: []string{":80"}}.Validate(), IsNil)
+}
+
+type testDirConfigs []DirConfig
+
+func (d testDirConfigs) Configs() []DirConfig { return d }
+
+func (suite *TheSuite) TestStartWeb(c *C) {
+	root := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "a.rpm"), []byte("package"), 0644), IsNil)
+	dir := c.MkDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(c, certFile, keyFile, "localhost")
+	sock, redirectSock := filepath.Join(dir, "roper.sock"), filepath.Join(dir, "redirect.sock")
+	// a socket left behind by a server that died is replaced
+	stale, err := net.Listen("unix", sock)
+	c.Assert(err, IsNil)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	shutdownChan, errChan, done := make(chan struct{}), make(chan error, 1), make(chan struct{})
+	go func() {
+		StartWeb(shutdownChan, errChan, NewRouter(testDirConfigs{testDirConfig{absPath: root}}), ListenConfig{
+			Addrs:         []string{"unix:" + sock},
+			TLSCert:       certFile,
+			TLSKey:        keyFile,
+			RedirectAddrs: []string{"unix:" + redirectSock},
+		})
+		close(done)
+	}()
+	client := func(path string) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				Dial:            func(network, addr string) (net.Conn, error) { return net.Dial("unix", path) },
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+	}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client(sock).Get("https://localhost/Repo/a.rpm"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "package")
+	c.Assert(resp.TLS, NotNil)
+	resp, err = client(redirectSock).Get("http://localhost/Repo/a.rpm")
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusMovedPermanently)
+	c.Assert(resp.Header.Get("Location"), Equals, "https://localhost/Repo/a.rpm")
+
+	// a second server can't take over the socket
+	StartWeb(make(chan struct{}), errChan, NewRouter(testDirConfigs{}), ListenConfig{Addrs: []string{"unix:" + sock}})
+	c.Assert(<-errChan, NotNil)
+
+	close(shutdownChan)
+	<-done
+	_, err = os.Stat(sock)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+type namedDirConfig struct {
+	topLevel string
+	absPath  string
+}
+
+func (d namedDirConfig) TopLevel() string { return d.topLevel }
+func (d namedDirConfig) AbsPath() string  { return d.absPath }
+
+type testRoutedDirConfigs map[string]http.Handler
+
+func (d testRoutedDirConfigs) Configs() []DirConfig            { return nil }
+func (d testRoutedDirConfigs) Routes() map[string]http.Handler { return d }
+
+func (suite *TheSuite) TestRouter(c *C) {
+	dirA, dirB := c.MkDir(), c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dirA, "a.rpm"), []byte("a"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirB, "a.rpm"), []byte("b"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirB, "s.rpm"), []byte("snapshot"), 0644), IsNil)
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("api")) })
+	rt := NewRouter(testRoutedDirConfigs{"api": api})
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			return ""
+		}
+		return rec.Body.String()
+	}
+	c.Assert(get("/Repo/a.rpm"), Equals, "")
+	c.Assert(get("/api/v1/repos"), Equals, "api")
+
+	// repos are served as soon as they are set, and follow changes to their dirs
+	rt.Set("Repo", []DirConfig{namedDirConfig{"Repo", dirA}, namedDirConfig{"Repo/snapshots", dirB}})
+	c.Assert(get("/Repo/a.rpm"), Equals, "a")
+	c.Assert(get("/Repo/snapshots/s.rpm"), Equals, "snapshot")
+	rt.Set("Repo", []DirConfig{namedDirConfig{"Repo", dirB}})
+	c.Assert(get("/Repo/a.rpm"), Equals, "b")
+	c.Assert(get("/Repo/snapshots/s.rpm"), Equals, "")
+	rt.Set("Other", []DirConfig{namedDirConfig{"Other", dirA}})
+	c.Assert(rt.Prefixes(), DeepEquals, []string{"Other", "Repo"})
+
+	rt.Remove("Repo")
+	c.Assert(get("/Repo/a.rpm"), Equals, "")
+	c.Assert(get("/Other/a.rpm"), Equals, "a")
+	c.Assert(get("/api/v1/repos"), Equals, "api")
+	c.Assert(rt.Prefixes(), DeepEquals, []string{"Other"})
+}
+
+type authDirConfig struct {
+	testDirConfig
+}
+
+func (d authDirConfig) Authorize(r *http.Request) int {
+	switch user, _, _ := r.BasicAuth(); user {
+	case "":
+		return http.StatusUnauthorized
+	case "reader":
+		return 0
+	}
+	return http.StatusForbidden
+}
+
+func (suite *TheSuite) TestAuthDirConfig(c *C) {
+	root := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "a.rpm"), []byte("package"), 0644), IsNil)
+	h := http.StripPrefix("/Repo/", dirHandler(authDirConfig{testDirConfig{absPath: root}}))
+	get := func(user string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/Repo/a.rpm", nil)
+		if user != "" {
+			req.SetBasicAuth(user, "password")
+		}
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := get("")
+	c.Assert(rec.Code, Equals, http.StatusUnauthorized)
+	c.Assert(rec.Header().Get("WWW-Authenticate"), Equals, `Basic realm="roper"`)
+	c.Assert(get("other").Code, Equals, http.StatusForbidden)
+	rec = get("reader")
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), Equals, "package")
+}
+
+// testCA is a certificate authority for client certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCA creates a CA, and writes its certificate to a file if one is given
+func newTestCA(c *C, file string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	if file != "" {
+		c.Assert(ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644), IsNil)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// clientCert issues a client certificate for a subject
+func (ca *testCA) clientCert(c *C, subject pkix.Name) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	c.Assert(err, IsNil)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// certDirConfig only serves requests with a verified client certificate in the build OU
+type certDirConfig struct {
+	testDirConfig
+}
+
+func (d certDirConfig) Authorize(r *http.Request) int {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return http.StatusUnauthorized
+	}
+	for _, ou := range r.TLS.VerifiedChains[0][0].Subject.OrganizationalUnit {
+		if ou == "build" {
+			return 0
+		}
+	}
+	return http.StatusForbidden
+}
+
+func (suite *TheSuite) TestClientCerts(c *C) {
+	root := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "a.rpm"), []byte("package"), 0644), IsNil)
+	dir := c.MkDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	writeCert(c, certFile, keyFile, "localhost")
+	ca, untrusted := newTestCA(c, caFile), newTestCA(c, "")
+	build := ca.clientCert(c, pkix.Name{CommonName: "host1", OrganizationalUnit: []string{"build"}})
+	other := ca.clientCert(c, pkix.Name{CommonName: "host2", OrganizationalUnit: []string{"web"}})
+	forged := untrusted.clientCert(c, pkix.Name{CommonName: "host3", OrganizationalUnit: []string{"build"}})
+
+	c.Assert(ListenConfig{Addrs: []string{":3000"}, ClientCA: caFile}.Validate(), NotNil)
+	c.Assert(ListenConfig{Addrs: []string{":3000"}, TLSCert: certFile, TLSKey: keyFile, ClientCA: caFile, ClientAuth: "maybe"}.Validate(), NotNil)
+
+	// serve on a socket with a config, and return a function to get the file with a client certificate
+	serve := func(clientAuth string) (func(cert *tls.Certificate) (int, error), func()) {
+		sock := filepath.Join(c.MkDir(), "roper.sock")
+		shutdownChan, done := make(chan struct{}), make(chan struct{})
+		go func() {
+			StartWeb(shutdownChan, make(chan error, 1), NewRouter(testDirConfigs{certDirConfig{testDirConfig{absPath: root}}}), ListenConfig{
+				Addrs:      []string{"unix:" + sock},
+				TLSCert:    certFile,
+				TLSKey:     keyFile,
+				ClientCA:   caFile,
+				ClientAuth: clientAuth,
+			})
+			close(done)
+		}()
+		get := func(cert *tls.Certificate) (int, error) {
+			tlsConfig := &tls.Config{InsecureSkipVerify: true}
+			if cert != nil {
+				tlsConfig.Certificates = []tls.Certificate{*cert}
+			}
+			client := &http.Client{Transport: &http.Transport{
+				Dial:            func(network, addr string) (net.Conn, error) { return net.Dial("unix", sock) },
+				TLSClientConfig: tlsConfig,
+			}}
+			resp, err := client.Get("https://localhost/Repo/a.rpm")
+			if err != nil {
+				return 0, err
+			}
+			resp.Body.Close()
+			return resp.StatusCode, nil
+		}
+		for i := 0; i < 50; i++ {
+			if _, err := os.Stat(sock); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return get, func() {
+			close(shutdownChan)
+			<-done
+		}
+	}
+
+	get, stop := serve("")
+	status, err := get(&build)
+	c.Assert(err, IsNil)
+	c.Assert(status, Equals, http.StatusOK)
+	status, err = get(&other)
+	c.Assert(err, IsNil)
+	c.Assert(status, Equals, http.StatusForbidden)
+	// certificates from other CAs, and no certificate at all, don't get past the handshake
+	_, err = get(&forged)
+	c.Assert(err, NotNil)
+	_, err = get(nil)
+	c.Assert(err, NotNil)
+	stop()
+
+	get, stop = serve(ClientAuthOptional)
+	status, err = get(nil)
+	c.Assert(err, IsNil)
+	c.Assert(status, Equals, http.StatusUnauthorized)
+	status, err = get(&build)
+	c.Assert(err, IsNil)
+	c.Assert(status, Equals, http.StatusOK)
+	_, err = get(&forged)
+	c.Assert(err, NotNil)
+	stop()
+}
+
+func (suite *TheSuite) TestUI(c *C) {
+	rc, err := controller.Init(filepath.Join(c.MkDir(), "roper.db"), "nothing")
+	c.Assert(err, IsNil)
+	defer rc.Close()
+	pkgName := "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm"
+	data, err := ioutil.ReadFile(filepath.Join("..", "hack", "test_repos", "docker", "7", "Packages", pkgName))
+	c.Assert(err, IsNil)
+	for _, name := range []string{"Public", "Private"} {
+		repoPath := c.MkDir()
+		c.Assert(os.MkdirAll(filepath.Join(repoPath, "Packages"), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(repoPath, "Packages", pkgName), data, 0644), IsNil)
+		repo := &model.Repo{Name: name, AbsPath: repoPath, Access: model.AccessOptions{Private: name == "Private"}}
+		c.Assert(rc.AddRepo(repo), IsNil)
+	}
+	c.Assert(rc.AddUser("dev", "secret", false), IsNil)
+	c.Assert(rc.GrantRole("dev", "Private", model.RoleRead), IsNil)
+
+	rt := NewRouter(testRoutedDirConfigs{UIPrefix: NewUI(rc)})
+	get := func(target string, auth bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if auth {
+			req.SetBasicAuth("dev", "secret")
+		}
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/", false)
+	c.Assert(rec.Code, Equals, http.StatusFound)
+	c.Assert(rec.Header().Get("Location"), Equals, "/ui/")
+
+	// anonymous requests only see public repos
+	rec = get("/ui/", false)
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("Content-Type"), Equals, "text/html; charset=utf-8")
+	c.Assert(rec.Body.String(), Matches, `(?s).*href="/ui/repos/Public".*`)
+	c.Assert(strings.Contains(rec.Body.String(), "/ui/repos/Private"), Equals, false)
+	rec = get("/ui/", true)
+	c.Assert(rec.Body.String(), Matches, `(?s).*href="/ui/repos/Private".*private.*`)
+	c.Assert(get("/ui/repos/Private", false).Code, Equals, http.StatusUnauthorized)
+	c.Assert(get("/ui/repos/Private", true).Code, Equals, http.StatusOK)
+	c.Assert(get("/ui/repos/Nothing", true).Code, Equals, http.StatusForbidden)
+
+	rec = get("/ui/repos/Public", false)
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), Matches, `(?s).*href="/ui/repos/Public/packages/Packages/`+pkgName+`".*`)
+
+	// package pages have details from the header, and link to the file
+	rec = get("/ui/repos/Public/packages/Packages/"+pkgName, false)
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	body := rec.Body.String()
+	c.Assert(body, Matches, `(?s).*<h1>docker-engine-selinux-1.9.0-1.el7.centos.noarch</h1>.*`)
+	c.Assert(body, Matches, `(?s).*href="/Public/Packages/`+pkgName+`".*`)
+	c.Assert(body, Matches, `(?s).*<h2>Description</h2>.*<h2>Dependencies</h2>.*<h3>Requires</h3>.*<h2>Changelog</h2>.*`)
+	c.Assert(get("/ui/repos/Public/packages/Packages/nothing.rpm", false).Code, Equals, http.StatusNotFound)
+
+	// searches match anywhere in a name, in the repos the request can read
+	rec = get("/ui/search?q=engine-selinux", false)
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(strings.Count(rec.Body.String(), `href="/ui/repos/Public/packages/`), Equals, 1)
+	c.Assert(strings.Contains(rec.Body.String(), "/ui/repos/Private/packages/"), Equals, false)
+	rec = get("/ui/search?q=engine-selinux", true)
+	c.Assert(strings.Count(rec.Body.String(), `/packages/Packages/`+pkgName), Equals, 2)
+	c.Assert(get("/ui/search?q=[", false).Code, Equals, http.StatusBadRequest)
+	c.Assert(get("/ui/nothing", false).Code, Equals, http.StatusNotFound)
+
+	// unexpected errors are logged, not shown
+	rec = httptest.NewRecorder()
+	(&ui{rc: rc}).internalError(rec, errors.New("open /var/lib/roper/roper.db: permission denied"))
+	c.Assert(rec.Code, Equals, http.StatusInternalServerError)
+	c.Assert(strings.Contains(rec.Body.String(), "/var/lib/roper"), Equals, false)
+}
+
+func (suite *TheSuite) TestUITemplatesGenerated(c *C) {
+	// templates.go has to be regenerated with go generate after changing a template
+	files, err := filepath.Glob(filepath.Join("templates", "*.html"))
+	c.Assert(err, IsNil)
+	c.Assert(uiTemplateFiles, HasLen, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		c.Assert(err, IsNil)
+		c.Assert(uiTemplateFiles[filepath.Base(file)], Equals, string(data), Commentf("template %s", file))
+	}
+}
+
+func (suite *TheSuite) TestUIFormat(c *C) {
+	c.Assert(humanSize(0), Equals, "0 B")
+	c.Assert(humanSize(1023), Equals, "1023 B")
+	c.Assert(humanSize(1536), Equals, "1.5 KiB")
+	c.Assert(humanSize(5<<30), Equals, "5.0 GiB")
+	c.Assert(formatDep(model.Dependency{Name: "a", Flags: "GE", Epoch: "1", Version: "2", Release: "3"}), Equals, "a >= 1:2-3")
+	c.Assert(formatDep(model.Dependency{Name: "b"}), Equals, "b")
+	c.Assert(escapePath("Packages/a b#1.rpm"), Equals, "Packages/a%20b%231.rpm")
+	c.Assert(escapeElement("a/b?"), Equals, "a%2Fb%3F")
+}
@@ -0,0 +1,328 @@
+// Package mirror downloads the packages of a remote yum repository into a local directory.
+package mirror
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// RepomdPath is the path of repomd.xml, relative to the root of a repo
+const RepomdPath = "repodata/repomd.xml"
+
+// Package is a package listed in the primary metadata of a remote repo
+type Package struct {
+	Name     string
+	Epoch    string
+	Version  string
+	Release  string
+	Arch     string
+	Location string // path relative to the root of the repo, with forward slashes
+	Base     string // xml:base of the location, if the package lives outside of the repo
+	Size     int64
+	Checksum Checksum
+}
+
+// Checksum is a checksum of a package or metadata file
+type Checksum struct {
+	Type  string // sha256, sha1 (or sha), sha512, sha384, sha224 or md5
+	Value string // hex encoded
+}
+
+// newHash returns a hash for a checksum type
+func newHash(checksumType string) (hash.Hash, error) {
+	switch checksumType {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha", "sha1":
+		return sha1.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "sha384":
+		return sha512.New384(), nil
+	case "sha224":
+		return sha256.New224(), nil
+	case "md5":
+		return md5.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum type %q", checksumType)
+}
+
+// verify checks data against the checksum
+func (sum Checksum) verify(data []byte) error {
+	h, err := newHash(sum.Type)
+	if err != nil {
+		return err
+	}
+	h.Write(data)
+	if got := hex.EncodeToString(h.Sum(nil)); got != strings.ToLower(sum.Value) {
+		return fmt.Errorf("%s checksum mismatch: expected %s, got %s", sum.Type, sum.Value, got)
+	}
+	return nil
+}
+
+// the parts of repomd.xml and primary.xml needed to mirror a repo
+type xmlRepomd struct {
+	Data []struct {
+		Type     string      `xml:"type,attr"`
+		Checksum xmlChecksum `xml:"checksum"`
+		Location xmlLocation `xml:"location"`
+	} `xml:"data"`
+}
+
+type xmlChecksum struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlLocation struct {
+	Href string `xml:"href,attr"`
+	Base string `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
+}
+
+type xmlPrimary struct {
+	Packages []struct {
+		Name    string `xml:"name"`
+		Arch    string `xml:"arch"`
+		Version struct {
+			Epoch   string `xml:"epoch,attr"`
+			Version string `xml:"ver,attr"`
+			Release string `xml:"rel,attr"`
+		} `xml:"version"`
+		Checksum xmlChecksum `xml:"checksum"`
+		Location xmlLocation `xml:"location"`
+		Size     struct {
+			Package int64 `xml:"package,attr"`
+		} `xml:"size"`
+	} `xml:"package"`
+}
+
+// FetchPackages reads the list of packages in the remote repo at baseURL from its repomd.xml and
+// primary metadata.  The primary metadata is checked against the checksum in repomd.xml.
+func FetchPackages(client *http.Client, baseURL string) ([]*Package, error) {
+	repomdData, err := fetch(client, resolve(baseURL, RepomdPath))
+	if err != nil {
+		return nil, err
+	}
+	return readPackages(repomdData, func(href string) ([]byte, error) {
+		return fetch(client, resolve(baseURL, href))
+	})
+}
+
+// ReadPackages reads the list of packages from the metadata of a local repo, such as one fetched
+// with FetchMetadata
+func ReadPackages(repoPath string) ([]*Package, error) {
+	repomdData, err := ioutil.ReadFile(filepath.Join(repoPath, filepath.FromSlash(RepomdPath)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read repomd.xml: %s", err)
+	}
+	return readPackages(repomdData, func(href string) ([]byte, error) {
+		relPath, err := metadataPath(href)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadFile(filepath.Join(repoPath, relPath))
+	})
+}
+
+// readPackages parses repomd.xml, and the primary metadata it points to, which is read with get
+func readPackages(repomdData []byte, get func(href string) ([]byte, error)) ([]*Package, error) {
+	repomd := &xmlRepomd{}
+	if err := xml.Unmarshal(repomdData, repomd); err != nil {
+		return nil, fmt.Errorf("unable to parse repomd.xml: %s", err)
+	}
+	for _, data := range repomd.Data {
+		if data.Type != "primary" {
+			continue
+		}
+		primaryData, err := get(data.Location.Href)
+		if err != nil {
+			return nil, err
+		}
+		sum := Checksum{Type: data.Checksum.Type, Value: strings.TrimSpace(data.Checksum.Value)}
+		if err = sum.verify(primaryData); err != nil {
+			return nil, fmt.Errorf("bad primary metadata: %s", err)
+		}
+		r, err := decompress(data.Location.Href, bytes.NewReader(primaryData))
+		if err != nil {
+			return nil, err
+		}
+		primary := &xmlPrimary{}
+		if err = xml.NewDecoder(r).Decode(primary); err != nil {
+			return nil, fmt.Errorf("unable to parse primary metadata: %s", err)
+		}
+		pkgs := make([]*Package, 0, len(primary.Packages))
+		for _, p := range primary.Packages {
+			pkgs = append(pkgs, &Package{
+				Name:     p.Name,
+				Epoch:    p.Version.Epoch,
+				Version:  p.Version.Version,
+				Release:  p.Version.Release,
+				Arch:     p.Arch,
+				Location: p.Location.Href,
+				Base:     p.Location.Base,
+				Size:     p.Size.Package,
+				Checksum: Checksum{Type: p.Checksum.Type, Value: strings.TrimSpace(p.Checksum.Value)},
+			})
+		}
+		return pkgs, nil
+	}
+	return nil, fmt.Errorf("repomd.xml has no primary metadata")
+}
+
+// FetchMetadata copies the metadata of the remote repo at baseURL into the repodata dir of destDir.
+// Every file listed in repomd.xml is checked against its checksum, and files that are already there
+// aren't fetched again.  repomd.xml is written last, so the metadata in destDir is always complete,
+// and files that it no longer lists are removed afterwards.
+func FetchMetadata(client *http.Client, baseURL, destDir string) error {
+	repomdData, err := fetch(client, resolve(baseURL, RepomdPath))
+	if err != nil {
+		return err
+	}
+	repomd := &xmlRepomd{}
+	if err = xml.Unmarshal(repomdData, repomd); err != nil {
+		return fmt.Errorf("unable to parse repomd.xml: %s", err)
+	}
+	keep := map[string]struct{}{filepath.FromSlash(RepomdPath): struct{}{}}
+	for _, data := range repomd.Data {
+		relPath, err := metadataPath(data.Location.Href)
+		if err != nil {
+			return err
+		}
+		keep[relPath] = struct{}{}
+		dest := filepath.Join(destDir, relPath)
+		sum := Checksum{Type: data.Checksum.Type, Value: strings.TrimSpace(data.Checksum.Value)}
+		if existing, err := ioutil.ReadFile(dest); err == nil && sum.verify(existing) == nil {
+			continue
+		}
+		content, err := fetch(client, resolve(baseURL, data.Location.Href))
+		if err != nil {
+			return err
+		}
+		if err = sum.verify(content); err != nil {
+			return fmt.Errorf("bad %s metadata: %s", data.Type, err)
+		}
+		if err = writeFile(dest, content); err != nil {
+			return err
+		}
+	}
+	if err = writeFile(filepath.Join(destDir, filepath.FromSlash(RepomdPath)), repomdData); err != nil {
+		return err
+	}
+	// old metadata is only removed once nothing points to it
+	mdDir := filepath.Join(destDir, filepath.FromSlash(path.Dir(RepomdPath)))
+	files, err := ioutil.ReadDir(mdDir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		relPath := filepath.Join(filepath.FromSlash(path.Dir(RepomdPath)), fi.Name())
+		if _, ok := keep[relPath]; !ok && !fi.IsDir() {
+			os.Remove(filepath.Join(destDir, relPath))
+		}
+	}
+	return nil
+}
+
+// metadataPath turns the location of a metadata file into a path relative to the root of the repo,
+// refusing locations outside of the repodata dir
+func metadataPath(href string) (string, error) {
+	clean := path.Clean("/" + href)[1:]
+	if path.Dir(clean) != path.Dir(RepomdPath) {
+		return "", fmt.Errorf("refusing to fetch metadata with location %q", href)
+	}
+	return filepath.FromSlash(clean), nil
+}
+
+// writeFile writes data to a temporary file next to filePath, and renames it into place
+func writeFile(filePath string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// resolve returns the url of a file relative to the root of a repo
+func resolve(baseURL, relPath string) string {
+	base, err := url.Parse(strings.TrimRight(baseURL, "/") + "/")
+	if err != nil {
+		// a bad base url will fail when it is fetched
+		return strings.TrimRight(baseURL, "/") + "/" + relPath
+	}
+	return base.ResolveReference(&url.URL{Path: relPath}).String()
+}
+
+// fetch gets the whole body of a url
+func fetch(client *http.Client, u string) ([]byte, error) {
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch %s: %s", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch %s: %s", u, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch %s: %s", u, err)
+	}
+	return data, nil
+}
+
+// decompress wraps r in a decompressor picked by the file extension of name
+func decompress(name string, r io.Reader) (io.Reader, error) {
+	switch path.Ext(name) {
+	case ".gz":
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompress %s: %s", name, err)
+		}
+		return gzr, nil
+	case ".bz2":
+		return bzip2.NewReader(r), nil
+	case ".xz":
+		xzr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompress %s: %s", name, err)
+		}
+		return xzr, nil
+	case ".xml":
+		return r, nil
+	}
+	return nil, fmt.Errorf("unsupported compression for %s", name)
+}
@@ -0,0 +1,259 @@
+package mirror
+
+import (
+	"bytes"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alapidas/roper/repodata"
+	"github.com/alapidas/roper/rpm"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TheSuite struct {
+	upstream string
+	dest     string
+	server   *httptest.Server
+	mu       sync.Mutex
+	ranges   []string
+	corrupt  string
+}
+
+var _ = Suite(&TheSuite{})
+
+var testPkgs = []string{
+	"docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm",
+	"docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm",
+}
+
+// SetUpTest builds an upstream repo out of some of the test packages, and serves it
+func (suite *TheSuite) SetUpTest(c *C) {
+	suite.upstream = c.MkDir()
+	suite.dest = c.MkDir()
+	suite.ranges = nil
+	suite.corrupt = ""
+	var pkgs []*repodata.Package
+	for _, name := range testPkgs {
+		data, err := ioutil.ReadFile(filepath.Join("..", "hack", "test_repos", "docker", "7", "Packages", name))
+		c.Assert(err, IsNil)
+		c.Assert(os.MkdirAll(filepath.Join(suite.upstream, "Packages"), 0755), IsNil)
+		path := filepath.Join(suite.upstream, "Packages", name)
+		c.Assert(ioutil.WriteFile(path, data, 0644), IsNil)
+		pkg, err := rpm.ReadFile(path)
+		c.Assert(err, IsNil)
+		pkgs = append(pkgs, &repodata.Package{Package: pkg, Location: "Packages/" + name})
+	}
+	c.Assert(repodata.Generate(suite.upstream, pkgs, nil), IsNil)
+
+	files := http.FileServer(http.Dir(suite.upstream))
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.mu.Lock()
+		if rng := r.Header.Get("Range"); rng != "" {
+			suite.ranges = append(suite.ranges, rng)
+		}
+		corrupt := suite.corrupt != "" && strings.HasSuffix(r.URL.Path, suite.corrupt)
+		suite.mu.Unlock()
+		if corrupt {
+			w.Write(bytes.Repeat([]byte("x"), 100))
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+}
+
+func (suite *TheSuite) TearDownTest(c *C) {
+	suite.server.Close()
+}
+
+func (suite *TheSuite) assertMirrored(c *C, name string) {
+	want, err := ioutil.ReadFile(filepath.Join(suite.upstream, "Packages", name))
+	c.Assert(err, IsNil)
+	got, err := ioutil.ReadFile(filepath.Join(suite.dest, "Packages", name))
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(got, want), Equals, true)
+	_, err = os.Stat(filepath.Join(suite.dest, "Packages", name+PartSuffix))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (suite *TheSuite) TestFetchPackages(c *C) {
+	pkgs, err := FetchPackages(http.DefaultClient, suite.server.URL+"/")
+	c.Assert(err, IsNil)
+	c.Assert(pkgs, HasLen, 2)
+	c.Assert(pkgs[0].Name, Equals, "docker-engine-selinux")
+	c.Assert(pkgs[0].Location, Equals, "Packages/"+testPkgs[0])
+	c.Assert(pkgs[0].Checksum.Type, Equals, "sha256")
+	c.Assert(pkgs[0].Size > 0, Equals, true)
+
+	// the test repos have xml.gz primaries written by createrepo
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "hack", "test_repos"))))
+	defer server.Close()
+	pkgs, err = FetchPackages(http.DefaultClient, server.URL+"/docker/7")
+	c.Assert(err, IsNil)
+	c.Assert(pkgs, HasLen, 12)
+
+	_, err = FetchPackages(http.DefaultClient, server.URL+"/nothing/")
+	c.Assert(err, NotNil)
+}
+
+func (suite *TheSuite) TestSync(c *C) {
+	report, err := Sync(suite.server.URL, suite.dest, nil)
+	c.Assert(err, IsNil)
+	c.Assert(report.Failed, HasLen, 0)
+	c.Assert(report.Downloaded, DeepEquals, []string{
+		filepath.Join("Packages", testPkgs[0]),
+		filepath.Join("Packages", testPkgs[1]),
+	})
+	for _, name := range testPkgs {
+		suite.assertMirrored(c, name)
+	}
+
+	// nothing changed upstream, so there's nothing to do
+	report, err = Sync(suite.server.URL, suite.dest, nil)
+	c.Assert(err, IsNil)
+	c.Assert(report.Downloaded, HasLen, 0)
+	c.Assert(report.Failed, HasLen, 0)
+}
+
+func (suite *TheSuite) TestChangedLocally(c *C) {
+	_, err := Sync(suite.server.URL, suite.dest, nil)
+	c.Assert(err, IsNil)
+
+	// a package with the right size, but different contents, is downloaded again
+	local := filepath.Join(suite.dest, "Packages", testPkgs[0])
+	data, err := ioutil.ReadFile(local)
+	c.Assert(err, IsNil)
+	data[len(data)-1] ^= 0xff
+	c.Assert(ioutil.WriteFile(local, data, 0644), IsNil)
+	report, err := Sync(suite.server.URL, suite.dest, nil)
+	c.Assert(err, IsNil)
+	c.Assert(report.Downloaded, DeepEquals, []string{filepath.Join("Packages", testPkgs[0])})
+	suite.assertMirrored(c, testPkgs[0])
+
+	// known checksums are used instead of reading the files
+	var asked []string
+	known := func(relPath string, fi os.FileInfo) string {
+		asked = append(asked, relPath)
+		return strings.Repeat("0", 64)
+	}
+	report, err = Sync(suite.server.URL, suite.dest, &Options{KnownChecksum: known})
+	c.Assert(err, IsNil)
+	c.Assert(asked, HasLen, 2)
+	c.Assert(report.Downloaded, HasLen, 2)
+}
+
+func (suite *TheSuite) TestResume(c *C) {
+	data, err := ioutil.ReadFile(filepath.Join(suite.upstream, "Packages", testPkgs[0]))
+	c.Assert(err, IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(suite.dest, "Packages"), 0755), IsNil)
+	part := filepath.Join(suite.dest, "Packages", testPkgs[0]+PartSuffix)
+	c.Assert(ioutil.WriteFile(part, data[:1000], 0644), IsNil)
+
+	report, err := Sync(suite.server.URL, suite.dest, nil)
+	c.Assert(err, IsNil)
+	c.Assert(report.Failed, HasLen, 0)
+	c.Assert(report.Downloaded, HasLen, 2)
+	c.Assert(suite.ranges, DeepEquals, []string{"bytes=1000-"})
+	suite.assertMirrored(c, testPkgs[0])
+
+	// a part file that doesn't match upstream is thrown away, and the next sync starts over
+	suite.ranges = nil
+	c.Assert(os.Remove(filepath.Join(suite.dest, "Packages", testPkgs[1])), IsNil)
+	part = filepath.Join(suite.dest, "Packages", testPkgs[1]+PartSuffix)
+	c.Assert(ioutil.WriteFile(part, bytes.Repeat([]byte("x"), 1000), 0644), IsNil)
+	report, err = Sync(suite.server.URL, suite.dest, nil)
+	c.Assert(err, IsNil)
+	c.Assert(report.Failed, HasLen, 1)
+	_, err = os.Stat(part)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	report, err = Sync(suite.server.URL, suite.dest, nil)
+	c.Assert(err, IsNil)
+	c.Assert(report.Failed, HasLen, 0)
+	suite.assertMirrored(c, testPkgs[1])
+}
+
+func (suite *TheSuite) TestChecksumMismatch(c *C) {
+	suite.corrupt = testPkgs[1]
+	report, err := Sync(suite.server.URL, suite.dest, nil)
+	c.Assert(err, IsNil)
+	c.Assert(report.Downloaded, DeepEquals, []string{filepath.Join("Packages", testPkgs[0])})
+	c.Assert(report.Failed, HasLen, 1)
+	c.Assert(report.Failed[filepath.Join("Packages", testPkgs[1])], ErrorMatches, "size mismatch.*")
+	for _, suffix := range []string{"", PartSuffix} {
+		_, err = os.Stat(filepath.Join(suite.dest, "Packages", testPkgs[1]+suffix))
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+}
+
+func (suite *TheSuite) TestDelete(c *C) {
+	extra := filepath.Join(suite.dest, "Packages", "old-1.0-1.noarch.rpm")
+	c.Assert(os.MkdirAll(filepath.Dir(extra), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(extra, []byte("old"), 0644), IsNil)
+	other := filepath.Join(suite.dest, "README")
+	c.Assert(ioutil.WriteFile(other, []byte("keep me"), 0644), IsNil)
+
+	report, err := Sync(suite.server.URL, suite.dest, nil)
+	c.Assert(err, IsNil)
+	c.Assert(report.Deleted, HasLen, 0)
+	_, err = os.Stat(extra)
+	c.Assert(err, IsNil)
+
+	report, err = Sync(suite.server.URL, suite.dest, &Options{Delete: true})
+	c.Assert(err, IsNil)
+	c.Assert(report.Deleted, DeepEquals, []string{filepath.Join("Packages", "old-1.0-1.noarch.rpm")})
+	_, err = os.Stat(extra)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = os.Stat(other)
+	c.Assert(err, IsNil)
+	for _, name := range testPkgs {
+		suite.assertMirrored(c, name)
+	}
+}
+
+func (suite *TheSuite) TestLocalPath(c *C) {
+	for _, location := range []string{"../etc/passwd", "/etc/passwd", "Packages/../../x.rpm", "repodata/repomd.xml", ""} {
+		_, err := localPath(location)
+		c.Assert(err, NotNil, Commentf(location))
+	}
+	path, err := localPath("Packages/a.rpm")
+	c.Assert(err, IsNil)
+	c.Assert(path, Equals, filepath.Join("Packages", "a.rpm"))
+}
+
+func (suite *TheSuite) TestFetchMetadata(c *C) {
+	c.Assert(FetchMetadata(http.DefaultClient, suite.server.URL, suite.dest), IsNil)
+	want, err := ioutil.ReadFile(filepath.Join(suite.upstream, "repodata", "repomd.xml"))
+	c.Assert(err, IsNil)
+	got, err := ioutil.ReadFile(filepath.Join(suite.dest, "repodata", "repomd.xml"))
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(got, want), Equals, true)
+	pkgs, err := ReadPackages(suite.dest)
+	c.Assert(err, IsNil)
+	c.Assert(pkgs, HasLen, 2)
+	c.Assert(pkgs[1].Location, Equals, "Packages/"+testPkgs[1])
+
+	// new metadata upstream replaces the old
+	stale := filepath.Join(suite.dest, "repodata", "stale-primary.xml.gz")
+	c.Assert(ioutil.WriteFile(stale, []byte("old"), 0644), IsNil)
+	pkg, err := rpm.ReadFile(filepath.Join(suite.upstream, "Packages", testPkgs[0]))
+	c.Assert(err, IsNil)
+	c.Assert(repodata.Generate(suite.upstream, []*repodata.Package{{Package: pkg, Location: "Packages/" + testPkgs[0]}}, nil), IsNil)
+	c.Assert(FetchMetadata(http.DefaultClient, suite.server.URL, suite.dest), IsNil)
+	pkgs, err = ReadPackages(suite.dest)
+	c.Assert(err, IsNil)
+	c.Assert(pkgs, HasLen, 1)
+	_, err = os.Stat(stale)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = metadataPath("../repomd.xml")
+	c.Assert(err, NotNil)
+	_, err = metadataPath("Packages/a.rpm")
+	c.Assert(err, NotNil)
+}
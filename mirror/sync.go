@@ -0,0 +1,269 @@
+package mirror
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// PartSuffix is added to the name of a package while it is being downloaded
+const PartSuffix = ".part"
+
+// Options control a sync
+type Options struct {
+	// Client is used for all requests.  Defaults to http.DefaultClient.
+	Client *http.Client
+	// Delete removes local packages that are no longer in the remote repo
+	Delete bool
+	// KnownChecksum returns the hex encoded SHA-256 of a local package, by its path relative to the
+	// local dir, if it was computed before and the file hasn't changed since, or an empty string.  It
+	// saves reading every local package to compare it with the remote metadata.
+	KnownChecksum func(relPath string, fi os.FileInfo) string
+}
+
+// Report describes what a sync changed
+type Report struct {
+	Downloaded []string         // paths of new packages, relative to the local dir
+	Deleted    []string         // paths of packages removed because they are gone upstream
+	Failed     map[string]error // packages that couldn't be downloaded, by path
+}
+
+// Sync downloads the packages of the remote repo at baseURL that are missing from destDir, keeping
+// the layout of the remote repo.  Downloads are written next to the package with PartSuffix added,
+// and are resumed if a previous sync was interrupted.  A package is only moved into place once its
+// checksum matches the remote metadata.  Packages that are already in destDir with the right size
+// and checksum are not downloaded again, and ones that differ from the remote package, like a package
+// rebuilt upstream under the same name, are replaced.
+//
+// Failing to download a package doesn't stop the sync, the failures are returned in the report.  An
+// error is only returned if the remote metadata can't be read.
+func Sync(baseURL, destDir string, opts *Options) (*Report, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	pkgs, err := FetchPackages(client, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{Failed: make(map[string]error)}
+	wanted := make(map[string]struct{}, len(pkgs))
+	for _, pkg := range pkgs {
+		relPath, err := localPath(pkg.Location)
+		if err != nil {
+			report.Failed[pkg.Location] = err
+			continue
+		}
+		wanted[relPath] = struct{}{}
+		dest := filepath.Join(destDir, relPath)
+		if fi, err := os.Stat(dest); err == nil && upToDate(dest, relPath, fi, pkg, opts) {
+			continue
+		}
+		if err = Download(client, baseURL, pkg, dest); err != nil {
+			log.WithFields(log.Fields{
+				"path":  relPath,
+				"error": err,
+			}).Warn("unable to download package")
+			report.Failed[relPath] = err
+			continue
+		}
+		report.Downloaded = append(report.Downloaded, relPath)
+	}
+	if opts.Delete {
+		if report.Deleted, err = deleteRemoved(destDir, wanted); err != nil {
+			return report, err
+		}
+	}
+	sort.Strings(report.Downloaded)
+	return report, nil
+}
+
+// upToDate returns whether a local package matches the remote one, by its size and checksum.  The
+// known checksum of the local file is used when there is one, and the file is read otherwise.
+func upToDate(dest, relPath string, fi os.FileInfo, pkg *Package, opts *Options) bool {
+	if pkg.Size > 0 && fi.Size() != pkg.Size {
+		return false
+	}
+	if pkg.Checksum.Value == "" {
+		return true
+	}
+	if pkg.Checksum.Type == "sha256" && opts.KnownChecksum != nil {
+		if sum := opts.KnownChecksum(relPath, fi); sum != "" {
+			return sum == strings.ToLower(pkg.Checksum.Value)
+		}
+	}
+	return verifyFile(dest, pkg) == nil
+}
+
+// localPath turns the location of a package into a path relative to the local dir, refusing
+// locations that would land outside of it
+func localPath(location string) (string, error) {
+	clean := path.Clean("/" + location)[1:]
+	if clean == "" || clean != strings.TrimPrefix(location, "./") || strings.HasPrefix(clean, "repodata/") {
+		return "", fmt.Errorf("refusing to mirror package with location %q", location)
+	}
+	return filepath.FromSlash(clean), nil
+}
+
+// NotFoundError is returned when the remote repo doesn't have a file
+type NotFoundError struct {
+	URL string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s not found", e.URL)
+}
+
+// Download fetches a package of the remote repo at baseURL into dest.  The download is written next
+// to dest with PartSuffix added, and resumes a previous download if there is one.  It is only moved
+// into place once its size and checksum match the package, and a download that doesn't match is
+// deleted.  The checksum isn't checked if the package doesn't have one.
+func Download(client *http.Client, baseURL string, pkg *Package, dest string) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if pkg.Base != "" {
+		baseURL = pkg.Base
+	}
+	u := resolve(baseURL, pkg.Location)
+	log.WithFields(log.Fields{
+		"url":  u,
+		"path": dest,
+	}).Info("downloading package")
+	return download(client, u, dest, pkg)
+}
+
+// download fetches a package into dest, resuming a partial download if there is one
+func download(client *http.Client, u, dest string, pkg *Package) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	part := dest + PartSuffix
+	var offset int64
+	if fi, err := os.Stat(part); err == nil {
+		offset = fi.Size()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			os.Remove(part)
+			return fmt.Errorf("server resumed at the wrong offset: %s", resp.Header.Get("Content-Range"))
+		}
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		flags |= os.O_TRUNC
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the partial file is already complete, or is garbage that the checksum will catch
+		flags = -1
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return &NotFoundError{URL: u}
+	default:
+		return fmt.Errorf("unable to fetch %s: %s", u, resp.Status)
+	}
+	if flags != -1 {
+		f, err := os.OpenFile(part, flags, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, resp.Body)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			// keep what we got, so the next sync can resume
+			return fmt.Errorf("download interrupted: %s", err)
+		}
+	}
+	if err = verifyFile(part, pkg); err != nil {
+		os.Remove(part)
+		return err
+	}
+	return os.Rename(part, dest)
+}
+
+// verifyFile checks the size and checksum of a downloaded package
+func verifyFile(filePath string, pkg *Package) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if pkg.Checksum.Value == "" {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if pkg.Size > 0 && fi.Size() != pkg.Size {
+			return fmt.Errorf("size mismatch: expected %d bytes, got %d", pkg.Size, fi.Size())
+		}
+		return nil
+	}
+	h, err := newHash(pkg.Checksum.Type)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if pkg.Size > 0 && n != pkg.Size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", pkg.Size, n)
+	}
+	if got := fmt.Sprintf("%x", h.Sum(nil)); got != strings.ToLower(pkg.Checksum.Value) {
+		return fmt.Errorf("%s checksum mismatch: expected %s, got %s", pkg.Checksum.Type, pkg.Checksum.Value, got)
+	}
+	return nil
+}
+
+// deleteRemoved removes the packages in dir that aren't wanted, and returns their paths
+func deleteRemoved(dir string, wanted map[string]struct{}) ([]string, error) {
+	var deleted []string
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(filePath) != ".rpm" {
+			return nil
+		}
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		if _, ok := wanted[relPath]; ok {
+			return nil
+		}
+		log.WithField("path", filePath).Info("deleting package removed upstream")
+		if err = os.Remove(filePath); err != nil {
+			return err
+		}
+		deleted = append(deleted, relPath)
+		return nil
+	})
+	if err != nil {
+		return deleted, fmt.Errorf("unable to delete removed packages: %s", err)
+	}
+	return deleted, nil
+}
@@ -3,13 +3,196 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"path/filepath"
+	"time"
+)
+
+// Metadata backends that can be used to build a repo's metadata
+const (
+	BackendNative     = "native"     // built in metadata generator
+	BackendCreaterepo = "createrepo" // external createrepo executable
+)
+
+// Repo types
+const (
+	TypeYum     = "yum"     // rpms with yum metadata
+	TypeApt     = "apt"     // debs with APT indexes
+	TypeHelm    = "helm"    // helm charts with an index.yaml
+	TypeProxy   = "proxy"   // rpms fetched from a remote yum repo as they are requested
+	TypeVirtual = "virtual" // yum metadata merged from the packages of other repos
+)
+
+// Roles a user can have on a repo.  Each role allows everything the roles before it do.
+const (
+	RoleRead  = "read"  // download the repo's files, and read it through the API
+	RoleWrite = "write" // upload packages to the repo, and discover or rebuild it
+	RoleAdmin = "admin" // remove the repo
+)
+
+// roleRanks orders the roles
+var roleRanks = map[string]int{RoleRead: 1, RoleWrite: 2, RoleAdmin: 3}
+
+// ValidRole returns whether a role is one of the Role* constants
+func ValidRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// RoleAllows returns whether a role allows everything another role does
+func RoleAllows(role, want string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[want]
+}
+
+// Ways a virtual repo resolves packages that are in more than one of its members
+const (
+	ResolvePriority = "priority" // the first member with a package name.arch provides all of its versions
+	ResolveNewest   = "newest"   // only the highest version of a package name.arch in any member is listed
 )
 
 type Repo struct {
-	Name     string
-	AbsPath  string              // key
-	Packages map[string]*Package // relative paths of packages
+	Name       string
+	AbsPath    string              // key
+	Packages   map[string]*Package // relative paths of packages
+	Type       string              // one of the Type* constants, empty means yum
+	Backend    string              // one of the Backend* constants, empty means native
+	Createrepo CreaterepoOptions   // options used when building metadata
+	Signing    SigningOptions      // how to sign the repo metadata
+	Verify     VerifyOptions       // how to check the signatures of packages added to the repo
+	Retention  RetentionOptions    // which old packages are pruned from the repo
+	Apt        AptOptions          // how to lay out the indexes of an apt repo
+	Helm       HelmOptions         // how to index a helm chart repo
+	Mirror     MirrorOptions       // the remote repo this repo mirrors, if any
+	Proxy      ProxyOptions        // the remote repo a proxy repo caches
+	Virtual    VirtualOptions      // the repos a virtual repo merges
+	Access     AccessOptions       // who can read the repo
+}
+
+// IsYum returns whether the repo holds rpms with yum metadata
+func (repo *Repo) IsYum() bool {
+	return repo.Type == "" || repo.Type == TypeYum
+}
+
+// AccessOptions describe who can read a repo
+type AccessOptions struct {
+	Private bool // only users with a role on the repo can read it, instead of everyone
+	// ClientCerts are rules for the TLS client certificates that can read the repo, like
+	// "ou:build-hosts" or "san:*.prod.example.com".  See the CertRule* constants.
+	ClientCerts []string
+}
+
+// Kinds of client certificate rules, which prefix a value separated by a colon
+const (
+	CertRuleSubject = "subject" // the whole subject, like "CN=host1.example.com,OU=build,O=Example"
+	CertRuleCN      = "cn"      // a glob for the common name
+	CertRuleSAN     = "san"     // a glob for any of the DNS names, email addresses, IP addresses or URIs
+	CertRuleOU      = "ou"      // any of the organizational units
+)
+
+// VirtualOptions describe the repos a virtual repo merges.  The packages of the members are listed in
+// the virtual repo's metadata, but their files stay where they are.
+type VirtualOptions struct {
+	Members []string // names of the member repos, in priority order
+	Resolve string   // one of the Resolve* constants, empty means priority
+}
+
+// ProxyOptions describe the remote yum repo a proxy repo caches.  The remote metadata is served as-is,
+// and packages are fetched from the remote repo the first time they are requested, then kept in the
+// repo.
+type ProxyOptions struct {
+	URL         string        // url of the root of the remote repo, the one holding repodata/
+	MetadataTTL time.Duration // how long remote metadata is used before checking for new metadata
+	NegativeTTL time.Duration // how long a file the remote repo doesn't have is remembered as missing
+}
+
+// Default TTLs of a proxy repo, used when the options are 0
+const (
+	DefaultMetadataTTL = time.Hour
+	DefaultNegativeTTL = 5 * time.Minute
+)
+
+// MetadataTTLOrDefault returns the metadata TTL, or the default if none is set
+func (opts ProxyOptions) MetadataTTLOrDefault() time.Duration {
+	if opts.MetadataTTL > 0 {
+		return opts.MetadataTTL
+	}
+	return DefaultMetadataTTL
+}
+
+// NegativeTTLOrDefault returns the negative caching TTL, or the default if none is set
+func (opts ProxyOptions) NegativeTTLOrDefault() time.Duration {
+	if opts.NegativeTTL > 0 {
+		return opts.NegativeTTL
+	}
+	return DefaultNegativeTTL
+}
+
+// MirrorOptions describe the remote yum repo a repo mirrors.  The packages of the remote repo are
+// downloaded into the repo, and the repo's metadata is built from them like any other repo.
+type MirrorOptions struct {
+	URL    string // url of the root of the remote repo, the one holding repodata/
+	Delete bool   // delete packages that are no longer in the remote repo
+}
+
+// Enabled returns whether the repo is a mirror
+func (opts MirrorOptions) Enabled() bool {
+	return opts.URL != ""
+}
+
+// HelmOptions control the index.yaml of a helm chart repo
+type HelmOptions struct {
+	URL string // base url the charts are served from, the index uses relative urls if empty
+}
+
+// AptOptions control where the indexes of an apt repo are written, and what goes in its Release file.
+// Indexes are written to dists/<suite>/<component>/binary-<arch>, and the packages may live anywhere
+// in the repo.
+type AptOptions struct {
+	Suite         string   // defaults to "stable"
+	Codename      string   // defaults to the suite
+	Component     string   // defaults to "main"
+	Origin        string   // Origin field of the Release file
+	Label         string   // Label field of the Release file
+	Architectures []string // defaults to the architectures of the packages in the repo
+}
+
+// RetentionOptions limit how many old versions of each package a repo keeps.  Versions are grouped
+// by name and arch, and the newest version of each is always kept.  A package is pruned if it is
+// outside of any of the limits, and a zero value disables that limit.
+type RetentionOptions struct {
+	KeepLast   int // number of versions of each package to keep
+	MaxAgeDays int // age in days, since the version was added to the repo, after which it is pruned
+}
+
+// Enabled returns whether any retention limit is set
+func (opts RetentionOptions) Enabled() bool {
+	return opts.KeepLast > 0 || opts.MaxAgeDays > 0
+}
+
+// VerifyOptions control the checking of package signatures.  Packages that fail the check are
+// quarantined instead of being added to the repo.
+type VerifyOptions struct {
+	Keyring string // keyring file holding the trusted public keys, verification is disabled if empty
+}
+
+// SigningOptions control the signing of a repo's repomd.xml
+type SigningOptions struct {
+	Keyring string // keyring file holding the private key, signing is disabled if empty
+	KeyID   string // key to sign with, defaults to the configured signing key id
+}
+
+// CreaterepoOptions are per-repo metadata options.  They map to the createrepo flags of the same
+// name.  The native backend rejects Deltas and checksums other than sha256, and ignores Update and
+// CacheDir, since it never re-hashes unchanged packages anyway.
+type CreaterepoOptions struct {
+	Update      bool     // --update: reuse existing metadata for unchanged packages
+	CacheDir    string   // --cachedir: checksum cache dir
+	Checksum    string   // --checksum: checksum type, defaults to sha256
+	Workers     int      // --workers: number of workers reading packages
+	Deltas      bool     // --deltas: generate delta rpms
+	GroupFile   string   // --groupfile: path to a comps.xml, relative to the repo or absolute
+	Distro      []string // --distro: distro tags, in "[cpeid,]tag" form
+	Content     []string // --content: content tags
+	RetainOldMD int      // --retain-old-md: number of old metadata files to keep around
 }
 type PersistableRepo struct {
 	Repo
@@ -18,11 +201,59 @@ type PersistableRepo struct {
 type Package struct {
 	RelPath  string // key
 	RepoName string
+	Added    int64 // unix time the package was first recorded in the repo with its current checksum
+
+	// State of the file on disk when it was last read, used to detect changed files without having to
+	// checksum them
+	Size    int64  // size of the file in bytes
+	ModTime int64  // unix time in nanoseconds
+	Inode   uint64 // 0 on platforms without inodes
+
+	// Everything below is read from the package header during discovery, and will be empty for files
+	// that could not be parsed
+	Name      string
+	Epoch     int
+	Version   string
+	Release   string
+	Arch      string
+	SourceRPM string
+	Checksum  string // hex encoded SHA-256 of the file
+	BuildTime int64  // unix time
+	SignedBy  string // id of the key the signature was verified with, or SignerApproved
+
+	Provides  []Dependency
+	Requires  []Dependency
+	Conflicts []Dependency
+	Obsoletes []Dependency
+	Files     []string
+}
+
+// Dependency is a single provides/requires/conflicts/obsoletes entry of a package
+type Dependency struct {
+	Name    string
+	Flags   string // EQ, LT, GT, LE, GE, or empty for unversioned deps
+	Epoch   string
+	Version string
+	Release string
 }
 type PersistablePackage struct {
 	Package
 }
 
+// SignerApproved is used as the SignedBy of packages that were released from quarantine by hand
+const SignerApproved = "approved"
+
+// QuarantinedPackage is a package that failed signature verification, and is being held out of its
+// repo until it is approved or rejected
+type QuarantinedPackage struct {
+	Package
+	Reason string // why the package was quarantined
+	Time   int64  // unix time the package was quarantined
+}
+type PersistableQuarantinedPackage struct {
+	QuarantinedPackage
+}
+
 func (repo *Repo) AddPackage(pkg *Package) error {
 	// Overwrites an existing package at the same path
 
@@ -63,6 +294,37 @@ func (pkg *Package) IsRPM() bool {
 	return filepath.Ext(pkg.RelPath) == ".rpm"
 }
 
+// DebVersion returns the version of a deb in [epoch:]upstream[-revision] form.  The upstream version
+// and revision of a deb are kept in Version and Release.
+func (pkg *Package) DebVersion() string {
+	v := pkg.Version
+	if pkg.Epoch != 0 {
+		v = fmt.Sprintf("%d:%s", pkg.Epoch, v)
+	}
+	if pkg.Release != "" {
+		v += "-" + pkg.Release
+	}
+	return v
+}
+
+// SameFile reports whether the file described by fi looks like the file the package was read from.
+// This only compares the file's metadata, not its contents.
+func (pkg *Package) SameFile(fi os.FileInfo, inode uint64) bool {
+	return pkg.Size == fi.Size() && pkg.ModTime == fi.ModTime().UnixNano() && pkg.Inode == inode
+}
+
+// NEVRA returns the name-epoch:version-release.arch string for the package.  The epoch is omitted
+// when it is 0.  Packages with no header data return an empty string.
+func (pkg *Package) NEVRA() string {
+	if pkg.Name == "" {
+		return ""
+	}
+	if pkg.Epoch == 0 {
+		return fmt.Sprintf("%s-%s-%s.%s", pkg.Name, pkg.Version, pkg.Release, pkg.Arch)
+	}
+	return fmt.Sprintf("%s-%d:%s-%s.%s", pkg.Name, pkg.Epoch, pkg.Version, pkg.Release, pkg.Arch)
+}
+
 func (pr *PersistableRepo) Serial() ([]byte, []byte, error) {
 	kbytes := []byte(pr.Name)
 	// copy the repo and clear out packages, then persist it
@@ -84,3 +346,186 @@ func (pp *PersistablePackage) Serial() ([]byte, []byte, error) {
 	}
 	return kbytes, vbytes, nil
 }
+
+func (pq *PersistableQuarantinedPackage) Serial() ([]byte, []byte, error) {
+	key := fmt.Sprintf("%s::%s", pq.RepoName, pq.RelPath)
+	kbytes := []byte(key)
+	vbytes, err := json.Marshal(pq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to marshal value: %s", err)
+	}
+	return kbytes, vbytes, nil
+}
+
+// Snapshot is a frozen copy of the packages and metadata of a repo at a point in time
+type Snapshot struct {
+	ID       int // key, numbered from 1 within each repo
+	RepoName string
+	Label    string // optional name for the snapshot, unique within the repo
+	Created  int64  // unix time
+	Packages []SnapshotPackage
+}
+
+// SnapshotPackage is a package in a snapshot
+type SnapshotPackage struct {
+	RelPath  string
+	NEVRA    string // empty if the package header couldn't be read
+	Checksum string
+	Blob     bool // the snapshot's file is a link to the blob of the checksum, and holds a reference to it
+}
+
+// Advisory types, as used in updateinfo.xml
+const (
+	AdvisorySecurity    = "security"
+	AdvisoryBugfix      = "bugfix"
+	AdvisoryEnhancement = "enhancement"
+	AdvisoryNewPackage  = "newpackage"
+)
+
+// Advisory is an erratum published in the updateinfo.xml of a repo
+type Advisory struct {
+	ID          string // key, e.g. RHSA-2016:0001
+	RepoName    string
+	Type        string // one of the Advisory* constants
+	Severity    string // e.g. Critical, Important, Moderate or Low
+	Title       string
+	Description string
+	Issued      int64 // unix time
+	Updated     int64 // unix time, 0 if never updated
+	References  []Reference
+	Packages    []string // NEVRAs of the packages that fix the issue
+}
+
+// Reference is a link from an advisory to a bug, CVE or other advisory
+type Reference struct {
+	Type  string // e.g. cve, bugzilla or self
+	ID    string
+	Href  string
+	Title string
+}
+type PersistableAdvisory struct {
+	Advisory
+}
+
+func (pa *PersistableAdvisory) Serial() ([]byte, []byte, error) {
+	key := fmt.Sprintf("%s::%s", pa.RepoName, pa.ID)
+	kbytes := []byte(key)
+	vbytes, err := json.Marshal(pa)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to marshal value: %s", err)
+	}
+	return kbytes, vbytes, nil
+}
+
+// Package requirement types of a group, as used in comps.xml
+const (
+	GroupMandatory   = "mandatory"
+	GroupDefault     = "default"
+	GroupOptional    = "optional"
+	GroupConditional = "conditional"
+)
+
+// Group is a package group published in the comps.xml of a repo
+type Group struct {
+	ID          string // key
+	RepoName    string
+	Name        string
+	Description string
+	Default     bool // installed by default when the group's environment is
+	UserVisible bool // shown in group lists
+	Packages    []GroupPackage
+}
+
+// GroupPackage is a package in a group
+type GroupPackage struct {
+	Name     string
+	Type     string // one of the Group* constants
+	Requires string // for conditional packages, the package that pulls this one in
+}
+
+// Environment is a set of groups published in the comps.xml of a repo
+type Environment struct {
+	ID          string // key
+	RepoName    string
+	Name        string
+	Description string
+	Groups      []string // ids of the groups that make up the environment
+	Options     []string // ids of groups that can optionally be added to the environment
+}
+type PersistableGroup struct {
+	Group
+}
+type PersistableEnvironment struct {
+	Environment
+}
+
+func (pg *PersistableGroup) Serial() ([]byte, []byte, error) {
+	key := fmt.Sprintf("%s::%s", pg.RepoName, pg.ID)
+	kbytes := []byte(key)
+	vbytes, err := json.Marshal(pg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to marshal value: %s", err)
+	}
+	return kbytes, vbytes, nil
+}
+
+func (pe *PersistableEnvironment) Serial() ([]byte, []byte, error) {
+	key := fmt.Sprintf("%s::%s", pe.RepoName, pe.ID)
+	kbytes := []byte(key)
+	vbytes, err := json.Marshal(pe)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to marshal value: %s", err)
+	}
+	return kbytes, vbytes, nil
+}
+
+// Promotion records packages that were promoted from one repo to another
+type Promotion struct {
+	From     string
+	To       string   // key, along with the time
+	Pattern  string   // NEVRA or glob the packages were picked with
+	User     string   // who promoted the packages
+	Time     int64    // unix time in nanoseconds
+	Packages []string // NEVRAs of the promoted packages, including dependencies
+}
+
+// Blob is a file in the content addressable blob store, which repos link their packages to
+type Blob struct {
+	Checksum string // key, the hex encoded SHA-256 the blob is stored under
+	Size     int64
+	Refs     int   // number of package and snapshot records that use the blob
+	Created  int64 // unix time
+}
+
+// BuildStatus describes the last metadata build of a repo
+type BuildStatus struct {
+	Repo     string // key
+	Started  int64  // unix time
+	Duration time.Duration
+	Packages int    // number of packages in the repo when the build started
+	Running  bool   // the build hasn't finished
+	Error    string // why the build failed, empty if it succeeded
+}
+
+// User is someone who can authenticate to the web server, with a password or an API token
+type User struct {
+	Name         string            // key
+	PasswordHash string            // salted hash of the password, empty if the user only has tokens
+	Admin        bool              // has the admin role on every repo, and can add repos
+	Roles        map[string]string // role on each repo, by repo name
+}
+
+// Can returns whether the user has a role on a repo, or one that allows it
+func (u *User) Can(repoName, role string) bool {
+	return u.Admin || RoleAllows(u.Roles[repoName], role)
+}
+
+// Token is an API token of a user.  Tokens are given out as the ID and a secret joined by a dot, and
+// only a hash of the secret is kept.
+type Token struct {
+	ID          string // key
+	User        string
+	Hash        string // hex SHA-256 of the secret
+	Description string
+	Created     int64 // unix time
+}
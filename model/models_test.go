@@ -23,6 +23,11 @@ func (suite *TheSuite) TestPackage(c *C) {
 	c.Assert(p1.IsRPM(), Equals, false)
 	p2 := Package{RelPath: "a/b/c.rpm"}
 	c.Assert(p2.IsRPM(), Equals, true)
+	c.Assert(p2.NEVRA(), Equals, "")
+	p3 := Package{Name: "foo", Version: "1.0", Release: "1.el7", Arch: "noarch"}
+	c.Assert(p3.NEVRA(), Equals, "foo-1.0-1.el7.noarch")
+	p3.Epoch = 2
+	c.Assert(p3.NEVRA(), Equals, "foo-2:1.0-1.el7.noarch")
 }
 
 func (suite *TheSuite) TestRepo(c *C) {
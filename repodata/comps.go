@@ -0,0 +1,92 @@
+package repodata
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+)
+
+// Group is a package group in comps.xml
+type Group struct {
+	ID          string
+	Name        string
+	Description string
+	Default     bool
+	UserVisible bool
+	Packages    []GroupPackage
+}
+
+// GroupPackage is a package in a group
+type GroupPackage struct {
+	Name     string
+	Type     string // mandatory, default, optional or conditional
+	Requires string // only for conditional packages
+}
+
+// Environment is a set of groups in comps.xml
+type Environment struct {
+	ID          string
+	Name        string
+	Description string
+	Groups      []string
+	Options     []string
+}
+
+type xmlComps struct {
+	XMLName      xml.Name         `xml:"comps"`
+	Groups       []xmlGroup       `xml:"group"`
+	Environments []xmlEnvironment `xml:"environment"`
+}
+
+type xmlGroup struct {
+	ID          string          `xml:"id"`
+	Name        string          `xml:"name"`
+	Description string          `xml:"description"`
+	Default     bool            `xml:"default"`
+	UserVisible bool            `xml:"uservisible"`
+	Packages    []xmlPackageReq `xml:"packagelist>packagereq"`
+}
+
+type xmlPackageReq struct {
+	Type     string `xml:"type,attr"`
+	Requires string `xml:"requires,attr,omitempty"`
+	Name     string `xml:",chardata"`
+}
+
+type xmlEnvironment struct {
+	ID          string   `xml:"id"`
+	Name        string   `xml:"name"`
+	Description string   `xml:"description"`
+	Groups      []string `xml:"grouplist>groupid"`
+	Options     []string `xml:"optionlist>groupid"`
+}
+
+const compsDoctype = `<!DOCTYPE comps PUBLIC "-//Red Hat, Inc.//DTD Comps info//EN" "comps.dtd">` + "\n"
+
+// Comps builds a comps.xml document from groups and environments, suitable for Options.GroupFile
+func Comps(groups []*Group, envs []*Environment) ([]byte, error) {
+	doc := &xmlComps{}
+	for _, g := range groups {
+		xg := xmlGroup{ID: g.ID, Name: g.Name, Description: g.Description, Default: g.Default, UserVisible: g.UserVisible}
+		for _, pkg := range g.Packages {
+			xg.Packages = append(xg.Packages, xmlPackageReq{Type: pkg.Type, Requires: pkg.Requires, Name: pkg.Name})
+		}
+		doc.Groups = append(doc.Groups, xg)
+	}
+	for _, e := range envs {
+		doc.Environments = append(doc.Environments, xmlEnvironment{
+			ID:          e.ID,
+			Name:        e.Name,
+			Description: e.Description,
+			Groups:      e.Groups,
+			Options:     e.Options,
+		})
+	}
+	data, err := marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build comps.xml: %s", err)
+	}
+	// the doctype goes right after the xml declaration
+	i := bytes.IndexByte(data, '\n') + 1
+	return append(append(append([]byte{}, data[:i]...), compsDoctype...), data[i:]...), nil
+}
@@ -0,0 +1,352 @@
+// Package repodata is a native implementation of yum repository metadata generation.  It writes the
+// same repomd.xml, primary.xml.gz, filelists.xml.gz and other.xml.gz files that createrepo would,
+// without needing createrepo installed.
+package repodata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alapidas/roper/rpm"
+)
+
+// Dir is the name of the metadata directory inside of a repo
+const Dir = "repodata"
+
+// Package is an RPM along with its location in the repo
+type Package struct {
+	*rpm.Package
+	Location string // path relative to the root of the repo
+}
+
+// Options control metadata generation
+type Options struct {
+	// Revision is written to repomd.xml.  Defaults to the current unix time.
+	Revision string
+	// GroupFile is the path to a comps.xml file to include in the metadata
+	GroupFile string
+	// Distro and Content tags are written to repomd.xml.  Distro tags may be of the form "cpeid,tag".
+	Distro  []string
+	Content []string
+	// Updates are written to updateinfo.xml, if there are any
+	Updates []*Update
+	// RetainOldMD is the number of old metadata files of each type to keep in the repodata dir
+	RetainOldMD int
+	// Signer, if set, is used to write a detached signature of repomd.xml to repomd.xml.asc
+	Signer Signer
+}
+
+// Signer creates detached signatures
+type Signer interface {
+	DetachSign(data []byte) ([]byte, error)
+}
+
+// SignatureFile is the name of the detached signature of repomd.xml
+const SignatureFile = "repomd.xml.asc"
+
+// Generate writes metadata for the given packages into the repodata directory of repoPath.  The new
+// metadata is written to a temporary directory first, and then swapped into place, so clients never
+// see a partially written repodata directory.
+func Generate(repoPath string, pkgs []*Package, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	now := time.Now().Unix()
+	revision := opts.Revision
+	if revision == "" {
+		revision = strconv.FormatInt(now, 10)
+	}
+
+	tmpDir, err := ioutil.TempDir(repoPath, ".repodata-")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary metadata dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	primary, filelists, other := buildDocs(pkgs)
+	repomd := &xmlRepomd{Xmlns: nsRepo, XmlnsRpm: nsRpm, Revision: revision, Tags: tags(opts)}
+	for _, doc := range []struct {
+		mdType string
+		v      interface{}
+	}{
+		{"primary", primary},
+		{"filelists", filelists},
+		{"other", other},
+	} {
+		data, err := writeXMLGz(tmpDir, doc.mdType, doc.v, now)
+		if err != nil {
+			return err
+		}
+		repomd.Data = append(repomd.Data, *data)
+	}
+	if len(opts.Updates) > 0 {
+		data, err := writeXMLGz(tmpDir, "updateinfo", buildUpdateinfo(opts.Updates), now)
+		if err != nil {
+			return err
+		}
+		repomd.Data = append(repomd.Data, *data)
+	}
+	if opts.GroupFile != "" {
+		groupData, err := ioutil.ReadFile(opts.GroupFile)
+		if err != nil {
+			return fmt.Errorf("unable to read group file: %s", err)
+		}
+		group, err := writeRaw(tmpDir, "group", "comps.xml", groupData, now)
+		if err != nil {
+			return err
+		}
+		groupGz, err := writeData(tmpDir, "group_gz", "comps.xml.gz", groupData, now)
+		if err != nil {
+			return err
+		}
+		repomd.Data = append(repomd.Data, *group, *groupGz)
+	}
+	repomdData, err := marshal(repomd)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(tmpDir, "repomd.xml"), repomdData, 0644); err != nil {
+		return fmt.Errorf("unable to write repomd.xml: %s", err)
+	}
+	if opts.Signer != nil {
+		sig, err := opts.Signer.DetachSign(repomdData)
+		if err != nil {
+			return fmt.Errorf("unable to sign repomd.xml: %s", err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(tmpDir, SignatureFile), sig, 0644); err != nil {
+			return fmt.Errorf("unable to write %s: %s", SignatureFile, err)
+		}
+	}
+	if opts.RetainOldMD > 0 {
+		if err = retainOld(filepath.Join(repoPath, Dir), tmpDir, opts.RetainOldMD); err != nil {
+			return err
+		}
+	}
+	if err = os.Chmod(tmpDir, 0755); err != nil {
+		return fmt.Errorf("unable to set permissions on metadata dir: %s", err)
+	}
+	return publish(repoPath, tmpDir)
+}
+
+func tags(opts *Options) *xmlTags {
+	if len(opts.Distro) == 0 && len(opts.Content) == 0 {
+		return nil
+	}
+	t := &xmlTags{Content: opts.Content}
+	for _, d := range opts.Distro {
+		dt := xmlDistroTag{Value: d}
+		if i := strings.Index(d, ","); i >= 0 {
+			dt.CPEID, dt.Value = d[:i], d[i+1:]
+		}
+		t.Distro = append(t.Distro, dt)
+	}
+	return t
+}
+
+// retainOld copies metadata files from the current repodata dir into the new one, keeping the newest
+// keep files of each type alongside the new ones.  Files are hardlinked where possible.
+func retainOld(oldDir, newDir string, keep int) error {
+	old, err := ioutil.ReadDir(oldDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read old metadata dir: %s", err)
+	}
+	// group the old files by type, newest first
+	sort.Sort(byModTime(old))
+	kept := map[string]int{}
+	for _, fi := range old {
+		i := strings.Index(fi.Name(), "-")
+		if fi.IsDir() || i < 0 || fi.Name() == "repomd.xml" {
+			continue
+		}
+		mdType := fi.Name()[i+1:]
+		if kept[mdType] >= keep {
+			continue
+		}
+		kept[mdType]++
+		src, dst := filepath.Join(oldDir, fi.Name()), filepath.Join(newDir, fi.Name())
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := linkOrCopy(src, dst); err != nil {
+			return fmt.Errorf("unable to retain old metadata file %s: %s", fi.Name(), err)
+		}
+	}
+	return nil
+}
+
+type byModTime []os.FileInfo
+
+func (f byModTime) Len() int           { return len(f) }
+func (f byModTime) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f byModTime) Less(i, j int) bool { return f[i].ModTime().After(f[j].ModTime()) }
+
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, data, 0644)
+}
+
+// publish swaps a freshly written metadata directory into place
+func publish(repoPath, newDir string) error {
+	finalDir := filepath.Join(repoPath, Dir)
+	oldDir := filepath.Join(repoPath, ".olddata")
+	if err := os.RemoveAll(oldDir); err != nil {
+		return fmt.Errorf("unable to remove stale metadata dir %s: %s", oldDir, err)
+	}
+	if err := os.Rename(finalDir, oldDir); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to move aside old metadata: %s", err)
+	}
+	if err := os.Rename(newDir, finalDir); err != nil {
+		// try to put the old metadata back
+		os.Rename(oldDir, finalDir)
+		return fmt.Errorf("unable to move new metadata into place: %s", err)
+	}
+	if err := os.RemoveAll(oldDir); err != nil {
+		return fmt.Errorf("unable to remove old metadata: %s", err)
+	}
+	return nil
+}
+
+func buildDocs(pkgs []*Package) (*xmlPrimary, *xmlFilelists, *xmlOther) {
+	primary := &xmlPrimary{Xmlns: nsCommon, XmlnsRpm: nsRpm, Count: len(pkgs)}
+	filelists := &xmlFilelists{Xmlns: nsFilelists, Count: len(pkgs)}
+	other := &xmlOther{Xmlns: nsOther, Count: len(pkgs)}
+	for _, pkg := range pkgs {
+		ver := xmlVersion{Epoch: pkg.Epoch, Version: pkg.Version, Release: pkg.Release}
+
+		pp := xmlPrimaryPackage{
+			Type:        "rpm",
+			Name:        pkg.Name,
+			Arch:        pkg.Arch,
+			Version:     ver,
+			Checksum:    xmlChecksum{Type: "sha256", PkgID: "YES", Value: pkg.Checksum},
+			Summary:     pkg.Summary,
+			Description: pkg.Description,
+			Packager:    pkg.Packager,
+			URL:         pkg.URL,
+			Location:    xmlLocation{Href: filepath.ToSlash(pkg.Location)},
+		}
+		pp.Time.File = pkg.ModTime
+		pp.Time.Build = pkg.BuildTime
+		pp.Size.Package = pkg.Size
+		pp.Size.Installed = pkg.InstalledSize
+		pp.Size.Archive = pkg.ArchiveSize
+		pp.Format.License = pkg.License
+		pp.Format.Vendor = pkg.Vendor
+		pp.Format.Group = pkg.Group
+		pp.Format.BuildHost = pkg.BuildHost
+		if !pkg.Source {
+			pp.Format.SourceRPM = pkg.SourceRPM
+		}
+		pp.Format.HeaderRange.Start = pkg.HeaderStart
+		pp.Format.HeaderRange.End = pkg.HeaderEnd
+		pp.Format.Provides = entries(pkg.Provides, false)
+		pp.Format.Requires = entries(pkg.Requires, true)
+		pp.Format.Conflicts = entries(pkg.Conflicts, false)
+		pp.Format.Obsoletes = entries(pkg.Obsoletes, false)
+		pp.Format.Files = xmlFiles(pkg.Files, true)
+		primary.Packages = append(primary.Packages, pp)
+
+		filelists.Packages = append(filelists.Packages, xmlFilelistsPackage{
+			PkgID:   pkg.Checksum,
+			Name:    pkg.Name,
+			Arch:    pkg.Arch,
+			Version: ver,
+			Files:   xmlFiles(pkg.Files, false),
+		})
+
+		op := xmlOtherPackage{PkgID: pkg.Checksum, Name: pkg.Name, Arch: pkg.Arch, Version: ver}
+		for _, cl := range pkg.Changelogs {
+			op.Changelogs = append(op.Changelogs, xmlChangelog{Author: cl.Author, Date: cl.Time, Text: cl.Text})
+		}
+		other.Packages = append(other.Packages, op)
+	}
+	return primary, filelists, other
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("unable to marshal xml: %s", err)
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// writeXMLGz writes a gzipped xml document named after its checksum, and returns the repomd entry
+// describing it
+func writeXMLGz(dir, mdType string, v interface{}, timestamp int64) (*xmlRepoData, error) {
+	data, err := marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return writeData(dir, mdType, mdType+".xml.gz", data, timestamp)
+}
+
+// writeData gzips data into dir, and returns the repomd entry describing it
+func writeData(dir, mdType, name string, data []byte, timestamp int64) (*xmlRepoData, error) {
+	gzBuf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(gzBuf)
+	if _, err := gzw.Write(data); err != nil {
+		return nil, fmt.Errorf("unable to compress %s: %s", mdType, err)
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, fmt.Errorf("unable to compress %s: %s", mdType, err)
+	}
+	sum := sha256sum(gzBuf.Bytes())
+	fileName := sum + "-" + name
+	if err := ioutil.WriteFile(filepath.Join(dir, fileName), gzBuf.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("unable to write %s: %s", fileName, err)
+	}
+	return &xmlRepoData{
+		Type:         mdType,
+		Checksum:     xmlChecksum{Type: "sha256", Value: sum},
+		OpenChecksum: &xmlChecksum{Type: "sha256", Value: sha256sum(data)},
+		Location:     xmlLocation{Href: Dir + "/" + fileName},
+		Timestamp:    timestamp,
+		Size:         int64(gzBuf.Len()),
+		OpenSize:     int64(len(data)),
+	}, nil
+}
+
+// writeRaw writes uncompressed data into dir, and returns the repomd entry describing it
+func writeRaw(dir, mdType, name string, data []byte, timestamp int64) (*xmlRepoData, error) {
+	sum := sha256sum(data)
+	fileName := sum + "-" + name
+	if err := ioutil.WriteFile(filepath.Join(dir, fileName), data, 0644); err != nil {
+		return nil, fmt.Errorf("unable to write %s: %s", fileName, err)
+	}
+	return &xmlRepoData{
+		Type:      mdType,
+		Checksum:  xmlChecksum{Type: "sha256", Value: sum},
+		Location:  xmlLocation{Href: Dir + "/" + fileName},
+		Timestamp: timestamp,
+		Size:      int64(len(data)),
+	}, nil
+}
+
+func sha256sum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
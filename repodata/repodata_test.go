@@ -0,0 +1,228 @@
+package repodata
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alapidas/roper/rpm"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TheSuite struct {
+	repoPath string
+	pkgs     []*Package
+}
+
+var _ = Suite(&TheSuite{})
+
+func (suite *TheSuite) SetUpTest(c *C) {
+	suite.repoPath = c.MkDir()
+	suite.pkgs = nil
+	srcDir := filepath.Join("..", "hack", "test_repos", "docker", "7", "Packages")
+	for _, name := range []string{
+		"docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm",
+		"docker-engine-selinux-1.9.0-1.el7.centos.src.rpm",
+	} {
+		pkg, err := rpm.ReadFile(filepath.Join(srcDir, name))
+		c.Assert(err, IsNil)
+		suite.pkgs = append(suite.pkgs, &Package{Package: pkg, Location: "Packages/" + name})
+	}
+}
+
+func readRepomd(c *C, repoPath string) *xmlRepomd {
+	data, err := ioutil.ReadFile(filepath.Join(repoPath, Dir, "repomd.xml"))
+	c.Assert(err, IsNil)
+	repomd := &xmlRepomd{}
+	c.Assert(xml.Unmarshal(data, repomd), IsNil)
+	return repomd
+}
+
+func readGz(c *C, path string) []byte {
+	f, err := os.Open(path)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(gzr)
+	c.Assert(err, IsNil)
+	return data
+}
+
+func (suite *TheSuite) TestGenerate(c *C) {
+	err := Generate(suite.repoPath, suite.pkgs, &Options{Revision: "42"})
+	c.Assert(err, IsNil)
+
+	repomd := readRepomd(c, suite.repoPath)
+	c.Assert(repomd.Revision, Equals, "42")
+	c.Assert(repomd.Data, HasLen, 3)
+	types := map[string]xmlRepoData{}
+	for _, d := range repomd.Data {
+		types[d.Type] = d
+		// every referenced file has to exist with the advertised checksum
+		data, err := ioutil.ReadFile(filepath.Join(suite.repoPath, d.Location.Href))
+		c.Assert(err, IsNil)
+		c.Assert(sha256sum(data), Equals, d.Checksum.Value)
+		c.Assert(int64(len(data)), Equals, d.Size)
+	}
+
+	primaryData := readGz(c, filepath.Join(suite.repoPath, types["primary"].Location.Href))
+	c.Assert(strings.Contains(string(primaryData), `<rpm:header-range start="1392" end="4804">`), Equals, true)
+	primary := &xmlPrimary{}
+	c.Assert(xml.Unmarshal(primaryData, primary), IsNil)
+	c.Assert(primary.Count, Equals, 2)
+	c.Assert(primary.Packages, HasLen, 2)
+	bin := primary.Packages[0]
+	c.Assert(bin.Name, Equals, "docker-engine-selinux")
+	c.Assert(bin.Arch, Equals, "noarch")
+	c.Assert(bin.Checksum.Value, Equals, "3e5802011a2148068771817f81fecfe1458c460fe071dc11f517f4c39d214c4d")
+	c.Assert(bin.Location.Href, Equals, "Packages/docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm")
+	c.Assert(primary.Packages[1].Arch, Equals, "src")
+
+	filelists := &xmlFilelists{}
+	c.Assert(xml.Unmarshal(readGz(c, filepath.Join(suite.repoPath, types["filelists"].Location.Href)), filelists), IsNil)
+	c.Assert(filelists.Packages, HasLen, 2)
+
+	other := &xmlOther{}
+	c.Assert(xml.Unmarshal(readGz(c, filepath.Join(suite.repoPath, types["other"].Location.Href)), other), IsNil)
+	c.Assert(other.Packages, HasLen, 2)
+	c.Assert(len(other.Packages[0].Changelogs) > 0, Equals, true)
+}
+
+func (suite *TheSuite) TestRegenerateReplacesOld(c *C) {
+	c.Assert(Generate(suite.repoPath, suite.pkgs, nil), IsNil)
+	c.Assert(Generate(suite.repoPath, suite.pkgs[:1], nil), IsNil)
+
+	repomd := readRepomd(c, suite.repoPath)
+	files, err := ioutil.ReadDir(filepath.Join(suite.repoPath, Dir))
+	c.Assert(err, IsNil)
+	// only the files from the latest run should be left behind
+	c.Assert(files, HasLen, len(repomd.Data)+1)
+
+	// no temporary dirs left lying around
+	entries, err := ioutil.ReadDir(suite.repoPath)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+}
+
+func (suite *TheSuite) TestDepEntries(c *C) {
+	deps := []rpm.Dependency{
+		{Name: "rpmlib(CompressedFileNames)", Flags: rpm.SenseLess | rpm.SenseEqual, Version: "3.0.4-1"},
+		{Name: "/bin/sh", Flags: rpm.SenseScriptPre},
+		{Name: "selinux-policy", Flags: rpm.SenseGreater | rpm.SenseEqual, Version: "3.13.1-23"},
+	}
+	e := entries(deps, true)
+	c.Assert(e.Entries, DeepEquals, []xmlEntry{
+		{Name: "/bin/sh", Pre: "1"},
+		{Name: "selinux-policy", Flags: "GE", Epoch: "0", Version: "3.13.1", Release: "23"},
+	})
+	c.Assert(entries(nil, false), IsNil)
+}
+
+func (suite *TheSuite) TestGenerateOptions(c *C) {
+	groupFile := filepath.Join(c.MkDir(), "comps.xml")
+	c.Assert(ioutil.WriteFile(groupFile, []byte("<comps></comps>\n"), 0644), IsNil)
+	opts := &Options{
+		GroupFile: groupFile,
+		Distro:    []string{"cpe:/o:centos:centos:7,CentOS 7", "el7"},
+		Content:   []string{"binary-noarch"},
+	}
+	c.Assert(Generate(suite.repoPath, suite.pkgs, opts), IsNil)
+
+	repomd := readRepomd(c, suite.repoPath)
+	c.Assert(repomd.Tags, NotNil)
+	c.Assert(repomd.Tags.Content, DeepEquals, []string{"binary-noarch"})
+	c.Assert(repomd.Tags.Distro, DeepEquals, []xmlDistroTag{
+		{CPEID: "cpe:/o:centos:centos:7", Value: "CentOS 7"},
+		{Value: "el7"},
+	})
+	types := map[string]xmlRepoData{}
+	for _, d := range repomd.Data {
+		types[d.Type] = d
+	}
+	c.Assert(types["group"].Location.Href, Matches, "repodata/.*-comps.xml")
+	c.Assert(types["group_gz"].Location.Href, Matches, "repodata/.*-comps.xml.gz")
+	data, err := ioutil.ReadFile(filepath.Join(suite.repoPath, types["group"].Location.Href))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "<comps></comps>\n")
+}
+
+func (suite *TheSuite) TestRetainOldMD(c *C) {
+	c.Assert(Generate(suite.repoPath, suite.pkgs, nil), IsNil)
+	first := readRepomd(c, suite.repoPath)
+	c.Assert(Generate(suite.repoPath, suite.pkgs[:1], &Options{RetainOldMD: 1}), IsNil)
+
+	// the first run's files are still around, next to the new ones
+	for _, d := range first.Data {
+		_, err := os.Stat(filepath.Join(suite.repoPath, d.Location.Href))
+		c.Assert(err, IsNil)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(suite.repoPath, Dir))
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 2*len(first.Data)+1)
+}
+
+func (suite *TheSuite) TestUpdateinfo(c *C) {
+	update := &Update{
+		ID:         "ROPER-2016:0001",
+		Type:       "security",
+		Severity:   "Important",
+		Title:      "docker-engine-selinux security update",
+		Issued:     time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC),
+		References: []Reference{{Type: "cve", ID: "CVE-2016-0001", Href: "https://example.com/CVE-2016-0001"}},
+		Collection: "docker",
+		Packages: []UpdatePackage{{
+			Name: "docker-engine-selinux", Version: "1.9.1", Release: "1.el7.centos", Arch: "noarch",
+			Filename: "docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm",
+		}},
+	}
+	err := Generate(suite.repoPath, suite.pkgs, &Options{Updates: []*Update{update}})
+	c.Assert(err, IsNil)
+	repomd := readRepomd(c, suite.repoPath)
+	c.Assert(repomd.Data, HasLen, 4)
+	c.Assert(repomd.Data[3].Type, Equals, "updateinfo")
+	data := string(readGz(c, filepath.Join(suite.repoPath, repomd.Data[3].Location.Href)))
+	c.Assert(data, Matches, `(?s).*<update from="" status="final" type="security" version="1">.*`)
+	c.Assert(data, Matches, `(?s).*<issued date="2016-01-02 03:04:05"></issued>.*`)
+	c.Assert(data, Matches, `(?s).*<reference href="https://example.com/CVE-2016-0001" id="CVE-2016-0001" type="cve"></reference>.*`)
+	c.Assert(data, Matches, `(?s).*<filename>docker-engine-selinux-1.9.1-1.el7.centos.noarch.rpm</filename>.*`)
+
+	// merging into existing metadata replaces the old updateinfo
+	oldPath := filepath.Join(suite.repoPath, repomd.Data[3].Location.Href)
+	update.Severity = "Critical"
+	c.Assert(AddUpdateinfo(suite.repoPath, []*Update{update}), IsNil)
+	repomd = readRepomd(c, suite.repoPath)
+	c.Assert(repomd.Data, HasLen, 4)
+	c.Assert(repomd.Data[0].Type, Equals, "primary")
+	c.Assert(repomd.Data[3].Type, Equals, "updateinfo")
+	data = string(readGz(c, filepath.Join(suite.repoPath, repomd.Data[3].Location.Href)))
+	c.Assert(data, Matches, `(?s).*<severity>Critical</severity>.*`)
+	_, err = os.Stat(oldPath)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (suite *TheSuite) TestComps(c *C) {
+	data, err := Comps([]*Group{{
+		ID:          "docker",
+		Name:        "Docker",
+		UserVisible: true,
+		Packages: []GroupPackage{
+			{Name: "docker-engine", Type: "mandatory"},
+			{Name: "docker-engine-selinux", Type: "conditional", Requires: "selinux-policy"},
+		},
+	}}, []*Environment{{ID: "container-host", Name: "Container Host", Groups: []string{"docker"}}})
+	c.Assert(err, IsNil)
+	comps := string(data)
+	c.Assert(strings.HasPrefix(comps, xml.Header+compsDoctype+"<comps>"), Equals, true)
+	c.Assert(comps, Matches, `(?s).*<uservisible>true</uservisible>.*`)
+	c.Assert(comps, Matches, `(?s).*<packagereq type="mandatory">docker-engine</packagereq>.*`)
+	c.Assert(comps, Matches, `(?s).*<packagereq type="conditional" requires="selinux-policy">docker-engine-selinux</packagereq>.*`)
+	c.Assert(comps, Matches, `(?s).*<grouplist>\s*<groupid>docker</groupid>\s*</grouplist>.*`)
+}
@@ -0,0 +1,206 @@
+package repodata
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Update is a single advisory in updateinfo.xml
+type Update struct {
+	ID          string // e.g. RHSA-2016:0001
+	Type        string // security, bugfix, enhancement or newpackage
+	Severity    string // e.g. Critical, Important, Moderate or Low, mostly for security updates
+	Title       string
+	Description string
+	From        string // who issued the advisory
+	Issued      time.Time
+	Updated     time.Time // optional
+	References  []Reference
+	// Collection names the list of packages, usually after the repo
+	Collection string
+	Packages   []UpdatePackage
+}
+
+// Reference is a link from an advisory to a bug, CVE or other advisory
+type Reference struct {
+	Type  string // e.g. cve, bugzilla or self
+	ID    string
+	Href  string
+	Title string
+}
+
+// UpdatePackage is a package that is fixed by an advisory
+type UpdatePackage struct {
+	Name     string
+	Epoch    int
+	Version  string
+	Release  string
+	Arch     string
+	Filename string // file name of the rpm, without any directories
+	Src      string // source rpm
+}
+
+type xmlUpdates struct {
+	XMLName xml.Name    `xml:"updates"`
+	Updates []xmlUpdate `xml:"update"`
+}
+
+type xmlUpdate struct {
+	From        string         `xml:"from,attr"`
+	Status      string         `xml:"status,attr"`
+	Type        string         `xml:"type,attr"`
+	Version     string         `xml:"version,attr"`
+	ID          string         `xml:"id"`
+	Title       string         `xml:"title"`
+	Issued      xmlDate        `xml:"issued"`
+	Updated     *xmlDate       `xml:"updated,omitempty"`
+	Severity    string         `xml:"severity,omitempty"`
+	Description string         `xml:"description"`
+	References  []xmlReference `xml:"references>reference"`
+	Collections []xmlPkgList   `xml:"pkglist>collection"`
+}
+
+type xmlDate struct {
+	Date string `xml:"date,attr"`
+}
+
+type xmlReference struct {
+	Href  string `xml:"href,attr"`
+	ID    string `xml:"id,attr"`
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type xmlPkgList struct {
+	Short    string         `xml:"short,attr"`
+	Name     string         `xml:"name"`
+	Packages []xmlUpdatePkg `xml:"package"`
+}
+
+type xmlUpdatePkg struct {
+	Name     string `xml:"name,attr"`
+	Version  string `xml:"version,attr"`
+	Release  string `xml:"release,attr"`
+	Epoch    string `xml:"epoch,attr"`
+	Arch     string `xml:"arch,attr"`
+	Src      string `xml:"src,attr,omitempty"`
+	Filename string `xml:"filename"`
+}
+
+// updateDateFormat is the date format used by yum and dnf in updateinfo.xml
+const updateDateFormat = "2006-01-02 15:04:05"
+
+func buildUpdateinfo(updates []*Update) *xmlUpdates {
+	doc := &xmlUpdates{Updates: []xmlUpdate{}}
+	for _, u := range updates {
+		xu := xmlUpdate{
+			From:        u.From,
+			Status:      "final",
+			Type:        u.Type,
+			Version:     "1",
+			ID:          u.ID,
+			Title:       u.Title,
+			Issued:      xmlDate{Date: u.Issued.UTC().Format(updateDateFormat)},
+			Severity:    u.Severity,
+			Description: u.Description,
+		}
+		if !u.Updated.IsZero() {
+			xu.Updated = &xmlDate{Date: u.Updated.UTC().Format(updateDateFormat)}
+		}
+		for _, ref := range u.References {
+			xu.References = append(xu.References, xmlReference{Href: ref.Href, ID: ref.ID, Type: ref.Type, Title: ref.Title})
+		}
+		if len(u.Packages) > 0 {
+			coll := xmlPkgList{Short: u.Collection, Name: u.Collection}
+			for _, pkg := range u.Packages {
+				coll.Packages = append(coll.Packages, xmlUpdatePkg{
+					Name:     pkg.Name,
+					Version:  pkg.Version,
+					Release:  pkg.Release,
+					Epoch:    strconv.Itoa(pkg.Epoch),
+					Arch:     pkg.Arch,
+					Src:      pkg.Src,
+					Filename: pkg.Filename,
+				})
+			}
+			xu.Collections = append(xu.Collections, coll)
+		}
+		doc.Updates = append(doc.Updates, xu)
+	}
+	return doc
+}
+
+// AddUpdateinfo merges updateinfo.xml.gz into existing metadata, like modifyrepo does, replacing any
+// updateinfo already there.  This is for metadata that wasn't built by Generate, which includes the
+// updates itself.  An existing repomd.xml signature is not updated.
+func AddUpdateinfo(repoPath string, updates []*Update) error {
+	data, err := marshal(buildUpdateinfo(updates))
+	if err != nil {
+		return err
+	}
+	return addData(repoPath, "updateinfo", "updateinfo.xml.gz", data)
+}
+
+// addData gzips data into the repodata dir of repoPath, and points repomd.xml at it.  Any existing
+// data of the same type is replaced, and its file removed.
+func addData(repoPath, mdType, name string, data []byte) error {
+	dir := filepath.Join(repoPath, Dir)
+	repomdPath := filepath.Join(dir, "repomd.xml")
+	repomdData, err := ioutil.ReadFile(repomdPath)
+	if err != nil {
+		return fmt.Errorf("unable to read repomd.xml: %s", err)
+	}
+	repomd := &xmlRepomd{}
+	if err = xml.Unmarshal(repomdData, repomd); err != nil {
+		return fmt.Errorf("unable to parse repomd.xml: %s", err)
+	}
+	// namespace attributes don't survive unmarshaling
+	repomd.Xmlns, repomd.XmlnsRpm = nsRepo, nsRpm
+
+	newData, err := writeData(dir, mdType, name, data, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	var stale []string
+	kept := repomd.Data[:0]
+	for _, d := range repomd.Data {
+		if d.Type == mdType {
+			if d.Location.Href != newData.Location.Href {
+				stale = append(stale, filepath.Join(repoPath, filepath.FromSlash(d.Location.Href)))
+			}
+			continue
+		}
+		kept = append(kept, d)
+	}
+	repomd.Data = append(kept, *newData)
+	if repomdData, err = marshal(repomd); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".repomd.xml-")
+	if err != nil {
+		return fmt.Errorf("unable to write repomd.xml: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(repomdData); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write repomd.xml: %s", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write repomd.xml: %s", err)
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("unable to write repomd.xml: %s", err)
+	}
+	if err = os.Rename(tmp.Name(), repomdPath); err != nil {
+		return fmt.Errorf("unable to write repomd.xml: %s", err)
+	}
+	for _, path := range stale {
+		os.Remove(path)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package repodata
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/alapidas/roper/rpm"
+)
+
+const (
+	nsCommon    = "http://linux.duke.edu/metadata/common"
+	nsRpm       = "http://linux.duke.edu/metadata/rpm"
+	nsFilelists = "http://linux.duke.edu/metadata/filelists"
+	nsOther     = "http://linux.duke.edu/metadata/other"
+	nsRepo      = "http://linux.duke.edu/metadata/repo"
+)
+
+/* primary.xml */
+
+type xmlPrimary struct {
+	XMLName  xml.Name            `xml:"metadata"`
+	Xmlns    string              `xml:"xmlns,attr"`
+	XmlnsRpm string              `xml:"xmlns:rpm,attr"`
+	Count    int                 `xml:"packages,attr"`
+	Packages []xmlPrimaryPackage `xml:"package"`
+}
+
+type xmlPrimaryPackage struct {
+	Type        string      `xml:"type,attr"`
+	Name        string      `xml:"name"`
+	Arch        string      `xml:"arch"`
+	Version     xmlVersion  `xml:"version"`
+	Checksum    xmlChecksum `xml:"checksum"`
+	Summary     string      `xml:"summary"`
+	Description string      `xml:"description"`
+	Packager    string      `xml:"packager"`
+	URL         string      `xml:"url"`
+	Time        struct {
+		File  int64 `xml:"file,attr"`
+		Build int64 `xml:"build,attr"`
+	} `xml:"time"`
+	Size struct {
+		Package   int64 `xml:"package,attr"`
+		Installed int64 `xml:"installed,attr"`
+		Archive   int64 `xml:"archive,attr"`
+	} `xml:"size"`
+	Location xmlLocation `xml:"location"`
+	Format   xmlFormat   `xml:"format"`
+}
+
+type xmlVersion struct {
+	Epoch   int    `xml:"epoch,attr"`
+	Version string `xml:"ver,attr"`
+	Release string `xml:"rel,attr"`
+}
+
+type xmlChecksum struct {
+	Type  string `xml:"type,attr"`
+	PkgID string `xml:"pkgid,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type xmlLocation struct {
+	Href string `xml:"href,attr"`
+}
+
+type xmlFormat struct {
+	License     string `xml:"rpm:license"`
+	Vendor      string `xml:"rpm:vendor"`
+	Group       string `xml:"rpm:group"`
+	BuildHost   string `xml:"rpm:buildhost"`
+	SourceRPM   string `xml:"rpm:sourcerpm"`
+	HeaderRange struct {
+		Start int64 `xml:"start,attr"`
+		End   int64 `xml:"end,attr"`
+	} `xml:"rpm:header-range"`
+	Provides  *xmlEntries `xml:"rpm:provides,omitempty"`
+	Requires  *xmlEntries `xml:"rpm:requires,omitempty"`
+	Conflicts *xmlEntries `xml:"rpm:conflicts,omitempty"`
+	Obsoletes *xmlEntries `xml:"rpm:obsoletes,omitempty"`
+	Files     []xmlFile   `xml:"file"`
+}
+
+type xmlEntries struct {
+	Entries []xmlEntry `xml:"rpm:entry"`
+}
+
+type xmlEntry struct {
+	Name    string `xml:"name,attr"`
+	Flags   string `xml:"flags,attr,omitempty"`
+	Epoch   string `xml:"epoch,attr,omitempty"`
+	Version string `xml:"ver,attr,omitempty"`
+	Release string `xml:"rel,attr,omitempty"`
+	Pre     string `xml:"pre,attr,omitempty"`
+}
+
+type xmlFile struct {
+	Type string `xml:"type,attr,omitempty"`
+	Path string `xml:",chardata"`
+}
+
+/* filelists.xml */
+
+type xmlFilelists struct {
+	XMLName  xml.Name              `xml:"filelists"`
+	Xmlns    string                `xml:"xmlns,attr"`
+	Count    int                   `xml:"packages,attr"`
+	Packages []xmlFilelistsPackage `xml:"package"`
+}
+
+type xmlFilelistsPackage struct {
+	PkgID   string     `xml:"pkgid,attr"`
+	Name    string     `xml:"name,attr"`
+	Arch    string     `xml:"arch,attr"`
+	Version xmlVersion `xml:"version"`
+	Files   []xmlFile  `xml:"file"`
+}
+
+/* other.xml */
+
+type xmlOther struct {
+	XMLName  xml.Name          `xml:"otherdata"`
+	Xmlns    string            `xml:"xmlns,attr"`
+	Count    int               `xml:"packages,attr"`
+	Packages []xmlOtherPackage `xml:"package"`
+}
+
+type xmlOtherPackage struct {
+	PkgID      string         `xml:"pkgid,attr"`
+	Name       string         `xml:"name,attr"`
+	Arch       string         `xml:"arch,attr"`
+	Version    xmlVersion     `xml:"version"`
+	Changelogs []xmlChangelog `xml:"changelog"`
+}
+
+type xmlChangelog struct {
+	Author string `xml:"author,attr"`
+	Date   int64  `xml:"date,attr"`
+	Text   string `xml:",chardata"`
+}
+
+/* repomd.xml */
+
+type xmlRepomd struct {
+	XMLName  xml.Name      `xml:"repomd"`
+	Xmlns    string        `xml:"xmlns,attr"`
+	XmlnsRpm string        `xml:"xmlns:rpm,attr"`
+	Revision string        `xml:"revision"`
+	Tags     *xmlTags      `xml:"tags,omitempty"`
+	Data     []xmlRepoData `xml:"data"`
+}
+
+type xmlTags struct {
+	Content []string       `xml:"content"`
+	Distro  []xmlDistroTag `xml:"distro"`
+}
+
+type xmlDistroTag struct {
+	CPEID string `xml:"cpeid,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type xmlRepoData struct {
+	Type         string       `xml:"type,attr"`
+	Checksum     xmlChecksum  `xml:"checksum"`
+	OpenChecksum *xmlChecksum `xml:"open-checksum,omitempty"`
+	Location     xmlLocation  `xml:"location"`
+	Timestamp    int64        `xml:"timestamp"`
+	Size         int64        `xml:"size"`
+	OpenSize     int64        `xml:"open-size,omitempty"`
+	// DatabaseVersion is only set on the sqlite databases written by createrepo
+	DatabaseVersion int `xml:"database_version,omitempty"`
+}
+
+func entries(deps []rpm.Dependency, requires bool) *xmlEntries {
+	out := &xmlEntries{}
+	seen := map[xmlEntry]struct{}{}
+	for _, dep := range deps {
+		// rpmlib requirements are satisfied by rpm itself, and are never listed
+		if requires && strings.HasPrefix(dep.Name, "rpmlib(") {
+			continue
+		}
+		e := xmlEntry{Name: dep.Name, Flags: dep.Sense()}
+		if e.Flags != "" {
+			e.Epoch, e.Version, e.Release = rpm.EVR(dep.Version)
+			if e.Epoch == "" {
+				e.Epoch = "0"
+			}
+		}
+		if requires && dep.Pre() {
+			e.Pre = "1"
+		}
+		if _, ok := seen[e]; ok {
+			continue
+		}
+		seen[e] = struct{}{}
+		out.Entries = append(out.Entries, e)
+	}
+	if len(out.Entries) == 0 {
+		return nil
+	}
+	return out
+}
+
+// primaryFile decides if a file is listed in primary.xml, using the same rules as createrepo
+func primaryFile(path string) bool {
+	return strings.HasPrefix(path, "/etc/") || strings.Contains(path, "bin/") || path == "/usr/lib/sendmail"
+}
+
+func xmlFiles(files []rpm.File, primaryOnly bool) []xmlFile {
+	out := []xmlFile{}
+	for _, f := range files {
+		if primaryOnly && !primaryFile(f.Path) {
+			continue
+		}
+		xf := xmlFile{Path: f.Path}
+		if f.Dir {
+			xf.Type = "dir"
+		} else if f.Ghost {
+			xf.Type = "ghost"
+		}
+		out = append(out, xf)
+	}
+	return out
+}
@@ -0,0 +1,205 @@
+package rpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+var (
+	leadMagic   = []byte{0xed, 0xab, 0xee, 0xdb}
+	headerMagic = []byte{0x8e, 0xad, 0xe8}
+)
+
+const (
+	leadSize        = 96
+	headerIntroSize = 16
+	indexEntrySize  = 16
+)
+
+// Header entry data types, as defined by the RPM file format
+const (
+	typeNull        = 0
+	typeChar        = 1
+	typeInt8        = 2
+	typeInt16       = 3
+	typeInt32       = 4
+	typeInt64       = 5
+	typeString      = 6
+	typeBin         = 7
+	typeStringArray = 8
+	typeI18NString  = 9
+)
+
+type indexEntry struct {
+	Tag    int32
+	Type   uint32
+	Offset int32
+	Count  uint32
+}
+
+// Header is a parsed RPM header structure.  Both the signature header and the main header of a
+// package use this structure.
+type Header struct {
+	entries map[int]indexEntry
+	store   []byte
+	// Raw holds the bytes of the header exactly as they appeared in the file, starting at the magic
+	Raw []byte
+}
+
+// readHeader reads a header structure from r.  If pad is true, the reader will be advanced past the
+// padding to the next 8 byte boundary, as is required for the signature header.
+func readHeader(r io.Reader, pad bool) (*Header, error) {
+	intro := make([]byte, headerIntroSize)
+	if _, err := io.ReadFull(r, intro); err != nil {
+		return nil, fmt.Errorf("unable to read header intro: %s", err)
+	}
+	if !bytes.Equal(intro[:3], headerMagic) {
+		return nil, fmt.Errorf("bad header magic %x", intro[:3])
+	}
+	nindex := binary.BigEndian.Uint32(intro[8:12])
+	hsize := binary.BigEndian.Uint32(intro[12:16])
+	// sanity check these so a corrupt file can't make us allocate the world
+	if nindex > 0xffff || hsize > 0x0fffffff {
+		return nil, fmt.Errorf("header too large (%d entries, %d bytes)", nindex, hsize)
+	}
+	body := make([]byte, int(nindex)*indexEntrySize+int(hsize))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("unable to read header body: %s", err)
+	}
+	h := &Header{
+		entries: make(map[int]indexEntry, nindex),
+		store:   body[int(nindex)*indexEntrySize:],
+		Raw:     append(intro, body...),
+	}
+	for i := 0; i < int(nindex); i++ {
+		var e indexEntry
+		if err := binary.Read(bytes.NewReader(body[i*indexEntrySize:(i+1)*indexEntrySize]), binary.BigEndian, &e); err != nil {
+			return nil, fmt.Errorf("unable to read index entry %d: %s", i, err)
+		}
+		if e.Offset < 0 || int(e.Offset) > len(h.store) {
+			return nil, fmt.Errorf("index entry for tag %d has bad offset %d", e.Tag, e.Offset)
+		}
+		h.entries[int(e.Tag)] = e
+	}
+	if pad {
+		if padding := (8 - len(h.Raw)%8) % 8; padding > 0 {
+			if _, err := io.ReadFull(r, make([]byte, padding)); err != nil {
+				return nil, fmt.Errorf("unable to read header padding: %s", err)
+			}
+		}
+	}
+	return h, nil
+}
+
+// Size returns the size of the header on disk, not including any padding
+func (h *Header) Size() int64 {
+	return int64(len(h.Raw))
+}
+
+// Has returns whether or not the given tag is present in the header
+func (h *Header) Has(tag int) bool {
+	_, ok := h.entries[tag]
+	return ok
+}
+
+// String returns the value of a string tag.  For string arrays and i18n strings, the first value is
+// returned.  An empty string is returned for missing tags.
+func (h *Header) String(tag int) string {
+	vals := h.Strings(tag)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+// Strings returns the values of a string array tag
+func (h *Header) Strings(tag int) []string {
+	e, ok := h.entries[tag]
+	if !ok {
+		return nil
+	}
+	switch e.Type {
+	case typeString, typeStringArray, typeI18NString:
+	default:
+		return nil
+	}
+	count := int(e.Count)
+	if e.Type == typeString {
+		count = 1
+	}
+	vals := make([]string, 0, count)
+	data := h.store[e.Offset:]
+	for i := 0; i < count; i++ {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			break
+		}
+		vals = append(vals, string(data[:end]))
+		data = data[end+1:]
+	}
+	return vals
+}
+
+// Int returns the first value of an integer tag, or 0 for missing tags
+func (h *Header) Int(tag int) int64 {
+	vals := h.Ints(tag)
+	if len(vals) == 0 {
+		return 0
+	}
+	return vals[0]
+}
+
+// Ints returns the values of an integer tag
+func (h *Header) Ints(tag int) []int64 {
+	e, ok := h.entries[tag]
+	if !ok {
+		return nil
+	}
+	var width int
+	switch e.Type {
+	case typeChar, typeInt8:
+		width = 1
+	case typeInt16:
+		width = 2
+	case typeInt32:
+		width = 4
+	case typeInt64:
+		width = 8
+	default:
+		return nil
+	}
+	data := h.store[e.Offset:]
+	if len(data) < width*int(e.Count) {
+		return nil
+	}
+	vals := make([]int64, e.Count)
+	for i := range vals {
+		b := data[i*width:]
+		switch width {
+		case 1:
+			vals[i] = int64(b[0])
+		case 2:
+			vals[i] = int64(binary.BigEndian.Uint16(b))
+		case 4:
+			vals[i] = int64(binary.BigEndian.Uint32(b))
+		case 8:
+			vals[i] = int64(binary.BigEndian.Uint64(b))
+		}
+	}
+	return vals
+}
+
+// Bytes returns the raw value of a binary tag
+func (h *Header) Bytes(tag int) []byte {
+	e, ok := h.entries[tag]
+	if !ok || e.Type != typeBin {
+		return nil
+	}
+	end := int(e.Offset) + int(e.Count)
+	if end > len(h.store) {
+		return nil
+	}
+	return h.store[e.Offset:end]
+}
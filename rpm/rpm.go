@@ -0,0 +1,382 @@
+package rpm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Header tags used by roper.  See rpmtag.h in the rpm sources for the full list.
+const (
+	TagName            = 1000
+	TagVersion         = 1001
+	TagRelease         = 1002
+	TagEpoch           = 1003
+	TagSummary         = 1004
+	TagDescription     = 1005
+	TagBuildTime       = 1006
+	TagBuildHost       = 1007
+	TagSize            = 1009
+	TagVendor          = 1011
+	TagLicense         = 1014
+	TagPackager        = 1015
+	TagGroup           = 1016
+	TagURL             = 1020
+	TagArch            = 1022
+	TagOldFilenames    = 1027
+	TagFileModes       = 1030
+	TagFileFlags       = 1037
+	TagSourceRPM       = 1044
+	TagProvideName     = 1047
+	TagRequireFlags    = 1048
+	TagRequireName     = 1049
+	TagRequireVersion  = 1050
+	TagConflictFlags   = 1053
+	TagConflictName    = 1054
+	TagConflictVersion = 1055
+	TagChangelogTime   = 1080
+	TagChangelogName   = 1081
+	TagChangelogText   = 1082
+	TagObsoleteName    = 1090
+	TagSourcePackage   = 1106
+	TagProvideFlags    = 1112
+	TagProvideVersion  = 1113
+	TagObsoleteFlags   = 1114
+	TagObsoleteVersion = 1115
+	TagDirIndexes      = 1116
+	TagBaseNames       = 1117
+	TagDirNames        = 1118
+	TagPayloadDigest   = 5092 // digest of the compressed payload, in hex
+	TagPayloadAlgo     = 5093 // PGP hash algorithm of the payload digest
+
+	// signature header tags
+	SigTagDSA         = 267 // DSA signature of the header
+	SigTagRSA         = 268 // RSA signature of the header
+	SigTagSize        = 1000
+	SigTagPGP         = 1002 // RSA signature of the header and payload
+	SigTagGPG         = 1005 // DSA signature of the header and payload
+	SigTagPayloadSize = 1007
+)
+
+// Dependency flag bits
+const (
+	SenseLess       = 1 << 1
+	SenseGreater    = 1 << 2
+	SenseEqual      = 1 << 3
+	SensePrereq     = 1 << 6
+	SenseScriptPre  = 1 << 9
+	SenseScriptPost = 1 << 10
+)
+
+const (
+	fileFlagGhost = 1 << 6
+	modeTypeMask  = 0170000
+	modeDir       = 0040000
+)
+
+// Dependency is a single provides/requires/conflicts/obsoletes entry
+type Dependency struct {
+	Name    string
+	Flags   int64
+	Version string // [epoch:]version[-release], may be empty
+}
+
+// Sense returns the comparison of a versioned dependency as used in repo metadata (EQ, LT, GT, LE
+// or GE), or an empty string for unversioned dependencies.
+func (dep Dependency) Sense() string {
+	switch dep.Flags & (SenseLess | SenseGreater | SenseEqual) {
+	case SenseEqual:
+		return "EQ"
+	case SenseLess:
+		return "LT"
+	case SenseGreater:
+		return "GT"
+	case SenseLess | SenseEqual:
+		return "LE"
+	case SenseGreater | SenseEqual:
+		return "GE"
+	}
+	return ""
+}
+
+// Pre returns whether the dependency is needed before the package is installed
+func (dep Dependency) Pre() bool {
+	return dep.Flags&(SensePrereq|SenseScriptPre|SenseScriptPost) != 0
+}
+
+// File is a single file entry in a package
+type File struct {
+	Path  string
+	Dir   bool
+	Ghost bool
+}
+
+// Changelog is a single changelog entry in a package
+type Changelog struct {
+	Author string
+	Time   int64
+	Text   string
+}
+
+// Package holds the information from an RPM file that roper cares about
+type Package struct {
+	Name        string
+	Epoch       int
+	Version     string
+	Release     string
+	Arch        string
+	Summary     string
+	Description string
+	Packager    string
+	URL         string
+	License     string
+	Vendor      string
+	Group       string
+	BuildHost   string
+	SourceRPM   string
+	BuildTime   int64
+	Source      bool // true for source packages
+
+	InstalledSize int64
+	ArchiveSize   int64
+
+	Provides  []Dependency
+	Requires  []Dependency
+	Conflicts []Dependency
+	Obsoletes []Dependency
+
+	Files      []File
+	Changelogs []Changelog
+
+	// HeaderStart and HeaderEnd are the byte offsets of the main header in the file
+	HeaderStart int64
+	HeaderEnd   int64
+
+	// These are only set when reading from a file
+	Size     int64
+	ModTime  int64
+	Checksum string // hex encoded SHA-256 of the whole file
+
+	Signature *Header
+	Header    *Header
+}
+
+// ReadFile reads the headers of an RPM file, and computes its checksum
+func ReadFile(path string) (*Package, error) {
+	return readFile(path, true)
+}
+
+// ReadHeaders reads the headers of an RPM file without reading the rest of the file.  The Checksum of
+// the returned package will be empty.
+func ReadHeaders(path string) (*Package, error) {
+	return readFile(path, false)
+}
+
+func readFile(path string, checksum bool) (*Package, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open rpm %s: %s", path, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat rpm %s: %s", path, err)
+	}
+	var r io.Reader = f
+	hash := sha256.New()
+	if checksum {
+		r = io.TeeReader(f, hash)
+	}
+	pkg, err := Read(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rpm %s: %s", path, err)
+	}
+	pkg.Size = fi.Size()
+	pkg.ModTime = fi.ModTime().Unix()
+	if checksum {
+		// hash the rest of the file
+		if _, err = io.Copy(hash, f); err != nil {
+			return nil, fmt.Errorf("unable to checksum rpm %s: %s", path, err)
+		}
+		pkg.Checksum = hex.EncodeToString(hash.Sum(nil))
+	}
+	return pkg, nil
+}
+
+// Read reads the lead, signature and header of an RPM from r.  The reader is left positioned at the
+// start of the payload.
+func Read(r io.Reader) (*Package, error) {
+	lead := make([]byte, leadSize)
+	if _, err := io.ReadFull(r, lead); err != nil {
+		return nil, fmt.Errorf("unable to read lead: %s", err)
+	}
+	if !bytes.Equal(lead[:4], leadMagic) {
+		return nil, fmt.Errorf("not an rpm file (bad lead magic %x)", lead[:4])
+	}
+	sig, err := readHeader(r, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read signature header: %s", err)
+	}
+	hdr, err := readHeader(r, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header: %s", err)
+	}
+	pkg := &Package{
+		Name:          hdr.String(TagName),
+		Epoch:         int(hdr.Int(TagEpoch)),
+		Version:       hdr.String(TagVersion),
+		Release:       hdr.String(TagRelease),
+		Arch:          hdr.String(TagArch),
+		Summary:       hdr.String(TagSummary),
+		Description:   hdr.String(TagDescription),
+		Packager:      hdr.String(TagPackager),
+		URL:           hdr.String(TagURL),
+		License:       hdr.String(TagLicense),
+		Vendor:        hdr.String(TagVendor),
+		Group:         hdr.String(TagGroup),
+		BuildHost:     hdr.String(TagBuildHost),
+		SourceRPM:     hdr.String(TagSourceRPM),
+		BuildTime:     hdr.Int(TagBuildTime),
+		Source:        hdr.Has(TagSourcePackage) || !hdr.Has(TagSourceRPM),
+		InstalledSize: hdr.Int(TagSize),
+		ArchiveSize:   sig.Int(SigTagPayloadSize),
+		Provides:      deps(hdr, TagProvideName, TagProvideFlags, TagProvideVersion),
+		Requires:      deps(hdr, TagRequireName, TagRequireFlags, TagRequireVersion),
+		Conflicts:     deps(hdr, TagConflictName, TagConflictFlags, TagConflictVersion),
+		Obsoletes:     deps(hdr, TagObsoleteName, TagObsoleteFlags, TagObsoleteVersion),
+		Files:         files(hdr),
+		Changelogs:    changelogs(hdr),
+		Signature:     sig,
+		Header:        hdr,
+	}
+	if pkg.Source {
+		pkg.Arch = "src"
+	}
+	pkg.HeaderStart = leadSize + sig.Size() + (8-sig.Size()%8)%8
+	pkg.HeaderEnd = pkg.HeaderStart + hdr.Size()
+	if pkg.Name == "" {
+		return nil, fmt.Errorf("package has no name")
+	}
+	return pkg, nil
+}
+
+// NEVRA returns the name-epoch:version-release.arch string for the package.  The epoch is omitted
+// when it is 0.
+func (pkg *Package) NEVRA() string {
+	if pkg.Epoch == 0 {
+		return fmt.Sprintf("%s-%s-%s.%s", pkg.Name, pkg.Version, pkg.Release, pkg.Arch)
+	}
+	return fmt.Sprintf("%s-%d:%s-%s.%s", pkg.Name, pkg.Epoch, pkg.Version, pkg.Release, pkg.Arch)
+}
+
+// ParseNEVRA parses a name-[epoch:]version-release.arch string, as returned by NEVRA, into a package
+// with only those fields set
+func ParseNEVRA(nevra string) (*Package, error) {
+	pkg := &Package{}
+	s := nevra
+	i := strings.LastIndex(s, ".")
+	if i < 0 {
+		return nil, fmt.Errorf("no arch in %q", nevra)
+	}
+	s, pkg.Arch = s[:i], s[i+1:]
+	if i = strings.LastIndex(s, "-"); i < 0 {
+		return nil, fmt.Errorf("no release in %q", nevra)
+	}
+	s, pkg.Release = s[:i], s[i+1:]
+	if i = strings.LastIndex(s, "-"); i < 0 {
+		return nil, fmt.Errorf("no version in %q", nevra)
+	}
+	pkg.Name, pkg.Version = s[:i], s[i+1:]
+	if i = strings.Index(pkg.Version, ":"); i >= 0 {
+		epoch, err := strconv.Atoi(pkg.Version[:i])
+		if err != nil {
+			return nil, fmt.Errorf("bad epoch in %q", nevra)
+		}
+		pkg.Epoch, pkg.Version = epoch, pkg.Version[i+1:]
+	}
+	if pkg.Name == "" || pkg.Version == "" || pkg.Release == "" || pkg.Arch == "" {
+		return nil, fmt.Errorf("malformed nevra %q", nevra)
+	}
+	return pkg, nil
+}
+
+// EVR splits a dependency version string of the form [epoch:]version[-release] into its parts
+func EVR(s string) (epoch, version, release string) {
+	if i := strings.Index(s, ":"); i >= 0 {
+		if _, err := strconv.Atoi(s[:i]); err == nil {
+			epoch, s = s[:i], s[i+1:]
+		}
+	}
+	if i := strings.LastIndex(s, "-"); i >= 0 {
+		s, release = s[:i], s[i+1:]
+	}
+	return epoch, s, release
+}
+
+func deps(hdr *Header, nameTag, flagsTag, versionTag int) []Dependency {
+	names := hdr.Strings(nameTag)
+	flags := hdr.Ints(flagsTag)
+	versions := hdr.Strings(versionTag)
+	out := make([]Dependency, 0, len(names))
+	for i, name := range names {
+		dep := Dependency{Name: name}
+		if i < len(flags) {
+			dep.Flags = flags[i]
+		}
+		if i < len(versions) {
+			dep.Version = versions[i]
+		}
+		out = append(out, dep)
+	}
+	return out
+}
+
+func files(hdr *Header) []File {
+	var paths []string
+	if hdr.Has(TagBaseNames) {
+		bases := hdr.Strings(TagBaseNames)
+		dirs := hdr.Strings(TagDirNames)
+		idxs := hdr.Ints(TagDirIndexes)
+		for i, base := range bases {
+			if i >= len(idxs) || int(idxs[i]) >= len(dirs) {
+				break
+			}
+			paths = append(paths, dirs[idxs[i]]+base)
+		}
+	} else {
+		paths = hdr.Strings(TagOldFilenames)
+	}
+	modes := hdr.Ints(TagFileModes)
+	fflags := hdr.Ints(TagFileFlags)
+	out := make([]File, 0, len(paths))
+	for i, path := range paths {
+		f := File{Path: path}
+		if i < len(modes) {
+			f.Dir = modes[i]&modeTypeMask == modeDir
+		}
+		if i < len(fflags) {
+			f.Ghost = fflags[i]&fileFlagGhost != 0
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func changelogs(hdr *Header) []Changelog {
+	times := hdr.Ints(TagChangelogTime)
+	names := hdr.Strings(TagChangelogName)
+	texts := hdr.Strings(TagChangelogText)
+	out := make([]Changelog, 0, len(times))
+	for i, t := range times {
+		if i >= len(names) || i >= len(texts) {
+			break
+		}
+		out = append(out, Changelog{Author: names[i], Time: t, Text: texts[i]})
+	}
+	return out
+}
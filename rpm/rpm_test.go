@@ -0,0 +1,126 @@
+package rpm
+
+import (
+	"bytes"
+	. "gopkg.in/check.v1"
+	"path/filepath"
+	"testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TheSuite struct {
+	dockerPath string
+}
+
+var _ = Suite(&TheSuite{})
+
+func (suite *TheSuite) SetUpTest(c *C) {
+	suite.dockerPath = filepath.Join("..", "hack", "test_repos", "docker", "7", "Packages")
+}
+
+func (suite *TheSuite) TestReadFile(c *C) {
+	pkg, err := ReadFile(filepath.Join(suite.dockerPath, "docker-engine-selinux-1.9.0-1.el7.centos.noarch.rpm"))
+	c.Assert(err, IsNil)
+	c.Assert(pkg.Name, Equals, "docker-engine-selinux")
+	c.Assert(pkg.Epoch, Equals, 0)
+	c.Assert(pkg.Version, Equals, "1.9.0")
+	c.Assert(pkg.Release, Equals, "1.el7.centos")
+	c.Assert(pkg.Arch, Equals, "noarch")
+	c.Assert(pkg.Source, Equals, false)
+	c.Assert(pkg.SourceRPM, Equals, "docker-engine-selinux-1.9.0-1.el7.centos.src.rpm")
+	c.Assert(pkg.NEVRA(), Equals, "docker-engine-selinux-1.9.0-1.el7.centos.noarch")
+	c.Assert(pkg.BuildTime, Equals, int64(1446573681))
+	c.Assert(pkg.InstalledSize, Equals, int64(25852))
+	c.Assert(pkg.ArchiveSize, Equals, int64(26300))
+	c.Assert(pkg.Size, Equals, int64(21716))
+	c.Assert(pkg.HeaderStart, Equals, int64(1392))
+	c.Assert(pkg.HeaderEnd, Equals, int64(4804))
+	// checksum as recorded by createrepo in the test repo's metadata
+	c.Assert(pkg.Checksum, Equals, "3e5802011a2148068771817f81fecfe1458c460fe071dc11f517f4c39d214c4d")
+	c.Assert(pkg.Provides, HasLen, 1)
+	c.Assert(pkg.Provides[0].Name, Equals, "docker-engine-selinux")
+	c.Assert(pkg.Provides[0].Flags&SenseEqual, Not(Equals), int64(0))
+	c.Assert(pkg.Conflicts, DeepEquals, []Dependency{{Name: "docker-selinux"}})
+	c.Assert(len(pkg.Files) > 0, Equals, true)
+	c.Assert(len(pkg.Changelogs) > 0, Equals, true)
+}
+
+func (suite *TheSuite) TestReadSource(c *C) {
+	pkg, err := ReadFile(filepath.Join(suite.dockerPath, "docker-engine-selinux-1.9.0-1.el7.centos.src.rpm"))
+	c.Assert(err, IsNil)
+	c.Assert(pkg.Source, Equals, true)
+	c.Assert(pkg.Arch, Equals, "src")
+}
+
+func (suite *TheSuite) TestReadGarbage(c *C) {
+	_, err := Read(bytes.NewReader([]byte("this is not an rpm")))
+	c.Assert(err, NotNil)
+	_, err = Read(bytes.NewReader(make([]byte, 200)))
+	c.Assert(err, NotNil)
+}
+
+func (suite *TheSuite) TestEVR(c *C) {
+	e, v, r := EVR("1:2.3-4.el7")
+	c.Assert([]string{e, v, r}, DeepEquals, []string{"1", "2.3", "4.el7"})
+	e, v, r = EVR("2.3")
+	c.Assert([]string{e, v, r}, DeepEquals, []string{"", "2.3", ""})
+	e, v, r = EVR("3.13.1-23")
+	c.Assert([]string{e, v, r}, DeepEquals, []string{"", "3.13.1", "23"})
+}
+
+func (suite *TheSuite) TestVercmp(c *C) {
+	// cases from the rpmvercmp tests in the rpm sources
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0.1", "2.0", 1},
+		{"5.5p1", "5.5p2", -1},
+		{"5.5p10", "5.5p1", 1},
+		{"10xyz", "10.1xyz", -1},
+		{"xyz10", "xyz10.1", -1},
+		{"1.0aa", "1.0a", 1},
+		{"10.0001", "10.1", 0},
+		{"10.0001", "10.0039", -1},
+		{"4.999.9", "5.0", -1},
+		{"20101121", "20101122", -1},
+		{"2_0", "2.0", 0},
+		{"a", "1", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~rc1~git123", "1.0~rc1", -1},
+		{"1.0^", "1.0", 1},
+		{"1.0^git1", "1.0.1", -1},
+		{"1.0^git1~pre", "1.0^git1", -1},
+		{"1.9.1", "1.10.0", -1},
+	}
+	for _, tc := range cases {
+		c.Check(Vercmp(tc.a, tc.b), Equals, tc.want, Commentf("%s vs %s", tc.a, tc.b))
+		c.Check(Vercmp(tc.b, tc.a), Equals, -tc.want, Commentf("%s vs %s", tc.b, tc.a))
+	}
+	c.Assert(CompareEVR(1, "1.0", "1", 0, "2.0", "1"), Equals, 1)
+	c.Assert(CompareEVR(0, "1.0", "2.el7", 0, "1.0", "10.el7"), Equals, -1)
+}
+
+func (suite *TheSuite) TestParseNEVRA(c *C) {
+	pkg, err := ParseNEVRA("docker-engine-selinux-1.9.0-1.el7.centos.noarch")
+	c.Assert(err, IsNil)
+	c.Assert(pkg.Name, Equals, "docker-engine-selinux")
+	c.Assert(pkg.Version, Equals, "1.9.0")
+	c.Assert(pkg.Release, Equals, "1.el7.centos")
+	c.Assert(pkg.Arch, Equals, "noarch")
+	c.Assert(pkg.NEVRA(), Equals, "docker-engine-selinux-1.9.0-1.el7.centos.noarch")
+
+	pkg, err = ParseNEVRA("bash-2:4.2.46-19.el7.x86_64")
+	c.Assert(err, IsNil)
+	c.Assert(pkg.Epoch, Equals, 2)
+	c.Assert(pkg.NEVRA(), Equals, "bash-2:4.2.46-19.el7.x86_64")
+
+	for _, bad := range []string{"", "bash", "bash.x86_64", "bash-4.2.x86_64", "bash-x:4.2-1.x86_64"} {
+		_, err = ParseNEVRA(bad)
+		c.Check(err, NotNil, Commentf(bad))
+	}
+}
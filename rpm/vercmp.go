@@ -0,0 +1,115 @@
+package rpm
+
+import "strings"
+
+// Vercmp compares two version or release strings the same way rpm does (rpmvercmp), returning -1, 0
+// or 1 if a is older than, the same as, or newer than b.
+func Vercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	for {
+		a = strings.TrimLeftFunc(a, isSeparator)
+		b = strings.TrimLeftFunc(b, isSeparator)
+
+		// a tilde sorts before everything, even the end of the string
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		// a caret sorts after the end of the string, but before everything else
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if a == "" {
+				return -1
+			}
+			if b == "" {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+
+		// compare the next segment of digits or letters
+		numeric := isDigit(rune(a[0]))
+		segA, segB := a, b
+		if numeric {
+			a = strings.TrimLeftFunc(a, isDigit)
+			b = strings.TrimLeftFunc(b, isDigit)
+		} else {
+			a = strings.TrimLeftFunc(a, isAlpha)
+			b = strings.TrimLeftFunc(b, isAlpha)
+		}
+		segA, segB = segA[:len(segA)-len(a)], segB[:len(segB)-len(b)]
+		if segB == "" {
+			// segments of different types: numbers are newer than letters
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) > len(segB) {
+					return 1
+				}
+				return -1
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+	// whichever has characters left over is newer
+	if a == "" && b == "" {
+		return 0
+	}
+	if a == "" {
+		return -1
+	}
+	return 1
+}
+
+// CompareEVR compares two epoch, version, release triples with rpm ordering, returning -1, 0 or 1
+// if the first is older than, the same as, or newer than the second.
+func CompareEVR(epoch1 int, version1, release1 string, epoch2 int, version2, release2 string) int {
+	if epoch1 != epoch2 {
+		if epoch1 > epoch2 {
+			return 1
+		}
+		return -1
+	}
+	if c := Vercmp(version1, version2); c != 0 {
+		return c
+	}
+	return Vercmp(release1, release2)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isAlpha(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// isSeparator matches the characters that rpm ignores between segments
+func isSeparator(r rune) bool {
+	return !isDigit(r) && !isAlpha(r) && r != '~' && r != '^'
+}